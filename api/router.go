@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"os"
 
@@ -8,8 +10,13 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/alerts"
+	"github.com/CLDWare/schoolbox-backend/internal/broker"
 	"github.com/CLDWare/schoolbox-backend/internal/handlers"
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
 	"github.com/CLDWare/schoolbox-backend/internal/middleware"
+	"github.com/CLDWare/schoolbox-backend/internal/rbac"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
 
 	_ "github.com/CLDWare/schoolbox-backend/docs" // docs is generated by Swag CLI, you have to import it.
 	httpSwagger "github.com/swaggo/http-swagger/v2"
@@ -35,21 +42,43 @@ type API struct {
 	UserHandler           *handlers.UserHandler
 	SessionHandler        *handlers.SessionHandler
 	DeviceHandler         *handlers.DeviceHandler
+	DeviceGroupHandler    *handlers.DeviceGroupHandler
+	RoomHandler           *handlers.RoomHandler
+	AlertHandler          *handlers.AlertHandler
+	AuditHandler          *handlers.AuditHandler
+	alerter               *alerts.Alerter
+	offlineMonitor        *alerts.OfflineMonitor
 }
 
 // NewAPI creates a new API instance
 func NewAPI(db *gorm.DB, quitCh chan os.Signal) *API {
 	cfg := config.Get()
-	websocketHandler := handlers.NewWebsocketHandler(cfg, db)
+	messageBroker, err := broker.New(&cfg.Broker)
+	if err != nil {
+		panic(fmt.Sprintf("Could not create message broker: %v", err))
+	}
+	logger.Info(fmt.Sprintf("Using %s message broker as instance %s", cfg.Broker.Type, cfg.Broker.InstanceID))
+	alerter := alerts.NewAlerter(cfg, db)
+	offlineMonitor := alerts.NewOfflineMonitor(cfg, db, alerter, messageBroker)
+	offlineMonitor.Start()
+	websocketHandler := handlers.NewWebsocketHandler(cfg, db, messageBroker, alerter)
+	sessionHandler := handlers.NewSessionHandler(quitCh, cfg, db, websocketHandler)
+	deviceHandler := handlers.NewDeviceHandler(quitCh, cfg, db, websocketHandler)
 	return &API{
 		config:                cfg,
 		database:              db,
 		versionHandler:        handlers.NewVersionHandler(quitCh, cfg),
 		websocketHandler:      websocketHandler,
 		authenticationHandler: handlers.NewAuthenticationHandler(quitCh, cfg, db),
-		UserHandler:           handlers.NewUserHandler(quitCh, cfg, db),
-		SessionHandler:        handlers.NewSessionHandler(quitCh, cfg, db, websocketHandler),
-		DeviceHandler:         handlers.NewDeviceHandler(quitCh, cfg, db, websocketHandler),
+		UserHandler:           handlers.NewUserHandler(quitCh, cfg, db, sessionHandler),
+		SessionHandler:        sessionHandler,
+		DeviceHandler:         deviceHandler,
+		DeviceGroupHandler:    handlers.NewDeviceGroupHandler(quitCh, cfg, db, websocketHandler, deviceHandler, sessionHandler),
+		RoomHandler:           handlers.NewRoomHandler(quitCh, cfg, db),
+		AlertHandler:          handlers.NewAlertHandler(quitCh, cfg, db),
+		AuditHandler:          handlers.NewAuditHandler(quitCh, cfg, db),
+		alerter:               alerter,
+		offlineMonitor:        offlineMonitor,
 	}
 }
 
@@ -63,6 +92,16 @@ func NewMethodRouter(handlerFuncMap map[string]http.HandlerFunc) http.HandlerFun
 	}
 }
 
+// Shutdown closes the device connections, which are hijacked and not closed by http.Server.Shutdown
+func (api *API) Shutdown(ctx context.Context) error {
+	api.offlineMonitor.Stop()
+	if err := api.websocketHandler.Shutdown(ctx); err != nil {
+		return err
+	}
+	// Alerts raised while the connections closed should still reach the admins
+	return api.alerter.Wait(ctx)
+}
+
 // CreateMux creates and configures the HTTP mux
 func (api *API) CreateMux() *http.ServeMux {
 	mux := http.NewServeMux()
@@ -82,37 +121,134 @@ func (api *API) setupRoutes(mux *http.ServeMux) {
 	auth := middleware.NewAuthenticationMiddleware(api.config, api.database)
 
 	// Frontend authentication
-	mux.HandleFunc("/login", api.authenticationHandler.GetLogin)                  // redirect to google OAuth consent
-	mux.HandleFunc("/oauth2callback", api.authenticationHandler.GetOAuthCallback) // google OAuth consent callback
+	mux.HandleFunc("/login", api.authenticationHandler.GetLogin)                             // redirect to the login page of the first provider
+	mux.HandleFunc("/login/providers", api.authenticationHandler.GetLoginProviders)          // providers users can log in with
+	mux.HandleFunc("/login/{provider}", api.authenticationHandler.GetLogin)                  // redirect to the login page of a provider
+	mux.HandleFunc("/oauth2callback/{provider}", api.authenticationHandler.GetOAuthCallback) // provider login callback
+	mux.HandleFunc("/oauth2callback", api.authenticationHandler.GetOAuthCallback)            // google login callback, registered by existing deployments
+	if api.config.OAuth.DevLogin && api.config.IsDevelopment() {
+		devLoginRouter := NewMethodRouter(map[string]http.HandlerFunc{
+			http.MethodGet:  api.authenticationHandler.GetDevLogin,
+			http.MethodPost: api.authenticationHandler.PostDevLogin,
+		})
+		mux.HandleFunc("/login/"+config.DevLoginProviderID, devLoginRouter) // log in as any user without a provider
+	}
 	mux.HandleFunc("/logout", auth.Required(api.authenticationHandler.GetLogout))
 
 	// User api
-	mux.HandleFunc("/me", auth.Required(api.UserHandler.GetMe))
-	mux.HandleFunc("/user", auth.RequiresAdmin(api.UserHandler.GetUser))
-	mux.HandleFunc("/user/{id}", auth.RequiresAdmin(api.UserHandler.GetUserById))
+	meRouter := NewMethodRouter(map[string]http.HandlerFunc{
+		http.MethodGet:   api.UserHandler.GetMe,
+		http.MethodPatch: api.UserHandler.PatchMe,
+	})
+	mux.HandleFunc("/me", auth.Required(meRouter))
+	meTokensRouter := NewMethodRouter(map[string]http.HandlerFunc{
+		http.MethodGet:  api.UserHandler.GetMeTokens,
+		http.MethodPost: api.UserHandler.PostMeTokens,
+	})
+	mux.HandleFunc("/me/tokens", auth.Required(meTokensRouter))
+	mux.HandleFunc("/me/tokens/{id}", auth.Required(api.UserHandler.DeleteMeToken))
+	meSessionsRouter := NewMethodRouter(map[string]http.HandlerFunc{
+		http.MethodGet:    api.UserHandler.GetMeSessions,
+		http.MethodDelete: api.UserHandler.DeleteMeSessions,
+	})
+	mux.HandleFunc("/me/sessions", auth.Required(meSessionsRouter))
+	mux.HandleFunc("/me/sessions/{id}", auth.Required(api.UserHandler.DeleteMeSession))
+	userRouter := NewMethodRouter(map[string]http.HandlerFunc{
+		http.MethodGet:  auth.Requires(rbac.UserReadAny)(api.UserHandler.GetUser),
+		http.MethodPost: auth.Requires(rbac.UserWrite)(api.UserHandler.PostUser),
+	})
+	mux.HandleFunc("/user", userRouter)
+	userByIdRouter := NewMethodRouter(map[string]http.HandlerFunc{
+		http.MethodGet:    auth.Requires(rbac.UserReadAny)(api.UserHandler.GetUserById),
+		http.MethodPatch:  auth.Requires(rbac.UserWrite)(api.UserHandler.PatchUserById),
+		http.MethodDelete: auth.Requires(rbac.UserWrite)(api.UserHandler.DeleteUserById),
+	})
+	mux.HandleFunc("/user/{id}", userByIdRouter)
 	mux.HandleFunc("/user/{id}/pfp", auth.Required(api.UserHandler.GetUserPfpById))
+	userSessionsRouter := NewMethodRouter(map[string]http.HandlerFunc{
+		http.MethodGet:    api.UserHandler.GetUserSessions,
+		http.MethodDelete: api.UserHandler.DeleteUserSessions,
+	})
+	mux.HandleFunc("/user/{id}/sessions", auth.Requires(rbac.UserWrite)(userSessionsRouter))
+	mux.HandleFunc("/user/{id}/sessions/{session_id}", auth.Requires(rbac.UserWrite)(api.UserHandler.DeleteUserSession))
+	mux.HandleFunc("/user/{id}/impersonate", auth.Requires(rbac.UserImpersonate)(api.UserHandler.PostUserImpersonate))
+	mux.HandleFunc("/impersonation/stop", api.UserHandler.PostImpersonationStop) // also works after the impersonation expired
 
 	// Device api
-	mux.HandleFunc("/device", auth.RequiresAdmin(api.DeviceHandler.GetDevice))
+	mux.HandleFunc("/device", auth.Requires(rbac.DeviceRead)(api.DeviceHandler.GetDevice))
 	deviceByIdRouter := NewMethodRouter(map[string]http.HandlerFunc{
-		http.MethodGet:    api.DeviceHandler.GetDeviceById,
-		http.MethodDelete: api.DeviceHandler.DeleteDeviceById,
+		http.MethodGet:    auth.Requires(rbac.DeviceRead)(api.DeviceHandler.GetDeviceById),
+		http.MethodPatch:  auth.Requires(rbac.DeviceWrite)(api.DeviceHandler.PatchDeviceById),
+		http.MethodDelete: auth.Requires(rbac.DeviceWrite)(api.DeviceHandler.DeleteDeviceById),
 	})
-	mux.HandleFunc("/device/{id}", auth.RequiresAdmin(deviceByIdRouter))
+	mux.HandleFunc("/device/{id}", deviceByIdRouter)
+	mux.HandleFunc("/device/{id}/token/rotate", auth.Requires(rbac.DeviceWrite)(api.DeviceHandler.PostDeviceTokenRotate))
+	mux.HandleFunc("/device/{id}/events", auth.Requires(rbac.DeviceRead)(api.DeviceHandler.GetDeviceEvents))
+	mux.HandleFunc("/device/{id}/uptime", auth.Requires(rbac.DeviceRead)(api.DeviceHandler.GetDeviceUptime))
+	mux.HandleFunc("/device/{id}/tags", auth.Requires(rbac.DeviceWrite)(api.DeviceHandler.PutDeviceTags))
 
-	mux.HandleFunc("/device/register", auth.RequiresAdmin(api.DeviceHandler.PostDeviceRegister))
-	mux.HandleFunc("/device/relink", auth.RequiresAdmin(api.DeviceHandler.PostDeviceRelink))
+	mux.HandleFunc("/device/register", auth.Requires(rbac.DeviceWrite)(api.DeviceHandler.PostDeviceRegister))
+	mux.HandleFunc("/device/relink", auth.Requires(rbac.DeviceWrite)(api.DeviceHandler.PostDeviceRelink))
+	mux.HandleFunc("/device/claim", auth.Requires(rbac.DeviceWrite)(api.DeviceHandler.PostDeviceClaim))
+	mux.HandleFunc("/device/presence", auth.Requires(rbac.DeviceRead)(api.DeviceHandler.GetDevicePresence))
+	mux.HandleFunc("/device/auth-failures", auth.Requires(rbac.DeviceRead)(api.DeviceHandler.GetDeviceAuthFailures))
 
-	// Session api
+	// Device group api
+	deviceGroupRouter := NewMethodRouter(map[string]http.HandlerFunc{
+		http.MethodGet:  auth.Requires(rbac.DeviceGroupRead)(api.DeviceGroupHandler.GetDeviceGroup),
+		http.MethodPost: auth.Requires(rbac.DeviceGroupWrite)(api.DeviceGroupHandler.PostDeviceGroup),
+	})
+	mux.HandleFunc("/device-group", deviceGroupRouter)
+	deviceGroupByIdRouter := NewMethodRouter(map[string]http.HandlerFunc{
+		http.MethodGet:    auth.Requires(rbac.DeviceGroupRead)(api.DeviceGroupHandler.GetDeviceGroupById),
+		http.MethodPatch:  auth.Requires(rbac.DeviceGroupWrite)(api.DeviceGroupHandler.PatchDeviceGroupById),
+		http.MethodDelete: auth.Requires(rbac.DeviceGroupWrite)(api.DeviceGroupHandler.DeleteDeviceGroupById),
+	})
+	mux.HandleFunc("/device-group/{id}", deviceGroupByIdRouter)
+	mux.HandleFunc("/device-group/{id}/members", auth.Requires(rbac.DeviceGroupWrite)(api.DeviceGroupHandler.PostDeviceGroupMembers))
+	mux.HandleFunc("/device-group/{id}/members/{device_id}", auth.Requires(rbac.DeviceGroupWrite)(api.DeviceGroupHandler.DeleteDeviceGroupMember))
+	mux.HandleFunc("/device-group/{id}/config", auth.Requires(rbac.DeviceGroupWrite)(api.DeviceGroupHandler.PostDeviceGroupConfig))
+	mux.HandleFunc("/device-group/{id}/command", auth.Requires(rbac.DeviceGroupWrite)(api.DeviceGroupHandler.PostDeviceGroupCommand))
+	mux.HandleFunc("/device-group/{id}/delete", auth.Requires(rbac.DeviceGroupWrite, rbac.DeviceWrite)(api.DeviceGroupHandler.PostDeviceGroupDelete))
+	mux.HandleFunc("/device-group/{id}/session", auth.Requires(rbac.DeviceGroupWrite, rbac.SessionWriteOwn)(api.DeviceGroupHandler.PostDeviceGroupSession))
+	mux.HandleFunc("/device-group/{id}/session/stop", auth.Requires(rbac.DeviceGroupWrite, rbac.SessionStopAny)(api.DeviceGroupHandler.PostDeviceGroupSessionStop))
+
+	// Room api
+	roomRouter := NewMethodRouter(map[string]http.HandlerFunc{
+		http.MethodGet:  auth.Requires(rbac.RoomRead)(api.RoomHandler.GetRoom),
+		http.MethodPost: auth.Requires(rbac.RoomWrite)(api.RoomHandler.PostRoom),
+	})
+	mux.HandleFunc("/room", roomRouter)
+	roomByIdRouter := NewMethodRouter(map[string]http.HandlerFunc{
+		http.MethodGet:    auth.Requires(rbac.RoomRead)(api.RoomHandler.GetRoomById),
+		http.MethodPatch:  auth.Requires(rbac.RoomWrite)(api.RoomHandler.PatchRoomById),
+		http.MethodDelete: auth.Requires(rbac.RoomWrite)(api.RoomHandler.DeleteRoomById),
+	})
+	mux.HandleFunc("/room/{id}", roomByIdRouter)
+
+	// Alert api
+	mux.HandleFunc("/alert", auth.Requires(rbac.AlertRead)(api.AlertHandler.GetAlert))
+	mux.HandleFunc("/alert/{id}/acknowledge", auth.Requires(rbac.AlertWrite)(api.AlertHandler.PostAlertAcknowledge))
+
+	// Audit api
+	mux.HandleFunc("/audit", auth.Requires(rbac.AuditRead)(api.AuditHandler.GetAudit))
+	mux.HandleFunc("/audit/export", auth.Requires(rbac.AuditRead)(api.AuditHandler.GetAuditExport))
+
+	// Session api, reading sessions is checked per session because it depends on who owns them
 	sessionRouter := NewMethodRouter(map[string]http.HandlerFunc{
-		http.MethodGet:  api.SessionHandler.GetSession,
-		http.MethodPost: api.SessionHandler.PostSession,
+		http.MethodGet:  auth.Required(api.SessionHandler.GetSession),
+		http.MethodPost: auth.Requires(rbac.SessionWriteOwn)(api.SessionHandler.PostSession),
 	})
-	mux.HandleFunc("/session", auth.Required(sessionRouter))
-	mux.HandleFunc("/session/stop", auth.Required(api.SessionHandler.PostSessionStop))
+	mux.HandleFunc("/session", sessionRouter)
+	mux.HandleFunc("/session/stop", auth.Requires(rbac.SessionWriteOwn)(api.SessionHandler.PostSessionStop))
 	mux.HandleFunc("/session/current", auth.Required(api.SessionHandler.GetCurrentSession))
 	mux.HandleFunc("/session/{id}", auth.Required(api.SessionHandler.GetSessionById))
-	mux.HandleFunc("/session/{id}/stop", auth.RequiresAdmin(api.SessionHandler.PostSessionStopById))
+	mux.HandleFunc("/session/{id}/stop", auth.Requires(rbac.SessionStopAny)(api.SessionHandler.PostSessionStopById))
+
+	// Prometheus metrics, served on their own listener instead when METRICS_LISTEN_ADDR is set
+	if api.config.Metrics.ListenAddr == "" {
+		mux.HandleFunc("/metrics", auth.Requires(rbac.MetricsRead)(metrics.Handler().ServeHTTP))
+	}
 
 	// Swagger API docs
 	mux.Handle("/swagger/", http.StripPrefix("/swagger/",
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// apiClient calls the admin endpoints the simulator needs, authenticated with the auth_session_token cookie of an admin
+type apiClient struct {
+	baseURL   string
+	authToken string
+	http      *http.Client
+}
+
+func newAPIClient(baseURL string, authToken string) *apiClient {
+	return &apiClient{
+		baseURL:   baseURL,
+		authToken: authToken,
+		http:      &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func (c *apiClient) do(method string, path string, body any, result any) error {
+	var rawBody []byte
+	if body != nil {
+		var err error
+		rawBody, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	request, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(rawBody))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.AddCookie(&http.Cookie{Name: "auth_session_token", Value: c.authToken})
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	var decoded apiResponse
+	if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+		return fmt.Errorf("%s %s: %d, invalid response: %s", method, path, response.StatusCode, err.Error())
+	}
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %d %s", method, path, response.StatusCode, decoded.Message)
+	}
+	if result != nil {
+		return json.Unmarshal(decoded.Data, result)
+	}
+	return nil
+}
+
+func (c *apiClient) registerDevice(pin uint) (uint, error) {
+	var result struct {
+		DeviceID uint `json:"device_id"`
+	}
+	err := c.do(http.MethodPost, "/device/register", map[string]any{"pin": pin}, &result)
+	return result.DeviceID, err
+}
+
+func (c *apiClient) claimDevice(token string) (uint, error) {
+	var device struct {
+		ID uint `json:"id"`
+	}
+	err := c.do(http.MethodPost, "/device/claim", map[string]any{"token": token}, &device)
+	return device.ID, err
+}
+
+func (c *apiClient) createGroup(name string, deviceIDs []uint) (uint, error) {
+	var group struct {
+		ID uint `json:"id"`
+	}
+	err := c.do(http.MethodPost, "/device-group", map[string]any{
+		"name":        name,
+		"description": "Created by devicesim",
+	}, &group)
+	if err != nil {
+		return 0, err
+	}
+	err = c.do(http.MethodPost, fmt.Sprintf("/device-group/%d/members", group.ID), map[string]any{"device_ids": deviceIDs}, nil)
+	return group.ID, err
+}
+
+func (c *apiClient) deleteGroup(groupID uint) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("/device-group/%d", groupID), nil, nil)
+}
+
+type bulkActionResult struct {
+	DeviceID uint    `json:"device_id"`
+	Success  bool    `json:"success"`
+	Error    *string `json:"error"`
+}
+
+func (c *apiClient) startGroupSession(groupID uint, question string) ([]bulkActionResult, error) {
+	var results []bulkActionResult
+	err := c.do(http.MethodPost, fmt.Sprintf("/device-group/%d/session", groupID), map[string]any{"question": question}, &results)
+	return results, err
+}
+
+func (c *apiClient) stopGroupSession(groupID uint) ([]bulkActionResult, error) {
+	var results []bulkActionResult
+	err := c.do(http.MethodPost, fmt.Sprintf("/device-group/%d/session/stop", groupID), nil, &results)
+	return results, err
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/gorilla/websocket"
+)
+
+// credentials of a registered device, stored in the credentials file so devices are only registered once
+type credentials struct {
+	ID    uint   `json:"id"`
+	Token string `json:"token"`
+}
+
+// message is a websocket message in either direction, errors from the server have no command
+type message struct {
+	Command   string         `json:"c,omitempty"`
+	Data      map[string]any `json:"d,omitempty"`
+	ErrorCode *int           `json:"e,omitempty"`
+	Info      *string        `json:"info,omitempty"`
+}
+
+func (m message) isError() bool {
+	return m.Command == "" && (m.ErrorCode != nil || m.Info != nil)
+}
+
+type serverError struct {
+	code int
+	info string
+}
+
+func (e *serverError) Error() string {
+	return fmt.Sprintf("server error %d: %s", e.code, e.info)
+}
+
+func toServerError(m message) *serverError {
+	err := &serverError{}
+	if m.ErrorCode != nil {
+		err.code = *m.ErrorCode
+	}
+	if m.Info != nil {
+		err.info = *m.Info
+	}
+	return err
+}
+
+var errDisconnected = errors.New("connection closed by server")
+
+// shutdownError is returned when the server announced it shuts down, the device reconnects after reconnectAfter
+type shutdownError struct {
+	reconnectAfter time.Duration
+}
+
+func (e *shutdownError) Error() string {
+	return fmt.Sprintf("server shutting down, reconnecting in %s", e.reconnectAfter)
+}
+
+// simulatedDevice behaves like the firmware of a single box
+type simulatedDevice struct {
+	index    int
+	sim      *simulator
+	creds    *credentials
+	ws       *websocket.Conn
+	writeMu  sync.Mutex
+	messages chan message
+	done     chan struct{}
+}
+
+func (d *simulatedDevice) logf(format string, v ...any) {
+	if d.sim.config.verbose {
+		logger.Info(fmt.Sprintf("device %d: %s", d.index, fmt.Sprintf(format, v...)))
+	}
+}
+
+func (d *simulatedDevice) send(m message) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	d.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return d.ws.WriteJSON(m)
+}
+
+func (d *simulatedDevice) readLoop() {
+	defer close(d.messages)
+	for {
+		var m message
+		if err := d.ws.ReadJSON(&m); err != nil {
+			return
+		}
+		select {
+		case d.messages <- m:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// handleCommon answers the messages that can arrive in any state, it reports if m was handled
+func (d *simulatedDevice) handleCommon(m message) bool {
+	switch m.Command {
+	case "ping":
+		d.sim.stats.count("pings_received")
+		if rand.Float64() < d.sim.config.pongDrop {
+			d.sim.stats.count("pongs_dropped")
+			return true
+		}
+		if err := d.send(message{Command: "pong"}); err != nil {
+			d.sim.stats.error("send_pong")
+		}
+		d.sim.stats.count("pongs_sent")
+		return true
+	case "token_new":
+		token, ok := m.Data["token"].(string)
+		nonce, nonceOk := m.Data["nonce"].(string)
+		if !ok || !nonceOk || d.creds == nil {
+			d.sim.stats.error("invalid_token_new")
+			return true
+		}
+		d.creds.Token = token
+		if err := d.send(message{Command: "token_ack", Data: map[string]any{"signature": signNonce(token, nonce)}}); err != nil {
+			d.sim.stats.error("send_token_ack")
+		}
+		d.sim.stats.count("token_rotations")
+		d.logf("stored rotated token")
+		return true
+	}
+	return false
+}
+
+// expect waits for a message with command, answering other messages in the mean time
+func (d *simulatedDevice) expect(ctx context.Context, command string, timeout time.Duration) (message, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return message{}, ctx.Err()
+		case <-timer.C:
+			return message{}, fmt.Errorf("timed out waiting for '%s'", command)
+		case m, ok := <-d.messages:
+			if !ok {
+				return message{}, errDisconnected
+			}
+			if m.isError() {
+				return message{}, toServerError(m)
+			}
+			if m.Command == command {
+				return m, nil
+			}
+			if !d.handleCommon(m) {
+				d.sim.stats.error(fmt.Sprintf("unexpected_%s", m.Command))
+			}
+		}
+	}
+}
+
+// recordError counts err under stage, server errors are counted by their code
+func (d *simulatedDevice) recordError(stage string, err error) {
+	var serverErr *serverError
+	if errors.As(err, &serverErr) {
+		d.sim.stats.error(fmt.Sprintf("%s_server_error_%d", stage, serverErr.code))
+	} else if errors.Is(err, errDisconnected) {
+		d.sim.stats.error(fmt.Sprintf("%s_disconnected", stage))
+	} else {
+		d.sim.stats.error(stage)
+	}
+	d.logf("%s failed: %s", stage, err.Error())
+}
+
+func (d *simulatedDevice) register(ctx context.Context) error {
+	if d.sim.api == nil {
+		return errors.New("registering a device requires -auth-token")
+	}
+	start := time.Now()
+	if err := d.send(message{Command: "reg_start"}); err != nil {
+		return err
+	}
+	m, err := d.expect(ctx, "reg_pin", 10*time.Second)
+	if err != nil {
+		return err
+	}
+	if d.sim.config.claim {
+		token, ok := m.Data["claim_token"].(string)
+		if !ok {
+			return errors.New("reg_pin without claim_token")
+		}
+		if _, err := d.sim.api.claimDevice(token); err != nil {
+			return err
+		}
+	} else {
+		pin, ok := m.Data["pin"].(float64)
+		if !ok {
+			return errors.New("reg_pin without pin")
+		}
+		if _, err := d.sim.api.registerDevice(uint(pin)); err != nil {
+			return err
+		}
+	}
+	m, err = d.expect(ctx, "reg_ok", 10*time.Second)
+	if err != nil {
+		return err
+	}
+	id, idOK := m.Data["id"].(float64)
+	token, tokenOK := m.Data["token"].(string)
+	if !idOK || !tokenOK {
+		return errors.New("reg_ok without id or token")
+	}
+	d.creds = &credentials{ID: uint(id), Token: token}
+	d.sim.stats.observe("register", time.Since(start))
+	d.logf("registered as device %d", d.creds.ID)
+	return nil
+}
+
+func signNonce(token string, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *simulatedDevice) authenticate(ctx context.Context) error {
+	start := time.Now()
+	if err := d.send(message{Command: "auth_start", Data: map[string]any{"id": d.creds.ID}}); err != nil {
+		return err
+	}
+	m, err := d.expect(ctx, "auth_nonce", 10*time.Second)
+	if err != nil {
+		return err
+	}
+	nonce, ok := m.Data["nonce"].(string)
+	if !ok {
+		return errors.New("auth_nonce without nonce")
+	}
+	err = d.send(message{Command: "auth_validate", Data: map[string]any{"signature": signNonce(d.creds.Token, nonce)}})
+	if err != nil {
+		return err
+	}
+	if _, err := d.expect(ctx, "auth_ok", 10*time.Second); err != nil {
+		return err
+	}
+	d.sim.stats.observe("auth", time.Since(start))
+	d.logf("authenticated as device %d", d.creds.ID)
+	return nil
+}
+
+// nextVoteDelay is the vote interval with jitter applied
+func (d *simulatedDevice) nextVoteDelay() time.Duration {
+	jitter := d.sim.config.jitter * (2*rand.Float64() - 1)
+	return time.Duration(float64(d.sim.config.voteInterval) * (1 + jitter))
+}
+
+// pickVote picks a vote from 1 to 5 following the configured distribution
+func pickVote(weights [5]float64) int {
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	pick := rand.Float64() * total
+	for i, weight := range weights {
+		if pick < weight {
+			return i + 1
+		}
+		pick -= weight
+	}
+	return 5
+}
+
+// serve handles messages of an authenticated device and votes while it is in a session
+func (d *simulatedDevice) serve(ctx context.Context) error {
+	var voteTimer *time.Timer
+	var voteC <-chan time.Time
+	stopVoting := func() {
+		if voteTimer != nil {
+			voteTimer.Stop()
+		}
+		voteTimer, voteC = nil, nil
+	}
+	defer stopVoting()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-voteC:
+			vote := pickVote(d.sim.config.voteWeights)
+			if err := d.send(message{Command: "session_vote", Data: map[string]any{"vote": vote}}); err != nil {
+				return err
+			}
+			d.sim.stats.count("votes_sent")
+			voteTimer.Reset(d.nextVoteDelay())
+		case m, ok := <-d.messages:
+			if !ok {
+				return errDisconnected
+			}
+			if m.isError() {
+				serverErr := toServerError(m)
+				d.recordError("session", serverErr)
+				continue
+			}
+			switch m.Command {
+			case "session_start":
+				if requestedAt := d.sim.sessionRequestedAt.Load(); requestedAt != 0 {
+					d.sim.stats.observe("session_start", time.Since(time.Unix(0, requestedAt)))
+				}
+				d.sim.stats.count("sessions_started")
+				stopVoting()
+				voteTimer = time.NewTimer(d.nextVoteDelay())
+				voteC = voteTimer.C
+				d.logf("session started: %v", m.Data["text"])
+			case "session_stop":
+				d.sim.stats.count("sessions_stopped")
+				stopVoting()
+				d.logf("session stopped")
+			case "server_shutdown":
+				d.sim.stats.count("server_shutdowns")
+				seconds, _ := m.Data["reconnect_after"].(float64)
+				return &shutdownError{reconnectAfter: time.Duration(seconds * float64(time.Second))}
+			default:
+				if !d.handleCommon(m) {
+					d.sim.stats.count(fmt.Sprintf("received_%s", m.Command))
+				}
+			}
+		}
+	}
+}
+
+// run connects, registers if needed, authenticates and serves until ctx is done. When the server shuts down the device
+// reconnects after the time the server asked for.
+// authenticated is called once, with nil if the device did not get that far.
+func (d *simulatedDevice) run(ctx context.Context, authenticated func(*credentials)) {
+	reported := false
+	report := func(creds *credentials) {
+		if !reported {
+			reported = true
+			authenticated(creds)
+		}
+	}
+	defer report(nil)
+
+	for {
+		err := d.connect(ctx, report)
+		var shutdownErr *shutdownError
+		if !errors.As(err, &shutdownErr) {
+			return
+		}
+		d.logf("%s", shutdownErr.Error())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(shutdownErr.reconnectAfter):
+		}
+		d.sim.stats.count("reconnects")
+	}
+}
+
+// connect runs a single connection, it only returns the error of serve so run can decide to reconnect
+func (d *simulatedDevice) connect(ctx context.Context, report func(*credentials)) error {
+	start := time.Now()
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, d.sim.config.wsURL, nil)
+	if err != nil {
+		d.recordError("connect", err)
+		return nil
+	}
+	d.sim.stats.observe("connect", time.Since(start))
+	d.ws = ws
+	d.messages = make(chan message, 16)
+	d.done = make(chan struct{})
+	go d.readLoop()
+	defer func() {
+		close(d.done)
+		d.writeMu.Lock()
+		ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		d.writeMu.Unlock()
+		ws.Close()
+	}()
+
+	if d.creds == nil {
+		if err := d.register(ctx); err != nil {
+			d.recordError("register", err)
+			return nil
+		}
+	}
+	if err := d.authenticate(ctx); err != nil {
+		d.recordError("auth", err)
+		return nil
+	}
+	report(d.creds)
+
+	err = d.serve(ctx)
+	var shutdownErr *shutdownError
+	if err != nil && !errors.As(err, &shutdownErr) {
+		d.recordError("connection", err)
+	}
+	return err
+}
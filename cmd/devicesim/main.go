@@ -0,0 +1,275 @@
+// Command devicesim simulates schoolbox devices against the websocket API, for load testing and CI without hardware.
+//
+// Devices are registered once with the auth_session_token of an admin and stored in the credentials file,
+// later runs reuse them. With -session a session is started on all simulated devices through a temporary device group.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+)
+
+type simulatorConfig struct {
+	wsURL        string
+	voteWeights  [5]float64
+	voteInterval time.Duration
+	jitter       float64
+	pongDrop     float64
+	claim        bool
+	verbose      bool
+}
+
+type simulator struct {
+	config simulatorConfig
+	api    *apiClient // nil without -auth-token
+	stats  *stats
+	// Unix nano time the sessions were requested at, used to measure how long session_start takes to arrive
+	sessionRequestedAt atomic.Int64
+}
+
+// parseVoteWeights parses five comma separated weights for the votes 1 to 5
+func parseVoteWeights(value string) ([5]float64, error) {
+	var weights [5]float64
+	parts := strings.Split(value, ",")
+	if len(parts) != 5 {
+		return weights, fmt.Errorf("expected 5 weights, got %d", len(parts))
+	}
+	total := 0.0
+	for i, part := range parts {
+		weight, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || weight < 0 {
+			return weights, fmt.Errorf("invalid weight '%s'", part)
+		}
+		weights[i] = weight
+		total += weight
+	}
+	if total == 0 {
+		return weights, errors.New("at least one weight should be above 0")
+	}
+	return weights, nil
+}
+
+func loadCredentials(path string) ([]*credentials, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var creds []*credentials
+	err = json.Unmarshal(data, &creds)
+	return creds, err
+}
+
+func saveCredentials(path string, creds []*credentials) error {
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// websocketURL turns the server address into the address of the websocket endpoint
+func websocketURL(server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported scheme '%s', use http or https", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/ws"
+	return u.String(), nil
+}
+
+func main() {
+	server := flag.String("server", "http://localhost:8080", "Base URL of the API")
+	deviceCount := flag.Int("devices", 10, "Amount of devices to simulate")
+	credentialsPath := flag.String("credentials", "devicesim.json", "File to load and store device credentials")
+	authToken := flag.String("auth-token", os.Getenv("DEVICESIM_AUTH_TOKEN"), "auth_session_token of an admin, required to register devices and for -session (default $DEVICESIM_AUTH_TOKEN)")
+	duration := flag.Duration("duration", time.Minute, "How long to run")
+	rampUp := flag.Duration("ramp-up", 5*time.Second, "Time over which the devices connect")
+	startSession := flag.Bool("session", false, "Start a session on all devices once they are authenticated")
+	question := flag.String("question", "Load test", "Question to use with -session")
+	votes := flag.String("votes", "1,2,4,2,1", "Relative weights of the votes 1 to 5")
+	voteInterval := flag.Duration("vote-interval", 5*time.Second, "Average time between votes of a device in a session")
+	jitter := flag.Float64("jitter", 0.5, "Fraction the vote interval randomly varies by, from 0 to 1")
+	pongDrop := flag.Float64("pong-drop", 0, "Chance to not answer a ping, from 0 to 1")
+	claim := flag.Bool("claim", false, "Register new devices with the claim token of their QR code instead of the pin")
+	verbose := flag.Bool("v", false, "Log what every device does")
+	flag.Parse()
+
+	logger.Init()
+
+	weights, err := parseVoteWeights(*votes)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Invalid -votes: %s", err.Error()))
+		os.Exit(2)
+	}
+	if *jitter < 0 || *jitter > 1 || *pongDrop < 0 || *pongDrop > 1 {
+		logger.Err("-jitter and -pong-drop should be between 0 and 1")
+		os.Exit(2)
+	}
+	wsURL, err := websocketURL(*server)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Invalid -server: %s", err.Error()))
+		os.Exit(2)
+	}
+
+	creds, err := loadCredentials(*credentialsPath)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not load credentials from %s: %s", *credentialsPath, err.Error()))
+		os.Exit(1)
+	}
+	newDevices := max(*deviceCount-len(creds), 0)
+	if (newDevices > 0 || *startSession) && *authToken == "" {
+		logger.Err(fmt.Sprintf("%d new devices have to be registered or -session is set, this requires -auth-token", newDevices))
+		os.Exit(2)
+	}
+
+	sim := &simulator{
+		config: simulatorConfig{
+			wsURL:        wsURL,
+			voteWeights:  weights,
+			voteInterval: *voteInterval,
+			jitter:       *jitter,
+			pongDrop:     *pongDrop,
+			claim:        *claim,
+			verbose:      *verbose,
+		},
+		stats: newStats(),
+	}
+	if *authToken != "" {
+		sim.api = newAPIClient(strings.TrimSuffix(*server, "/"), *authToken)
+	}
+
+	logger.Info(fmt.Sprintf("Simulating %d devices (%d new) against %s for %s", *deviceCount, newDevices, wsURL, *duration))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	runCtx, cancelRun := context.WithTimeout(ctx, *duration)
+	defer cancelRun()
+	// Devices stay connected a bit after the run, so sessions can be stopped cleanly
+	deviceCtx, cancelDevices := context.WithCancel(context.Background())
+	defer cancelDevices()
+
+	devices := make([]*simulatedDevice, *deviceCount)
+	var authenticatedMu sync.Mutex
+	authenticatedIDs := []uint{}
+	var authWg, runWg sync.WaitGroup
+	started := time.Now()
+	for i := range devices {
+		device := &simulatedDevice{index: i, sim: sim}
+		if i < len(creds) {
+			device.creds = creds[i]
+		}
+		devices[i] = device
+
+		authWg.Add(1)
+		runWg.Add(1)
+		go func() {
+			defer runWg.Done()
+			if *deviceCount > 1 {
+				select {
+				case <-time.After(*rampUp * time.Duration(i) / time.Duration(*deviceCount-1)):
+				case <-runCtx.Done():
+					authWg.Done()
+					return
+				}
+			}
+			device.run(deviceCtx, func(c *credentials) {
+				if c != nil {
+					authenticatedMu.Lock()
+					authenticatedIDs = append(authenticatedIDs, c.ID)
+					authenticatedMu.Unlock()
+				}
+				authWg.Done()
+			})
+		}()
+	}
+
+	authDone := make(chan struct{})
+	go func() {
+		authWg.Wait()
+		close(authDone)
+	}()
+	select {
+	case <-authDone:
+	case <-runCtx.Done():
+	}
+	authenticatedMu.Lock()
+	authenticated := slices.Clone(authenticatedIDs)
+	authenticatedMu.Unlock()
+	logger.Info(fmt.Sprintf("%d of %d devices authenticated after %s", len(authenticated), *deviceCount, time.Since(started).Round(time.Millisecond)))
+
+	var groupID uint
+	if *startSession && runCtx.Err() == nil && len(authenticated) > 0 {
+		groupID, err = sim.api.createGroup(fmt.Sprintf("devicesim-%d", time.Now().Unix()), authenticated)
+		if err != nil {
+			logger.Err(fmt.Sprintf("Could not create device group: %s", err.Error()))
+		} else {
+			sim.sessionRequestedAt.Store(time.Now().UnixNano())
+			results, err := sim.api.startGroupSession(groupID, *question)
+			if err != nil {
+				logger.Err(fmt.Sprintf("Could not start sessions: %s", err.Error()))
+			}
+			for _, result := range results {
+				if !result.Success {
+					sim.stats.error("session_start_request")
+					logger.Warn(fmt.Sprintf("Could not start session on device %d: %s", result.DeviceID, *result.Error))
+				}
+			}
+		}
+	}
+
+	<-runCtx.Done()
+	elapsed := time.Since(started)
+
+	if groupID != 0 {
+		if _, err := sim.api.stopGroupSession(groupID); err != nil {
+			logger.Err(fmt.Sprintf("Could not stop sessions: %s", err.Error()))
+		}
+		if err := sim.api.deleteGroup(groupID); err != nil {
+			logger.Err(fmt.Sprintf("Could not delete device group %d: %s", groupID, err.Error()))
+		}
+	}
+	cancelDevices()
+	runWg.Wait()
+
+	saved := []*credentials{}
+	for _, device := range devices {
+		if device.creds != nil {
+			saved = append(saved, device.creds)
+		}
+	}
+	if len(creds) > len(devices) {
+		// Keep the devices that were not used this run
+		saved = append(saved, creds[len(devices):]...)
+	}
+	if err := saveCredentials(*credentialsPath, saved); err != nil {
+		logger.Err(fmt.Sprintf("Could not save credentials to %s: %s", *credentialsPath, err.Error()))
+	}
+
+	sim.stats.report(os.Stdout, elapsed)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// stats collects latencies, counters and errors of all simulated devices
+type stats struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	counters  map[string]int
+	errors    map[string]int
+}
+
+func newStats() *stats {
+	return &stats{
+		latencies: map[string][]time.Duration{},
+		counters:  map[string]int{},
+		errors:    map[string]int{},
+	}
+}
+
+func (s *stats) observe(name string, latency time.Duration) {
+	s.mu.Lock()
+	s.latencies[name] = append(s.latencies[name], latency)
+	s.mu.Unlock()
+}
+
+func (s *stats) count(name string) {
+	s.mu.Lock()
+	s.counters[name]++
+	s.mu.Unlock()
+}
+
+func (s *stats) error(kind string) {
+	s.mu.Lock()
+	s.errors[kind]++
+	s.mu.Unlock()
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(float64(len(sorted)-1) * p)
+	return sorted[index]
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (s *stats) report(w io.Writer, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(w, "\nRan for %s\n", elapsed.Round(time.Millisecond))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\nlatency\tcount\tmin\tp50\tp95\tp99\tmax\t")
+	for _, name := range sortedKeys(s.latencies) {
+		latencies := slices.Clone(s.latencies[name])
+		slices.Sort(latencies)
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t\n",
+			name,
+			len(latencies),
+			latencies[0].Round(time.Microsecond),
+			percentile(latencies, 0.50).Round(time.Microsecond),
+			percentile(latencies, 0.95).Round(time.Microsecond),
+			percentile(latencies, 0.99).Round(time.Microsecond),
+			latencies[len(latencies)-1].Round(time.Microsecond),
+		)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(tw, "\ncounter\ttotal\tper second\t")
+	for _, name := range sortedKeys(s.counters) {
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t\n", name, s.counters[name], float64(s.counters[name])/elapsed.Seconds())
+	}
+	tw.Flush()
+
+	if len(s.errors) == 0 {
+		fmt.Fprintln(w, "\nNo errors")
+		return
+	}
+	fmt.Fprintln(tw, "\nerror\ttotal\t")
+	for _, kind := range sortedKeys(s.errors) {
+		fmt.Fprintf(tw, "%s\t%d\t\n", kind, s.errors[kind])
+	}
+	tw.Flush()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/CLDWare/schoolbox-backend/internal/rbac"
 	models "github.com/CLDWare/schoolbox-backend/pkg/db"
 
 	"gorm.io/driver/sqlite"
@@ -29,7 +30,7 @@ func main() {
 	user1 := models.User{
 		Email: "t.vandervelden@chrlyceumdelft.nl ",
 		Name:  "Tom van der Velden",
-		Role:  1,
+		Role:  rbac.RoleAdmin,
 	}
 	err = gorm.G[models.User](db).Create(ctx, &user1)
 
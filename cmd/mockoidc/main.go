@@ -0,0 +1,49 @@
+// Command mockoidc runs a mock OpenID Connect provider, to log in locally without a Google or Entra ID client.
+//
+// Configure the server with:
+//
+//	OIDC_PROVIDERS=mock
+//	OIDC_MOCK_ISSUER=http://localhost:9000
+//	OIDC_MOCK_CLIENT_ID=schoolbox
+//	OIDC_MOCK_CLIENT_SECRET=secret
+//
+// Every login is accepted without asking anything, as -email or as the login_hint of the login url.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/CLDWare/schoolbox-backend/internal/mockoidc"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "Address to listen on")
+	issuer := flag.String("issuer", "", "Issuer url, must be the url the server reaches this provider on (default http://<addr>)")
+	clientID := flag.String("client-id", "schoolbox", "Client ID the server uses")
+	clientSecret := flag.String("client-secret", "secret", "Client secret the server uses")
+	email := flag.String("email", "teacher@example.com", "Email address of the user that logs in when there is no login_hint")
+	flag.Parse()
+
+	logger.Init()
+
+	mock, err := mockoidc.New(*clientID, *clientSecret)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not create mock provider: %s", err.Error()))
+		os.Exit(1)
+	}
+	mock.Issuer = *issuer
+	if mock.Issuer == "" {
+		mock.Issuer = "http://" + *addr
+	}
+	mock.DefaultEmail = *email
+
+	logger.Info(fmt.Sprintf("Mock OpenID Connect provider %s listening on %s", mock.Issuer, *addr))
+	if err := http.ListenAndServe(*addr, mock); err != nil {
+		logger.Err(err.Error())
+		os.Exit(1)
+	}
+}
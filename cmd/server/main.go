@@ -8,11 +8,11 @@ import (
 	"os/signal"
 	"path/filepath"
 	"syscall"
-	"time"
 
 	"github.com/CLDWare/schoolbox-backend/api"
 	"github.com/CLDWare/schoolbox-backend/config"
 	"github.com/CLDWare/schoolbox-backend/internal/janitor"
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
 	models "github.com/CLDWare/schoolbox-backend/pkg/db"
 	"github.com/CLDWare/schoolbox-backend/pkg/logger"
 	"github.com/joho/godotenv"
@@ -87,20 +87,58 @@ func main() {
 		}
 	}()
 
+	// Serve metrics on their own address so they can be scraped without admin credentials
+	var metricsServer *http.Server
+	if cfg.Metrics.ListenAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:         cfg.Metrics.ListenAddr,
+			Handler:      metricsMux,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+		}
+		go func() {
+			logger.Info("Serving metrics on", metricsServer.Addr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Err("Metrics server failed to start:", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	<-quit
 
 	logger.Info("Shutting down server...")
 
 	// Create a deadline for shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 
+	// Let a running cleaning sequence finish
+	jan.Stop()
+
+	// Tell devices to reconnect later and wait for the messages they sent to be stored, sessions keep running
+	if err := apiInstance.Shutdown(ctx); err != nil {
+		logger.Err("Device connections forced to close:", err)
+	}
+
 	// Attempt graceful shutdown
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			logger.Err("Metrics server forced to shutdown:", err)
+		}
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Err("Server forced to shutdown:", err)
 		os.Exit(1)
 	}
 
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+
 	logger.Info("Server exited")
 }
@@ -43,6 +43,20 @@ func TestConfig_Validation(t *testing.T) {
 			},
 			shouldPanic: true,
 		},
+		{
+			name: "https external scheme",
+			env: map[string]string{
+				"SERVER_EXTERNAL_SCHEME": "https",
+			},
+			shouldPanic: false,
+		},
+		{
+			name: "invalid external scheme",
+			env: map[string]string{
+				"SERVER_EXTERNAL_SCHEME": "ftp",
+			},
+			shouldPanic: true,
+		},
 		{
 			name: "invalid environment",
 			env: map[string]string{
@@ -57,6 +71,51 @@ func TestConfig_Validation(t *testing.T) {
 			},
 			shouldPanic: true,
 		},
+		{
+			name: "valid device token keys",
+			env: map[string]string{
+				"DEVICE_TOKEN_KEYS": "2026:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=,old:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=",
+			},
+			shouldPanic: false,
+		},
+		{
+			name: "invalid device token key length",
+			env: map[string]string{
+				"DEVICE_TOKEN_KEYS": "2026:c2hvcnQ=",
+			},
+			shouldPanic: true,
+		},
+		{
+			name: "production without device token keys",
+			env: map[string]string{
+				"ENV": "production",
+			},
+			shouldPanic: true,
+		},
+		{
+			name: "dev login in development",
+			env: map[string]string{
+				"ENV":            "development",
+				"AUTH_DEV_LOGIN": "true",
+			},
+			shouldPanic: false,
+		},
+		{
+			name: "dev login outside development",
+			env: map[string]string{
+				"ENV":               "staging",
+				"DEVICE_TOKEN_KEYS": "2026:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+				"AUTH_DEV_LOGIN":    "true",
+			},
+			shouldPanic: true,
+		},
+		{
+			name: "redis broker without url",
+			env: map[string]string{
+				"BROKER_TYPE": "redis",
+			},
+			shouldPanic: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -19,9 +19,9 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/device": {
+        "/alert": {
             "get": {
-                "description": "Get DeviceInfo about all devices",
+                "description": "Get alerts about devices that need attention, newest first.\nAn alert is resolved automatically once the problem is gone, e.g. when an offline device is seen again.\nAuthentication failure alerts are resolved when they are acknowledged.\nRequires permission ` + "`" + `alert:read` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -29,15 +29,44 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "device requiresAuth requiresAdmin"
+                    "alert requiresAuth requiresPermission"
                 ],
-                "summary": "Get all devices",
+                "summary": "Get alerts",
                 "parameters": [
                     {
-                        "maximum": 20,
+                        "enum": [
+                            "open",
+                            "unacknowledged",
+                            "resolved",
+                            "all"
+                        ],
+                        "type": "string",
+                        "default": "open",
+                        "description": "` + "`" + `open` + "`" + `: not resolved, ` + "`" + `unacknowledged` + "`" + `: open and not acknowledged, ` + "`" + `resolved` + "`" + ` or ` + "`" + `all` + "`" + `",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
                         "type": "integer",
-                        "default": 20,
-                        "description": "Amount of devices to return",
+                        "description": "Only return alerts about this device",
+                        "name": "device_id",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "device_offline",
+                            "device_auth_failures"
+                        ],
+                        "type": "string",
+                        "description": "Only return alerts of this type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "maximum": 100,
+                        "type": "integer",
+                        "default": 50,
+                        "description": "Amount of alerts to return",
                         "name": "limit",
                         "in": "query"
                     },
@@ -45,15 +74,9 @@ const docTemplate = `{
                         "minimum": 0,
                         "type": "integer",
                         "default": 0,
-                        "description": "How much devices to skip before starting to return devices",
+                        "description": "How much alerts to skip before starting to return alerts",
                         "name": "offset",
                         "in": "query"
-                    },
-                    {
-                        "type": "boolean",
-                        "description": "Only return devices with this lease status",
-                        "name": "leased",
-                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -70,7 +93,7 @@ const docTemplate = `{
                                         "data": {
                                             "type": "array",
                                             "items": {
-                                                "$ref": "#/definitions/handlers.DeviceInfo"
+                                                "$ref": "#/definitions/handlers.AlertInfo"
                                             }
                                         }
                                     }
@@ -78,6 +101,12 @@ const docTemplate = `{
                             ]
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
@@ -99,9 +128,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/device/register": {
+        "/alert/{id}/acknowledge": {
             "post": {
-                "description": "Register a new device using the registration pin",
+                "description": "Mark an alert as seen, so other admins know somebody is looking into it.\nAcknowledging does not resolve the alert, except for authentication failure alerts which are not resolved otherwise.\nAcknowledging it again keeps the first acknowledgement.\nRequires permission ` + "`" + `alert:write` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -109,18 +138,16 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "device requiresAuth requiresAdmin"
+                    "alert requiresAuth requiresPermission"
                 ],
-                "summary": "Register a new device",
+                "summary": "Acknowledge an alert",
                 "parameters": [
                     {
-                        "description": "Registration pin\n` + "`" + `pin` + "`" + `: 4 digit registration pin recieved by the device via websocket API",
-                        "name": "registration_data",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/handlers.PostDeviceRegisterBody"
-                        }
+                        "type": "string",
+                        "description": "Alert ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
@@ -135,69 +162,29 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/handlers.PostDeviceRegisterResponse"
+                                            "$ref": "#/definitions/handlers.AlertInfo"
                                         }
                                     }
                                 }
                             ]
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
-                        "schema": {
-                            "$ref": "#/definitions/apiResponses.InternalServerError"
-                        }
-                    }
-                }
-            }
-        },
-        "/device/relink": {
-            "post": {
-                "description": "Relink a device using the registration pin. WARNING: This will generate a new auth token for the device.",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "device requiresAuth requiresAdmin"
-                ],
-                "summary": "Relink a device to an old database entry",
-                "parameters": [
-                    {
-                        "description": "Registration pin and device ID\n` + "`" + `pin` + "`" + `: 4 digit registration pin recieved by the device via websocket API",
-                        "name": "registration_data",
-                        "in": "body",
-                        "required": true,
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "$ref": "#/definitions/handlers.PostDeviceRelinkBody"
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
                         }
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
-                            "allOf": [
-                                {
-                                    "$ref": "#/definitions/apiResponses.BaseResponse"
-                                },
-                                {
-                                    "type": "object",
-                                    "properties": {
-                                        "data": {
-                                            "$ref": "#/definitions/handlers.PostDeviceRegisterResponse"
-                                        }
-                                    }
-                                }
-                            ]
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
                         }
                     },
                     "404": {
@@ -215,9 +202,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/device/{id}": {
+        "/audit": {
             "get": {
-                "description": "Get info about a device by using its id or room",
+                "description": "Get who did administrative and security relevant actions, like deleting devices, changing roles and logging in, newest first.\nEvents are kept for JANITOR_AUDIT_RETENTION and can not be changed.\nRequires permission ` + "`" + `audit:read` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -225,26 +212,69 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "device requiresAuth requiresAdmin"
+                    "audit requiresAuth requiresPermission"
                 ],
-                "summary": "Get device by id",
+                "summary": "Get the audit log",
                 "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Only return events of actions by this user",
+                        "name": "actor_id",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
-                        "description": "Device ID or Room",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "example": "device.delete",
+                        "description": "Only return events of this action",
+                        "name": "action",
+                        "in": "query"
                     },
                     {
                         "enum": [
-                            "\"id\"",
-                            "\"room\""
+                            "user",
+                            "device",
+                            "session",
+                            "personal_token"
                         ],
                         "type": "string",
-                        "default": "\"id\"",
-                        "description": "Specify identifier type",
-                        "name": "type",
+                        "description": "Only return events about this kind of target",
+                        "name": "target_type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only return events about the target with this ID",
+                        "name": "target_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Only return events after this time (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Only return events before this time (RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "maximum": 100,
+                        "type": "integer",
+                        "default": 50,
+                        "description": "Amount of events to return",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "minimum": 0,
+                        "type": "integer",
+                        "default": 0,
+                        "description": "How much events to skip before starting to return events",
+                        "name": "offset",
                         "in": "query"
                     }
                 ],
@@ -260,13 +290,22 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/handlers.DeviceInfo"
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.AuditEventInfo"
+                                            }
                                         }
                                     }
                                 }
                             ]
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
@@ -279,12 +318,6 @@ const docTemplate = `{
                             "$ref": "#/definitions/apiResponses.ForbiddenError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
-                        "schema": {
-                            "$ref": "#/definitions/apiResponses.NotFoundError"
-                        }
-                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -292,44 +325,76 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Delete a device from the database by using its id or room. The websocket connection, if present, will also be terminated.",
-                "consumes": [
-                    "application/json"
-                ],
+            }
+        },
+        "/audit/export": {
+            "get": {
+                "description": "Download all audit events matching the filters as CSV, oldest first. Takes the same filters as GET /audit.\nThe details column holds the details of the event as JSON.\nRequires permission ` + "`" + `audit:read` + "`" + `",
                 "produces": [
-                    "application/json"
+                    "text/csv"
                 ],
                 "tags": [
-                    "device requiresAuth requiresAdmin"
+                    "audit requiresAuth requiresPermission"
                 ],
-                "summary": "Delete device by id",
+                "summary": "Export the audit log",
                 "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Only export events of actions by this user",
+                        "name": "actor_id",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
-                        "description": "Device ID or Room",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "example": "device.delete",
+                        "description": "Only export events of this action",
+                        "name": "action",
+                        "in": "query"
                     },
                     {
                         "enum": [
-                            "\"id\"",
-                            "\"room\""
+                            "user",
+                            "device",
+                            "session",
+                            "personal_token"
                         ],
                         "type": "string",
-                        "default": "\"id\"",
-                        "description": "Specify identifier type",
-                        "name": "type",
+                        "description": "Only export events about this kind of target",
+                        "name": "target_type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only export events about the target with this ID",
+                        "name": "target_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Only export events after this time (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Only export events before this time (RFC3339)",
+                        "name": "to",
                         "in": "query"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content",
+                    "200": {
+                        "description": "id,occurred_at,actor_id,actor_email,impersonator_id,impersonator_email,action,target_type,target_id,ip_address,details",
                         "schema": {
-                            "$ref": "#/definitions/apiResponses.BaseBase"
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
                         }
                     },
                     "401": {
@@ -344,12 +409,6 @@ const docTemplate = `{
                             "$ref": "#/definitions/apiResponses.ForbiddenError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
-                        "schema": {
-                            "$ref": "#/definitions/apiResponses.NotFoundError"
-                        }
-                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -359,36 +418,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/login": {
-            "get": {
-                "description": "Redirect to the google OAuth endpoint",
-                "tags": [
-                    "auth"
-                ],
-                "summary": "Login with google OAuth",
-                "responses": {
-                    "302": {
-                        "description": "Found"
-                    }
-                }
-            }
-        },
-        "/logout": {
-            "get": {
-                "description": "Invalidate the session token",
-                "tags": [
-                    "auth requiresAuth"
-                ],
-                "summary": "Logout",
-                "responses": {
-                    "302": {
-                        "description": "Found"
-                    }
-                }
-            }
-        },
-        "/me": {
+        "/device": {
             "get": {
+                "description": "Get DeviceInfo about all devices\nRequires permission ` + "`" + `device:read` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -396,22 +428,61 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "user requiresAuth"
+                    "device requiresAuth requiresPermission"
                 ],
-                "summary": "Get UserInfo about current authenticated user",
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "allOf": [
-                                {
-                                    "$ref": "#/definitions/apiResponses.BaseResponse"
-                                },
-                                {
-                                    "type": "object",
-                                    "properties": {
+                "summary": "Get all devices",
+                "parameters": [
+                    {
+                        "maximum": 20,
+                        "type": "integer",
+                        "default": 20,
+                        "description": "Amount of devices to return",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "minimum": 0,
+                        "type": "integer",
+                        "default": 0,
+                        "description": "How much devices to skip before starting to return devices",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only return devices with this lease status",
+                        "name": "leased",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only return devices that are a member of this device group",
+                        "name": "group",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only return devices with this tag",
+                        "name": "tag",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/handlers.UserInfo"
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.DeviceInfo"
+                                            }
                                         }
                                     }
                                 }
@@ -424,6 +495,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/apiResponses.UnauthorizedError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -433,23 +510,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/oauth2callback": {
-            "get": {
-                "description": "The callback url for google OAuth that processes the things. Can not be used indivualy (requires google OAuth code)\nRefer too google OAuth docs for more information.",
-                "tags": [
-                    "auth"
-                ],
-                "summary": "Callback url for google OAuth",
-                "responses": {
-                    "302": {
-                        "description": "Found"
-                    }
-                }
-            }
-        },
-        "/session": {
+        "/device-group": {
             "get": {
-                "description": "Get all sessions owned by the current user or for all users if acting as admin",
+                "description": "Get all device groups and the ids of their members\nRequires permission ` + "`" + `device_group:read` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -457,44 +520,9 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "session requiresAuth supportsAdmin"
-                ],
-                "summary": "Get sessions owned by the current user or all users",
-                "parameters": [
-                    {
-                        "enum": [
-                            0,
-                            1
-                        ],
-                        "type": "integer",
-                        "default": 0,
-                        "description": "Try to act as this role (will cause 403 if you do not have this role)",
-                        "name": "asRole",
-                        "in": "query"
-                    },
-                    {
-                        "maximum": 20,
-                        "type": "integer",
-                        "default": 20,
-                        "description": "Amount of sessions to return",
-                        "name": "limit",
-                        "in": "query"
-                    },
-                    {
-                        "minimum": 0,
-                        "type": "integer",
-                        "default": 0,
-                        "description": "How much sessions to skip before starting to return sessions",
-                        "name": "offset",
-                        "in": "query"
-                    },
-                    {
-                        "type": "integer",
-                        "description": "Only return sessions that use this question",
-                        "name": "questionID",
-                        "in": "query"
-                    }
+                    "device requiresAuth requiresPermission"
                 ],
+                "summary": "Get all device groups",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -509,7 +537,7 @@ const docTemplate = `{
                                         "data": {
                                             "type": "array",
                                             "items": {
-                                                "$ref": "#/definitions/handlers.SessionInfo"
+                                                "$ref": "#/definitions/handlers.DeviceGroupInfo"
                                             }
                                         }
                                     }
@@ -538,7 +566,7 @@ const docTemplate = `{
                 }
             },
             "post": {
-                "description": "Any user can POST this endpoint to start a session if they dont have an active session",
+                "description": "Create an empty device group, add devices with POST ` + "`" + `/device-group/{id}/members` + "`" + `\nRequires permission ` + "`" + `device_group:write` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -546,23 +574,23 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "session requiresAuth"
+                    "device requiresAuth requiresPermission"
                 ],
-                "summary": "Start a new session if no active one is present",
+                "summary": "Create a device group",
                 "parameters": [
                     {
-                        "description": "device id and question to use for the session\n` + "`" + `device_id` + "`" + `: Id of the device to start the session on.\n` + "`" + `question` + "`" + `: Question to start the session id with. If identical question already exists in the database, it is used. If it doesnt exist a new entry is created.",
-                        "name": "session_info",
+                        "description": "` + "`" + `name` + "`" + `: Unique name of the group, e.g. \\",
+                        "name": "group",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handlers.PostSessionBody"
+                            "$ref": "#/definitions/handlers.PostDeviceGroupBody"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
                             "allOf": [
                                 {
@@ -572,7 +600,7 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/handlers.SessionInfo"
+                                            "$ref": "#/definitions/handlers.DeviceGroupInfo"
                                         }
                                     }
                                 }
@@ -591,8 +619,14 @@ const docTemplate = `{
                             "$ref": "#/definitions/apiResponses.UnauthorizedError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
                     "409": {
-                        "description": "User already has an active session",
+                        "description": "A group with this name already exists",
                         "schema": {
                             "$ref": "#/definitions/apiResponses.ConflictError"
                         }
@@ -602,19 +636,13 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/apiResponses.InternalServerError"
                         }
-                    },
-                    "503": {
-                        "description": "Requested device is not available",
-                        "schema": {
-                            "$ref": "#/definitions/apiResponses.ServiceUnavailableError"
-                        }
                     }
                 }
             }
         },
-        "/session/current": {
+        "/device-group/{id}": {
             "get": {
-                "description": "Any user can query this endpoint for their own session",
+                "description": "Get a device group and the ids of its members\nRequires permission ` + "`" + `device_group:read` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -622,9 +650,18 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "session requiresAuth supportsAdmin"
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Get device group by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device group ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Get your current session",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -637,13 +674,19 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/handlers.SessionInfo"
+                                            "$ref": "#/definitions/handlers.DeviceGroupInfo"
                                         }
                                     }
                                 }
                             ]
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
@@ -669,11 +712,9 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/session/stop": {
-            "post": {
-                "description": "Any user can POST this endpoint to stop their own session.\nMight be moved to PATCH ` + "`" + `/session` + "`" + `",
+            },
+            "delete": {
+                "description": "Delete a device group, its members are not deleted. Use POST ` + "`" + `/device-group/{id}/delete` + "`" + ` to delete the members.\nRequires permission ` + "`" + `device_group:write` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -681,30 +722,45 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "session requiresAuth"
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Delete a device group",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device group ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Stop your own sesssion",
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "204": {
+                        "description": "No Content",
                         "schema": {
-                            "allOf": [
-                                {
-                                    "$ref": "#/definitions/apiResponses.BaseResponse"
-                                },
-                                {
-                                    "type": "object",
-                                    "properties": {
-                                        "data": {
-                                            "$ref": "#/definitions/handlers.SessionInfo"
-                                        }
-                                    }
-                                }
-                            ]
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
                         }
                     },
                     "404": {
-                        "description": "no current session",
+                        "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/apiResponses.NotFoundError"
                         }
@@ -716,11 +772,9 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/session/{id}": {
-            "get": {
-                "description": "Any user can query this endpoint for their own sessions\nPrivileged users can add ` + "`" + `asRole=1` + "`" + ` query parameter to act with their privileges",
+            },
+            "patch": {
+                "description": "Rename a device group or change its description, fields that are left out stay the same\nRequires permission ` + "`" + `device_group:write` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -728,27 +782,25 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "session requiresAuth supportsAdmin"
+                    "device requiresAuth requiresPermission"
                 ],
-                "summary": "Get sessions by id if owner or acting as admin",
+                "summary": "Update a device group",
                 "parameters": [
-                    {
-                        "enum": [
-                            0,
-                            1
-                        ],
-                        "type": "integer",
-                        "default": 0,
-                        "description": "Try to act as this role (will cause 403 if you do not have this role)",
-                        "name": "asRole",
-                        "in": "query"
-                    },
                     {
                         "type": "string",
-                        "description": "Id of the session",
+                        "description": "Device group ID",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "` + "`" + `name` + "`" + `: New unique name\n` + "`" + `description` + "`" + `: New description",
+                        "name": "group",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PatchDeviceGroupBody"
+                        }
                     }
                 ],
                 "responses": {
@@ -763,16 +815,19 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "type": "array",
-                                            "items": {
-                                                "$ref": "#/definitions/handlers.SessionInfo"
-                                            }
+                                            "$ref": "#/definitions/handlers.DeviceGroupInfo"
                                         }
                                     }
                                 }
                             ]
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
@@ -791,6 +846,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/apiResponses.NotFoundError"
                         }
                     },
+                    "409": {
+                        "description": "A group with this name already exists",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ConflictError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -800,9 +861,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/session/{id}/stop": {
+        "/device-group/{id}/command": {
             "post": {
-                "description": "Admins can POST this endpoint to stop any session\nMight be moved to PATCH ` + "`" + `/session/{id}` + "`" + `",
+                "description": "Send a custom websocket command to every member of the group.\nCommands that are part of the websocket protocol itself, like ` + "`" + `session_start` + "`" + `, are rejected.\nRequires permission ` + "`" + `device_group:write` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -810,16 +871,25 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "session requiresAuth requiresAdmin"
+                    "device requiresAuth requiresPermission"
                 ],
-                "summary": "Stop a session with specific id",
+                "summary": "Send a command to all members of a device group",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Session id of the session to stop",
+                        "description": "Device group ID",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "` + "`" + `command` + "`" + `: Value of the ` + "`" + `c` + "`" + ` field\n` + "`" + `data` + "`" + `: Value of the ` + "`" + `d` + "`" + ` field",
+                        "name": "command",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostDeviceGroupCommandBody"
+                        }
                     }
                 ],
                 "responses": {
@@ -834,13 +904,22 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/handlers.SessionInfo"
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.BulkActionResult"
+                                            }
                                         }
                                     }
                                 }
                             ]
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
@@ -868,9 +947,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/user": {
-            "get": {
-                "description": "Get UserInfo about all users",
+        "/device-group/{id}/config": {
+            "post": {
+                "description": "Send a ` + "`" + `device_config` + "`" + ` websocket command with the config as data to every member of the group.\nMembers that are not connected are reported as failed, the config is not stored for later.\nRequires permission ` + "`" + `device_group:write` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -878,35 +957,25 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "user requiresAuth requiresAdmin"
+                    "device requiresAuth requiresPermission"
                 ],
-                "summary": "Get all users",
+                "summary": "Push config to all members of a device group",
                 "parameters": [
                     {
-                        "maximum": 20,
-                        "type": "integer",
-                        "default": 20,
-                        "description": "Amount of users to return",
-                        "name": "limit",
-                        "in": "query"
-                    },
-                    {
-                        "minimum": 0,
-                        "type": "integer",
-                        "default": 0,
-                        "description": "How much users to skip before starting to return users",
-                        "name": "offset",
-                        "in": "query"
+                        "type": "string",
+                        "description": "Device group ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     },
                     {
-                        "enum": [
-                            0,
-                            1
-                        ],
-                        "type": "integer",
-                        "description": "Only return users with this role",
-                        "name": "role",
-                        "in": "query"
+                        "description": "` + "`" + `config` + "`" + `: Object that is sent to the devices as is",
+                        "name": "config",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostDeviceGroupConfigBody"
+                        }
                     }
                 ],
                 "responses": {
@@ -923,7 +992,7 @@ const docTemplate = `{
                                         "data": {
                                             "type": "array",
                                             "items": {
-                                                "$ref": "#/definitions/handlers.UserInfo"
+                                                "$ref": "#/definitions/handlers.BulkActionResult"
                                             }
                                         }
                                     }
@@ -931,6 +1000,12 @@ const docTemplate = `{
                             ]
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
@@ -943,6 +1018,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/apiResponses.ForbiddenError"
                         }
                     },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -952,9 +1033,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/user/{id}": {
-            "get": {
-                "description": "Get info about a user by using either their id or email",
+        "/device-group/{id}/delete": {
+            "post": {
+                "description": "Delete every member of the group from the database and terminate their websocket connections. The group itself is kept.\nRequires permissions ` + "`" + `device_group:write` + "`" + `, ` + "`" + `device:write` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -962,27 +1043,102 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "user requiresAuth requiresAdmin"
+                    "device requiresAuth requiresPermission"
                 ],
-                "summary": "Get user by id",
+                "summary": "Delete all members of a device group",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "User ID or email",
+                        "description": "Device group ID",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.BulkActionResult"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
                     },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device-group/{id}/members": {
+            "post": {
+                "description": "Add devices to a device group, devices that already are a member are ignored\nRequires permission ` + "`" + `device_group:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Add devices to a device group",
+                "parameters": [
                     {
-                        "enum": [
-                            "\"id\"",
-                            "\"email\""
-                        ],
                         "type": "string",
-                        "default": "\"id\"",
-                        "description": "Specify identifier type",
-                        "name": "type",
-                        "in": "query"
+                        "description": "Device group ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "` + "`" + `device_ids` + "`" + `: Ids of the devices to add",
+                        "name": "members",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostDeviceGroupMembersBody"
+                        }
                     }
                 ],
                 "responses": {
@@ -997,13 +1153,19 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/handlers.UserInfo"
+                                            "$ref": "#/definitions/handlers.DeviceGroupInfo"
                                         }
                                     }
                                 }
                             ]
                         }
                     },
+                    "400": {
+                        "description": "Invalid body or unknown device ids",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
@@ -1031,45 +1193,46 @@ const docTemplate = `{
                 }
             }
         },
-        "/user/{id}/pfp": {
-            "get": {
-                "description": "Get a users profile picture by using either their id or email if authenticated as the user",
+        "/device-group/{id}/members/{device_id}": {
+            "delete": {
+                "description": "Remove a device from a device group, the device itself is not deleted\nRequires permission ` + "`" + `device_group:write` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "application/json",
-                    "image/jpeg"
+                    "application/json"
                 ],
                 "tags": [
-                    "user requiresAuth"
+                    "device requiresAuth requiresPermission"
                 ],
-                "summary": "Get user pfp by id",
+                "summary": "Remove a device from a device group",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "User ID or email",
+                        "description": "Device group ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "enum": [
-                            "\"id\"",
-                            "\"email\""
-                        ],
                         "type": "string",
-                        "default": "\"id\"",
-                        "description": "Specify identifier type",
-                        "name": "type",
-                        "in": "query"
+                        "description": "Device ID",
+                        "name": "device_id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "204": {
+                        "description": "No Content",
                         "schema": {
-                            "type": "file"
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
                         }
                     },
                     "401": {
@@ -1085,7 +1248,7 @@ const docTemplate = `{
                         }
                     },
                     "404": {
-                        "description": "Not Found",
+                        "description": "No such group or the device is not a member",
                         "schema": {
                             "$ref": "#/definitions/apiResponses.NotFoundError"
                         }
@@ -1099,9 +1262,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/v": {
-            "get": {
-                "description": "Get current api name, version and deployment env (prod, dev)",
+        "/device-group/{id}/session": {
+            "post": {
+                "description": "Start a separate session with the same question on every member of the group that is connected and not in a session.\nThese sessions are owned by the current user, but do not count as their current session.\nRequires permissions ` + "`" + `device_group:write` + "`" + `, ` + "`" + `session:write:own` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -1109,9 +1272,27 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "version"
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Start a session on all members of a device group",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device group ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "` + "`" + `question` + "`" + `: Question to start the sessions with",
+                        "name": "session_info",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostDeviceGroupSessionBody"
+                        }
+                    }
                 ],
-                "summary": "Get the api version",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -1124,19 +1305,52 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/handlers.GetVersionSuccessResponse"
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.BulkActionResult"
+                                            }
                                         }
                                     }
                                 }
                             ]
                         }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
                     }
                 }
             }
         },
-        "/ws": {
-            "get": {
-                "description": "Open a websocket connection used by devices to communicate with the server.\nDevices get notified about session changes and send votes via this connection.",
+        "/device-group/{id}/session/stop": {
+            "post": {
+                "description": "Stop the active session of every member of the group, regardless of who started it\nRequires permissions ` + "`" + `device_group:write` + "`" + `, ` + "`" + `session:stop:any` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -1144,266 +1358,4177 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "device_websocket"
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Stop the sessions on all members of a device group",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device group ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Open a connection to the device websocket API",
                 "responses": {
-                    "101": {
-                        "description": "Switching Protocols (WebSocket Upgrade)",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.BulkActionResult"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
                         }
-                    }
-                }
-            }
-        }
-    },
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/auth-failures": {
+            "get": {
+                "description": "Get the failed authentication attempts that are currently tracked per device, per device and remote address and per remote address, including active lockouts.\nRequires permission ` + "`" + `device:read` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Get failed device authentication attempts",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.AuthFailureInfo"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/claim": {
+            "post": {
+                "description": "Register the device that shows the QR code with this claim token, and optionally put it in a room.\nThe QR code links to the confirmation page with the token in the ` + "`" + `token` + "`" + ` query parameter.\nRequires permission ` + "`" + `device:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Claim a device with the token of its QR code",
+                "parameters": [
+                    {
+                        "description": "Claim token\n` + "`" + `token` + "`" + `: claim token recieved by the device in ` + "`" + `reg_pin` + "`" + `\n` + "`" + `room_id` + "`" + `: optional room to put the device in",
+                        "name": "claim_data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostDeviceClaimBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.DeviceInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid or expired claim token, or unknown room",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "409": {
+                        "description": "The room already has a device",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ConflictError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/presence": {
+            "get": {
+                "description": "Server-sent event stream for the admin dashboard.\nStarts with a ` + "`" + `snapshot` + "`" + ` event containing all connected devices and pending registrations of all instances,\nfollowed by a ` + "`" + `presence` + "`" + ` event (PresenceEvent) for every device that connects, disconnects or changes state and every registration pin that is handed out or used.\nRequires permission ` + "`" + `device:read` + "`" + `",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Live feed of device presence",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.PresenceEvent"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/register": {
+            "post": {
+                "description": "Register a new device using the registration pin\nRequires permission ` + "`" + `device:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Register a new device",
+                "parameters": [
+                    {
+                        "description": "Registration pin\n` + "`" + `pin` + "`" + `: 4 digit registration pin recieved by the device via websocket API",
+                        "name": "registration_data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostDeviceRegisterBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.PostDeviceRegisterResponse"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/relink": {
+            "post": {
+                "description": "Relink a device using the registration pin. WARNING: This will generate a new auth token for the device.\nRequires permission ` + "`" + `device:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Relink a device to an old database entry",
+                "parameters": [
+                    {
+                        "description": "Registration pin and device ID\n` + "`" + `pin` + "`" + `: 4 digit registration pin recieved by the device via websocket API",
+                        "name": "registration_data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostDeviceRelinkBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.PostDeviceRegisterResponse"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/{id}": {
+            "get": {
+                "description": "Get info about a device by using its id or room\nRequires permission ` + "`" + `device:read` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Get device by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID or Room",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "\"id\"",
+                            "\"room\""
+                        ],
+                        "type": "string",
+                        "default": "\"id\"",
+                        "description": "Specify identifier type",
+                        "name": "type",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.DeviceInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a device from the database by using its id or room. The websocket connection, if present, will also be terminated.\nRequires permission ` + "`" + `device:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Delete device by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID or Room",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "\"id\"",
+                            "\"room\""
+                        ],
+                        "type": "string",
+                        "default": "\"id\"",
+                        "description": "Specify identifier type",
+                        "name": "type",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Move a device to a room or change its display name, notes or location. Fields that are left out stay the same.\nA room can only hold one device. Sessions keep the room they took place in when the device moves.\nRequires permission ` + "`" + `device:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Update device metadata",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "` + "`" + `room_id` + "`" + `: Room to move the device to, 0 removes it from its room\n` + "`" + `display_name` + "`" + `: Name to show instead of the id, empty removes it\n` + "`" + `notes` + "`" + `: Free form notes\n` + "`" + `location` + "`" + `: Location within the room, e.g. \\",
+                        "name": "metadata",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PatchDeviceBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.DeviceInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "409": {
+                        "description": "The room already has a device",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ConflictError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/{id}/events": {
+            "get": {
+                "description": "Get connects, authentication results and disconnects (with reason) of a device, newest first\nRequires permission ` + "`" + `device:read` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Get the connection history of a device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "maximum": 100,
+                        "type": "integer",
+                        "default": 20,
+                        "description": "Amount of events to return",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "minimum": 0,
+                        "type": "integer",
+                        "default": 0,
+                        "description": "How much events to skip before starting to return events",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "connect",
+                            "auth_success",
+                            "auth_failure",
+                            "disconnect"
+                        ],
+                        "type": "string",
+                        "description": "Only return events of this type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Only return events after this time (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Only return events before this time (RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.DeviceEventInfo"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/{id}/tags": {
+            "put": {
+                "description": "Replace all tags of a device, e.g. \"floor 2\" or \"science wing\". An empty list removes all tags.\nRequires permission ` + "`" + `device:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Set the tags of a device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "` + "`" + `tags` + "`" + `: All tags the device should have",
+                        "name": "tags",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PutDeviceTagsBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.DeviceInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/{id}/token/rotate": {
+            "post": {
+                "description": "Issue a new token to a connected device over its authenticated websocket connection.\nThe previous token stays valid during the rotation grace period or until the device acknowledges the new token by signing the nonce sent with it.\nA token can not be rotated again until then.\nRequires permission ` + "`" + `device:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Rotate the token of a device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "409": {
+                        "description": "The previous rotation is not acknowledged yet",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ConflictError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    },
+                    "503": {
+                        "description": "Device is not connected",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ServiceUnavailableError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/{id}/uptime": {
+            "get": {
+                "description": "Get the percentage of time a device was connected and authenticated during a period. Defaults to the last 7 days.\nRequires permission ` + "`" + `device:read` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device requiresAuth requiresPermission"
+                ],
+                "summary": "Get the uptime of a device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "Start of the period (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "End of the period (RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.DeviceUptimeInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/impersonation/stop": {
+            "post": {
+                "description": "End the impersonation session and log back in with the session of the admin that started it.\nAlso works after the impersonation expired, so the admin does not have to log in again.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Stop impersonating a user",
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "400": {
+                        "description": "Not impersonating",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/login/dev": {
+            "get": {
+                "description": "A page to log in as any user without a login provider, or to create a user with any role.\nOnly available in development with AUTH_DEV_LOGIN, ` + "`" + `/login` + "`" + ` then redirects here instead of to the first provider.",
+                "produces": [
+                    "text/html"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Development login page",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "default": "/",
+                        "description": "Where to redirect after logging in",
+                        "name": "return_to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Log in as the user with ` + "`" + `email` + "`" + `, the user is created when it does not exist yet. When ` + "`" + `role` + "`" + ` is given the user\ngets that role. Creates a normal session, like logging in with a provider does, and redirects to ` + "`" + `return_to` + "`" + `.\nOnly available in development with AUTH_DEV_LOGIN.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Log in without a login provider",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Email address of the user",
+                        "name": "email",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name of a new user, defaults to the email address",
+                        "name": "name",
+                        "in": "formData"
+                    },
+                    {
+                        "enum": [
+                            "teacher",
+                            "department_head",
+                            "it_admin",
+                            "auditor",
+                            "admin"
+                        ],
+                        "type": "string",
+                        "description": "Role of the user",
+                        "name": "role",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "default": "/",
+                        "description": "Where to redirect after logging in",
+                        "name": "return_to",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "303": {
+                        "description": "See Other"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/login/providers": {
+            "get": {
+                "description": "Get the OpenID Connect providers users can log in with, in the configured order\nWith AUTH_DEV_LOGIN the development login comes first, with id ` + "`" + `dev` + "`" + `",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Get login providers",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.LoginProviderInfo"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        },
+        "/login/{provider}": {
+            "get": {
+                "description": "Redirect to the login page of a provider, ` + "`" + `/login` + "`" + ` uses the first configured provider\nor the development login page when AUTH_DEV_LOGIN is enabled\nAfter logging in the user is redirected to ` + "`" + `return_to` + "`" + `, which is a path on this server or an url on one of the LOGIN_RETURN_TO_ORIGINS.",
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Login with an OpenID Connect provider",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider ID, see ` + "`" + `/login/providers` + "`" + `",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "default": "/",
+                        "description": "Where to redirect after logging in",
+                        "name": "return_to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "302": {
+                        "description": "Found"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/logout": {
+            "get": {
+                "description": "Invalidate the session token. Logging out while impersonating also logs out the admin.",
+                "tags": [
+                    "auth requiresAuth"
+                ],
+                "summary": "Logout",
+                "responses": {
+                    "302": {
+                        "description": "Found"
+                    },
+                    "400": {
+                        "description": "Authenticated with a personal access token",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    }
+                }
+            }
+        },
+        "/me": {
+            "get": {
+                "description": "When an admin is impersonating the user, ` + "`" + `impersonation` + "`" + ` says who and until when",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth"
+                ],
+                "summary": "Get UserInfo about current authenticated user",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.MeInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Change the display name and default question of the current user, fields that are not given are not changed.\nCan not be used with a personal access token or while impersonating.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth"
+                ],
+                "summary": "Edit the current user",
+                "parameters": [
+                    {
+                        "description": "` + "`" + `display_name` + "`" + `: Name shown to others\n` + "`" + `default_question` + "`" + `: Question new sessions start with",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PatchMeBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.UserInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Authenticated with a personal access token or impersonating",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/sessions": {
+            "get": {
+                "description": "Get the active login sessions of the current user, newest first. The session of the request has ` + "`" + `current` + "`" + ` set.\nCan not be used with a personal access token or while impersonating.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth"
+                ],
+                "summary": "Get the login sessions of the current user",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.AuthSessionInfo"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Authenticated with a personal access token or impersonating",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "End all login sessions of the current user, including the current one unless ` + "`" + `keep_current` + "`" + ` is set.\nPersonal access tokens are not revoked, see ` + "`" + `/me/tokens` + "`" + `. Can not be used with a personal access token or while impersonating.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth"
+                ],
+                "summary": "Log out everywhere",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "default": false,
+                        "description": "Stay logged in with the session of this request",
+                        "name": "keep_current",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Authenticated with a personal access token or impersonating",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/sessions/{id}": {
+            "delete": {
+                "description": "End a login session of the current user, e.g. on a computer that was left logged in.\nCan not be used with a personal access token or while impersonating.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth"
+                ],
+                "summary": "End a login session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Login session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Authenticated with a personal access token or impersonating",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/tokens": {
+            "get": {
+                "description": "Get the personal access tokens of the current user, newest first. Revoked tokens are not returned.\nCan not be used with a personal access token or while impersonating.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth"
+                ],
+                "summary": "Get the personal access tokens of the current user",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.PersonalTokenInfo"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Authenticated with a personal access token or impersonating",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a personal access token for scripts, send it as ` + "`" + `Authorization: Bearer \u003ctoken\u003e` + "`" + `.\nThe token is only returned once. It is limited to its scopes and to the permissions of your role at the time it is used.\nCan not be used with a personal access token or while impersonating.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth"
+                ],
+                "summary": "Create a personal access token",
+                "parameters": [
+                    {
+                        "description": "` + "`" + `name` + "`" + `: Name to recognise the token by\n` + "`" + `scopes` + "`" + `: Permissions the token can use, your role needs to have them\n` + "`" + `expires_at` + "`" + `: Optional expiry date, defaults to PERSONAL_TOKEN_DEFAULT_LIFETIME from now and can be at most PERSONAL_TOKEN_MAX_LIFETIME from now",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostMeTokensBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.CreatedPersonalTokenInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Authenticated with a personal access token or impersonating, or a scope is missing from your role",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/tokens/{id}": {
+            "delete": {
+                "description": "Revoke a personal access token of the current user, it can not be used anymore.\nCan not be used with a personal access token or while impersonating.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth"
+                ],
+                "summary": "Revoke a personal access token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Authenticated with a personal access token or impersonating",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth2callback/{provider}": {
+            "get": {
+                "description": "The callback url the login page of a provider redirects back to. Can not be used indivualy (requires an authorization code\nand the login state cookie of the browser that started the login)\nA user is created on the first login, users are linked to the provider account they logged in with.\nRejected logins are redirected to LOGIN_ERROR_URL with the ` + "`" + `reason` + "`" + ` and ` + "`" + `provider` + "`" + ` in the query, the reason is one of\n` + "`" + `provider_error` + "`" + `, ` + "`" + `invalid_state` + "`" + `, ` + "`" + `no_email` + "`" + `, ` + "`" + `email_not_verified` + "`" + `, ` + "`" + `domain_not_allowed` + "`" + `, ` + "`" + `not_invited` + "`" + `, ` + "`" + `email_taken` + "`" + `, ` + "`" + `account_deleted` + "`" + ` or ` + "`" + `account_deactivated` + "`" + `.\nThe ` + "`" + `google` + "`" + ` provider uses ` + "`" + `/oauth2callback` + "`" + ` without provider ID, the callback url from before other providers were supported.",
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Callback url for OpenID Connect providers",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider ID",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "302": {
+                        "description": "Found"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/room": {
+            "get": {
+                "description": "Get all rooms and the ids of the devices in them\nRequires permission ` + "`" + `room:read` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "room requiresAuth requiresPermission"
+                ],
+                "summary": "Get all rooms",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only return rooms in this building",
+                        "name": "building",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.RoomInfo"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a room, move devices into it with PATCH ` + "`" + `/device/{id}` + "`" + `\nRequires permission ` + "`" + `room:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "room requiresAuth requiresPermission"
+                ],
+                "summary": "Create a room",
+                "parameters": [
+                    {
+                        "description": "` + "`" + `name` + "`" + `: Unique name of the room, also used by ` + "`" + `/device/{id}?type=room` + "`" + `\n` + "`" + `building` + "`" + `: Optional building\n` + "`" + `floor` + "`" + `: Optional floor\n` + "`" + `capacity` + "`" + `: Optional amount of people that fit in the room",
+                        "name": "room",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostRoomBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.RoomInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "409": {
+                        "description": "A room with this name already exists",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ConflictError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/room/{id}": {
+            "get": {
+                "description": "Get a room and the ids of the devices in it\nRequires permission ` + "`" + `room:read` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "room requiresAuth requiresPermission"
+                ],
+                "summary": "Get room by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.RoomInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a room, devices in it are left without a room. Sessions that took place in the room keep referring to it.\nRequires permission ` + "`" + `room:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "room requiresAuth requiresPermission"
+                ],
+                "summary": "Delete a room",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Change the name, building, floor or capacity of a room, fields that are left out stay the same\nRequires permission ` + "`" + `room:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "room requiresAuth requiresPermission"
+                ],
+                "summary": "Update a room",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "` + "`" + `name` + "`" + `: New unique name\n` + "`" + `building` + "`" + `: New building\n` + "`" + `floor` + "`" + `: New floor\n` + "`" + `capacity` + "`" + `: New capacity",
+                        "name": "room",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PatchRoomBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.RoomInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "409": {
+                        "description": "A room with this name already exists",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ConflictError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/session": {
+            "get": {
+                "description": "Get the sessions in a scope, newest first:\n` + "`" + `own` + "`" + ` sessions of the current user, needs ` + "`" + `session:read:own` + "`" + `\n` + "`" + `section` + "`" + ` sessions of all users in the section of the current user, needs ` + "`" + `session:read:section` + "`" + `\n` + "`" + `any` + "`" + ` sessions of all users, needs ` + "`" + `session:read:any` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "session requiresAuth"
+                ],
+                "summary": "Get sessions owned by the current user or other users",
+                "parameters": [
+                    {
+                        "enum": [
+                            "own",
+                            "section",
+                            "any"
+                        ],
+                        "type": "string",
+                        "description": "Whose sessions to return, defaults to own if you have permission to read your own sessions",
+                        "name": "scope",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only return sessions owned by this user, ignored in the own scope",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "maximum": 20,
+                        "type": "integer",
+                        "default": 20,
+                        "description": "Amount of sessions to return",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "minimum": 0,
+                        "type": "integer",
+                        "default": 0,
+                        "description": "How much sessions to skip before starting to return sessions",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only return sessions that use this question",
+                        "name": "questionID",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only return sessions that took place in this room",
+                        "name": "roomID",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.SessionInfo"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Missing the permission for the scope",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Any user can POST this endpoint to start a session if they dont have an active session\nRequires permission ` + "`" + `session:write:own` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "session requiresAuth requiresPermission"
+                ],
+                "summary": "Start a new session if no active one is present",
+                "parameters": [
+                    {
+                        "description": "device id and question to use for the session\n` + "`" + `device_id` + "`" + `: Id of the device to start the session on.\n` + "`" + `question` + "`" + `: Question to start the session id with. If identical question already exists in the database, it is used. If it doesnt exist a new entry is created.",
+                        "name": "session_info",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostSessionBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.SessionInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "409": {
+                        "description": "User already has an active session",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ConflictError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    },
+                    "503": {
+                        "description": "Requested device is not available",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ServiceUnavailableError"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/current": {
+            "get": {
+                "description": "Any user can query this endpoint for their own session",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "session requiresAuth"
+                ],
+                "summary": "Get your current session",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.SessionInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/stop": {
+            "post": {
+                "description": "Any user can POST this endpoint to stop their own session.\nMight be moved to PATCH ` + "`" + `/session` + "`" + `\nRequires permission ` + "`" + `session:write:own` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "session requiresAuth requiresPermission"
+                ],
+                "summary": "Stop your own sesssion",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.SessionInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "404": {
+                        "description": "no current session",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/{id}": {
+            "get": {
+                "description": "Get a session you are allowed to read: your own with ` + "`" + `session:read:own` + "`" + `,\none of a user in your section with ` + "`" + `session:read:section` + "`" + ` or any with ` + "`" + `session:read:any` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "session requiresAuth"
+                ],
+                "summary": "Get session by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Id of the session",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.SessionInfo"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/session/{id}/stop": {
+            "post": {
+                "description": "Admins can POST this endpoint to stop any session\nMight be moved to PATCH ` + "`" + `/session/{id}` + "`" + `\nRequires permission ` + "`" + `session:stop:any` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "session requiresAuth requiresPermission"
+                ],
+                "summary": "Stop a session with specific id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session id of the session to stop",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.SessionInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user": {
+            "get": {
+                "description": "Get UserInfo about all users\nRequires permission ` + "`" + `user:read:any` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth requiresPermission"
+                ],
+                "summary": "Get all users",
+                "parameters": [
+                    {
+                        "maximum": 20,
+                        "type": "integer",
+                        "default": 20,
+                        "description": "Amount of users to return",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "minimum": 0,
+                        "type": "integer",
+                        "default": 0,
+                        "description": "How much users to skip before starting to return users",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "teacher",
+                            "department_head",
+                            "it_admin",
+                            "auditor",
+                            "admin"
+                        ],
+                        "type": "string",
+                        "description": "Only return users with this role",
+                        "name": "role",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only return users in this section",
+                        "name": "section",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.UserInfo"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a user that can log in with any provider that shares the same, verified, email address.\nWith LOGIN_INVITE_ONLY only invited users can log in.\nRequires permission ` + "`" + `user:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth requiresPermission"
+                ],
+                "summary": "Invite a user",
+                "parameters": [
+                    {
+                        "description": "` + "`" + `email` + "`" + `: Email address the user logs in with\n` + "`" + `name` + "`" + `: Optional name, without it the name at the provider is used\n` + "`" + `role` + "`" + `: Optional role, defaults to the role LOGIN_ROLE_RULES give the email address\n` + "`" + `section` + "`" + `: Optional section",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostUserBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.UserInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "409": {
+                        "description": "A user with this email address already exists",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ConflictError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{id}": {
+            "get": {
+                "description": "Get info about a user by using either their id or email\nRequires permission ` + "`" + `user:read:any` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth requiresPermission"
+                ],
+                "summary": "Get user by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID or email",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "\"id\"",
+                            "\"email\""
+                        ],
+                        "type": "string",
+                        "default": "\"id\"",
+                        "description": "Specify identifier type",
+                        "name": "type",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.UserInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a user, e.g. a teacher who left. Their access is revoked like when they are deactivated.\nWith ` + "`" + `session_data=keep` + "`" + ` the sessions of the user keep referring to them, and they can not log in or be invited again.\nWith ` + "`" + `session_data=anonymise` + "`" + ` their email address, name and profile picture are removed and their login is unlinked,\nso their sessions are only counted in statistics. They can log in or be invited again as a new user.\nYou can not delete yourself.\nRequires permission ` + "`" + `user:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth requiresPermission"
+                ],
+                "summary": "Delete a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "keep",
+                            "anonymise"
+                        ],
+                        "type": "string",
+                        "description": "What to do with the sessions of the user",
+                        "name": "session_data",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Change the role, display name, section or active flag of a user, fields that are not given are not changed.\nDeactivating a user ends their login sessions, revokes their personal access tokens and stops their running sessions.\nYou can not change your own role or deactivate yourself.\nRequires permission ` + "`" + `user:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth requiresPermission"
+                ],
+                "summary": "Edit a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "` + "`" + `role` + "`" + `: New role\n` + "`" + `display_name` + "`" + `: Name shown to others\n` + "`" + `section` + "`" + `: Department of the user, empty to remove\n` + "`" + `active` + "`" + `: false to deactivate the user, true to activate them again",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PatchUserBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.UserInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{id}/impersonate": {
+            "post": {
+                "description": "Log in as a user to see what they see, e.g. to help a teacher with a problem. The session cookie is replaced by\na session of the user that ends after AUTH_IMPERSONATION_TTL and is not renewed, the session of the admin is kept\nin another cookie until the impersonation is stopped with POST /impersonation/stop.\nOnly GET requests can be made while impersonating unless ` + "`" + `allow_write` + "`" + ` is set. ` + "`" + `/me` + "`" + ` shows the impersonation,\nand the start, the end and every audited action during the impersonation are written to the audit log.\nAdmins and deactivated users can not be impersonated. Can not be used with a personal access token.\nRequires permission ` + "`" + `user:impersonate` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth requiresPermission"
+                ],
+                "summary": "Impersonate a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "` + "`" + `reason` + "`" + `: Why the user is impersonated, written to the audit log\n` + "`" + `allow_write` + "`" + `: Allow changes as the user",
+                        "name": "impersonation",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PostUserImpersonateBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.ImpersonationInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{id}/pfp": {
+            "get": {
+                "description": "Get a users profile picture by using either their id or email if authenticated as the user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "image/jpeg"
+                ],
+                "tags": [
+                    "user requiresAuth"
+                ],
+                "summary": "Get user pfp by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID or email",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "\"id\"",
+                            "\"email\""
+                        ],
+                        "type": "string",
+                        "default": "\"id\"",
+                        "description": "Specify identifier type",
+                        "name": "type",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{id}/sessions": {
+            "get": {
+                "description": "Get the active login sessions of a user, newest first\nRequires permission ` + "`" + `user:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth requiresPermission"
+                ],
+                "summary": "Get the login sessions of a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/handlers.AuthSessionInfo"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "End all login sessions of a user, e.g. when their laptop was stolen. Personal access tokens are not revoked.\nRequires permission ` + "`" + `user:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth requiresPermission"
+                ],
+                "summary": "Log a user out everywhere",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{id}/sessions/{session_id}": {
+            "delete": {
+                "description": "End a single login session of a user\nRequires permission ` + "`" + `user:write` + "`" + `",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user requiresAuth requiresPermission"
+                ],
+                "summary": "End a login session of a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Login session ID",
+                        "name": "session_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BaseBase"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.BadRequestError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.UnauthorizedError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.ForbiddenError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.NotFoundError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/apiResponses.InternalServerError"
+                        }
+                    }
+                }
+            }
+        },
+        "/v": {
+            "get": {
+                "description": "Get current api name, version and deployment env (prod, dev)",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "version"
+                ],
+                "summary": "Get the api version",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/apiResponses.BaseResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.GetVersionSuccessResponse"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        },
+        "/ws": {
+            "get": {
+                "description": "Open a websocket connection used by devices to communicate with the server.\nDevices get notified about session changes and send votes via this connection.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device_websocket"
+                ],
+                "summary": "Open a connection to the device websocket API",
+                "responses": {
+                    "101": {
+                        "description": "Switching Protocols (WebSocket Upgrade)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Server is shutting down",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        }
+    },
     "definitions": {
         "apiResponses.BadRequestError": {
             "type": "object",
             "properties": {
-                "message": {
+                "message": {
+                    "type": "string",
+                    "example": "Could not parse 'hi' as int"
+                },
+                "status": {
+                    "type": "integer",
+                    "default": 400
+                },
+                "success": {
+                    "type": "boolean",
+                    "default": false
+                },
+                "timestamp": {
+                    "type": "string",
+                    "format": "date-time",
+                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                }
+            }
+        },
+        "apiResponses.BaseBase": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "Ok"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 200
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "timestamp": {
+                    "type": "string",
+                    "format": "date-time",
+                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                }
+            }
+        },
+        "apiResponses.BaseResponse": {
+            "type": "object",
+            "properties": {
+                "data": {},
+                "message": {
+                    "type": "string",
+                    "example": "Ok"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 200
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "timestamp": {
+                    "type": "string",
+                    "format": "date-time",
+                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                }
+            }
+        },
+        "apiResponses.ConflictError": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "default": "Conflict"
+                },
+                "status": {
+                    "type": "integer",
+                    "default": 409
+                },
+                "success": {
+                    "type": "boolean",
+                    "default": false
+                },
+                "timestamp": {
+                    "type": "string",
+                    "format": "date-time",
+                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                }
+            }
+        },
+        "apiResponses.ForbiddenError": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "default": "Forbidden"
+                },
+                "status": {
+                    "type": "integer",
+                    "default": 403
+                },
+                "success": {
+                    "type": "boolean",
+                    "default": false
+                },
+                "timestamp": {
+                    "type": "string",
+                    "format": "date-time",
+                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                }
+            }
+        },
+        "apiResponses.InternalServerError": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "default": "Internal Server Error"
+                },
+                "status": {
+                    "type": "integer",
+                    "default": 500
+                },
+                "success": {
+                    "type": "boolean",
+                    "default": false
+                },
+                "timestamp": {
+                    "type": "string",
+                    "format": "date-time",
+                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                }
+            }
+        },
+        "apiResponses.NotFoundError": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "default": "Not Found"
+                },
+                "status": {
+                    "type": "integer",
+                    "default": 404
+                },
+                "success": {
+                    "type": "boolean",
+                    "default": false
+                },
+                "timestamp": {
+                    "type": "string",
+                    "format": "date-time",
+                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                }
+            }
+        },
+        "apiResponses.ServiceUnavailableError": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "default": "Service Unavailable"
+                },
+                "status": {
+                    "type": "integer",
+                    "default": 503
+                },
+                "success": {
+                    "type": "boolean",
+                    "default": false
+                },
+                "timestamp": {
+                    "type": "string",
+                    "format": "date-time",
+                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                }
+            }
+        },
+        "apiResponses.UnauthorizedError": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "Invalid or expired session"
+                },
+                "status": {
+                    "type": "integer",
+                    "default": 401
+                },
+                "success": {
+                    "type": "boolean",
+                    "default": false
+                },
+                "timestamp": {
+                    "type": "string",
+                    "format": "date-time",
+                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                }
+            }
+        },
+        "handlers.AlertInfo": {
+            "type": "object",
+            "properties": {
+                "acknowledged_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "acknowledged_by_id": {
+                    "type": "integer"
+                },
+                "device_id": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Device 3 in room A-101 has been offline since 2025-01-06 08:12"
+                },
+                "raised_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "resolved_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "type": {
+                    "type": "string",
+                    "enum": [
+                        "device_offline",
+                        "device_auth_failures"
+                    ]
+                }
+            }
+        },
+        "handlers.AuditEventInfo": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string",
+                    "example": "device.delete"
+                },
+                "actor_email": {
+                    "type": "string",
+                    "example": "admin@school.nl"
+                },
+                "actor_id": {
+                    "description": "null when nobody was logged in, e.g. for rejected logins",
+                    "type": "integer"
+                },
+                "details": {
+                    "type": "object",
+                    "additionalProperties": {}
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "impersonator_email": {
+                    "type": "string"
+                },
+                "impersonator_id": {
+                    "description": "Admin that did the action while impersonating the actor",
+                    "type": "integer"
+                },
+                "ip_address": {
+                    "type": "string",
+                    "example": "192.0.2.10"
+                },
+                "occurred_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "target_id": {
+                    "type": "integer"
+                },
+                "target_type": {
+                    "type": "string",
+                    "example": "device"
+                }
+            }
+        },
+        "handlers.AuthFailureInfo": {
+            "type": "object",
+            "properties": {
+                "alerted": {
+                    "type": "boolean"
+                },
+                "failures": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string",
+                    "example": "device:12"
+                },
+                "last_failure": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "locked_until": {
+                    "type": "string",
+                    "format": "date-time"
+                }
+            }
+        },
+        "handlers.AuthSessionInfo": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "current": {
+                    "description": "The session the request was made with",
+                    "type": "boolean"
+                },
+                "expires_at": {
+                    "description": "Moved forward when the session is used, up to max_expires_at",
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "impersonator_id": {
+                    "description": "Admin that started the session to impersonate the user",
+                    "type": "integer"
+                },
+                "ip_address": {
+                    "type": "string",
+                    "example": "192.0.2.10"
+                },
+                "last_used_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "max_expires_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "user_agent": {
+                    "type": "string",
+                    "example": "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0"
+                }
+            }
+        },
+        "handlers.BulkActionResult": {
+            "type": "object",
+            "properties": {
+                "device_id": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "session_id": {
+                    "type": "integer"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "handlers.CreatedPersonalTokenInfo": {
+            "type": "object",
+            "properties": {
+                "created_at": {
                     "type": "string",
-                    "example": "Could not parse 'hi' as int"
+                    "format": "date-time"
                 },
-                "status": {
-                    "type": "integer",
-                    "default": 400
+                "expired": {
+                    "type": "boolean"
                 },
-                "success": {
-                    "type": "boolean",
-                    "default": false
+                "expires_at": {
+                    "type": "string",
+                    "format": "date-time"
                 },
-                "timestamp": {
+                "hint": {
                     "type": "string",
-                    "format": "date-time",
-                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                    "example": "sbx_pat_Xk3a"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_used_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "Weekly report"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "session:read:own"
+                    ]
+                },
+                "token": {
+                    "type": "string",
+                    "example": "sbx_pat_Xk3aQ0yJ2m7WcVbE1nRtL9pZu4sHdF6gKoA8iY5eMxw"
                 }
             }
         },
-        "apiResponses.BaseBase": {
+        "handlers.DeviceEventInfo": {
             "type": "object",
             "properties": {
-                "message": {
+                "connection_id": {
+                    "type": "integer"
+                },
+                "device_id": {
+                    "type": "integer"
+                },
+                "duration": {
+                    "description": "seconds",
+                    "type": "number",
+                    "example": 3600.5
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "occurred_at": {
                     "type": "string",
-                    "example": "Ok"
+                    "format": "date-time"
                 },
-                "status": {
-                    "type": "integer",
-                    "example": 200
+                "reason": {
+                    "type": "string",
+                    "example": "heartbeat_missed"
                 },
-                "success": {
-                    "type": "boolean",
-                    "example": true
+                "remote_addr": {
+                    "type": "string"
                 },
-                "timestamp": {
+                "type": {
                     "type": "string",
-                    "format": "date-time",
-                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                    "enum": [
+                        "connect",
+                        "auth_success",
+                        "auth_failure",
+                        "disconnect"
+                    ]
                 }
             }
         },
-        "apiResponses.BaseResponse": {
+        "handlers.DeviceGroupInfo": {
             "type": "object",
             "properties": {
-                "data": {},
-                "message": {
+                "created_at": {
                     "type": "string",
-                    "example": "Ok"
+                    "format": "date-time"
                 },
-                "status": {
-                    "type": "integer",
-                    "example": 200
+                "description": {
+                    "type": "string"
                 },
-                "success": {
-                    "type": "boolean",
-                    "example": true
+                "device_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
                 },
-                "timestamp": {
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.DeviceInfo": {
+            "type": "object",
+            "properties": {
+                "active_session_id": {
+                    "type": "integer"
+                },
+                "connected_since": {
                     "type": "string",
-                    "format": "date-time",
-                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                    "format": "date-time"
+                },
+                "connection_state": {
+                    "type": "string",
+                    "enum": [
+                        "offline",
+                        "connected",
+                        "authenticating",
+                        "authenticated",
+                        "in_session"
+                    ]
+                },
+                "display_name": {
+                    "type": "string"
+                },
+                "group_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_seen": {
+                    "type": "string"
+                },
+                "latest_login": {
+                    "type": "string"
+                },
+                "lease_start": {
+                    "type": "string"
+                },
+                "location": {
+                    "type": "string"
+                },
+                "notes": {
+                    "type": "string"
+                },
+                "online": {
+                    "type": "boolean"
+                },
+                "registration_date": {
+                    "type": "string"
+                },
+                "room": {
+                    "type": "string"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
                 }
             }
         },
-        "apiResponses.ConflictError": {
+        "handlers.DeviceUptimeInfo": {
             "type": "object",
             "properties": {
-                "message": {
+                "connections": {
+                    "description": "successful authentications in the period",
+                    "type": "integer"
+                },
+                "device_id": {
+                    "type": "integer"
+                },
+                "from": {
                     "type": "string",
-                    "default": "Conflict"
+                    "format": "date-time"
                 },
-                "status": {
-                    "type": "integer",
-                    "default": 409
+                "online_seconds": {
+                    "type": "number"
                 },
-                "success": {
-                    "type": "boolean",
-                    "default": false
+                "to": {
+                    "type": "string",
+                    "format": "date-time"
                 },
-                "timestamp": {
+                "uptime_percentage": {
+                    "type": "number",
+                    "example": 97.5
+                }
+            }
+        },
+        "handlers.GetVersionSuccessResponse": {
+            "type": "object",
+            "properties": {
+                "environment": {
                     "type": "string",
-                    "format": "date-time",
-                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                    "example": "development"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "schoolbox-backend"
+                },
+                "version": {
+                    "type": "string",
+                    "example": "1.0.0"
                 }
             }
         },
-        "apiResponses.ForbiddenError": {
+        "handlers.ImpersonationInfo": {
             "type": "object",
             "properties": {
-                "message": {
+                "allow_write": {
+                    "description": "Without it only GET requests can be made",
+                    "type": "boolean"
+                },
+                "expires_at": {
                     "type": "string",
-                    "default": "Forbidden"
+                    "format": "date-time"
                 },
-                "status": {
-                    "type": "integer",
-                    "default": 403
+                "impersonator_email": {
+                    "type": "string",
+                    "example": "admin@school.nl"
                 },
-                "success": {
-                    "type": "boolean",
-                    "default": false
+                "impersonator_id": {
+                    "type": "integer"
                 },
-                "timestamp": {
+                "reason": {
                     "type": "string",
-                    "format": "date-time",
-                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                    "example": "Teacher reports an empty session list"
+                }
+            }
+        },
+        "handlers.LoginProviderInfo": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string",
+                    "example": "google"
+                },
+                "login_url": {
+                    "type": "string",
+                    "example": "/api/login/google"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "Google"
+                }
+            }
+        },
+        "handlers.MeInfo": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "description": "Deactivated users can not log in",
+                    "type": "boolean"
+                },
+                "default_question": {
+                    "type": "string"
+                },
+                "display_name": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string",
+                    "format": "email"
+                },
+                "google_sub": {
+                    "description": "Deprecated: only set for users that logged in with Google before other login providers were supported",
+                    "type": "string",
+                    "example": "012345678901234567890"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "impersonation": {
+                    "description": "null unless an admin is impersonating the user",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/handlers.ImpersonationInfo"
+                        }
+                    ]
+                },
+                "joinedAt": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "name": {
+                    "type": "string",
+                    "format": "name"
+                },
+                "permissions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "session:read:own",
+                        "session:write:own"
+                    ]
+                },
+                "picture_url": {
+                    "type": "string",
+                    "format": "url"
+                },
+                "role": {
+                    "type": "string",
+                    "enum": [
+                        "teacher",
+                        "department_head",
+                        "it_admin",
+                        "auditor",
+                        "admin"
+                    ]
+                },
+                "section": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.PatchDeviceBody": {
+            "type": "object",
+            "properties": {
+                "display_name": {
+                    "type": "string"
+                },
+                "location": {
+                    "type": "string"
+                },
+                "notes": {
+                    "type": "string"
+                },
+                "room_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.PatchDeviceGroupBody": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
                 }
             }
         },
-        "apiResponses.InternalServerError": {
+        "handlers.PatchMeBody": {
             "type": "object",
             "properties": {
-                "message": {
+                "default_question": {
                     "type": "string",
-                    "default": "Internal Server Error"
-                },
-                "status": {
-                    "type": "integer",
-                    "default": 500
-                },
-                "success": {
-                    "type": "boolean",
-                    "default": false
+                    "example": "Wat vond je van de les?"
                 },
-                "timestamp": {
-                    "type": "string",
-                    "format": "date-time",
-                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                "display_name": {
+                    "type": "string"
                 }
             }
         },
-        "apiResponses.NotFoundError": {
+        "handlers.PatchRoomBody": {
             "type": "object",
             "properties": {
-                "message": {
-                    "type": "string",
-                    "default": "Not Found"
+                "building": {
+                    "type": "string"
                 },
-                "status": {
-                    "type": "integer",
-                    "default": 404
+                "capacity": {
+                    "type": "integer"
                 },
-                "success": {
-                    "type": "boolean",
-                    "default": false
+                "floor": {
+                    "type": "integer"
                 },
-                "timestamp": {
-                    "type": "string",
-                    "format": "date-time",
-                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                "name": {
+                    "type": "string"
                 }
             }
         },
-        "apiResponses.ServiceUnavailableError": {
+        "handlers.PatchUserBody": {
             "type": "object",
             "properties": {
-                "message": {
-                    "type": "string",
-                    "default": "Service Unavailable"
-                },
-                "status": {
-                    "type": "integer",
-                    "default": 503
+                "active": {
+                    "type": "boolean"
                 },
-                "success": {
-                    "type": "boolean",
-                    "default": false
+                "display_name": {
+                    "type": "string"
                 },
-                "timestamp": {
+                "role": {
                     "type": "string",
-                    "format": "date-time",
-                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                    "enum": [
+                        "teacher",
+                        "department_head",
+                        "it_admin",
+                        "auditor",
+                        "admin"
+                    ]
+                },
+                "section": {
+                    "type": "string"
                 }
             }
         },
-        "apiResponses.UnauthorizedError": {
+        "handlers.PersonalTokenInfo": {
             "type": "object",
             "properties": {
-                "message": {
+                "created_at": {
                     "type": "string",
-                    "example": "Invalid or expired session"
+                    "format": "date-time"
                 },
-                "status": {
-                    "type": "integer",
-                    "default": 401
+                "expired": {
+                    "type": "boolean"
                 },
-                "success": {
-                    "type": "boolean",
-                    "default": false
+                "expires_at": {
+                    "type": "string",
+                    "format": "date-time"
                 },
-                "timestamp": {
+                "hint": {
                     "type": "string",
-                    "format": "date-time",
-                    "example": "2026-01-12T21:52:50.253429709+01:00"
+                    "example": "sbx_pat_Xk3a"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_used_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "Weekly report"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "session:read:own"
+                    ]
                 }
             }
         },
-        "handlers.DeviceInfo": {
+        "handlers.PostDeviceClaimBody": {
             "type": "object",
             "properties": {
-                "active_session_id": {
-                    "type": "integer"
-                },
-                "id": {
+                "room_id": {
                     "type": "integer"
                 },
-                "last_seen": {
+                "token": {
                     "type": "string"
-                },
-                "latest_login": {
+                }
+            }
+        },
+        "handlers.PostDeviceGroupBody": {
+            "type": "object",
+            "properties": {
+                "description": {
                     "type": "string"
                 },
-                "lease_start": {
+                "name": {
                     "type": "string"
-                },
-                "registration_date": {
+                }
+            }
+        },
+        "handlers.PostDeviceGroupCommandBody": {
+            "type": "object",
+            "properties": {
+                "command": {
                     "type": "string"
                 },
-                "room": {
-                    "type": "string"
+                "data": {
+                    "type": "object",
+                    "additionalProperties": {}
                 }
             }
         },
-        "handlers.GetVersionSuccessResponse": {
+        "handlers.PostDeviceGroupConfigBody": {
             "type": "object",
             "properties": {
-                "environment": {
-                    "type": "string",
-                    "example": "development"
-                },
-                "name": {
-                    "type": "string",
-                    "example": "schoolbox-backend"
-                },
-                "version": {
-                    "type": "string",
-                    "example": "1.0.0"
+                "config": {
+                    "type": "object",
+                    "additionalProperties": {}
+                }
+            }
+        },
+        "handlers.PostDeviceGroupMembersBody": {
+            "type": "object",
+            "properties": {
+                "device_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "handlers.PostDeviceGroupSessionBody": {
+            "type": "object",
+            "properties": {
+                "question": {
+                    "type": "string"
                 }
             }
         },
@@ -1434,6 +5559,44 @@ const docTemplate = `{
                 }
             }
         },
+        "handlers.PostMeTokensBody": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "session:read:own"
+                    ]
+                }
+            }
+        },
+        "handlers.PostRoomBody": {
+            "type": "object",
+            "properties": {
+                "building": {
+                    "type": "string"
+                },
+                "capacity": {
+                    "type": "integer"
+                },
+                "floor": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
         "handlers.PostSessionBody": {
             "type": "object",
             "properties": {
@@ -1447,6 +5610,129 @@ const docTemplate = `{
                 }
             }
         },
+        "handlers.PostUserBody": {
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string",
+                    "format": "email"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "role": {
+                    "type": "string",
+                    "enum": [
+                        "teacher",
+                        "department_head",
+                        "it_admin",
+                        "auditor",
+                        "admin"
+                    ]
+                },
+                "section": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.PostUserImpersonateBody": {
+            "type": "object",
+            "properties": {
+                "allow_write": {
+                    "type": "boolean"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Teacher reports an empty session list"
+                }
+            }
+        },
+        "handlers.PresenceEvent": {
+            "type": "object",
+            "properties": {
+                "at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "connected_since": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "connection_id": {
+                    "type": "integer"
+                },
+                "device_id": {
+                    "type": "integer"
+                },
+                "pin": {
+                    "type": "integer"
+                },
+                "remote_addr": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string",
+                    "enum": [
+                        "connected",
+                        "registering",
+                        "authenticating",
+                        "authenticated",
+                        "in_session"
+                    ]
+                },
+                "type": {
+                    "type": "string",
+                    "enum": [
+                        "device_online",
+                        "device_offline",
+                        "device_state",
+                        "registration_pending",
+                        "registration_finished"
+                    ]
+                }
+            }
+        },
+        "handlers.PutDeviceTagsBody": {
+            "type": "object",
+            "properties": {
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.RoomInfo": {
+            "type": "object",
+            "properties": {
+                "building": {
+                    "type": "string"
+                },
+                "capacity": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "device_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "floor": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
         "handlers.SessionInfo": {
             "type": "object",
             "properties": {
@@ -1474,6 +5760,9 @@ const docTemplate = `{
                 "question_id": {
                     "type": "integer"
                 },
+                "room_id": {
+                    "type": "integer"
+                },
                 "stopped_at": {
                     "type": "string",
                     "format": "date-time"
@@ -1492,6 +5781,10 @@ const docTemplate = `{
         "handlers.UserInfo": {
             "type": "object",
             "properties": {
+                "active": {
+                    "description": "Deactivated users can not log in",
+                    "type": "boolean"
+                },
                 "default_question": {
                     "type": "string"
                 },
@@ -1503,6 +5796,7 @@ const docTemplate = `{
                     "format": "email"
                 },
                 "google_sub": {
+                    "description": "Deprecated: only set for users that logged in with Google before other login providers were supported",
                     "type": "string",
                     "example": "012345678901234567890"
                 },
@@ -1517,12 +5811,32 @@ const docTemplate = `{
                     "type": "string",
                     "format": "name"
                 },
+                "permissions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "session:read:own",
+                        "session:write:own"
+                    ]
+                },
                 "picture_url": {
                     "type": "string",
                     "format": "url"
                 },
                 "role": {
-                    "type": "integer"
+                    "type": "string",
+                    "enum": [
+                        "teacher",
+                        "department_head",
+                        "it_admin",
+                        "auditor",
+                        "admin"
+                    ]
+                },
+                "section": {
+                    "type": "string"
                 }
             }
         }
@@ -0,0 +1,164 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+// Alert types
+const (
+	TypeDeviceOffline      = "device_offline"
+	TypeDeviceAuthFailures = "device_auth_failures"
+)
+
+// ResolvedOnAcknowledge reports whether alerts of alertType are resolved when an admin acknowledges them,
+// instead of when the problem is gone
+func ResolvedOnAcknowledge(alertType string) bool {
+	return alertType == TypeDeviceAuthFailures
+}
+
+// Event is what happened to an alert
+type Event string
+
+const (
+	EventRaised   Event = "raised"
+	EventResolved Event = "resolved"
+)
+
+// Notification is sent to every notifier when an alert is raised or resolved
+type Notification struct {
+	Event Event
+	Alert models.Alert
+}
+
+// Subject is a single line summary of the notification, e.g. for an email subject
+func (n Notification) Subject() string {
+	if n.Event == EventResolved {
+		return fmt.Sprintf("Resolved: %s", n.Alert.Message)
+	}
+	return n.Alert.Message
+}
+
+// Notifier delivers alert notifications to admins outside of the app
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// Alerter stores alerts and sends them to the configured notifiers.
+// The stored alerts are the in-app notification list, a device has at most one open alert of each type.
+type Alerter struct {
+	config    *config.Config
+	db        *gorm.DB
+	notifiers []Notifier
+	sending   sync.WaitGroup // Notifications that are being delivered
+}
+
+// NewAlerter creates an Alerter with the notifiers enabled in the configuration
+func NewAlerter(cfg *config.Config, db *gorm.DB) *Alerter {
+	a := &Alerter{
+		config: cfg,
+		db:     db,
+	}
+	if cfg.Alerts.WebhookURL != "" {
+		a.notifiers = append(a.notifiers, NewWebhookNotifier(cfg.Alerts.WebhookURL))
+	}
+	if cfg.Alerts.SMTPHost != "" {
+		a.notifiers = append(a.notifiers, NewEmailNotifier(&cfg.Alerts, db))
+	}
+
+	names := []string{"in-app"}
+	for _, notifier := range a.notifiers {
+		names = append(names, notifier.Name())
+	}
+	logger.Info(fmt.Sprintf("Sending alerts to: %s", strings.Join(names, ", ")))
+	return a
+}
+
+// Raise opens an alert of alertType for device, unless the device already has an open alert of that type
+func (a *Alerter) Raise(alertType string, deviceID uint, message string) error {
+	ctx := context.Background()
+
+	openAlerts, err := gorm.G[models.Alert](a.db).Where("type = ? AND device_id = ? AND resolved_at IS NULL", alertType, deviceID).Count(ctx, "id")
+	if err != nil {
+		return err
+	}
+	if openAlerts > 0 {
+		return nil
+	}
+
+	alert := models.Alert{
+		Type:     alertType,
+		DeviceID: deviceID,
+		Message:  message,
+	}
+	if err := gorm.G[models.Alert](a.db).Create(ctx, &alert); err != nil {
+		return err
+	}
+	metrics.AlertsRaised.WithLabelValues(alertType).Inc()
+	logger.Warn(fmt.Sprintf("ALERT: %s", message))
+
+	a.notify(Notification{Event: EventRaised, Alert: alert})
+	return nil
+}
+
+// Resolve closes the open alert of alertType for device, if there is one
+func (a *Alerter) Resolve(alertType string, deviceID uint) error {
+	ctx := context.Background()
+
+	openAlerts, err := gorm.G[models.Alert](a.db).Where("type = ? AND device_id = ? AND resolved_at IS NULL", alertType, deviceID).Find(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, alert := range openAlerts {
+		if _, err := gorm.G[models.Alert](a.db).Where("id = ?", alert.ID).Update(ctx, "resolved_at", now); err != nil {
+			return err
+		}
+		alert.ResolvedAt = &now
+		logger.Info(fmt.Sprintf("Alert %d resolved: %s", alert.ID, alert.Message))
+
+		a.notify(Notification{Event: EventResolved, Alert: alert})
+	}
+	return nil
+}
+
+// notify sends notification to all notifiers in the background, a slow mail server should not hold up the caller
+func (a *Alerter) notify(notification Notification) {
+	for _, notifier := range a.notifiers {
+		a.sending.Add(1)
+		go func() {
+			defer a.sending.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), a.config.Alerts.NotifyTimeout)
+			defer cancel()
+			if err := notifier.Notify(ctx, notification); err != nil {
+				metrics.AlertNotificationsFailed.WithLabelValues(notifier.Name()).Inc()
+				logger.Err(fmt.Sprintf("Could not send alert %d with %s notifier: %s", notification.Alert.ID, notifier.Name(), err.Error()))
+			}
+		}()
+	}
+}
+
+// Wait waits until the notifications that are being delivered are sent or ctx is done
+func (a *Alerter) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		a.sending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
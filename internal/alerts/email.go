@@ -0,0 +1,134 @@
+package alerts
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/rbac"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"gorm.io/gorm"
+)
+
+// EmailNotifier emails every notification through an SMTP server.
+// Without configured recipients it emails all admins.
+type EmailNotifier struct {
+	config *config.AlertsConfig
+	db     *gorm.DB
+}
+
+func NewEmailNotifier(cfg *config.AlertsConfig, db *gorm.DB) *EmailNotifier {
+	return &EmailNotifier{
+		config: cfg,
+		db:     db,
+	}
+}
+
+func (n *EmailNotifier) Name() string {
+	return "email"
+}
+
+// recipients returns the configured recipients, or the email addresses of all active admins
+func (n *EmailNotifier) recipients(ctx context.Context) ([]string, error) {
+	if len(n.config.EmailTo) > 0 {
+		return n.config.EmailTo, nil
+	}
+	var emails []string
+	err := n.db.WithContext(ctx).Model(&models.User{}).Where("role = ? AND email != '' AND deactivated_at IS NULL", rbac.RoleAdmin).Pluck("email", &emails).Error
+	return emails, err
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, notification Notification) error {
+	to, err := n.recipients(ctx)
+	if err != nil {
+		return err
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("no recipients, set ALERT_EMAIL_TO or add an admin")
+	}
+
+	var body strings.Builder
+	body.WriteString(notification.Alert.Message + "\r\n\r\n")
+	fmt.Fprintf(&body, "Alert %d (%s) was raised at %s.\r\n", notification.Alert.ID, notification.Alert.Type, notification.Alert.CreatedAt.Format(time.RFC1123))
+	if notification.Alert.ResolvedAt != nil {
+		fmt.Fprintf(&body, "It was resolved at %s.\r\n", notification.Alert.ResolvedAt.Format(time.RFC1123))
+	}
+
+	message := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		n.config.EmailFrom,
+		strings.Join(to, ", "),
+		encodeHeader("[Schoolbox] "+notification.Subject()),
+		time.Now().Format(time.RFC1123Z),
+		body.String(),
+	)
+	return n.send(ctx, to, []byte(message))
+}
+
+// encodeHeader makes value safe to use in a mail header. The subject contains names users can edit, control characters
+// are replaced by spaces so they can not add headers, and non-ASCII characters are encoded as described in RFC 2047.
+func encodeHeader(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, value)
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+// send delivers message like smtp.SendMail, but gives up when ctx is done
+func (n *EmailNotifier) send(ctx context.Context, to []string, message []byte) error {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(n.config.SMTPHost, n.config.SMTPPort))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, n.config.SMTPHost)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.config.SMTPHost}); err != nil {
+			return err
+		}
+	}
+	if n.config.SMTPUsername != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.config.SMTPUsername, n.config.SMTPPassword, n.config.SMTPHost)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(n.config.EmailFrom); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,53 @@
+package alerts
+
+import (
+	"mime"
+	"strings"
+	"testing"
+)
+
+func TestEncodeHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{
+			name:     "ascii",
+			value:    "Device 3 is offline",
+			expected: "Device 3 is offline",
+		},
+		{
+			name:     "header injection",
+			value:    "Lokaal 1\r\nBcc: attacker@example.com",
+			expected: "Lokaal 1  Bcc: attacker@example.com",
+		},
+		{
+			name:     "non-ascii",
+			value:    "Lokaal café is offline",
+			expected: "Lokaal café is offline",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encodeHeader(tt.value)
+			if strings.ContainsAny(encoded, "\r\n") {
+				t.Errorf("encoded header %q contains a line break", encoded)
+			}
+			for _, r := range encoded {
+				if r > 127 {
+					t.Errorf("encoded header %q is not ascii", encoded)
+					break
+				}
+			}
+			decoded, err := new(mime.WordDecoder).DecodeHeader(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, decoded)
+			}
+		})
+	}
+}
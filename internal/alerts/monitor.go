@@ -0,0 +1,126 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/broker"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const offlineCheckLockKey = "alerts:offline_check"
+
+// OfflineMonitor raises an alert for every device that has not been seen for longer than the offline threshold,
+// so admins hear about it before a teacher tries to start a session. The alert is resolved once the device is seen again.
+type OfflineMonitor struct {
+	cfg     *config.Config
+	db      *gorm.DB
+	alerter *Alerter
+	broker  broker.Broker
+	cancel  context.CancelFunc
+	done    chan struct{} // Closed when the check loop stopped
+}
+
+func NewOfflineMonitor(cfg *config.Config, db *gorm.DB, alerter *Alerter, b broker.Broker) *OfflineMonitor {
+	return &OfflineMonitor{
+		cfg:     cfg,
+		db:      db,
+		alerter: alerter,
+		broker:  b,
+	}
+}
+
+func (m *OfflineMonitor) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.cfg.Alerts.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.Check()
+			}
+		}
+	}()
+}
+
+// Stop stops the monitor, it waits for a running check to finish
+func (m *OfflineMonitor) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+		<-m.done
+	}
+}
+
+// Check raises alerts for devices that went offline and resolves the alerts of devices that came back or were deleted.
+// When running multiple instances only one of them checks per interval.
+func (m *OfflineMonitor) Check() {
+	ctx := context.Background()
+
+	locked, err := m.broker.SetNX(ctx, offlineCheckLockKey, m.cfg.Broker.InstanceID, m.cfg.Alerts.CheckInterval/2)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not take offline check lock: %s", err.Error()))
+		return
+	}
+	if !locked {
+		return
+	}
+
+	offlineSince := time.Now().Add(-m.cfg.Alerts.OfflineThreshold)
+	offlineDevices, err := gorm.G[models.Device](m.db).
+		Preload("Room", nil).
+		Where("last_seen < ? OR (last_seen IS NULL AND created_at < ?)", offlineSince, offlineSince).
+		Find(ctx)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not retrieve offline devices: %s", err.Error()))
+		return
+	}
+
+	offline := map[uint]bool{}
+	for _, device := range offlineDevices {
+		offline[device.ID] = true
+		if err := m.alerter.Raise(TypeDeviceOffline, device.ID, offlineMessage(device)); err != nil {
+			logger.Err(fmt.Sprintf("Could not raise offline alert for device %d: %s", device.ID, err.Error()))
+		}
+	}
+
+	openAlerts, err := gorm.G[models.Alert](m.db).Where("type = ? AND resolved_at IS NULL", TypeDeviceOffline).Find(ctx)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not retrieve open offline alerts: %s", err.Error()))
+		return
+	}
+	for _, alert := range openAlerts {
+		if offline[alert.DeviceID] {
+			continue
+		}
+		if err := m.alerter.Resolve(TypeDeviceOffline, alert.DeviceID); err != nil {
+			logger.Err(fmt.Sprintf("Could not resolve offline alert for device %d: %s", alert.DeviceID, err.Error()))
+		}
+	}
+}
+
+// offlineMessage describes an offline device, Room should be preloaded
+func offlineMessage(device models.Device) string {
+	name := fmt.Sprintf("Device %d", device.ID)
+	if device.DisplayName != nil && *device.DisplayName != "" {
+		name = fmt.Sprintf("Device %d (%s)", device.ID, *device.DisplayName)
+	}
+	if device.Room != nil {
+		name = fmt.Sprintf("%s in room %s", name, device.Room.Name)
+	}
+	if device.LastSeen == nil {
+		return fmt.Sprintf("%s has never been online", name)
+	}
+	return fmt.Sprintf("%s has been offline since %s", name, device.LastSeen.Format("2006-01-02 15:04"))
+}
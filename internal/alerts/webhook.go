@@ -0,0 +1,69 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookNotifier posts every notification as JSON to a URL, e.g. a chat integration
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{},
+	}
+}
+
+type webhookPayload struct {
+	Event      Event      `json:"event"`
+	AlertID    uint       `json:"alert_id"`
+	Type       string     `json:"type"`
+	DeviceID   uint       `json:"device_id"`
+	Message    string     `json:"message"`
+	Text       string     `json:"text"` // Subject of the notification, shown as is by most chat integrations
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(webhookPayload{
+		Event:      notification.Event,
+		AlertID:    notification.Alert.ID,
+		Type:       notification.Alert.Type,
+		DeviceID:   notification.Alert.DeviceID,
+		Message:    notification.Alert.Message,
+		Text:       notification.Subject(),
+		CreatedAt:  notification.Alert.CreatedAt,
+		ResolvedAt: notification.Alert.ResolvedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// Package authprovider logs users in with OpenID Connect providers, e.g. Google or Microsoft Entra ID.
+//
+// Providers are configured with their issuer, their endpoints and signing keys are found with OpenID Connect
+// discovery the first time a provider is used.
+package authprovider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// requestTimeout is the time a single request to a provider may take
+const requestTimeout = 10 * time.Second
+
+// Identity is a user as identified by a provider, (Issuer, Subject) is unique for every user
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool // The provider verified the user owns Email
+	Name          string
+	GivenName     string
+	Picture       string // Url of the profile picture, empty if the provider has none
+}
+
+// claims are the claims of an ID token that are used
+type claims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"` // Entra ID puts the email address of most accounts here
+	Name              string `json:"name"`
+	GivenName         string `json:"given_name"`
+	Picture           string `json:"picture"`
+}
+
+// Provider is an OpenID Connect provider users can log in with
+type Provider struct {
+	ID   string
+	Name string
+
+	config config.OIDCProviderConfig
+	client *http.Client
+	mu     sync.Mutex
+	oidc   *oidc.Provider // nil until discovery succeeded
+}
+
+func newProvider(cfg config.OIDCProviderConfig) *Provider {
+	return &Provider{
+		ID:     cfg.ID,
+		Name:   cfg.Name,
+		config: cfg,
+		client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// discover returns the discovered provider, discovery is retried on the next call when it fails
+func (p *Provider) discover() (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oidc != nil {
+		return p.oidc, nil
+	}
+	// The context is also used to fetch the signing keys later on, so it can not be bound to a request
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.Background(), p.client), p.config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovery of OIDC provider '%s' failed: %s", p.ID, err.Error())
+	}
+	p.oidc = provider
+	return provider, nil
+}
+
+func (p *Provider) oauth2Config(provider *oidc.Provider, redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       p.config.Scopes,
+	}
+}
+
+// AuthCodeURL returns the url of the login page of the provider, which redirects back to redirectURL with state.
+// verifier is the PKCE code verifier, the provider only accepts the code when it is exchanged with the same verifier.
+func (p *Provider) AuthCodeURL(redirectURL string, state string, verifier string) (string, error) {
+	provider, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	return p.oauth2Config(provider, redirectURL).AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange exchanges the code the provider redirected back with for the identity of the user.
+// redirectURL and verifier have to be the same as the ones passed to AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, code string, redirectURL string, verifier string) (Identity, error) {
+	provider, err := p.discover()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(oidc.ClientContext(ctx, p.client), requestTimeout)
+	defer cancel()
+	token, err := p.oauth2Config(provider, redirectURL).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("could not exchange code with OIDC provider '%s': %s", p.ID, err.Error())
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, fmt.Errorf("OIDC provider '%s' did not return an id token", p.ID)
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid id token from OIDC provider '%s': %s", p.ID, err.Error())
+	}
+
+	var parsedClaims claims
+	if err := idToken.Claims(&parsedClaims); err != nil {
+		return Identity{}, fmt.Errorf("invalid claims in id token from OIDC provider '%s': %s", p.ID, err.Error())
+	}
+	email := parsedClaims.Email
+	if email == "" && strings.Contains(parsedClaims.PreferredUsername, "@") {
+		email = parsedClaims.PreferredUsername
+	}
+	givenName := parsedClaims.GivenName
+	if givenName == "" {
+		givenName = parsedClaims.Name
+	}
+	return Identity{
+		Issuer:        idToken.Issuer,
+		Subject:       idToken.Subject,
+		Email:         email,
+		EmailVerified: parsedClaims.EmailVerified || p.config.TrustEmail,
+		Name:          parsedClaims.Name,
+		GivenName:     givenName,
+		Picture:       parsedClaims.Picture,
+	}, nil
+}
+
+// Registry holds the configured providers
+type Registry struct {
+	providers []*Provider
+}
+
+func NewRegistry(configs []config.OIDCProviderConfig) *Registry {
+	registry := &Registry{}
+	for _, cfg := range configs {
+		registry.providers = append(registry.providers, newProvider(cfg))
+	}
+	return registry
+}
+
+// Get returns the provider with id
+func (r *Registry) Get(id string) (*Provider, bool) {
+	for _, provider := range r.providers {
+		if provider.ID == id {
+			return provider, true
+		}
+	}
+	return nil, false
+}
+
+// Default returns the first configured provider, config validation makes sure there is one
+func (r *Registry) Default() *Provider {
+	return r.providers[0]
+}
+
+// List returns all providers in the configured order
+func (r *Registry) List() []*Provider {
+	return r.providers
+}
@@ -0,0 +1,130 @@
+package authprovider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/mockoidc"
+)
+
+const (
+	redirectURL = "http://localhost:8000/api/oauth2callback/mock"
+	verifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// startMock serves a mock provider and returns a provider configured for it
+func startMock(t *testing.T, clientID string) (*mockoidc.Server, *Provider) {
+	t.Helper()
+	mock, err := mockoidc.New("schoolbox", "secret")
+	if err != nil {
+		t.Fatalf("could not create mock provider: %v", err)
+	}
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+	mock.Issuer = server.URL
+
+	provider := newProvider(config.OIDCProviderConfig{
+		ID:           "mock",
+		Name:         "Mock",
+		Issuer:       server.URL,
+		ClientID:     clientID,
+		ClientSecret: "secret",
+		Scopes:       []string{"openid", "email", "profile"},
+	})
+	return mock, provider
+}
+
+// login follows the login page of provider as email and returns the code it redirects back with
+func login(t *testing.T, provider *Provider, email string) string {
+	t.Helper()
+	loginURL, err := provider.AuthCodeURL(redirectURL, "state", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error: %v", err)
+	}
+	parsedURL, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatalf("invalid login url %q: %v", loginURL, err)
+	}
+	query := parsedURL.Query()
+	query.Set("login_hint", email)
+	parsedURL.RawQuery = query.Encode()
+
+	client := http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(parsedURL.String())
+	if err != nil {
+		t.Fatalf("could not open login page: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login page returned status %d, expected %d", resp.StatusCode, http.StatusFound)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	if state := location.Query().Get("state"); state != "state" {
+		t.Fatalf("expected state %q in the redirect, got %q", "state", state)
+	}
+	return location.Query().Get("code")
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	mock, provider := startMock(t, "schoolbox")
+
+	code := login(t, provider, "j.jansen@school.nl")
+	identity, err := provider.Exchange(context.Background(), code, redirectURL, verifier)
+	if err != nil {
+		t.Fatalf("Exchange() error: %v", err)
+	}
+
+	if identity.Issuer != mock.Issuer {
+		t.Errorf("expected issuer %q, got %q", mock.Issuer, identity.Issuer)
+	}
+	if identity.Subject != mockoidc.Subject("j.jansen@school.nl") {
+		t.Errorf("expected subject %q, got %q", mockoidc.Subject("j.jansen@school.nl"), identity.Subject)
+	}
+	if identity.Email != "j.jansen@school.nl" || !identity.EmailVerified {
+		t.Errorf("expected verified email j.jansen@school.nl, got %q (verified: %v)", identity.Email, identity.EmailVerified)
+	}
+}
+
+func TestProvider_ExchangeRejectsReusedCode(t *testing.T) {
+	_, provider := startMock(t, "schoolbox")
+
+	code := login(t, provider, "j.jansen@school.nl")
+	if _, err := provider.Exchange(context.Background(), code, redirectURL, verifier); err != nil {
+		t.Fatalf("Exchange() error: %v", err)
+	}
+	if _, err := provider.Exchange(context.Background(), code, redirectURL, verifier); err == nil {
+		t.Error("expected an error when exchanging a code twice")
+	}
+}
+
+func TestProvider_ExchangeRejectsWrongVerifier(t *testing.T) {
+	_, provider := startMock(t, "schoolbox")
+
+	code := login(t, provider, "j.jansen@school.nl")
+	if _, err := provider.Exchange(context.Background(), code, redirectURL, "wrong-"+verifier); err == nil {
+		t.Error("expected an error when exchanging a code with another PKCE verifier")
+	}
+}
+
+func TestProvider_UnreachableIssuer(t *testing.T) {
+	provider := newProvider(config.OIDCProviderConfig{
+		ID:       "down",
+		Issuer:   "http://127.0.0.1:1",
+		ClientID: "schoolbox",
+		Scopes:   []string{"openid"},
+	})
+	if _, err := provider.AuthCodeURL(redirectURL, "state", verifier); err == nil {
+		t.Error("expected an error when discovery fails")
+	}
+}
@@ -0,0 +1,74 @@
+// Package authsession creates the tokens and cookies of the sessions users log in with.
+//
+// Only the hash of a session token is stored, see tokenhash.Hash, so the sessions in a leaked database can not be used.
+package authsession
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+)
+
+// CookieName is the name of the cookie the session token is sent in
+const CookieName = "auth_session_token"
+
+// ImpersonatorCookieName is the name of the cookie the session token of an admin is kept in while they impersonate
+// another user, so their own session can be restored when they stop
+const ImpersonatorCookieName = "impersonator_session_token"
+
+// Generate returns a new random session token
+func Generate() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// SameSite returns the SameSite mode of cookies configured with AUTH_COOKIE_SAMESITE
+func SameSite(cfg *config.Config) http.SameSite {
+	switch cfg.OAuth.CookieSameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// Cookie returns the cookie that stores token until expires
+func Cookie(cfg *config.Config, token string, expires time.Time) *http.Cookie {
+	return namedCookie(cfg, CookieName, token, expires)
+}
+
+// ExpiredCookie returns a cookie that removes the session cookie
+func ExpiredCookie(cfg *config.Config) *http.Cookie {
+	return Cookie(cfg, "", time.Unix(0, 0))
+}
+
+// ImpersonatorCookie returns the cookie that keeps the session token of an impersonating admin until expires
+func ImpersonatorCookie(cfg *config.Config, token string, expires time.Time) *http.Cookie {
+	return namedCookie(cfg, ImpersonatorCookieName, token, expires)
+}
+
+// ExpiredImpersonatorCookie returns a cookie that removes the impersonator cookie
+func ExpiredImpersonatorCookie(cfg *config.Config) *http.Cookie {
+	return ImpersonatorCookie(cfg, "", time.Unix(0, 0))
+}
+
+func namedCookie(cfg *config.Config, name string, token string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    token,
+		Domain:   cfg.Server.Host,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   cfg.OAuth.CookieSecure,
+		SameSite: SameSite(cfg),
+		Expires:  expires,
+	}
+}
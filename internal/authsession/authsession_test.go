@@ -0,0 +1,81 @@
+package authsession
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+)
+
+func TestCookieFollowsEnvironment(t *testing.T) {
+	tests := []struct {
+		name             string
+		env              map[string]string
+		expectedSecure   bool
+		expectedSameSite http.SameSite
+	}{
+		{
+			name:             "development",
+			env:              map[string]string{"ENV": "development"},
+			expectedSecure:   false,
+			expectedSameSite: http.SameSiteLaxMode,
+		},
+		{
+			name: "staging",
+			env: map[string]string{
+				"ENV":               "staging",
+				"DEVICE_TOKEN_KEYS": "2026:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+			},
+			expectedSecure:   true,
+			expectedSameSite: http.SameSiteLaxMode,
+		},
+		{
+			name: "development over https",
+			env: map[string]string{
+				"ENV":                  "development",
+				"AUTH_COOKIE_SECURE":   "true",
+				"AUTH_COOKIE_SAMESITE": "strict",
+			},
+			expectedSecure:   true,
+			expectedSameSite: http.SameSiteStrictMode,
+		},
+		{
+			name: "production cross site",
+			env: map[string]string{
+				"ENV":                  "production",
+				"DEVICE_TOKEN_KEYS":    "2026:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+				"AUTH_COOKIE_SAMESITE": "none",
+			},
+			expectedSecure:   true,
+			expectedSameSite: http.SameSiteNoneMode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			config.Reload()
+			t.Cleanup(config.Reload)
+			cfg := config.Get()
+
+			expires := time.Now().Add(time.Hour)
+			for _, cookie := range []*http.Cookie{Cookie(cfg, "token", expires), ImpersonatorCookie(cfg, "token", expires)} {
+				if cookie.Secure != tt.expectedSecure {
+					t.Errorf("%s: expected Secure %t, got %t", cookie.Name, tt.expectedSecure, cookie.Secure)
+				}
+				if cookie.SameSite != tt.expectedSameSite {
+					t.Errorf("%s: expected SameSite %d, got %d", cookie.Name, tt.expectedSameSite, cookie.SameSite)
+				}
+				if !cookie.HttpOnly {
+					t.Errorf("%s: expected the cookie to be HttpOnly", cookie.Name)
+				}
+			}
+			if expired := ExpiredCookie(cfg); expired.Value != "" || expired.Expires.After(time.Now()) {
+				t.Errorf("expected an empty expired cookie, got '%s' until %s", expired.Value, expired.Expires)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+// Broker shares state and messages between all backend instances.
+//
+// Keys with a ttl of 0 never expire. Messages published on a channel are delivered to every subscriber
+// of that channel on every instance, in the order they were published.
+type Broker interface {
+	// Publish sends payload to all subscribers of channel
+	Publish(ctx context.Context, channel string, payload []byte) error
+	// Subscribe calls handler for every message published on channel until unsubscribe is called.
+	// Messages published after Subscribe returns are guaranteed to be delivered.
+	Subscribe(ctx context.Context, channel string, handler func(payload []byte)) (unsubscribe func(), err error)
+
+	// Set stores value under key
+	Set(ctx context.Context, key string, value string, ttl time.Duration) error
+	// SetNX stores value under key only if key does not exist yet, it reports if the value was stored
+	SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+	// Get returns the value of key or ErrNotFound
+	Get(ctx context.Context, key string) (string, error)
+	// CompareAndSwap replaces the value of key with value only if it currently holds old, it reports if the value was replaced
+	CompareAndSwap(ctx context.Context, key string, old string, value string, ttl time.Duration) (bool, error)
+	// CompareAndDelete deletes key only if it currently holds value, it reports if the key was deleted
+	CompareAndDelete(ctx context.Context, key string, value string) (bool, error)
+	// Keys returns all keys starting with prefix
+	Keys(ctx context.Context, prefix string) ([]string, error)
+
+	Close() error
+}
+
+// New creates the broker selected in the configuration
+func New(cfg *config.BrokerConfig) (Broker, error) {
+	switch cfg.Type {
+	case "memory":
+		return NewMemory(), nil
+	case "redis":
+		return NewRedis(cfg.URL, cfg.Prefix)
+	default:
+		return nil, fmt.Errorf("unknown broker type '%s'", cfg.Type)
+	}
+}
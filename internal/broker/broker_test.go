@@ -0,0 +1,186 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// brokers returns a fresh broker of every implementation, Redis runs against an in-process stand-in
+func brokers(t *testing.T) map[string]Broker {
+	server := miniredis.RunT(t)
+	redisBroker, err := NewRedis("redis://"+server.Addr(), "test:")
+	if err != nil {
+		t.Fatalf("Could not create redis broker: %v", err)
+	}
+	memoryBroker := NewMemory()
+	t.Cleanup(func() {
+		redisBroker.Close()
+		memoryBroker.Close()
+	})
+	return map[string]Broker{
+		"memory": memoryBroker,
+		"redis":  redisBroker,
+	}
+}
+
+func TestBroker_KeyValue(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range brokers(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := b.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for missing key, got %v", err)
+			}
+
+			if ok, err := b.SetNX(ctx, "pin:1234", "a", 0); !ok || err != nil {
+				t.Fatalf("Expected first SetNX to succeed, got %v %v", ok, err)
+			}
+			if ok, err := b.SetNX(ctx, "pin:1234", "b", 0); ok || err != nil {
+				t.Errorf("Expected second SetNX to fail, got %v %v", ok, err)
+			}
+			if value, err := b.Get(ctx, "pin:1234"); value != "a" || err != nil {
+				t.Errorf("Expected value 'a', got '%s' %v", value, err)
+			}
+
+			if ok, _ := b.CompareAndSwap(ctx, "pin:1234", "b", "c", 0); ok {
+				t.Error("CompareAndSwap should fail when the old value does not match")
+			}
+			if ok, err := b.CompareAndSwap(ctx, "pin:1234", "a", "c", 0); !ok || err != nil {
+				t.Errorf("Expected CompareAndSwap to succeed, got %v %v", ok, err)
+			}
+
+			if ok, _ := b.CompareAndDelete(ctx, "pin:1234", "a"); ok {
+				t.Error("CompareAndDelete should fail when the value does not match")
+			}
+			if ok, err := b.CompareAndDelete(ctx, "pin:1234", "c"); !ok || err != nil {
+				t.Errorf("Expected CompareAndDelete to succeed, got %v %v", ok, err)
+			}
+			if _, err := b.Get(ctx, "pin:1234"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected key to be deleted, got %v", err)
+			}
+
+			b.Set(ctx, "device:1", "x", 0)
+			b.Set(ctx, "device:2", "y", 0)
+			b.Set(ctx, "session:1", "z", 0)
+			keys, err := b.Keys(ctx, "device:")
+			slices.Sort(keys)
+			if err != nil || !slices.Equal(keys, []string{"device:1", "device:2"}) {
+				t.Errorf("Expected device keys, got %v %v", keys, err)
+			}
+		})
+	}
+}
+
+func TestBroker_Expiry(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	redisBroker, _ := NewRedis("redis://"+server.Addr(), "")
+	defer redisBroker.Close()
+
+	for name, b := range map[string]Broker{"memory": NewMemory(), "redis": redisBroker} {
+		t.Run(name, func(t *testing.T) {
+			b.Set(ctx, "short", "a", 50*time.Millisecond)
+			b.Set(ctx, "long", "b", time.Hour)
+			time.Sleep(60 * time.Millisecond)
+			server.FastForward(60 * time.Millisecond) // miniredis does not expire keys on its own
+
+			if _, err := b.Get(ctx, "short"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected expired key to be gone, got %v", err)
+			}
+			if value, _ := b.Get(ctx, "long"); value != "b" {
+				t.Errorf("Expected unexpired key to remain, got '%s'", value)
+			}
+		})
+	}
+}
+
+func TestBroker_PublishSubscribe(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range brokers(t) {
+		t.Run(name, func(t *testing.T) {
+			received := make(chan string, 10)
+			unsubscribe, err := b.Subscribe(ctx, "presence", func(payload []byte) {
+				received <- string(payload)
+			})
+			if err != nil {
+				t.Fatalf("Could not subscribe: %v", err)
+			}
+
+			for _, message := range []string{"1", "2", "3"} {
+				if err := b.Publish(ctx, "presence", []byte(message)); err != nil {
+					t.Fatalf("Could not publish: %v", err)
+				}
+			}
+			b.Publish(ctx, "other", []byte("ignored"))
+
+			for _, expected := range []string{"1", "2", "3"} {
+				select {
+				case message := <-received:
+					if message != expected {
+						t.Errorf("Expected message '%s', got '%s'", expected, message)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("Did not receive message '%s'", expected)
+				}
+			}
+
+			unsubscribe()
+			b.Publish(ctx, "presence", []byte("4"))
+			select {
+			case message := <-received:
+				t.Errorf("Received message '%s' after unsubscribing", message)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestRPC(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range brokers(t) {
+		t.Run(name, func(t *testing.T) {
+			server := NewRPC(b, "instance-a", time.Second)
+			server.Handle("double", func(ctx context.Context, payload json.RawMessage) (any, error) {
+				var n int
+				if err := json.Unmarshal(payload, &n); err != nil {
+					return nil, err
+				}
+				if n < 0 {
+					return nil, &Error{Code: "negative", Message: "n can not be negative"}
+				}
+				return n * 2, nil
+			})
+			client := NewRPC(b, "instance-b", 200*time.Millisecond)
+			for _, rpc := range []*RPC{server, client} {
+				if err := rpc.Start(ctx); err != nil {
+					t.Fatalf("Could not start RPC: %v", err)
+				}
+				defer rpc.Stop()
+			}
+
+			var result int
+			if err := client.Call(ctx, "instance-a", "double", 21, &result); err != nil || result != 42 {
+				t.Errorf("Expected 42, got %d %v", result, err)
+			}
+
+			var rpcErr *Error
+			err := client.Call(ctx, "instance-a", "double", -1, &result)
+			if !errors.As(err, &rpcErr) || rpcErr.Code != "negative" {
+				t.Errorf("Expected error with code 'negative', got %v", err)
+			}
+
+			if err := client.Call(ctx, "instance-a", "missing", nil, nil); !errors.As(err, &rpcErr) {
+				t.Errorf("Expected error for unknown method, got %v", err)
+			}
+
+			if err := client.Call(ctx, "instance-c", "double", 1, &result); !errors.Is(err, ErrTimeout) {
+				t.Errorf("Expected ErrTimeout for an instance that does not exist, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryValue struct {
+	value     string
+	expiresAt time.Time // zero if the value does not expire
+}
+
+func (v memoryValue) expired(now time.Time) bool {
+	return !v.expiresAt.IsZero() && !now.Before(v.expiresAt)
+}
+
+// memorySubscription queues messages for a single subscriber so a slow handler never blocks Publish
+type memorySubscription struct {
+	queue  [][]byte
+	notify chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+}
+
+func (sub *memorySubscription) stop() {
+	sub.once.Do(func() {
+		close(sub.done)
+	})
+}
+
+func (sub *memorySubscription) push(payload []byte) {
+	sub.mu.Lock()
+	sub.queue = append(sub.queue, payload)
+	sub.mu.Unlock()
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *memorySubscription) run(handler func(payload []byte)) {
+	for {
+		select {
+		case <-sub.done:
+			return
+		case <-sub.notify:
+		}
+		for {
+			sub.mu.Lock()
+			if len(sub.queue) == 0 {
+				sub.mu.Unlock()
+				break
+			}
+			payload := sub.queue[0]
+			sub.queue = sub.queue[1:]
+			sub.mu.Unlock()
+			handler(payload)
+		}
+	}
+}
+
+// MemoryBroker keeps all state in process memory, it is only suitable for a single instance
+type MemoryBroker struct {
+	values        map[string]memoryValue
+	subscriptions map[string]map[*memorySubscription]struct{} // channel -> subscriptions
+	mu            sync.Mutex
+}
+
+func NewMemory() *MemoryBroker {
+	return &MemoryBroker{
+		values:        map[string]memoryValue{},
+		subscriptions: map[string]map[*memorySubscription]struct{}{},
+	}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, channel string, payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscriptions[channel] {
+		sub.push(payload)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, channel string, handler func(payload []byte)) (func(), error) {
+	sub := &memorySubscription{
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	b.mu.Lock()
+	if b.subscriptions[channel] == nil {
+		b.subscriptions[channel] = map[*memorySubscription]struct{}{}
+	}
+	b.subscriptions[channel][sub] = struct{}{}
+	b.mu.Unlock()
+	go sub.run(handler)
+
+	return func() {
+		b.mu.Lock()
+		delete(b.subscriptions[channel], sub)
+		if len(b.subscriptions[channel]) == 0 {
+			delete(b.subscriptions, channel)
+		}
+		b.mu.Unlock()
+		sub.stop()
+	}, nil
+}
+
+func (b *MemoryBroker) newValue(value string, ttl time.Duration) memoryValue {
+	v := memoryValue{value: value}
+	if ttl > 0 {
+		v.expiresAt = time.Now().Add(ttl)
+	}
+	return v
+}
+
+// lookup returns the value of key, b.mu must be held
+func (b *MemoryBroker) lookup(key string) (memoryValue, bool) {
+	v, ok := b.values[key]
+	if !ok {
+		return memoryValue{}, false
+	}
+	if v.expired(time.Now()) {
+		delete(b.values, key)
+		return memoryValue{}, false
+	}
+	return v, true
+}
+
+func (b *MemoryBroker) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.values[key] = b.newValue(value, ttl)
+	return nil
+}
+
+func (b *MemoryBroker) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.lookup(key); ok {
+		return false, nil
+	}
+	b.values[key] = b.newValue(value, ttl)
+	return true, nil
+}
+
+func (b *MemoryBroker) Get(ctx context.Context, key string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.lookup(key)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v.value, nil
+}
+
+func (b *MemoryBroker) CompareAndSwap(ctx context.Context, key string, old string, value string, ttl time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.lookup(key)
+	if !ok || v.value != old {
+		return false, nil
+	}
+	b.values[key] = b.newValue(value, ttl)
+	return true, nil
+}
+
+func (b *MemoryBroker) CompareAndDelete(ctx context.Context, key string, value string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.lookup(key)
+	if !ok || v.value != value {
+		return false, nil
+	}
+	delete(b.values, key)
+	return true, nil
+}
+
+func (b *MemoryBroker) Keys(ctx context.Context, prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := []string{}
+	for key := range b.values {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if _, ok := b.lookup(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	subscriptions := b.subscriptions
+	b.subscriptions = map[string]map[*memorySubscription]struct{}{}
+	b.mu.Unlock()
+	for _, subs := range subscriptions {
+		for sub := range subs {
+			sub.stop()
+		}
+	}
+	return nil
+}
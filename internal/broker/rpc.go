@@ -0,0 +1,220 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+)
+
+var ErrTimeout = errors.New("instance did not respond in time")
+
+// Error is returned by Call when the handler on the other instance failed.
+// Handlers can return an *Error themselves to pass a Code the caller can act on.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type RPCHandlerFunc func(ctx context.Context, payload json.RawMessage) (any, error)
+
+type rpcRequest struct {
+	ID      uint64          `json:"id"`
+	ReplyTo string          `json:"reply_to"`
+	Method  string          `json:"method"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type rpcResponse struct {
+	ID      uint64          `json:"id"`
+	Code    string          `json:"code,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// RPC sends requests to a specific instance and waits for the response, on top of the publish/subscribe of a Broker.
+// Every instance listens on its own request and reply channel.
+type RPC struct {
+	broker      Broker
+	instanceID  string
+	timeout     time.Duration
+	handlers    map[string]RPCHandlerFunc
+	pending     map[uint64]chan rpcResponse
+	nextID      uint64
+	unsubscribe []func()
+	mu          sync.Mutex
+}
+
+func NewRPC(b Broker, instanceID string, timeout time.Duration) *RPC {
+	return &RPC{
+		broker:     b,
+		instanceID: instanceID,
+		timeout:    timeout,
+		handlers:   map[string]RPCHandlerFunc{},
+		pending:    map[uint64]chan rpcResponse{},
+	}
+}
+
+func requestChannel(instanceID string) string {
+	return "rpc:" + instanceID
+}
+
+func replyChannel(instanceID string) string {
+	return "rpc-reply:" + instanceID
+}
+
+// Handle registers the handler for method, all handlers have to be registered before Start
+func (r *RPC) Handle(method string, handler RPCHandlerFunc) {
+	r.mu.Lock()
+	r.handlers[method] = handler
+	r.mu.Unlock()
+}
+
+// Start listens for requests and replies addressed to this instance
+func (r *RPC) Start(ctx context.Context) error {
+	unsubscribeRequests, err := r.broker.Subscribe(ctx, requestChannel(r.instanceID), r.handleRequest)
+	if err != nil {
+		return err
+	}
+	unsubscribeReplies, err := r.broker.Subscribe(ctx, replyChannel(r.instanceID), r.handleReply)
+	if err != nil {
+		unsubscribeRequests()
+		return err
+	}
+	r.mu.Lock()
+	r.unsubscribe = []func(){unsubscribeRequests, unsubscribeReplies}
+	r.mu.Unlock()
+	return nil
+}
+
+// Stop stops listening, pending calls run into their timeout
+func (r *RPC) Stop() {
+	r.mu.Lock()
+	unsubscribe := r.unsubscribe
+	r.unsubscribe = nil
+	r.mu.Unlock()
+	for _, fn := range unsubscribe {
+		fn()
+	}
+}
+
+// Call runs method on instanceID and stores the response in result (if not nil)
+func (r *RPC) Call(ctx context.Context, instanceID string, method string, payload any, result any) error {
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	responseCh := make(chan rpcResponse, 1)
+	r.mu.Lock()
+	r.nextID++
+	id := r.nextID
+	r.pending[id] = responseCh
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+	}()
+
+	request, err := json.Marshal(rpcRequest{
+		ID:      id,
+		ReplyTo: r.instanceID,
+		Method:  method,
+		Payload: rawPayload,
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.broker.Publish(ctx, requestChannel(instanceID), request); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return ErrTimeout
+	case response := <-responseCh:
+		if response.Error != "" {
+			return &Error{Code: response.Code, Message: response.Error}
+		}
+		if result != nil && len(response.Payload) > 0 {
+			return json.Unmarshal(response.Payload, result)
+		}
+		return nil
+	}
+}
+
+func (r *RPC) handleRequest(payload []byte) {
+	var request rpcRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		logger.Err(fmt.Sprintf("Invalid RPC request: %s", err.Error()))
+		return
+	}
+
+	// Handlers may take a while or call other instances themselves, so they do not block the subscription
+	go func() {
+		response := rpcResponse{ID: request.ID}
+		r.mu.Lock()
+		handler, ok := r.handlers[request.Method]
+		r.mu.Unlock()
+
+		var result any
+		var err error
+		if ok {
+			ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+			result, err = handler(ctx, request.Payload)
+			cancel()
+		} else {
+			err = fmt.Errorf("unknown RPC method '%s'", request.Method)
+		}
+
+		if err != nil {
+			response.Error = err.Error()
+			var rpcErr *Error
+			if errors.As(err, &rpcErr) {
+				response.Code = rpcErr.Code
+			}
+		} else if result != nil {
+			response.Payload, err = json.Marshal(result)
+			if err != nil {
+				response.Error = err.Error()
+			}
+		}
+
+		rawResponse, err := json.Marshal(response)
+		if err != nil {
+			logger.Err(fmt.Sprintf("Could not encode RPC response for '%s': %s", request.Method, err.Error()))
+			return
+		}
+		if err := r.broker.Publish(context.Background(), replyChannel(request.ReplyTo), rawResponse); err != nil {
+			logger.Err(fmt.Sprintf("Could not send RPC response for '%s' to %s: %s", request.Method, request.ReplyTo, err.Error()))
+		}
+	}()
+}
+
+func (r *RPC) handleReply(payload []byte) {
+	var response rpcResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		logger.Err(fmt.Sprintf("Invalid RPC response: %s", err.Error()))
+		return
+	}
+	r.mu.Lock()
+	responseCh, ok := r.pending[response.ID]
+	r.mu.Unlock()
+	if !ok {
+		return // the call already timed out
+	}
+	responseCh <- response
+}
@@ -5,4 +5,6 @@ type key int
 const (
 	AuthUserKey key = iota
 	AuthSessionKey
+	AuthTokenKey    // Set instead of AuthSessionKey when authenticated with a personal access token
+	ImpersonatorKey // Admin that is impersonating the user of AuthUserKey, only set for impersonation sessions
 )
@@ -0,0 +1,117 @@
+package devicetoken
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+)
+
+// Stored tokens look like "v1:<key id>:<base64(nonce + ciphertext)>".
+// Anything without this prefix is a legacy plaintext token.
+const formatVersion = "v1"
+
+var ErrUnknownKey = errors.New("device token was encrypted with an unknown key")
+
+// Cipher encrypts device tokens at rest with the configured server keys
+type Cipher struct {
+	currentID string
+	aeads     map[string]cipher.AEAD // key id -> AEAD
+}
+
+// New creates a Cipher from the configured keys, the first key is used for encryption.
+// Without keys tokens are stored as plaintext.
+func New(keys []config.DeviceTokenKey) (*Cipher, error) {
+	c := &Cipher{
+		aeads: map[string]cipher.AEAD{},
+	}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device token key '%s': %s", key.ID, err.Error())
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device token key '%s': %s", key.ID, err.Error())
+		}
+		if i == 0 {
+			c.currentID = key.ID
+		}
+		c.aeads[key.ID] = aead
+	}
+	return c, nil
+}
+
+// Enabled reports if tokens are encrypted at all
+func (c *Cipher) Enabled() bool {
+	return c.currentID != ""
+}
+
+// Encrypt encrypts a plaintext token with the current key
+func (c *Cipher) Encrypt(token string) (string, error) {
+	if !c.Enabled() {
+		return token, nil
+	}
+	aead := c.aeads[c.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(token), nil)
+	return fmt.Sprintf("%s:%s:%s", formatVersion, c.currentID, base64.RawStdEncoding.EncodeToString(sealed)), nil
+}
+
+// Decrypt returns the plaintext of a stored token, legacy plaintext tokens are returned as is
+func (c *Cipher) Decrypt(stored string) (string, error) {
+	keyID, payload, encrypted := parse(stored)
+	if !encrypted {
+		return stored, nil
+	}
+	aead, ok := c.aeads[keyID]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid device token encoding: %s", err.Error())
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("invalid device token: too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt device token: %s", err.Error())
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReencryption reports if a stored token is not encrypted with the current key
+func (c *Cipher) NeedsReencryption(stored string) bool {
+	if !c.Enabled() {
+		return false
+	}
+	keyID, _, encrypted := parse(stored)
+	return !encrypted || keyID != c.currentID
+}
+
+// Reencrypt decrypts a stored token and encrypts it again with the current key
+func (c *Cipher) Reencrypt(stored string) (string, error) {
+	token, err := c.Decrypt(stored)
+	if err != nil {
+		return "", err
+	}
+	return c.Encrypt(token)
+}
+
+func parse(stored string) (keyID string, payload string, encrypted bool) {
+	parts := strings.SplitN(stored, ":", 3)
+	if len(parts) != 3 || parts[0] != formatVersion {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
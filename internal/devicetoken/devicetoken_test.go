@@ -0,0 +1,172 @@
+package devicetoken
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+)
+
+var (
+	currentKey = config.DeviceTokenKey{ID: "2026", Key: []byte("0123456789abcdef0123456789abcdef")}
+	oldKey     = config.DeviceTokenKey{ID: "2025", Key: []byte("fedcba9876543210fedcba9876543210")}
+)
+
+func newCipher(t *testing.T, keys ...config.DeviceTokenKey) *Cipher {
+	t.Helper()
+	c, err := New(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func encrypt(t *testing.T, c *Cipher, token string) string {
+	t.Helper()
+	stored, err := c.Encrypt(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestDecrypt(t *testing.T) {
+	current := newCipher(t, currentKey, oldKey)
+	old := newCipher(t, oldKey)
+	plain := newCipher(t)
+
+	tampered := encrypt(t, current, "token")
+	payload, err := base64.RawStdEncoding.DecodeString(tampered[strings.LastIndex(tampered, ":")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload[len(payload)-1] ^= 1
+	tampered = "v1:2026:" + base64.RawStdEncoding.EncodeToString(payload)
+
+	tests := []struct {
+		name        string
+		cipher      *Cipher
+		stored      string
+		expected    string
+		expectedErr error // Checked with errors.Is when set
+		shouldFail  bool
+	}{
+		{
+			name:     "round trip",
+			cipher:   current,
+			stored:   encrypt(t, current, "token"),
+			expected: "token",
+		},
+		{
+			name:     "older key",
+			cipher:   current,
+			stored:   encrypt(t, old, "token"),
+			expected: "token",
+		},
+		{
+			name:        "unknown key",
+			cipher:      old,
+			stored:      encrypt(t, current, "token"),
+			expectedErr: ErrUnknownKey,
+			shouldFail:  true,
+		},
+		{
+			name:       "tampered ciphertext",
+			cipher:     current,
+			stored:     tampered,
+			shouldFail: true,
+		},
+		{
+			name:       "too short",
+			cipher:     current,
+			stored:     "v1:2026:" + base64.RawStdEncoding.EncodeToString([]byte("short")),
+			shouldFail: true,
+		},
+		{
+			name:       "invalid encoding",
+			cipher:     current,
+			stored:     "v1:2026:not base64!",
+			shouldFail: true,
+		},
+		{
+			name:     "legacy plaintext",
+			cipher:   current,
+			stored:   "token",
+			expected: "token",
+		},
+		{
+			name:     "plaintext without keys",
+			cipher:   plain,
+			stored:   encrypt(t, plain, "token"),
+			expected: "token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.cipher.Decrypt(tt.stored)
+			if tt.shouldFail {
+				if err == nil {
+					t.Fatalf("expected an error, got token '%s'", token)
+				}
+				if tt.expectedErr != nil && !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected %v, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.expected {
+				t.Errorf("expected token '%s', got '%s'", tt.expected, token)
+			}
+		})
+	}
+}
+
+func TestEncryptWithoutKeys(t *testing.T) {
+	c := newCipher(t)
+	if c.Enabled() {
+		t.Error("expected encryption to be disabled without keys")
+	}
+	if stored := encrypt(t, c, "token"); stored != "token" {
+		t.Errorf("expected the token to be stored as plaintext, got '%s'", stored)
+	}
+}
+
+func TestNeedsReencryption(t *testing.T) {
+	current := newCipher(t, currentKey, oldKey)
+	old := newCipher(t, oldKey)
+
+	tests := []struct {
+		name     string
+		cipher   *Cipher
+		stored   string
+		expected bool
+	}{
+		{name: "current key", cipher: current, stored: encrypt(t, current, "token"), expected: false},
+		{name: "older key", cipher: current, stored: encrypt(t, old, "token"), expected: true},
+		{name: "plaintext", cipher: current, stored: "token", expected: true},
+		{name: "plaintext without keys", cipher: newCipher(t), stored: "token", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if needsReencryption := tt.cipher.NeedsReencryption(tt.stored); needsReencryption != tt.expected {
+				t.Errorf("expected NeedsReencryption %t, got %t", tt.expected, needsReencryption)
+			}
+			if !tt.expected {
+				return
+			}
+			reencrypted, err := tt.cipher.Reencrypt(tt.stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.cipher.NeedsReencryption(reencrypted) {
+				t.Errorf("expected '%s' to be encrypted with the current key", reencrypted)
+			}
+		})
+	}
+}
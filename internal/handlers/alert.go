@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/alerts"
+	contextkeys "github.com/CLDWare/schoolbox-backend/internal/contextKeys"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/MonkyMars/gecho"
+	"gorm.io/gorm"
+)
+
+// AlertHandler handles requests about alerts, the in-app notification list of admins
+type AlertHandler struct {
+	quitCh chan os.Signal
+	config *config.Config
+	db     *gorm.DB
+}
+
+// NewAlertHandler creates a new AlertHandler
+func NewAlertHandler(quitCh chan os.Signal, cfg *config.Config, db *gorm.DB) *AlertHandler {
+	return &AlertHandler{
+		quitCh: quitCh,
+		config: cfg,
+		db:     db,
+	}
+}
+
+type AlertInfo struct {
+	ID               uint       `json:"id"`
+	Type             string     `json:"type" enums:"device_offline,device_auth_failures"`
+	DeviceID         uint       `json:"device_id"`
+	Message          string     `json:"message" example:"Device 3 in room A-101 has been offline since 2025-01-06 08:12"`
+	RaisedAt         time.Time  `json:"raised_at" format:"date-time"`
+	AcknowledgedAt   *time.Time `json:"acknowledged_at" format:"date-time"`
+	AcknowledgedByID *uint      `json:"acknowledged_by_id"`
+	ResolvedAt       *time.Time `json:"resolved_at" format:"date-time"`
+}
+
+func toAlertInfo(alert models.Alert) AlertInfo {
+	return AlertInfo{
+		ID:               alert.ID,
+		Type:             alert.Type,
+		DeviceID:         alert.DeviceID,
+		Message:          alert.Message,
+		RaisedAt:         alert.CreatedAt,
+		AcknowledgedAt:   alert.AcknowledgedAt,
+		AcknowledgedByID: alert.AcknowledgedByID,
+		ResolvedAt:       alert.ResolvedAt,
+	}
+}
+
+// GetAlert
+//
+// @Summary		Get alerts
+// @Description	Get alerts about devices that need attention, newest first.
+// @Description	An alert is resolved automatically once the problem is gone, e.g. when an offline device is seen again.
+// @Description	Authentication failure alerts are resolved when they are acknowledged.
+// @Description	Requires permission `alert:read`
+// @Tags			alert requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			status	query		string	false	"`open`: not resolved, `unacknowledged`: open and not acknowledged, `resolved` or `all`" Enums(open,unacknowledged,resolved,all) default(open)
+// @Param			device_id	query		int	false	"Only return alerts about this device"
+// @Param			type	query		string	false	"Only return alerts of this type" Enums(device_offline,device_auth_failures)
+// @Param			limit	query		int	false	"Amount of alerts to return" default(50) maximum(100)
+// @Param			offset	query		int	false	"How much alerts to skip before starting to return alerts" default(0) minimum(0)
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]AlertInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/alert [get]
+func (h *AlertHandler) GetAlert(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	query := r.URL.Query()
+	dbQuery := h.db.Model(&models.Alert{})
+
+	// return count filters
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			gecho.BadRequest(w).WithMessage(err.Error()).Send()
+			return
+		}
+		if limit > 100 {
+			limit = 100
+		}
+		dbQuery = dbQuery.Limit(limit)
+	} else {
+		dbQuery = dbQuery.Limit(50)
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil {
+			gecho.BadRequest(w).WithMessage(err.Error()).Send()
+			return
+		}
+		dbQuery = dbQuery.Offset(offset)
+	}
+	// filters
+	switch query.Get("status") {
+	case "", "open":
+		dbQuery = dbQuery.Where("resolved_at IS NULL")
+	case "unacknowledged":
+		dbQuery = dbQuery.Where("resolved_at IS NULL AND acknowledged_at IS NULL")
+	case "resolved":
+		dbQuery = dbQuery.Where("resolved_at IS NOT NULL")
+	case "all":
+	default:
+		gecho.BadRequest(w).WithMessage("Invalid 'status', expected one of: open, unacknowledged, resolved, all").Send()
+		return
+	}
+	if deviceIDStr := query.Get("device_id"); deviceIDStr != "" {
+		deviceID, err := strconv.ParseUint(deviceIDStr, 10, 0)
+		if err != nil {
+			gecho.BadRequest(w).WithMessage("Invalid 'device_id', expected positive integer").Send()
+			return
+		}
+		dbQuery = dbQuery.Where("device_id = ?", deviceID)
+	}
+	if alertType := query.Get("type"); alertType != "" {
+		dbQuery = dbQuery.Where("type = ?", alertType)
+	}
+
+	var alertList []models.Alert
+	err := dbQuery.Order("created_at DESC").Find(&alertList).Error
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	alertInfoArray := []AlertInfo{}
+	for _, alert := range alertList {
+		alertInfoArray = append(alertInfoArray, toAlertInfo(alert))
+	}
+
+	gecho.Success(w).WithData(alertInfoArray).Send()
+}
+
+// PostAlertAcknowledge
+//
+// @Summary		Acknowledge an alert
+// @Description	Mark an alert as seen, so other admins know somebody is looking into it.
+// @Description	Acknowledging does not resolve the alert, except for authentication failure alerts which are not resolved otherwise.
+// @Description	Acknowledging it again keeps the first acknowledgement.
+// @Description	Requires permission `alert:write`
+// @Tags			alert requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Alert ID"
+// @Success		200	{object}	apiResponses.BaseResponse{data=AlertInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/alert/{id}/acknowledge [post]
+func (h *AlertHandler) PostAlertAcknowledge(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	ctx := r.Context()
+	user, ok := ctx.Value(contextkeys.AuthUserKey).(models.User)
+	if !ok {
+		gecho.InternalServerError(w).Send()
+		return
+	}
+
+	alertID, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid alert ID, expected positive integer").Send()
+		return
+	}
+
+	var alert models.Alert
+	err = h.db.Where("id = ?", alertID).First(&alert).Error
+	if err == gorm.ErrRecordNotFound {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No alert with id: %d", alertID)).Send()
+		return
+	}
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	now := time.Now()
+	err = h.db.Model(&models.Alert{}).
+		Where("id = ? AND acknowledged_at IS NULL", alertID).
+		Updates(map[string]any{"acknowledged_at": now, "acknowledged_by_id": user.ID}).Error
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	if alerts.ResolvedOnAcknowledge(alert.Type) {
+		err = h.db.Model(&models.Alert{}).Where("id = ? AND resolved_at IS NULL", alertID).Update("resolved_at", now).Error
+		if err != nil {
+			gecho.InternalServerError(w).Send()
+			logger.Err(err.Error())
+			return
+		}
+	}
+
+	if err := h.db.Where("id = ?", alertID).First(&alert).Error; err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	gecho.Success(w).WithData(toAlertInfo(alert)).Send()
+}
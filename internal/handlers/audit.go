@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	contextkeys "github.com/CLDWare/schoolbox-backend/internal/contextKeys"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/MonkyMars/gecho"
+	"gorm.io/gorm"
+)
+
+// Audit actions
+const (
+	auditLogin               = "auth.login"
+	auditLoginRejected       = "auth.login_rejected"
+	auditLogout              = "auth.logout"
+	auditAuthSessionEnd      = "auth_session.end"
+	auditPersonalTokenCreate = "personal_token.create"
+	auditPersonalTokenRevoke = "personal_token.revoke"
+	auditDeviceRegister      = "device.register"
+	auditDeviceRelink        = "device.relink"
+	auditDeviceClaim         = "device.claim"
+	auditDeviceDelete        = "device.delete"
+	auditDeviceTokenRotate   = "device.token_rotate"
+	auditSessionStop         = "session.stop"
+	auditUserInvite          = "user.invite"
+	auditUserUpdate          = "user.update"
+	auditUserRoleChange      = "user.role_change"
+	auditUserDeactivate      = "user.deactivate"
+	auditUserActivate        = "user.activate"
+	auditUserDelete          = "user.delete"
+	auditImpersonationStart  = "impersonation.start"
+	auditImpersonationStop   = "impersonation.stop"
+)
+
+// Audit target types
+const (
+	auditTargetUser          = "user"
+	auditTargetDevice        = "device"
+	auditTargetSession       = "session"
+	auditTargetPersonalToken = "personal_token"
+)
+
+// recordAudit writes event to the audit log. The actor is the user r is authenticated as, unless event already has one.
+// Actions done while impersonating also record the admin that impersonated the actor.
+// Failing to record is logged, but does not fail the action that was audited.
+func recordAudit(db *gorm.DB, r *http.Request, event models.AuditEvent) {
+	// Record the action even when the client went away after it was done
+	ctx := context.WithoutCancel(r.Context())
+	if event.ActorID == nil {
+		if user, ok := ctx.Value(contextkeys.AuthUserKey).(models.User); ok {
+			event.ActorID = &user.ID
+		}
+	}
+	if event.ImpersonatorID == nil {
+		if impersonator, ok := ctx.Value(contextkeys.ImpersonatorKey).(models.User); ok {
+			event.ImpersonatorID = &impersonator.ID
+		}
+	}
+	event.IPAddress = clientAddress(r)
+	if err := gorm.G[models.AuditEvent](db).Create(ctx, &event); err != nil {
+		logger.Err(fmt.Sprintf("Could not record audit event %s: %s", event.Action, err.Error()))
+	}
+}
+
+// AuditHandler handles requests about the audit log
+type AuditHandler struct {
+	quitCh chan os.Signal
+	config *config.Config
+	db     *gorm.DB
+}
+
+// NewAuditHandler creates a new AuditHandler
+func NewAuditHandler(quitCh chan os.Signal, cfg *config.Config, db *gorm.DB) *AuditHandler {
+	return &AuditHandler{
+		quitCh: quitCh,
+		config: cfg,
+		db:     db,
+	}
+}
+
+type AuditEventInfo struct {
+	ID                uint           `json:"id"`
+	OccurredAt        time.Time      `json:"occurred_at" format:"date-time"`
+	ActorID           *uint          `json:"actor_id"` // null when nobody was logged in, e.g. for rejected logins
+	ActorEmail        string         `json:"actor_email" example:"admin@school.nl"`
+	ImpersonatorID    *uint          `json:"impersonator_id"` // Admin that did the action while impersonating the actor
+	ImpersonatorEmail string         `json:"impersonator_email"`
+	Action            string         `json:"action" example:"device.delete"`
+	TargetType        string         `json:"target_type" example:"device"`
+	TargetID          *uint          `json:"target_id"`
+	Details           map[string]any `json:"details"`
+	IPAddress         string         `json:"ip_address" example:"192.0.2.10"`
+}
+
+func toAuditEventInfo(event models.AuditEvent) AuditEventInfo {
+	info := AuditEventInfo{
+		ID:             event.ID,
+		OccurredAt:     event.CreatedAt,
+		ActorID:        event.ActorID,
+		ImpersonatorID: event.ImpersonatorID,
+		Action:         event.Action,
+		TargetType:     event.TargetType,
+		TargetID:       event.TargetID,
+		Details:        event.Details,
+		IPAddress:      event.IPAddress,
+	}
+	if event.Actor != nil {
+		info.ActorEmail = event.Actor.Email
+	}
+	if event.Impersonator != nil {
+		info.ImpersonatorEmail = event.Impersonator.Email
+	}
+	return info
+}
+
+// auditQuery returns the audit events matching the filters in the query of r
+func (h *AuditHandler) auditQuery(r *http.Request) (*gorm.DB, error) {
+	query := r.URL.Query()
+	// Deleted users are still the actor of what they did
+	unscoped := func(db *gorm.DB) *gorm.DB { return db.Unscoped() }
+	dbQuery := h.db.WithContext(r.Context()).Model(&models.AuditEvent{}).Preload("Actor", unscoped).Preload("Impersonator", unscoped)
+
+	if actorIDStr := query.Get("actor_id"); actorIDStr != "" {
+		actorID, err := strconv.ParseUint(actorIDStr, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid 'actor_id', expected positive integer")
+		}
+		dbQuery = dbQuery.Where("actor_id = ?", actorID)
+	}
+	if action := query.Get("action"); action != "" {
+		dbQuery = dbQuery.Where("action = ?", action)
+	}
+	if targetType := query.Get("target_type"); targetType != "" {
+		dbQuery = dbQuery.Where("target_type = ?", targetType)
+	}
+	if targetIDStr := query.Get("target_id"); targetIDStr != "" {
+		targetID, err := strconv.ParseUint(targetIDStr, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid 'target_id', expected positive integer")
+		}
+		dbQuery = dbQuery.Where("target_id = ?", targetID)
+	}
+	if query.Get("from") != "" || query.Get("to") != "" {
+		from, to, err := parseTimeRange(r, time.Time{}, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		dbQuery = dbQuery.Where("created_at BETWEEN ? AND ?", from, to)
+	}
+
+	return dbQuery, nil
+}
+
+// GetAudit
+//
+// @Summary		Get the audit log
+// @Description	Get who did administrative and security relevant actions, like deleting devices, changing roles and logging in, newest first.
+// @Description	Events are kept for JANITOR_AUDIT_RETENTION and can not be changed.
+// @Description	Requires permission `audit:read`
+// @Tags			audit requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			actor_id	query		int	false	"Only return events of actions by this user"
+// @Param			action	query		string	false	"Only return events of this action" example(device.delete)
+// @Param			target_type	query		string	false	"Only return events about this kind of target" Enums(user,device,session,personal_token)
+// @Param			target_id	query		int	false	"Only return events about the target with this ID"
+// @Param			from	query		string	false	"Only return events after this time (RFC3339)" format(date-time)
+// @Param			to	query		string	false	"Only return events before this time (RFC3339)" format(date-time)
+// @Param			limit	query		int	false	"Amount of events to return" default(50) maximum(100)
+// @Param			offset	query		int	false	"How much events to skip before starting to return events" default(0) minimum(0)
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]AuditEventInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/audit [get]
+func (h *AuditHandler) GetAudit(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	dbQuery, err := h.auditQuery(r)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage(err.Error()).Send()
+		return
+	}
+
+	// return count filters
+	query := r.URL.Query()
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			gecho.BadRequest(w).WithMessage(err.Error()).Send()
+			return
+		}
+		if limit > 100 {
+			limit = 100
+		}
+		dbQuery = dbQuery.Limit(limit)
+	} else {
+		dbQuery = dbQuery.Limit(50)
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil {
+			gecho.BadRequest(w).WithMessage(err.Error()).Send()
+			return
+		}
+		dbQuery = dbQuery.Offset(offset)
+	}
+
+	var events []models.AuditEvent
+	if err := dbQuery.Order("created_at DESC, id DESC").Find(&events).Error; err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	eventInfoArray := []AuditEventInfo{}
+	for _, event := range events {
+		eventInfoArray = append(eventInfoArray, toAuditEventInfo(event))
+	}
+
+	gecho.Success(w).WithData(eventInfoArray).Send()
+}
+
+// GetAuditExport
+//
+// @Summary		Export the audit log
+// @Description	Download all audit events matching the filters as CSV, oldest first. Takes the same filters as GET /audit.
+// @Description	The details column holds the details of the event as JSON.
+// @Description	Requires permission `audit:read`
+// @Tags			audit requiresAuth requiresPermission
+// @Produce		text/csv
+// @Param			actor_id	query		int	false	"Only export events of actions by this user"
+// @Param			action	query		string	false	"Only export events of this action" example(device.delete)
+// @Param			target_type	query		string	false	"Only export events about this kind of target" Enums(user,device,session,personal_token)
+// @Param			target_id	query		int	false	"Only export events about the target with this ID"
+// @Param			from	query		string	false	"Only export events after this time (RFC3339)" format(date-time)
+// @Param			to	query		string	false	"Only export events before this time (RFC3339)" format(date-time)
+// @Success		200	{string}	string	"id,occurred_at,actor_id,actor_email,impersonator_id,impersonator_email,action,target_type,target_id,ip_address,details"
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/audit/export [get]
+func (h *AuditHandler) GetAuditExport(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	dbQuery, err := h.auditQuery(r)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage(err.Error()).Send()
+		return
+	}
+
+	filename := fmt.Sprintf("audit-%s.csv", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "occurred_at", "actor_id", "actor_email", "impersonator_id", "impersonator_email", "action", "target_type", "target_id", "ip_address", "details"})
+
+	// The log can be large, so it is read and written in batches of increasing IDs instead of loading it at once
+	var events []models.AuditEvent
+	result := dbQuery.FindInBatches(&events, 500, func(tx *gorm.DB, batch int) error {
+		for _, event := range events {
+			info := toAuditEventInfo(event)
+			details := []byte{}
+			if len(info.Details) != 0 {
+				var err error
+				if details, err = json.Marshal(info.Details); err != nil {
+					return err
+				}
+			}
+			writer.Write([]string{
+				strconv.FormatUint(uint64(info.ID), 10),
+				info.OccurredAt.UTC().Format(time.RFC3339),
+				formatOptionalID(info.ActorID),
+				info.ActorEmail,
+				formatOptionalID(info.ImpersonatorID),
+				info.ImpersonatorEmail,
+				info.Action,
+				info.TargetType,
+				formatOptionalID(info.TargetID),
+				info.IPAddress,
+				string(details),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if result.Error != nil {
+		// The status was already sent with the first rows, all we can do is stop writing
+		logger.Err(fmt.Sprintf("Could not export audit log: %s", result.Error.Error()))
+		return
+	}
+	writer.Flush()
+}
+
+// formatOptionalID formats id for the audit export, nil is written as an empty field
+func formatOptionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
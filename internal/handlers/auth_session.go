@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/internal/authsession"
+	contextkeys "github.com/CLDWare/schoolbox-backend/internal/contextKeys"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/MonkyMars/gecho"
+	"gorm.io/gorm"
+)
+
+// AuthSessionInfo is a login session, the session token is never returned
+type AuthSessionInfo struct {
+	ID             uint       `json:"id"`
+	CreatedAt      time.Time  `json:"created_at" format:"date-time"`
+	LastUsedAt     *time.Time `json:"last_used_at" format:"date-time"`
+	ExpiresAt      time.Time  `json:"expires_at" format:"date-time"` // Moved forward when the session is used, up to max_expires_at
+	MaxExpiresAt   time.Time  `json:"max_expires_at" format:"date-time"`
+	UserAgent      string     `json:"user_agent" example:"Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0"`
+	IPAddress      string     `json:"ip_address" example:"192.0.2.10"`
+	Current        bool       `json:"current"`         // The session the request was made with
+	ImpersonatorID *uint      `json:"impersonator_id"` // Admin that started the session to impersonate the user
+}
+
+func toAuthSessionInfo(session models.AuthSession, currentID uint) AuthSessionInfo {
+	return AuthSessionInfo{
+		ID:             session.ID,
+		CreatedAt:      session.CreatedAt,
+		LastUsedAt:     session.LastUsedAt,
+		ExpiresAt:      session.ExpiresAt,
+		MaxExpiresAt:   session.AbsoluteExpiresAt,
+		UserAgent:      session.UserAgent,
+		IPAddress:      session.IPAddress,
+		Current:        session.ID == currentID,
+		ImpersonatorID: session.ImpersonatorID,
+	}
+}
+
+// currentAuthSessionID returns the ID of the auth session a request was made with, 0 when it was made with a personal access token
+func currentAuthSessionID(ctx context.Context) uint {
+	session, ok := ctx.Value(contextkeys.AuthSessionKey).(models.AuthSession)
+	if !ok {
+		return 0
+	}
+	return session.ID
+}
+
+// writeAuthSessions writes the active auth sessions of userID, most recently created first
+func (h *UserHandler) writeAuthSessions(w http.ResponseWriter, r *http.Request, userID uint) {
+	ctx := r.Context()
+	sessions, err := gorm.G[models.AuthSession](h.db).
+		Where("user_id = ? AND expires_at > ?", userID, time.Now()).Order("created_at DESC").Find(ctx)
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	currentID := currentAuthSessionID(ctx)
+	sessionInfoArray := []AuthSessionInfo{}
+	for _, session := range sessions {
+		sessionInfoArray = append(sessionInfoArray, toAuthSessionInfo(session, currentID))
+	}
+
+	gecho.Success(w).WithData(sessionInfoArray).Send()
+}
+
+// endAuthSessions ends the active auth sessions of userID that match the extra conditions, like /logout it expires
+// them instead of deleting them. Returns the number of sessions that were ended.
+func (h *UserHandler) endAuthSessions(ctx context.Context, userID uint, query string, args ...any) (int, error) {
+	now := time.Now()
+	dbQuery := gorm.G[models.AuthSession](h.db).Where("user_id = ? AND expires_at > ?", userID, now)
+	if query != "" {
+		dbQuery = dbQuery.Where(query, args...)
+	}
+	return dbQuery.Update(ctx, "expires_at", now)
+}
+
+// expireSessionCookie removes the auth session cookie, when the current session was ended
+func (h *UserHandler) expireSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, authsession.ExpiredCookie(h.config))
+}
+
+// GetMeSessions
+//
+// @Summary		Get the login sessions of the current user
+// @Description	Get the active login sessions of the current user, newest first. The session of the request has `current` set.
+// @Description	Can not be used with a personal access token or while impersonating.
+// @Tags			user requiresAuth
+// @Accept			json
+// @Produce		json
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]AuthSessionInfo}
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError "Authenticated with a personal access token or impersonating"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/me/sessions [get]
+func (h *UserHandler) GetMeSessions(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	h.writeAuthSessions(w, r, user.ID)
+}
+
+// DeleteMeSessions
+//
+// @Summary		Log out everywhere
+// @Description	End all login sessions of the current user, including the current one unless `keep_current` is set.
+// @Description	Personal access tokens are not revoked, see `/me/tokens`. Can not be used with a personal access token or while impersonating.
+// @Tags			user requiresAuth
+// @Accept			json
+// @Produce		json
+// @Param			keep_current	query		bool	false	"Stay logged in with the session of this request"	default(false)
+// @Success		204 {object}	apiResponses.BaseBase
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError "Authenticated with a personal access token or impersonating"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/me/sessions [delete]
+func (h *UserHandler) DeleteMeSessions(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodDelete); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	keepCurrent := false
+	if keepCurrentStr := r.URL.Query().Get("keep_current"); keepCurrentStr != "" {
+		var err error
+		keepCurrent, err = strconv.ParseBool(keepCurrentStr)
+		if err != nil {
+			gecho.BadRequest(w).WithMessage("Invalid 'keep_current', expected boolean").Send()
+			return
+		}
+	}
+
+	var err error
+	var ended int
+	if keepCurrent {
+		ended, err = h.endAuthSessions(ctx, user.ID, "id != ?", currentAuthSessionID(ctx))
+	} else {
+		ended, err = h.endAuthSessions(ctx, user.ID, "")
+	}
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("User %d ended %d of their login sessions", user.ID, ended))
+	recordAudit(h.db, r, models.AuditEvent{
+		Action:     auditAuthSessionEnd,
+		TargetType: auditTargetUser,
+		TargetID:   &user.ID,
+		Details:    map[string]any{"ended": ended, "keep_current": keepCurrent},
+	})
+
+	if !keepCurrent {
+		h.expireSessionCookie(w)
+	}
+	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
+}
+
+// DeleteMeSession
+//
+// @Summary		End a login session
+// @Description	End a login session of the current user, e.g. on a computer that was left logged in.
+// @Description	Can not be used with a personal access token or while impersonating.
+// @Tags			user requiresAuth
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Login session ID"
+// @Success		204 {object}	apiResponses.BaseBase
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError "Authenticated with a personal access token or impersonating"
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/me/sessions/{id} [delete]
+func (h *UserHandler) DeleteMeSession(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodDelete); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid session ID, expected positive integer").Send()
+		return
+	}
+
+	// Sessions of other users are not found, so their IDs can not be discovered
+	ended, err := h.endAuthSessions(ctx, user.ID, "id = ?", sessionID)
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	if ended == 0 {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No active login session with id: %d", sessionID)).Send()
+		return
+	}
+	recordAudit(h.db, r, models.AuditEvent{Action: auditAuthSessionEnd, TargetType: auditTargetUser, TargetID: &user.ID, Details: map[string]any{"session_id": sessionID}})
+
+	if uint(sessionID) == currentAuthSessionID(ctx) {
+		h.expireSessionCookie(w)
+	}
+	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
+}
+
+// GetUserSessions
+//
+// @Summary		Get the login sessions of a user
+// @Description	Get the active login sessions of a user, newest first
+// @Description	Requires permission `user:write`
+// @Tags			user requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"User ID"
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]AuthSessionInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/user/{id}/sessions [get]
+func (h *UserHandler) GetUserSessions(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	user, ok := h.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	h.writeAuthSessions(w, r, user.ID)
+}
+
+// DeleteUserSessions
+//
+// @Summary		Log a user out everywhere
+// @Description	End all login sessions of a user, e.g. when their laptop was stolen. Personal access tokens are not revoked.
+// @Description	Requires permission `user:write`
+// @Tags			user requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"User ID"
+// @Success		204 {object}	apiResponses.BaseBase
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/user/{id}/sessions [delete]
+func (h *UserHandler) DeleteUserSessions(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodDelete); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+	user, ok := h.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	ended, err := h.endAuthSessions(ctx, user.ID, "")
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	if admin, ok := ctx.Value(contextkeys.AuthUserKey).(models.User); ok {
+		logger.Info(fmt.Sprintf("User %d ended %d login sessions of user %d", admin.ID, ended, user.ID))
+	}
+	recordAudit(h.db, r, models.AuditEvent{Action: auditAuthSessionEnd, TargetType: auditTargetUser, TargetID: &user.ID, Details: map[string]any{"ended": ended}})
+
+	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
+}
+
+// DeleteUserSession
+//
+// @Summary		End a login session of a user
+// @Description	End a single login session of a user
+// @Description	Requires permission `user:write`
+// @Tags			user requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id			path		string	true	"User ID"
+// @Param			session_id	path		string	true	"Login session ID"
+// @Success		204 {object}	apiResponses.BaseBase
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/user/{id}/sessions/{session_id} [delete]
+func (h *UserHandler) DeleteUserSession(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodDelete); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+	user, ok := h.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(r.PathValue("session_id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid session ID, expected positive integer").Send()
+		return
+	}
+
+	ended, err := h.endAuthSessions(ctx, user.ID, "id = ?", sessionID)
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	if ended == 0 {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No active login session with id %d for user %d", sessionID, user.ID)).Send()
+		return
+	}
+	recordAudit(h.db, r, models.AuditEvent{Action: auditAuthSessionEnd, TargetType: auditTargetUser, TargetID: &user.ID, Details: map[string]any{"session_id": sessionID}})
+
+	if uint(sessionID) == currentAuthSessionID(ctx) {
+		h.expireSessionCookie(w)
+	}
+	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
+}
@@ -2,267 +2,519 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/authprovider"
+	"github.com/CLDWare/schoolbox-backend/internal/authsession"
 	contextkeys "github.com/CLDWare/schoolbox-backend/internal/contextKeys"
+	"github.com/CLDWare/schoolbox-backend/internal/loginstate"
+	"github.com/CLDWare/schoolbox-backend/internal/provisioning"
 	models "github.com/CLDWare/schoolbox-backend/pkg/db"
 	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/CLDWare/schoolbox-backend/pkg/tokenhash"
 	"github.com/MonkyMars/gecho"
 	"gorm.io/gorm"
-
-	"google.golang.org/api/idtoken"
 )
 
 // RegistrationHandler handles registration-related requests
 type AuthenticationHandler struct {
-	quitCh chan os.Signal
-	config *config.Config
-	db     *gorm.DB
+	quitCh    chan os.Signal
+	config    *config.Config
+	db        *gorm.DB
+	providers *authprovider.Registry
+	states    *loginstate.Signer
 }
 
 // NewRegistrationHandler creates a new registration handler
 func NewAuthenticationHandler(quitCh chan os.Signal, cfg *config.Config, db *gorm.DB) *AuthenticationHandler {
 	return &AuthenticationHandler{
-		quitCh: quitCh,
-		config: cfg,
-		db:     db,
+		quitCh:    quitCh,
+		config:    cfg,
+		db:        db,
+		providers: authprovider.NewRegistry(cfg.OAuth.Providers),
+		states:    loginstate.NewSigner(cfg.OAuth.StateKey),
 	}
 }
 
-// https://developers.google.com/identity/protocols/oauth2/native-app?utm_source=chatgpt.com#exchange-authorization-code
-type GoogleOAuthTokenResponseBody struct {
-	AccessToken           string `json:"access_token"`             // The token that your application sends to authorize a Google API request.
-	ExpiresIn             int    `json:"expires_in"`               // The remaining lifetime of the access token in seconds.
-	IdToken               string `json:"id_token"`                 // Note: This property is only returned if your request included an identity scope, such as openid, profile, or email. The value is a JSON Web Token (JWT) that contains digitally signed identity information about the user.
-	RefreshToken          string `json:"refresh_token"`            // A token that you can use to obtain a new access token. Refresh tokens are valid until the user revokes access or the refresh token expires. Note that refresh tokens are always returned for installed applications.
-	RefreshTokenExpiresIn int    `json:"refresh_token_expires_in"` // The remaining lifetime of the refresh token in seconds. This value is only set when the user grants time-based access.
-	Scope                 string `json:"scope"`                    // The scopes of access granted by the access_token expressed as a list of space-delimited, case-sensitive strings.
-	TokenType             string `json:"token_type"`               // The type of token returned. At this time, this field's value is always set to Bearer.
+// LoginProviderInfo is a provider users can log in with
+type LoginProviderInfo struct {
+	ID       string `json:"id" example:"google"`
+	Name     string `json:"name" example:"Google"`
+	LoginURL string `json:"login_url" example:"/api/login/google"`
 }
 
-type GoogleIdTokenClaims struct {
-	ATHash        string `json:"at_hash"`
-	AUD           string `json:"aud"`
-	AZP           string `json:"azp"`
-	Email         string `json:"email"`
-	EmailVerified bool   `json:"email_verified"`
-	ExpiresIn     int    `json:"exp"`
-	GivenName     string `json:"given_name"`
-	ISS           string `json:"iss"`
-	Name          string `json:"name"`
-	Picture       string `json:"picture"`
-	GoogleSubject string `json:"sub"`
+// loginRejection is the reason a login was rejected, the login error page explains it to the user
+type loginRejection string
+
+const (
+	rejectionProviderError    loginRejection = "provider_error"
+	rejectionInvalidState     loginRejection = "invalid_state" // The login expired or was started in another browser
+	rejectionNoEmail          loginRejection = "no_email"
+	rejectionEmailNotVerified loginRejection = "email_not_verified"
+	rejectionDomainNotAllowed loginRejection = "domain_not_allowed"
+	rejectionNotInvited       loginRejection = "not_invited"
+	rejectionEmailTaken       loginRejection = "email_taken" // The email address belongs to a user linked to another provider
+	rejectionAccountDeleted   loginRejection = "account_deleted"
+	rejectionAccountInactive  loginRejection = "account_deactivated"
+)
+
+func (r loginRejection) Error() string {
+	return string(r)
+}
+
+// rejectLogin redirects to the login error page
+func (h *AuthenticationHandler) rejectLogin(w http.ResponseWriter, r *http.Request, provider *authprovider.Provider, reason loginRejection) {
+	errorURL, err := url.Parse(h.config.Provisioning.ErrorURL)
+	if err != nil {
+		gecho.Forbidden(w).WithMessage(fmt.Sprintf("Login rejected: %s", reason)).Send()
+		return
+	}
+	params := errorURL.Query()
+	params.Set("reason", string(reason))
+	params.Set("provider", provider.Name)
+	errorURL.RawQuery = params.Encode()
+	http.Redirect(w, r, errorURL.String(), http.StatusFound)
+}
+
+// setLoginStateCookie stores value in the login state cookie, an empty value removes the cookie
+func (h *AuthenticationHandler) setLoginStateCookie(w http.ResponseWriter, value string) {
+	cookie := http.Cookie{
+		Name:     loginstate.CookieName,
+		Value:    value,
+		Domain:   h.config.Server.Host,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.config.OAuth.CookieSecure,
+		// Lax, because the provider redirects back to the callback from another site
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(h.config.OAuth.StateTTL.Seconds()),
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, &cookie)
+}
+
+// legacyCallbackProviderID is the provider that uses /oauth2callback, the callback url from before other providers than
+// Google were supported. Existing deployments have it registered as redirect uri at Google.
+const legacyCallbackProviderID = "google"
+
+// callbackURL returns the url provider redirects back to after a login
+func (h *AuthenticationHandler) callbackURL(provider *authprovider.Provider) (string, error) {
+	if provider.ID == legacyCallbackProviderID {
+		return url.JoinPath(h.config.GetServerURL(), "/api/oauth2callback")
+	}
+	return url.JoinPath(h.config.GetServerURL(), "/api/oauth2callback", provider.ID)
+}
+
+// GetLoginProviders
+//
+// @Summary		Get login providers
+// @Description	Get the OpenID Connect providers users can log in with, in the configured order
+// @Description	With AUTH_DEV_LOGIN the development login comes first, with id `dev`
+// @Tags			auth
+// @Produce		json
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]LoginProviderInfo}
+// @Router			/login/providers [get]
+func (h *AuthenticationHandler) GetLoginProviders(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	providerInfoArray := []LoginProviderInfo{}
+	if h.config.OAuth.DevLogin {
+		providerInfoArray = append(providerInfoArray, LoginProviderInfo{
+			ID:       config.DevLoginProviderID,
+			Name:     "Development login",
+			LoginURL: "/api/login/" + config.DevLoginProviderID,
+		})
+	}
+	for _, provider := range h.providers.List() {
+		providerInfoArray = append(providerInfoArray, LoginProviderInfo{
+			ID:       provider.ID,
+			Name:     provider.Name,
+			LoginURL: "/api/login/" + provider.ID,
+		})
+	}
+
+	gecho.Success(w).WithData(providerInfoArray).Send()
 }
 
 // redirects GET /login requests
 // GetLogin
 //
-// @Summary		Login with google OAuth
-// @Description	Redirect to the google OAuth endpoint
+// @Summary		Login with an OpenID Connect provider
+// @Description	Redirect to the login page of a provider, `/login` uses the first configured provider
+// @Description	or the development login page when AUTH_DEV_LOGIN is enabled
+// @Description	After logging in the user is redirected to `return_to`, which is a path on this server or an url on one of the LOGIN_RETURN_TO_ORIGINS.
 // @Tags		auth
+// @Param			provider	path		string	true	"Provider ID, see `/login/providers`"
+// @Param			return_to	query		string	false	"Where to redirect after logging in"	default(/)
 // @Response		302
-// @Router			/login [get]
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/login/{provider} [get]
 func (h *AuthenticationHandler) GetLogin(w http.ResponseWriter, r *http.Request) {
 	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
 		err.Send() // Automatically sends 405 Method Not Allowed
 		return
 	}
 
-	params := url.Values{}
-	params.Set("client_id", h.config.OAuth.ClientId)
-	redirectURI, err := url.JoinPath("http://"+h.config.GetServerAddress(), "/api/oauth2callback")
+	provider := h.providers.Default()
+	providerID := r.PathValue("provider")
+	if providerID != "" {
+		var ok bool
+		provider, ok = h.providers.Get(providerID)
+		if !ok {
+			gecho.NotFound(w).WithMessage(fmt.Sprintf("No login provider '%s'", providerID)).Send()
+			return
+		}
+	}
+
+	returnTo := r.URL.Query().Get("return_to")
+	if returnTo == "" {
+		returnTo = "/"
+	}
+	if !loginstate.ValidReturnTo(returnTo, h.config.OAuth.ReturnToOrigins) {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Can not return to '%s' after logging in", returnTo)).Send()
+		return
+	}
+
+	if providerID == "" && h.config.OAuth.DevLogin {
+		devLoginURL, err := h.devLoginURL()
+		if err != nil {
+			logger.Err(err.Error())
+			gecho.InternalServerError(w).Send()
+			return
+		}
+		http.Redirect(w, r, devLoginURL+"?"+url.Values{"return_to": {returnTo}}.Encode(), http.StatusFound)
+		return
+	}
+
+	redirectURI, err := h.callbackURL(provider)
 	if err != nil {
 		errMsg := fmt.Sprintf("Could not create login redirect uri: %s", err.Error())
 		logger.Err(errMsg)
 		gecho.InternalServerError(w).WithMessage(errMsg).Send()
+		return
+	}
+	state := loginstate.New(provider.ID, returnTo, h.config.OAuth.StateTTL)
+	loginURL, err := provider.AuthCodeURL(redirectURI, state.Value, state.Verifier)
+	if err != nil {
+		logger.Err(err.Error())
+		gecho.InternalServerError(w).WithMessage(fmt.Sprintf("Login provider '%s' is not available", provider.ID)).Send()
+		return
+	}
+	stateCookie, err := h.states.Encode(state)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not encode login state: %s", err.Error()))
+		gecho.InternalServerError(w).Send()
+		return
 	}
-	params.Set("redirect_uri", redirectURI)
-	params.Set("response_type", "code")
-	params.Set("scope", "openid email profile")
 
-	oauthURL := "https://accounts.google.com/o/oauth2/v2/auth?" + params.Encode()
-	logger.Info(oauthURL)
-	http.Redirect(w, r, oauthURL, http.StatusFound)
+	h.setLoginStateCookie(w, stateCookie)
+	http.Redirect(w, r, loginURL, http.StatusFound)
 }
 
 // GetOAuthCallback
 //
-// @Summary		Callback url for google OAuth
-// @Description	The callback url for google OAuth that processes the things. Can not be used indivualy (requires google OAuth code)
-// @Description Refer too google OAuth docs for more information.
+// @Summary		Callback url for OpenID Connect providers
+// @Description	The callback url the login page of a provider redirects back to. Can not be used indivualy (requires an authorization code
+// @Description	and the login state cookie of the browser that started the login)
+// @Description	A user is created on the first login, users are linked to the provider account they logged in with.
+// @Description	Rejected logins are redirected to LOGIN_ERROR_URL with the `reason` and `provider` in the query, the reason is one of
+// @Description	`provider_error`, `invalid_state`, `no_email`, `email_not_verified`, `domain_not_allowed`, `not_invited`, `email_taken`, `account_deleted` or `account_deactivated`.
+// @Description	The `google` provider uses `/oauth2callback` without provider ID, the callback url from before other providers were supported.
 // @Tags			auth
+// @Param			provider	path		string	true	"Provider ID"
 // @Response		302
-// @Router			/oauth2callback [get]
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/oauth2callback/{provider} [get]
 func (h *AuthenticationHandler) GetOAuthCallback(w http.ResponseWriter, r *http.Request) {
 	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
 		err.Send() // Automatically sends 405 Method Not Allowed
 		return
 	}
+	ctx := r.Context()
+
+	providerID := r.PathValue("provider")
+	if providerID == "" {
+		providerID = legacyCallbackProviderID
+	}
+	provider, ok := h.providers.Get(providerID)
+	if !ok {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No login provider '%s'", providerID)).Send()
+		return
+	}
+
+	// The state cookie can only be used once
+	h.setLoginStateCookie(w, "")
+	stateCookie, err := r.Cookie(loginstate.CookieName)
+	if err != nil {
+		logger.Info(fmt.Sprintf("Rejected callback from OIDC provider '%s' without login state", provider.ID))
+		h.rejectLogin(w, r, provider, rejectionInvalidState)
+		return
+	}
+	state, err := h.states.Decode(stateCookie.Value)
+	if err == nil {
+		err = state.Verify(provider.ID, r.URL.Query().Get("state"))
+	}
+	if err != nil {
+		logger.Info(fmt.Sprintf("Rejected callback from OIDC provider '%s': %s", provider.ID, err.Error()))
+		h.rejectLogin(w, r, provider, rejectionInvalidState)
+		return
+	}
 
 	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		logger.Info(fmt.Sprintf("Login at OIDC provider '%s' failed: %s", provider.ID, errCode))
+		h.rejectLogin(w, r, provider, rejectionProviderError)
+		return
+	}
 	code := query.Get("code")
+	if code == "" {
+		gecho.BadRequest(w).WithMessage("Missing 'code'").Send()
+		return
+	}
 
-	data := url.Values{}
-	data.Set("code", code)
-	data.Set("client_id", h.config.OAuth.ClientId)
-	data.Set("client_secret", h.config.OAuth.ClientSecret)
-	redirectURI, err := url.JoinPath("http://"+h.config.GetServerAddress(), "/api/oauth2callback")
+	redirectURI, err := h.callbackURL(provider)
 	if err != nil {
 		errMsg := fmt.Sprintf("Could not create login redirect uri: %s", err.Error())
 		logger.Err(errMsg)
 		gecho.InternalServerError(w).WithMessage(errMsg).Send()
 		return
 	}
-	data.Set("redirect_uri", redirectURI)
-	data.Set("grant_type", "authorization_code")
-
-	resp, err := http.Post(
-		"https://oauth2.googleapis.com/token",
-		"application/x-www-form-urlencoded",
-		strings.NewReader(data.Encode()),
-	)
+	identity, err := provider.Exchange(ctx, code, redirectURI, state.Verifier)
 	if err != nil {
-		errMsg := fmt.Sprintf("Could not retrieve token using OAuth callback code: %s", err.Error())
-		logger.Err(errMsg)
-		gecho.InternalServerError(w).Send() // No message because we are handeling auth data and i dont want to accidently leak it or something
+		logger.Err(err.Error())
+		h.rejectLogin(w, r, provider, rejectionProviderError)
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 199 || 300 < resp.StatusCode {
-		logger.Err(fmt.Sprintf("An %d error occured while posting to https://oauth2.googleapis.com/token", resp.StatusCode))
+	user, err := h.userForIdentity(ctx, identity)
+	if rejection, ok := err.(loginRejection); ok {
+		logger.Info(fmt.Sprintf("Rejected login of '%s' from OIDC provider '%s': %s", identity.Email, provider.ID, rejection))
+		recordAudit(h.db, r, models.AuditEvent{
+			Action:  auditLoginRejected,
+			Details: map[string]any{"provider": provider.ID, "email": identity.Email, "reason": string(rejection)},
+		})
+		h.rejectLogin(w, r, provider, rejection)
+		return
+	}
+	if err != nil {
 		gecho.InternalServerError(w).Send()
+		logger.Err(fmt.Sprintf("An error occured retrieving the user: %s", err.Error()))
+		return
+	}
 
+	// Download the pfp image async (also updates from the provider if user already exists)
+	if identity.Picture != "" {
+		go downloadProfilePicture(user.ID, identity.Picture)
 	}
 
-	body := GoogleOAuthTokenResponseBody{}
-	err = json.NewDecoder(resp.Body).Decode(&body)
-	if err != nil {
-		gecho.InternalServerError(w).Send() // No message because we are handeling auth data and i dont want to accidently leak it or something
-		logger.Err(err.Error())
+	if !h.startAuthSession(w, r, user, provider.ID) {
 		return
 	}
+	http.Redirect(w, r, state.ReturnTo, http.StatusFound)
+}
 
-	ctx := context.Background()
-	payload, err := idtoken.Validate(ctx, body.IdToken, h.config.OAuth.ClientId)
+// startAuthSession logs user in by creating an auth session and setting its cookie. When the session can not be
+// created an error is sent and false is returned.
+func (h *AuthenticationHandler) startAuthSession(w http.ResponseWriter, r *http.Request, user models.User, providerID string) bool {
+	// Create auth session, only the hash of the token is stored
+	session_token, err := authsession.Generate()
 	if err != nil {
-		gecho.InternalServerError(w).Send()
+		gecho.InternalServerError(w).WithMessage("Could not create authenticated session").Send()
 		logger.Err(err.Error())
-		return
+		return false
 	}
-
-	jsonClaims, err := json.Marshal(payload.Claims)
-	if err != nil {
-		gecho.InternalServerError(w).Send()
-		logger.Err(err)
-		return
+	now := time.Now()
+	session := models.AuthSession{
+		TokenHash:         tokenhash.Hash(session_token),
+		UserID:            user.ID,
+		ExpiresAt:         now.Add(h.config.OAuth.SessionIdleTimeout),
+		AbsoluteExpiresAt: now.Add(h.config.OAuth.SessionMaxLifetime),
+		UserAgent:         r.UserAgent(),
+		IPAddress:         clientAddress(r),
 	}
-	var parsedClaims GoogleIdTokenClaims
-	err = json.Unmarshal(jsonClaims, &parsedClaims)
-	if err != nil {
-		gecho.InternalServerError(w).Send()
-		logger.Err(err)
-		return
+	if err := gorm.G[models.AuthSession](h.db).Create(r.Context(), &session); err != nil {
+		gecho.InternalServerError(w).WithMessage("Could not create authenticated session").Send()
+		logger.Err(err.Error())
+		return false
 	}
 
-	// Download the pfp image async (also updates from google if user already exists)
-	go (func() {
-		pfp_dir := "data/user_pfp/"
-		err := os.Mkdir(pfp_dir, os.ModePerm)
-		if err != nil && err == os.ErrExist {
-			logger.Err(fmt.Errorf("failed to create directory '%s': %s", pfp_dir, err.Error()))
-		}
-		filename := filepath.Join(pfp_dir, fmt.Sprintf("%s.jpg", payload.Subject))
-		logger.Info(filename)
+	recordAudit(h.db, r, models.AuditEvent{
+		ActorID:    &user.ID,
+		Action:     auditLogin,
+		TargetType: auditTargetUser,
+		TargetID:   &user.ID,
+		Details:    map[string]any{"provider": providerID, "auth_session_id": session.ID},
+	})
 
-		resp, err = http.Get(parsedClaims.Picture)
-		if err != nil {
-			logger.Err(fmt.Sprintf("Failed to download profile image from '%s': %s", parsedClaims.Picture, err.Error()))
-			return
+	http.SetCookie(w, authsession.Cookie(h.config, session_token, session.ExpiresAt))
+	return true
+}
+
+// userForIdentity returns the user linked to identity, a loginRejection is returned when the user may not log in.
+//
+// On the first login with an identity it is linked to the user an admin invited with the same email address, or a new
+// user is created when the login is not invite only. Emails are only trusted when the provider verified them, and
+// identities are never linked to users that already have an identity at another provider.
+func (h *AuthenticationHandler) userForIdentity(ctx context.Context, identity authprovider.Identity) (models.User, error) {
+	provisioningConfig := &h.config.Provisioning
+	if provisioning.RestrictsDomains(provisioningConfig) {
+		if identity.Email == "" {
+			return models.User{}, rejectionNoEmail
+		}
+		if !identity.EmailVerified {
+			return models.User{}, rejectionEmailNotVerified
 		}
-		defer resp.Body.Close()
+		if !provisioning.DomainAllowed(provisioningConfig, identity.Email) {
+			return models.User{}, rejectionDomainNotAllowed
+		}
+	}
 
-		out, err := os.Create(filename)
-		if err != nil {
-			logger.Err(fmt.Sprintf("Failed to create file '%s': %s", filename, err.Error()))
-			return
+	userIdentity, err := gorm.G[models.UserIdentity](h.db).Preload("User", nil).
+		Where("issuer = ? AND subject = ?", identity.Issuer, identity.Subject).First(ctx)
+	if err == nil {
+		if userIdentity.User.ID == 0 {
+			return models.User{}, rejectionAccountDeleted
+		}
+		if userIdentity.User.DeactivatedAt != nil {
+			return models.User{}, rejectionAccountInactive
 		}
-		defer out.Close()
+		return userIdentity.User, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return models.User{}, err
+	}
+
+	if identity.Email == "" {
+		return models.User{}, rejectionNoEmail
+	}
+	newIdentity := models.UserIdentity{
+		Issuer:  identity.Issuer,
+		Subject: identity.Subject,
+		Email:   identity.Email,
+	}
 
-		_, err = io.Copy(out, resp.Body)
+	existingUser, err := findUserByEmailIncludingDeleted(ctx, h.db, identity.Email)
+	if err == nil {
+		if existingUser.DeletedAt.Valid {
+			return models.User{}, rejectionAccountDeleted
+		}
+		if existingUser.DeactivatedAt != nil {
+			return models.User{}, rejectionAccountInactive
+		}
+		identityCount, err := gorm.G[models.UserIdentity](h.db).Where("user_id = ?", existingUser.ID).Count(ctx, "id")
 		if err != nil {
-			logger.Err(fmt.Sprintf("Failed to copy profile image from '%s' to file '%s': %s", parsedClaims.Picture, filename, err.Error()))
-			return
+			return models.User{}, err
 		}
-	})()
-
-	user, err := gorm.G[models.User](h.db).Where("google_subject = ?", payload.Subject).First(ctx)
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			user = models.User{
-				GoogleSubject:  payload.Subject,
-				ProfilePicture: parsedClaims.Picture, // TODO: deprecate because this unused
-				Email:          parsedClaims.Email,
-				Name:           parsedClaims.Name,
-				DisplayName:    parsedClaims.GivenName,
-			}
-			err := gorm.G[models.User](h.db).Create(ctx, &user)
-			if err != nil {
-				gecho.InternalServerError(w).Send()
-				err := fmt.Errorf("An error occured creating the user: %s", err.Error())
-				logger.Err(err)
-				return
+		if identityCount > 0 {
+			return models.User{}, rejectionEmailTaken
+		}
+		// Invited by an admin and logging in for the first time
+		if !identity.EmailVerified {
+			return models.User{}, rejectionEmailNotVerified
+		}
+		if existingUser.Name == "" {
+			existingUser.Name = identity.Name
+			existingUser.DisplayName = identity.GivenName
+		}
+		newIdentity.UserID = existingUser.ID
+		err = h.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&existingUser).Updates(map[string]any{"name": existingUser.Name, "display_name": existingUser.DisplayName}).Error; err != nil {
+				return err
 			}
-		} else {
-			gecho.InternalServerError(w).Send()
-			err := fmt.Errorf("An error occured retrieving the user: %s", err.Error())
-			logger.Err(err)
-			return
+			return gorm.G[models.UserIdentity](tx).Create(ctx, &newIdentity)
+		})
+		return existingUser, err
+	}
+	if err != gorm.ErrRecordNotFound {
+		return models.User{}, err
+	}
+
+	if provisioningConfig.InviteOnly {
+		return models.User{}, rejectionNotInvited
+	}
+	role := provisioning.Role(provisioningConfig, identity.Email)
+	if !identity.EmailVerified {
+		// Anybody can claim an unverified email address, so it can not give a role
+		role = provisioningConfig.DefaultRole
+	}
+	user := models.User{
+		ProfilePicture: identity.Picture, // TODO: deprecate because this unused
+		Email:          identity.Email,
+		Name:           identity.Name,
+		DisplayName:    identity.GivenName,
+		Role:           role,
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := gorm.G[models.User](tx).Create(ctx, &user); err != nil {
+			return err
 		}
+		newIdentity.UserID = user.ID
+		return gorm.G[models.UserIdentity](tx).Create(ctx, &newIdentity)
+	})
+	if err == nil {
+		logger.Info(fmt.Sprintf("Created user %d (%s) with role %s", user.ID, user.Email, user.Role))
+	}
+	return user, err
+}
+
+// downloadProfilePicture stores the profile picture at pictureURL as the profile picture of a user
+func downloadProfilePicture(userID uint, pictureURL string) {
+	pfp_dir := "data/user_pfp/"
+	if err := os.MkdirAll(pfp_dir, os.ModePerm); err != nil {
+		logger.Err(fmt.Errorf("failed to create directory '%s': %s", pfp_dir, err.Error()))
+		return
 	}
+	filename := filepath.Join(pfp_dir, fmt.Sprintf("%d.jpg", userID))
 
-	// Create auth session
-	session_token, err := generateSecureToken(128)
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(pictureURL)
 	if err != nil {
-		gecho.InternalServerError(w).WithMessage("Could not create authenticated session").Send()
-		logger.Err(err.Error())
+		logger.Err(fmt.Sprintf("Failed to download profile image from '%s': %s", pictureURL, err.Error()))
 		return
 	}
-	session := models.AuthSession{
-		SessionToken: session_token,
-		UserID:       user.ID,
-		ExpiresAt:    time.Now().Add(h.config.OAuth.SessionDuration),
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Err(fmt.Sprintf("Failed to download profile image from '%s': status %d", pictureURL, resp.StatusCode))
+		return
 	}
 
-	gorm.G[models.AuthSession](h.db).Create(ctx, &session)
+	out, err := os.Create(filename)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Failed to create file '%s': %s", filename, err.Error()))
+		return
+	}
+	defer out.Close()
 
-	cookie := http.Cookie{
-		Name:     "auth_session_token",
-		Value:    session.SessionToken,
-		Domain:   h.config.Server.Host,
-		Path:     "/",
-		HttpOnly: true,
-		Expires:  session.ExpiresAt,
+	_, err = io.Copy(out, resp.Body)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Failed to copy profile image from '%s' to file '%s': %s", pictureURL, filename, err.Error()))
+		return
 	}
-	http.SetCookie(w, &cookie)
-	http.Redirect(w, r, "/", http.StatusFound)
 }
 
 // GetLogout
 //
 // @Summary		Logout
-// @Description	Invalidate the session token
+// @Description	Invalidate the session token. Logging out while impersonating also logs out the admin.
 // @Tags		auth requiresAuth
 // @Response		302
+// @Failure		400	{object}	apiResponses.BadRequestError "Authenticated with a personal access token"
 // @Router			/logout [get]
 func (h *AuthenticationHandler) GetLogout(w http.ResponseWriter, r *http.Request) {
 	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
@@ -271,6 +523,10 @@ func (h *AuthenticationHandler) GetLogout(w http.ResponseWriter, r *http.Request
 	}
 
 	ctx := r.Context()
+	if _, ok := ctx.Value(contextkeys.AuthTokenKey).(models.PersonalAccessToken); ok {
+		gecho.BadRequest(w).WithMessage("Can not log out with a personal access token, revoke the token instead").Send()
+		return
+	}
 	session, ok := ctx.Value(contextkeys.AuthSessionKey).(models.AuthSession)
 	if !ok {
 		gecho.InternalServerError(w).Send()
@@ -280,15 +536,19 @@ func (h *AuthenticationHandler) GetLogout(w http.ResponseWriter, r *http.Request
 
 	logger.Info(session)
 	gorm.G[models.AuthSession](h.db).Where("id = ?", session.ID).Update(ctx, "expires_at", time.Now())
+	recordAudit(h.db, r, models.AuditEvent{
+		Action:     auditLogout,
+		TargetType: auditTargetUser,
+		TargetID:   &session.UserID,
+		Details:    map[string]any{"auth_session_id": session.ID},
+	})
 
-	cookie := http.Cookie{
-		Name:     "auth_session_token",
-		Value:    "",
-		Domain:   h.config.Server.Host,
-		Path:     "/",
-		HttpOnly: true,
-		Expires:  time.Unix(0, 0),
+	// Do not leave the session of an impersonating admin behind in the browser
+	if adminCookie, err := r.Cookie(authsession.ImpersonatorCookieName); err == nil {
+		gorm.G[models.AuthSession](h.db).Where("token_hash = ?", tokenhash.Hash(adminCookie.Value)).Update(ctx, "expires_at", time.Now())
+		http.SetCookie(w, authsession.ExpiredImpersonatorCookie(h.config))
 	}
-	http.SetCookie(w, &cookie)
+
+	http.SetCookie(w, authsession.ExpiredCookie(h.config))
 	http.Redirect(w, r, "/", http.StatusFound)
 }
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/loginstate"
+	"github.com/CLDWare/schoolbox-backend/internal/rbac"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/MonkyMars/gecho"
+	"gorm.io/gorm"
+)
+
+// devLoginPage lists the users that can be logged in as, and has a form to create a new user
+var devLoginPage = template.Must(template.New("dev_login").Parse(`<!DOCTYPE html>
+<html>
+
+<head>
+    <title>development login</title>
+    <meta charset="UTF-8" />
+    <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+    <!-- Plain css, the development login has to work offline -->
+    <style>
+        body { margin: 0.5rem; padding: 0.25rem; background: #0a0a0a; color: #fff; font-family: sans-serif; }
+        .card { background: #171717; padding: 0.5rem; margin-top: 0.5rem; }
+        .title { font-weight: 600; margin: 0; }
+        .muted { color: #a3a3a3; margin: 0.5rem 0 0; }
+        form { margin-top: 0.5rem; display: flex; gap: 0.5rem; align-items: center; }
+        .user { width: 20rem; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+        input, select { background: #262626; color: #fff; border: none; padding: 0.25rem; }
+        button { background: #404040; color: #fff; border: none; padding: 0.25rem 0.5rem; cursor: pointer; }
+    </style>
+</head>
+
+<body>
+    <div class="card">
+        <p class="title">Development login</p>
+        <p class="muted">Log in as any user without a login provider. Only available in development.</p>
+    </div>
+
+    <div class="card">
+        <p class="title">Existing users</p>
+        {{range .Users}}
+        <form method="post" action="{{$.Action}}">
+            <input type="hidden" name="return_to" value="{{$.ReturnTo}}" />
+            <input type="hidden" name="email" value="{{.Email}}" />
+            <span class="user">{{.ID}}: {{.Email}} {{if .Name}}({{.Name}}){{end}}</span>
+            <select name="role">
+                {{$role := .Role}}{{range $.Roles}}<option value="{{.}}" {{if eq . $role}}selected{{end}}>{{.}}</option>{{end}}
+            </select>
+            <button type="submit">Log in</button>
+        </form>
+        {{else}}
+        <p class="muted">There are no users yet.</p>
+        {{end}}
+    </div>
+
+    <div class="card">
+        <p class="title">New user</p>
+        <form method="post" action="{{.Action}}">
+            <input type="hidden" name="return_to" value="{{.ReturnTo}}" />
+            <input type="email" name="email" placeholder="email" required />
+            <input type="text" name="name" placeholder="name" />
+            <select name="role">
+                {{range .Roles}}<option value="{{.}}" {{if eq . $.DefaultRole}}selected{{end}}>{{.}}</option>{{end}}
+            </select>
+            <button type="submit">Create and log in</button>
+        </form>
+    </div>
+</body>
+
+</html>
+`))
+
+// devLoginURL returns the url of the development login page
+func (h *AuthenticationHandler) devLoginURL() (string, error) {
+	return url.JoinPath(h.config.GetServerURL(), "/api/login", config.DevLoginProviderID)
+}
+
+// GetDevLogin
+//
+// @Summary		Development login page
+// @Description	A page to log in as any user without a login provider, or to create a user with any role.
+// @Description	Only available in development with AUTH_DEV_LOGIN, `/login` then redirects here instead of to the first provider.
+// @Tags			auth
+// @Produce		html
+// @Param			return_to	query		string	false	"Where to redirect after logging in"	default(/)
+// @Success		200
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/login/dev [get]
+func (h *AuthenticationHandler) GetDevLogin(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	returnTo := r.URL.Query().Get("return_to")
+	if returnTo == "" {
+		returnTo = "/"
+	}
+	if !loginstate.ValidReturnTo(returnTo, h.config.OAuth.ReturnToOrigins) {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Can not return to '%s' after logging in", returnTo)).Send()
+		return
+	}
+	action, err := h.devLoginURL()
+	if err != nil {
+		logger.Err(err.Error())
+		gecho.InternalServerError(w).Send()
+		return
+	}
+
+	users, err := gorm.G[models.User](h.db).Where("deactivated_at IS NULL").Order("id").Limit(100).Find(r.Context())
+	if err != nil {
+		logger.Err(err.Error())
+		gecho.InternalServerError(w).Send()
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = devLoginPage.Execute(w, map[string]any{
+		"Action":      action,
+		"ReturnTo":    returnTo,
+		"Users":       users,
+		"Roles":       rbac.Roles(),
+		"DefaultRole": h.config.Provisioning.DefaultRole,
+	})
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not render development login page: %s", err.Error()))
+	}
+}
+
+// PostDevLogin
+//
+// @Summary		Log in without a login provider
+// @Description	Log in as the user with `email`, the user is created when it does not exist yet. When `role` is given the user
+// @Description	gets that role. Creates a normal session, like logging in with a provider does, and redirects to `return_to`.
+// @Description	Only available in development with AUTH_DEV_LOGIN.
+// @Tags			auth
+// @Accept			x-www-form-urlencoded
+// @Param			email		formData	string	true	"Email address of the user"
+// @Param			name		formData	string	false	"Name of a new user, defaults to the email address"
+// @Param			role		formData	string	false	"Role of the user"	Enums(teacher,department_head,it_admin,auditor,admin)
+// @Param			return_to	formData	string	false	"Where to redirect after logging in"	default(/)
+// @Response		303
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/login/dev [post]
+func (h *AuthenticationHandler) PostDevLogin(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while parsing form: %s", err.Error())).Send()
+		return
+	}
+	email := strings.TrimSpace(r.PostForm.Get("email"))
+	if email == "" {
+		gecho.BadRequest(w).WithMessage("Missing field 'email'").Send()
+		return
+	}
+	role := r.PostForm.Get("role")
+	if role != "" && !rbac.ValidRole(role) {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid role '%s', must be one of: %s", role, strings.Join(rbac.Roles(), ", "))).Send()
+		return
+	}
+	returnTo := r.PostForm.Get("return_to")
+	if returnTo == "" {
+		returnTo = "/"
+	}
+	if !loginstate.ValidReturnTo(returnTo, h.config.OAuth.ReturnToOrigins) {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Can not return to '%s' after logging in", returnTo)).Send()
+		return
+	}
+
+	user, err := findUserByEmailIncludingDeleted(ctx, h.db, email)
+	switch {
+	case err == gorm.ErrRecordNotFound:
+		user = models.User{
+			Email:       email,
+			Name:        strings.TrimSpace(r.PostForm.Get("name")),
+			DisplayName: strings.TrimSpace(r.PostForm.Get("name")),
+			Role:        role,
+		}
+		if user.Name == "" {
+			user.Name = email
+			user.DisplayName = strings.Split(email, "@")[0]
+		}
+		if user.Role == "" {
+			user.Role = h.config.Provisioning.DefaultRole
+		}
+		if err := gorm.G[models.User](h.db).Create(ctx, &user); err != nil {
+			logger.Err(err.Error())
+			gecho.InternalServerError(w).Send()
+			return
+		}
+		logger.Info(fmt.Sprintf("Created user %d (%s) with role %s on the development login", user.ID, user.Email, user.Role))
+	case err != nil:
+		logger.Err(err.Error())
+		gecho.InternalServerError(w).Send()
+		return
+	case user.DeletedAt.Valid:
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("User %d has been deleted", user.ID)).Send()
+		return
+	case user.DeactivatedAt != nil:
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("User %d has been deactivated", user.ID)).Send()
+		return
+	case role != "" && role != user.Role:
+		previousRole := user.Role
+		if _, err := gorm.G[models.User](h.db).Where("id = ?", user.ID).Update(ctx, "role", role); err != nil {
+			logger.Err(err.Error())
+			gecho.InternalServerError(w).Send()
+			return
+		}
+		user.Role = role
+		recordAudit(h.db, r, models.AuditEvent{
+			ActorID:    &user.ID,
+			Action:     auditUserRoleChange,
+			TargetType: auditTargetUser,
+			TargetID:   &user.ID,
+			Details:    map[string]any{"from": previousRole, "to": user.Role, "provider": config.DevLoginProviderID},
+		})
+	}
+
+	if !h.startAuthSession(w, r, user, config.DevLoginProviderID) {
+		return
+	}
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/CLDWare/schoolbox-backend/config"
@@ -38,33 +41,66 @@ type DeviceInfo struct {
 	LatestLogin      *time.Time `json:"latest_login"`
 	LastSeen         *time.Time `json:"last_seen"`
 	Room             *string    `json:"room"`
+	RoomID           *uint      `json:"room_id"`
+	DisplayName      *string    `json:"display_name"`
+	Notes            string     `json:"notes"`
+	Location         string     `json:"location"`
 	LeaseStart       time.Time  `json:"lease_start"`
 	ActiveSessionID  *uint      `json:"active_session_id"`
 	RegistrationDate time.Time  `json:"registration_date"`
+	Online           bool       `json:"online"`
+	ConnectionState  string     `json:"connection_state" enums:"offline,connected,authenticating,authenticated,in_session"`
+	ConnectedSince   *time.Time `json:"connected_since" format:"date-time"`
+	Tags             []string   `json:"tags"`
+	GroupIDs         []uint     `json:"group_ids"`
 }
 
+// preloadDeviceInfo loads the relations toDeviceInfo needs
+func preloadDeviceInfo(dbQuery *gorm.DB) *gorm.DB {
+	return dbQuery.Preload("Tags").Preload("Groups").Preload("Room")
+}
+
+// toDeviceInfo converts a device, the relations should be loaded with preloadDeviceInfo
 func toDeviceInfo(device models.Device) DeviceInfo {
-	return DeviceInfo{
+	deviceInfo := DeviceInfo{
 		ID:               device.ID,
 		LatestLogin:      device.LatestLogin,
 		LastSeen:         device.LastSeen,
-		Room:             device.Room,
+		RoomID:           device.RoomID,
+		DisplayName:      device.DisplayName,
+		Notes:            device.Notes,
+		Location:         device.Location,
 		LeaseStart:       device.LeaseStart,
 		ActiveSessionID:  device.ActiveSessionID,
 		RegistrationDate: device.RegistrationDate,
+		Tags:             []string{},
+		GroupIDs:         []uint{},
+	}
+	if device.Room != nil {
+		deviceInfo.Room = &device.Room.Name
+	}
+	for _, tag := range device.Tags {
+		deviceInfo.Tags = append(deviceInfo.Tags, tag.Name)
 	}
+	for _, group := range device.Groups {
+		deviceInfo.GroupIDs = append(deviceInfo.GroupIDs, group.ID)
+	}
+	return deviceInfo
 }
 
 // GetDevice
 //
 // @Summary		Get all devices
 // @Description	Get DeviceInfo about all devices
-// @Tags			device requiresAuth requiresAdmin
+// @Description	Requires permission `device:read`
+// @Tags			device requiresAuth requiresPermission
 // @Accept			json
 // @Produce		json
 // @Param			limit	query		int	false	"Amount of devices to return" default(20) maximum(20)
 // @Param			offset	query		int	false	"How much devices to skip before starting to return devices" default(0) minimum(0)
 // @Param			leased	query		bool	false	"Only return devices with this lease status"
+// @Param			group	query		int	false	"Only return devices that are a member of this device group"
+// @Param			tag	query		string	false	"Only return devices with this tag"
 // @Success		200	{object}	apiResponses.BaseResponse{data=[]DeviceInfo}
 // @Failure		401	{object}	apiResponses.UnauthorizedError
 // @Failure		403	{object}	apiResponses.ForbiddenError
@@ -114,9 +150,20 @@ func (h *DeviceHandler) GetDevice(w http.ResponseWriter, r *http.Request) {
 			dbQuery = dbQuery.Where("active_session_id IS NULL")
 		}
 	}
+	if groupStr := query.Get("group"); groupStr != "" {
+		groupID, err := strconv.ParseUint(groupStr, 10, 0)
+		if err != nil {
+			gecho.BadRequest(w).WithMessage("Invalid group ID, expected positive integer").Send()
+			return
+		}
+		dbQuery = dbQuery.Where("id IN (?)", h.db.Table("device_group_members").Select("device_id").Where("device_group_id = ?", groupID))
+	}
+	if tag := query.Get("tag"); tag != "" {
+		dbQuery = dbQuery.Where("id IN (?)", h.db.Model(&models.DeviceTag{}).Select("device_id").Where("name = ?", tag))
+	}
 
 	var devices []models.Device
-	err := dbQuery.Find(&devices).Error
+	err := preloadDeviceInfo(dbQuery).Find(&devices).Error
 	if err != nil {
 		gecho.InternalServerError(w).Send()
 		logger.Err(err.Error())
@@ -125,7 +172,9 @@ func (h *DeviceHandler) GetDevice(w http.ResponseWriter, r *http.Request) {
 
 	deviceInfoArray := []DeviceInfo{}
 	for _, device := range devices {
-		deviceInfoArray = append(deviceInfoArray, toDeviceInfo(device))
+		deviceInfo := toDeviceInfo(device)
+		h.websocketHandler.applyPresence(&deviceInfo)
+		deviceInfoArray = append(deviceInfoArray, deviceInfo)
 	}
 
 	gecho.Success(w).WithData(deviceInfoArray).Send()
@@ -135,7 +184,8 @@ func (h *DeviceHandler) GetDevice(w http.ResponseWriter, r *http.Request) {
 //
 // @Summary		Get device by id
 // @Description	Get info about a device by using its id or room
-// @Tags			device requiresAuth requiresAdmin
+// @Description	Requires permission `device:read`
+// @Tags			device requiresAuth requiresPermission
 // @Accept			json
 // @Produce		json
 // @Param			id	path		string	true	"Device ID or Room"
@@ -170,14 +220,14 @@ func (h *DeviceHandler) GetDeviceById(w http.ResponseWriter, r *http.Request) {
 		}
 		dbQuery = dbQuery.Where("id = ?", userID)
 	case "room":
-		dbQuery = dbQuery.Where("room = ?", idStr)
+		dbQuery = dbQuery.Where("room_id IN (?)", h.db.Model(&models.Room{}).Select("id").Where("name = ?", idStr))
 	default:
 		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid identifier type '%s'", idType)).Send()
 		return
 	}
 
 	var device models.Device
-	result := dbQuery.First(&device)
+	result := preloadDeviceInfo(dbQuery).First(&device)
 	if result.Error == gorm.ErrRecordNotFound {
 		gecho.NotFound(w).WithMessage(fmt.Sprintf("No device with %s of '%s'", idType, idStr)).Send()
 		return
@@ -189,6 +239,7 @@ func (h *DeviceHandler) GetDeviceById(w http.ResponseWriter, r *http.Request) {
 	}
 
 	deviceInfo := toDeviceInfo(device)
+	h.websocketHandler.applyPresence(&deviceInfo)
 
 	gecho.Success(w).WithData(deviceInfo).Send()
 }
@@ -197,7 +248,8 @@ func (h *DeviceHandler) GetDeviceById(w http.ResponseWriter, r *http.Request) {
 //
 // @Summary		Delete device by id
 // @Description	Delete a device from the database by using its id or room. The websocket connection, if present, will also be terminated.
-// @Tags			device requiresAuth requiresAdmin
+// @Description	Requires permission `device:write`
+// @Tags			device requiresAuth requiresPermission
 // @Accept			json
 // @Produce		json
 // @Param			id	path		string	true	"Device ID or Room"
@@ -233,7 +285,7 @@ func (h *DeviceHandler) DeleteDeviceById(w http.ResponseWriter, r *http.Request)
 		}
 		dbQuery = dbQuery.Where("id = ?", userID)
 	case "room":
-		dbQuery = dbQuery.Where("room = ?", idStr)
+		dbQuery = dbQuery.Where("room_id IN (?)", h.db.Model(&models.Room{}).Select("id").Where("name = ?", idStr))
 	default:
 		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid identifier type '%s'", idType)).Send()
 		return
@@ -251,24 +303,11 @@ func (h *DeviceHandler) DeleteDeviceById(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	connID, ok := h.websocketHandler.connectedDevices[device.ID]
-	if ok {
-		conn, ok := h.websocketHandler.connections[device.ID]
-		if ok {
-			sendMessage(conn.ws, map[string]any{
-				"e":    4,
-				"info": "Device deleted.",
-			})
-			conn.close()
-		} else {
-			logger.Err(fmt.Sprintf("Tried to terminate connection for device %d but connection %d does not exist.", device.ID, connID))
-		}
-	}
-
-	rows, err := gorm.G[models.Device](h.db).Where("id = ?", device.ID).Delete(ctx)
+	rows, err := h.deleteDevice(ctx, device.ID)
 	if err != nil {
 		logger.Err(err)
 		gecho.InternalServerError(w).WithMessage("Failed to delete from database. Any active connection was terminated.").Send()
+		return
 	}
 	if rows > 1 {
 		logger.Err(fmt.Sprintf("Deleted %d devices instead of 1 from database!!!!", rows))
@@ -276,6 +315,225 @@ func (h *DeviceHandler) DeleteDeviceById(w http.ResponseWriter, r *http.Request)
 		h.quitCh <- os.Interrupt
 	}
 
+	recordAudit(h.db, r, models.AuditEvent{Action: auditDeviceDelete, TargetType: auditTargetDevice, TargetID: &device.ID})
+	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
+}
+
+type PatchDeviceBody struct {
+	RoomID      *uint   `json:"room_id"`
+	DisplayName *string `json:"display_name"`
+	Notes       *string `json:"notes"`
+	Location    *string `json:"location"`
+}
+
+// PatchDeviceById
+//
+// @Summary		Update device metadata
+// @Description	Move a device to a room or change its display name, notes or location. Fields that are left out stay the same.
+// @Description	A room can only hold one device. Sessions keep the room they took place in when the device moves.
+// @Description	Requires permission `device:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device ID"
+// @Param			metadata	body		PatchDeviceBody	true	"`room_id`: Room to move the device to, 0 removes it from its room\n`display_name`: Name to show instead of the id, empty removes it\n`notes`: Free form notes\n`location`: Location within the room, e.g. \"next to the door\""
+// @Success		200	{object}	apiResponses.BaseResponse{data=DeviceInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		409	{object}	apiResponses.ConflictError "The room already has a device"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device/{id} [patch]
+func (h *DeviceHandler) PatchDeviceById(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPatch); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+
+	deviceID, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid device ID, expected positive integer").Send()
+		return
+	}
+
+	var body PatchDeviceBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+
+	_, err = gorm.G[models.Device](h.db).Where("id = ?", deviceID).First(ctx)
+	if err == gorm.ErrRecordNotFound {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No device with id of %d", deviceID)).Send()
+		return
+	}
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	updates := map[string]any{}
+	if body.RoomID != nil {
+		if *body.RoomID == 0 {
+			updates["room_id"] = nil
+		} else {
+			if !h.roomAvailable(w, ctx, *body.RoomID, uint(deviceID)) {
+				return
+			}
+			updates["room_id"] = *body.RoomID
+		}
+	}
+	if body.DisplayName != nil {
+		if displayName := strings.TrimSpace(*body.DisplayName); displayName == "" {
+			updates["display_name"] = nil
+		} else {
+			updates["display_name"] = displayName
+		}
+	}
+	if body.Notes != nil {
+		updates["notes"] = *body.Notes
+	}
+	if body.Location != nil {
+		updates["location"] = *body.Location
+	}
+
+	if len(updates) != 0 {
+		err := h.db.Model(&models.Device{}).Where("id = ?", deviceID).Updates(updates).Error
+		if err != nil {
+			gecho.InternalServerError(w).Send()
+			logger.Err(err.Error())
+			return
+		}
+	}
+
+	var device models.Device
+	if err := preloadDeviceInfo(h.db).Where("id = ?", deviceID).First(&device).Error; err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	deviceInfo := toDeviceInfo(device)
+	h.websocketHandler.applyPresence(&deviceInfo)
+
+	gecho.Success(w).WithData(deviceInfo).Send()
+}
+
+// roomAvailable checks if the room exists and has no device other than deviceID, it sends an error response if not
+func (h *DeviceHandler) roomAvailable(w http.ResponseWriter, ctx context.Context, roomID uint, deviceID uint) bool {
+	_, err := gorm.G[models.Room](h.db).Where("id = ?", roomID).First(ctx)
+	if err == gorm.ErrRecordNotFound {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("No room with id of %d", roomID)).Send()
+		return false
+	}
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return false
+	}
+	occupant, err := gorm.G[models.Device](h.db).Where("room_id = ? AND id != ?", roomID, deviceID).First(ctx)
+	if err == nil {
+		gecho.NewErr(w).WithStatus(http.StatusConflict).WithMessage(fmt.Sprintf("Room %d already has device %d", roomID, occupant.ID)).Send()
+		return false
+	}
+	if err != gorm.ErrRecordNotFound {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return false
+	}
+	return true
+}
+
+// deleteDevice terminates the connection of a device and deletes it together with its tags and group memberships.
+// The room of the device is freed up. It returns the number of deleted devices.
+func (h *DeviceHandler) deleteDevice(ctx context.Context, deviceID uint) (int, error) {
+	err := h.websocketHandler.disconnectDevice(deviceID, disconnectDeleted, 4, "Device deleted.")
+	if err != nil && !errors.Is(err, ErrDeviceNotConnected) {
+		logger.Err(fmt.Sprintf("Tried to terminate connection for device %d but failed: %s", deviceID, err.Error()))
+	}
+
+	if _, err := gorm.G[models.DeviceTag](h.db).Where("device_id = ?", deviceID).Delete(ctx); err != nil {
+		return 0, err
+	}
+	device := models.Device{Model: gorm.Model{ID: deviceID}}
+	if err := h.db.Model(&device).Association("Groups").Clear(); err != nil {
+		return 0, err
+	}
+	if err := h.db.Model(&device).Update("room_id", nil).Error; err != nil {
+		return 0, err
+	}
+	return gorm.G[models.Device](h.db).Where("id = ?", deviceID).Delete(ctx)
+}
+
+// GetDeviceAuthFailures
+//
+// @Summary		Get failed device authentication attempts
+// @Description	Get the failed authentication attempts that are currently tracked per device, per device and remote address and per remote address, including active lockouts.
+// @Description	Requires permission `device:read`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]AuthFailureInfo}
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Router			/device/auth-failures [get]
+func (h *DeviceHandler) GetDeviceAuthFailures(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	gecho.Success(w).WithData(h.websocketHandler.authFailures.snapshot()).Send()
+}
+
+// PostDeviceTokenRotate
+//
+// @Summary		Rotate the token of a device
+// @Description	Issue a new token to a connected device over its authenticated websocket connection.
+// @Description	The previous token stays valid during the rotation grace period or until the device acknowledges the new token by signing the nonce sent with it.
+// @Description	A token can not be rotated again until then.
+// @Description	Requires permission `device:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device ID"
+// @Success		204 {object}	apiResponses.BaseBase
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		409	{object}	apiResponses.ConflictError "The previous rotation is not acknowledged yet"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Failure		503	{object}	apiResponses.ServiceUnavailableError "Device is not connected"
+// @Router			/device/{id}/token/rotate [post]
+func (h *DeviceHandler) PostDeviceTokenRotate(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	deviceID, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid device ID, expected positive integer").Send()
+		return
+	}
+
+	err = h.websocketHandler.rotateDeviceToken(uint(deviceID))
+	if errors.Is(err, ErrDeviceNotConnected) {
+		gecho.ServiceUnavailable(w).WithMessage("Device currently unavailable").Send()
+		return
+	} else if errors.Is(err, ErrTokenRotationPending) {
+		gecho.NewErr(w).WithStatus(http.StatusConflict).WithMessage("The device has not acknowledged the previous new token yet").Send()
+		return
+	} else if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err)
+		return
+	}
+
+	rotatedID := uint(deviceID)
+	recordAudit(h.db, r, models.AuditEvent{Action: auditDeviceTokenRotate, TargetType: auditTargetDevice, TargetID: &rotatedID})
 	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
 }
 
@@ -291,7 +549,8 @@ type PostDeviceRegisterResponse struct {
 //
 // @Summary		Register a new device
 // @Description	Register a new device using the registration pin
-// @Tags			device requiresAuth requiresAdmin
+// @Description	Requires permission `device:write`
+// @Tags			device requiresAuth requiresPermission
 // @Accept			json
 // @Produce		json
 // @Param			registration_data	body		PostDeviceRegisterBody	true	"Registration pin\n`pin`: 4 digit registration pin recieved by the device via websocket API"
@@ -314,13 +573,14 @@ func (h *DeviceHandler) PostDeviceRegister(w http.ResponseWriter, r *http.Reques
 	}
 	device, err := h.websocketHandler.registerWithPin(body.Pin, nil)
 	if err != nil {
-		if err.Error() == "No connectionID for this pin" {
+		if errors.Is(err, ErrInvalidPin) {
 			gecho.BadRequest(w).WithMessage("Invalid pin").Send()
 		} else {
 			gecho.InternalServerError(w).WithMessage(err.Error()).Send()
 		}
 		return
 	}
+	recordAudit(h.db, r, models.AuditEvent{Action: auditDeviceRegister, TargetType: auditTargetDevice, TargetID: &device.ID})
 
 	RegistrationPinData := PostDeviceRegisterResponse{
 		DeviceID: device.ID,
@@ -338,7 +598,8 @@ type PostDeviceRelinkBody struct {
 //
 // @Summary		Relink a device to an old database entry
 // @Description	Relink a device using the registration pin. WARNING: This will generate a new auth token for the device.
-// @Tags			device requiresAuth requiresAdmin
+// @Description	Requires permission `device:write`
+// @Tags			device requiresAuth requiresPermission
 // @Accept			json
 // @Produce		json
 // @Param			registration_data	body		PostDeviceRelinkBody	true	"Registration pin and device ID\n`pin`: 4 digit registration pin recieved by the device via websocket API"
@@ -375,13 +636,14 @@ func (h *DeviceHandler) PostDeviceRelink(w http.ResponseWriter, r *http.Request)
 
 	device, err = h.websocketHandler.registerWithPin(body.Pin, device)
 	if err != nil {
-		if err.Error() == "No connectionID for this pin" {
+		if errors.Is(err, ErrInvalidPin) {
 			gecho.BadRequest(w).WithMessage("Invalid pin").Send()
 		} else {
 			gecho.InternalServerError(w).WithMessage(err.Error()).Send()
 		}
 		return
 	}
+	recordAudit(h.db, r, models.AuditEvent{Action: auditDeviceRelink, TargetType: auditTargetDevice, TargetID: &device.ID})
 
 	RegistrationPinData := PostDeviceRegisterResponse{
 		DeviceID: device.ID,
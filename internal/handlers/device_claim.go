@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/MonkyMars/gecho"
+)
+
+type PostDeviceClaimBody struct {
+	Token  string `json:"token"`
+	RoomID *uint  `json:"room_id"`
+}
+
+// PostDeviceClaim
+//
+// @Summary		Claim a device with the token of its QR code
+// @Description	Register the device that shows the QR code with this claim token, and optionally put it in a room.
+// @Description	The QR code links to the confirmation page with the token in the `token` query parameter.
+// @Description	Requires permission `device:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			claim_data	body		PostDeviceClaimBody	true	"Claim token\n`token`: claim token recieved by the device in `reg_pin`\n`room_id`: optional room to put the device in"
+// @Success		201	{object}	apiResponses.BaseResponse{data=DeviceInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError "Invalid or expired claim token, or unknown room"
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		409	{object}	apiResponses.ConflictError "The room already has a device"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device/claim [post]
+func (h *DeviceHandler) PostDeviceClaim(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+
+	var body PostDeviceClaimBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+	if body.Token == "" {
+		gecho.BadRequest(w).WithMessage("Missing field 'token'").Send()
+		return
+	}
+	// Check the room first, so a device is not registered without the room it was meant for
+	if body.RoomID != nil && *body.RoomID != 0 && !h.roomAvailable(w, ctx, *body.RoomID, 0) {
+		return
+	}
+
+	pin, err := h.websocketHandler.pinForClaimToken(body.Token)
+	if errors.Is(err, ErrInvalidPin) {
+		gecho.BadRequest(w).WithMessage("Invalid or expired claim token").Send()
+		return
+	}
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	device, err := h.websocketHandler.registerWithPin(pin, nil)
+	if errors.Is(err, ErrInvalidPin) {
+		gecho.BadRequest(w).WithMessage("Invalid or expired claim token").Send()
+		return
+	}
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	if body.RoomID != nil && *body.RoomID != 0 {
+		err := h.db.Model(&models.Device{}).Where("id = ?", device.ID).Update("room_id", *body.RoomID).Error
+		if err != nil {
+			gecho.InternalServerError(w).WithMessage(fmt.Sprintf("Device %d is registered, but could not be put in room %d", device.ID, *body.RoomID)).Send()
+			logger.Err(err.Error())
+			return
+		}
+	}
+	logger.Info(fmt.Sprintf("Device %d claimed with its QR code", device.ID))
+	recordAudit(h.db, r, models.AuditEvent{Action: auditDeviceClaim, TargetType: auditTargetDevice, TargetID: &device.ID, Details: map[string]any{"room_id": body.RoomID}})
+
+	if err := preloadDeviceInfo(h.db).Where("id = ?", device.ID).First(device).Error; err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	deviceInfo := toDeviceInfo(*device)
+	h.websocketHandler.applyPresence(&deviceInfo)
+
+	gecho.Created(w).WithData(deviceInfo).Send()
+}
@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/MonkyMars/gecho"
+	"gorm.io/gorm"
+)
+
+// Device event types
+const (
+	deviceEventConnect     = "connect"
+	deviceEventAuthSuccess = "auth_success"
+	deviceEventAuthFailure = "auth_failure"
+	deviceEventDisconnect  = "disconnect"
+)
+
+// Disconnect reasons
+const (
+	disconnectCloseFrame      = "close_frame"
+	disconnectReadError       = "read_error"
+	disconnectHeartbeatMissed = "heartbeat_missed"
+	disconnectKicked          = "kicked"
+	disconnectDeleted         = "deleted"
+	disconnectInternalError   = "internal_error"
+	disconnectWriteError      = "write_error"
+	disconnectSlowConnection  = "slow_connection"
+	disconnectShutdown        = "server_shutdown"
+)
+
+// recordDeviceEvent writes an event about conn to the device event log
+func (h *WebsocketHandler) recordDeviceEvent(conn *websocketConnection, deviceID *uint, eventType string, reason string, duration *time.Duration) {
+	event := models.DeviceEvent{
+		DeviceID:     deviceID,
+		ConnectionID: conn.connectionID,
+		Type:         eventType,
+		Reason:       reason,
+		RemoteAddr:   conn.remoteAddr,
+		Duration:     duration,
+	}
+	if err := h.db.Create(&event).Error; err != nil {
+		logger.Err(fmt.Sprintf("Could not record %s event for connection %d: %s", eventType, conn.connectionID, err.Error()))
+		return
+	}
+
+	// The connect event is recorded before we know which device is connecting, link it now we do
+	if eventType == deviceEventAuthSuccess && deviceID != nil {
+		err := h.db.Model(&models.DeviceEvent{}).
+			Where("connection_id = ? AND type = ? AND device_id IS NULL AND created_at >= ?", conn.connectionID, deviceEventConnect, conn.connectedAt).
+			Update("device_id", *deviceID).Error
+		if err != nil {
+			logger.Err(fmt.Sprintf("Could not link connect event of connection %d to device %d: %s", conn.connectionID, *deviceID, err.Error()))
+		}
+	}
+}
+
+type DeviceEventInfo struct {
+	ID           uint      `json:"id"`
+	DeviceID     *uint     `json:"device_id"`
+	ConnectionID uint      `json:"connection_id"`
+	Type         string    `json:"type" enums:"connect,auth_success,auth_failure,disconnect"`
+	Reason       string    `json:"reason" example:"heartbeat_missed"`
+	RemoteAddr   string    `json:"remote_addr"`
+	Duration     *float64  `json:"duration" example:"3600.5"` // seconds
+	OccurredAt   time.Time `json:"occurred_at" format:"date-time"`
+}
+
+func toDeviceEventInfo(event models.DeviceEvent) DeviceEventInfo {
+	info := DeviceEventInfo{
+		ID:           event.ID,
+		DeviceID:     event.DeviceID,
+		ConnectionID: event.ConnectionID,
+		Type:         event.Type,
+		Reason:       event.Reason,
+		RemoteAddr:   event.RemoteAddr,
+		OccurredAt:   event.CreatedAt,
+	}
+	if event.Duration != nil {
+		seconds := event.Duration.Seconds()
+		info.Duration = &seconds
+	}
+	return info
+}
+
+// parseTimeRange parses the 'from' and 'to' query parameters, falling back to the given defaults
+func parseTimeRange(r *http.Request, defaultFrom time.Time, defaultTo time.Time) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	from, to := defaultFrom, defaultTo
+	if fromStr := query.Get("from"); fromStr != "" {
+		parsed, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return from, to, fmt.Errorf("Invalid 'from', expected RFC3339 timestamp: %s", err.Error())
+		}
+		from = parsed
+	}
+	if toStr := query.Get("to"); toStr != "" {
+		parsed, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return from, to, fmt.Errorf("Invalid 'to', expected RFC3339 timestamp: %s", err.Error())
+		}
+		to = parsed
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("'from' must be before 'to'")
+	}
+	return from, to, nil
+}
+
+// GetDeviceEvents
+//
+// @Summary		Get the connection history of a device
+// @Description	Get connects, authentication results and disconnects (with reason) of a device, newest first
+// @Description	Requires permission `device:read`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device ID"
+// @Param			limit	query		int	false	"Amount of events to return" default(20) maximum(100)
+// @Param			offset	query		int	false	"How much events to skip before starting to return events" default(0) minimum(0)
+// @Param			type	query		string	false	"Only return events of this type" Enums(connect,auth_success,auth_failure,disconnect)
+// @Param			from	query		string	false	"Only return events after this time (RFC3339)" format(date-time)
+// @Param			to	query		string	false	"Only return events before this time (RFC3339)" format(date-time)
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]DeviceEventInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device/{id}/events [get]
+func (h *DeviceHandler) GetDeviceEvents(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	deviceID, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid device ID, expected positive integer").Send()
+		return
+	}
+
+	query := r.URL.Query()
+	dbQuery := h.db.Model(&models.DeviceEvent{}).Where("device_id = ?", deviceID)
+
+	// return count filters
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			gecho.BadRequest(w).WithMessage(err.Error()).Send()
+			return
+		}
+		if limit > 100 {
+			limit = 100
+		}
+		dbQuery = dbQuery.Limit(limit)
+	} else {
+		dbQuery = dbQuery.Limit(20)
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil {
+			gecho.BadRequest(w).WithMessage(err.Error()).Send()
+			return
+		}
+		dbQuery = dbQuery.Offset(offset)
+	}
+	// filters
+	if eventType := query.Get("type"); eventType != "" {
+		dbQuery = dbQuery.Where("type = ?", eventType)
+	}
+	if query.Get("from") != "" || query.Get("to") != "" {
+		from, to, err := parseTimeRange(r, time.Time{}, time.Now())
+		if err != nil {
+			gecho.BadRequest(w).WithMessage(err.Error()).Send()
+			return
+		}
+		dbQuery = dbQuery.Where("created_at BETWEEN ? AND ?", from, to)
+	}
+
+	var events []models.DeviceEvent
+	err = dbQuery.Order("created_at DESC").Find(&events).Error
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	eventInfoArray := []DeviceEventInfo{}
+	for _, event := range events {
+		eventInfoArray = append(eventInfoArray, toDeviceEventInfo(event))
+	}
+
+	gecho.Success(w).WithData(eventInfoArray).Send()
+}
+
+type DeviceUptimeInfo struct {
+	DeviceID         uint      `json:"device_id"`
+	From             time.Time `json:"from" format:"date-time"`
+	To               time.Time `json:"to" format:"date-time"`
+	OnlineSeconds    float64   `json:"online_seconds"`
+	UptimePercentage float64   `json:"uptime_percentage" example:"97.5"`
+	Connections      int       `json:"connections"` // successful authentications in the period
+}
+
+// onlineDuration sums the time between auth_success and disconnect events within [from, to].
+// events must be sorted oldest first and may start with the last event before from.
+// If the device is still online after the last event, it is counted as online until openUntil.
+func onlineDuration(events []models.DeviceEvent, from time.Time, to time.Time, openUntil time.Time) time.Duration {
+	var online time.Duration
+	var onlineSince *time.Time
+	for _, event := range events {
+		at := event.CreatedAt
+		if at.Before(from) {
+			at = from
+		}
+		switch event.Type {
+		case deviceEventAuthSuccess:
+			// Two logins without a disconnect in between means we missed the disconnect (server restart), count the gap as online
+			if onlineSince != nil {
+				online += at.Sub(*onlineSince)
+			}
+			onlineSince = &at
+		case deviceEventDisconnect:
+			if onlineSince != nil {
+				online += at.Sub(*onlineSince)
+				onlineSince = nil
+			}
+		}
+	}
+	if onlineSince != nil {
+		end := openUntil
+		if to.Before(end) {
+			end = to
+		}
+		if end.After(*onlineSince) {
+			online += end.Sub(*onlineSince)
+		}
+	}
+	return online
+}
+
+// GetDeviceUptime
+//
+// @Summary		Get the uptime of a device
+// @Description	Get the percentage of time a device was connected and authenticated during a period. Defaults to the last 7 days.
+// @Description	Requires permission `device:read`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device ID"
+// @Param			from	query		string	false	"Start of the period (RFC3339)" format(date-time)
+// @Param			to	query		string	false	"End of the period (RFC3339)" format(date-time)
+// @Success		200	{object}	apiResponses.BaseResponse{data=DeviceUptimeInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device/{id}/uptime [get]
+func (h *DeviceHandler) GetDeviceUptime(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	deviceID, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid device ID, expected positive integer").Send()
+		return
+	}
+
+	now := time.Now()
+	from, to, err := parseTimeRange(r, now.Add(-7*24*time.Hour), now)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage(err.Error()).Send()
+		return
+	}
+
+	var device models.Device
+	result := h.db.Where("id = ?", deviceID).First(&device)
+	if result.Error == gorm.ErrRecordNotFound {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No device with id of '%d'", deviceID)).Send()
+		return
+	}
+	if result.Error != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(result.Error.Error())
+		return
+	}
+
+	presenceTypes := []string{deviceEventAuthSuccess, deviceEventDisconnect}
+	var events []models.DeviceEvent
+	// The last event before the period tells us if the device was online when it started
+	var previous models.DeviceEvent
+	result = h.db.Where("device_id = ? AND type IN ? AND created_at < ?", deviceID, presenceTypes, from).
+		Order("created_at DESC").
+		Limit(1).
+		Find(&previous)
+	if result.Error != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(result.Error.Error())
+		return
+	}
+	if result.RowsAffected != 0 {
+		events = append(events, previous)
+	}
+	var periodEvents []models.DeviceEvent
+	err = h.db.Where("device_id = ? AND type IN ? AND created_at BETWEEN ? AND ?", deviceID, presenceTypes, from, to).
+		Order("created_at ASC").
+		Find(&periodEvents).Error
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	events = append(events, periodEvents...)
+
+	// A device without a disconnect event is only really online if it is still connected, otherwise the server
+	// stopped without recording the disconnect and LastSeen is the best guess we have.
+	openUntil := now
+	_, connected := h.websocketHandler.devicePresence(device.ID)
+	if !connected && device.LastSeen != nil {
+		openUntil = *device.LastSeen
+	}
+
+	online := onlineDuration(events, from, to, openUntil)
+	connections := 0
+	for _, event := range periodEvents {
+		if event.Type == deviceEventAuthSuccess {
+			connections++
+		}
+	}
+
+	uptimeInfo := DeviceUptimeInfo{
+		DeviceID:         device.ID,
+		From:             from,
+		To:               to,
+		OnlineSeconds:    online.Seconds(),
+		UptimePercentage: float64(online) / float64(to.Sub(from)) * 100,
+		Connections:      connections,
+	}
+
+	gecho.Success(w).WithData(uptimeInfo).Send()
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"gorm.io/gorm"
+)
+
+func TestOnlineDuration(t *testing.T) {
+	from := time.Date(2026, 1, 12, 8, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Hour)
+	event := func(eventType string, offset time.Duration) models.DeviceEvent {
+		return models.DeviceEvent{Model: gorm.Model{CreatedAt: from.Add(offset)}, Type: eventType}
+	}
+
+	tests := []struct {
+		name      string
+		events    []models.DeviceEvent
+		openUntil time.Time
+		expected  time.Duration
+	}{
+		{
+			name:      "no events",
+			events:    nil,
+			openUntil: to,
+			expected:  0,
+		},
+		{
+			name: "single connection",
+			events: []models.DeviceEvent{
+				event(deviceEventAuthSuccess, 1*time.Hour),
+				event(deviceEventDisconnect, 3*time.Hour),
+			},
+			openUntil: to,
+			expected:  2 * time.Hour,
+		},
+		{
+			name: "online since before the period",
+			events: []models.DeviceEvent{
+				event(deviceEventAuthSuccess, -5*time.Hour),
+				event(deviceEventDisconnect, 1*time.Hour),
+			},
+			openUntil: to,
+			expected:  1 * time.Hour,
+		},
+		{
+			name: "still connected",
+			events: []models.DeviceEvent{
+				event(deviceEventAuthSuccess, 6*time.Hour),
+			},
+			openUntil: from.Add(20 * time.Hour),
+			expected:  4 * time.Hour,
+		},
+		{
+			name: "missed disconnect",
+			events: []models.DeviceEvent{
+				event(deviceEventAuthSuccess, 1*time.Hour),
+				event(deviceEventAuthSuccess, 2*time.Hour),
+				event(deviceEventDisconnect, 4*time.Hour),
+			},
+			openUntil: to,
+			expected:  3 * time.Hour,
+		},
+		{
+			name: "server stopped without disconnect",
+			events: []models.DeviceEvent{
+				event(deviceEventAuthSuccess, 1*time.Hour),
+			},
+			openUntil: from.Add(2 * time.Hour),
+			expected:  1 * time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if online := onlineDuration(tt.events, from, to, tt.openUntil); online != tt.expected {
+				t.Errorf("Expected %s online, got %s", tt.expected, online)
+			}
+		})
+	}
+}
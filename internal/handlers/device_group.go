@@ -0,0 +1,865 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	contextkeys "github.com/CLDWare/schoolbox-backend/internal/contextKeys"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/MonkyMars/gecho"
+	"gorm.io/gorm"
+)
+
+// DeviceGroupHandler handles requests about device groups and bulk actions on their members
+type DeviceGroupHandler struct {
+	quitCh           chan os.Signal
+	config           *config.Config
+	db               *gorm.DB
+	websocketHandler *WebsocketHandler
+	deviceHandler    *DeviceHandler
+	sessionHandler   *SessionHandler
+}
+
+// NewDeviceGroupHandler creates a new DeviceGroupHandler
+func NewDeviceGroupHandler(quitCh chan os.Signal, cfg *config.Config, db *gorm.DB, websocketHandler *WebsocketHandler, deviceHandler *DeviceHandler, sessionHandler *SessionHandler) *DeviceGroupHandler {
+	return &DeviceGroupHandler{
+		quitCh:           quitCh,
+		config:           cfg,
+		db:               db,
+		websocketHandler: websocketHandler,
+		deviceHandler:    deviceHandler,
+		sessionHandler:   sessionHandler,
+	}
+}
+
+// Commands the server already sends as part of the websocket protocol, these can not be sent as a custom command
+var reservedDeviceCommands = []string{
+	"ping", "pong",
+	"reg_pin", "reg_ok",
+	"auth_nonce", "auth_ok",
+	"token_new",
+	"session_start", "session_stop",
+	"device_config",
+	"server_shutdown",
+}
+
+type DeviceGroupInfo struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	DeviceIDs   []uint    `json:"device_ids"`
+	CreatedAt   time.Time `json:"created_at" format:"date-time"`
+}
+
+// toDeviceGroupInfo converts a device group, Devices should be preloaded
+func toDeviceGroupInfo(group models.DeviceGroup) DeviceGroupInfo {
+	groupInfo := DeviceGroupInfo{
+		ID:          group.ID,
+		Name:        group.Name,
+		Description: group.Description,
+		DeviceIDs:   []uint{},
+		CreatedAt:   group.CreatedAt,
+	}
+	for _, device := range group.Devices {
+		groupInfo.DeviceIDs = append(groupInfo.DeviceIDs, device.ID)
+	}
+	return groupInfo
+}
+
+// BulkActionResult is the outcome of a bulk action for a single member of a group
+type BulkActionResult struct {
+	DeviceID  uint    `json:"device_id"`
+	Success   bool    `json:"success"`
+	Error     *string `json:"error"`
+	SessionID *uint   `json:"session_id,omitempty"`
+}
+
+func toBulkActionResult(deviceID uint, err error) BulkActionResult {
+	if err == nil {
+		return BulkActionResult{DeviceID: deviceID, Success: true}
+	}
+	errMsg := err.Error()
+	if errors.Is(err, ErrDeviceNotConnected) {
+		errMsg = "Device currently unavailable"
+	}
+	return BulkActionResult{DeviceID: deviceID, Error: &errMsg}
+}
+
+// groupFromPath loads the group in the id path value with its devices, it sends an error response if it could not
+func (h *DeviceGroupHandler) groupFromPath(w http.ResponseWriter, r *http.Request) (*models.DeviceGroup, bool) {
+	groupID, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid group ID, expected positive integer").Send()
+		return nil, false
+	}
+
+	var group models.DeviceGroup
+	err = h.db.Preload("Devices").Where("id = ?", groupID).First(&group).Error
+	if err == gorm.ErrRecordNotFound {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No device group with id: %d", groupID)).Send()
+		return nil, false
+	}
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return nil, false
+	}
+	return &group, true
+}
+
+// groupNameTaken checks if another group already uses name
+func (h *DeviceGroupHandler) groupNameTaken(name string, exceptID uint) (bool, error) {
+	var count int64
+	err := h.db.Model(&models.DeviceGroup{}).Where("name = ? AND id != ?", name, exceptID).Count(&count).Error
+	return count > 0, err
+}
+
+// GetDeviceGroup
+//
+// @Summary		Get all device groups
+// @Description	Get all device groups and the ids of their members
+// @Description	Requires permission `device_group:read`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]DeviceGroupInfo}
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group [get]
+func (h *DeviceGroupHandler) GetDeviceGroup(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	var groups []models.DeviceGroup
+	err := h.db.Preload("Devices").Order("name").Find(&groups).Error
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	groupInfoArray := []DeviceGroupInfo{}
+	for _, group := range groups {
+		groupInfoArray = append(groupInfoArray, toDeviceGroupInfo(group))
+	}
+
+	gecho.Success(w).WithData(groupInfoArray).Send()
+}
+
+type PostDeviceGroupBody struct {
+	Name        *string `json:"name"`
+	Description string  `json:"description"`
+}
+
+// PostDeviceGroup
+//
+// @Summary		Create a device group
+// @Description	Create an empty device group, add devices with POST `/device-group/{id}/members`
+// @Description	Requires permission `device_group:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			group	body		PostDeviceGroupBody	true	"`name`: Unique name of the group, e.g. \"building A\"\n`description`: Optional description"
+// @Success		201	{object}	apiResponses.BaseResponse{data=DeviceGroupInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		409	{object}	apiResponses.ConflictError "A group with this name already exists"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group [post]
+func (h *DeviceGroupHandler) PostDeviceGroup(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+
+	var body PostDeviceGroupBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+	if body.Name == nil || strings.TrimSpace(*body.Name) == "" {
+		gecho.BadRequest(w).WithMessage("Missing field 'name'").Send()
+		return
+	}
+
+	name := strings.TrimSpace(*body.Name)
+	taken, err := h.groupNameTaken(name, 0)
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	if taken {
+		gecho.NewErr(w).WithStatus(http.StatusConflict).WithMessage(fmt.Sprintf("A device group named '%s' already exists", name)).Send()
+		return
+	}
+
+	group := models.DeviceGroup{
+		Name:        name,
+		Description: body.Description,
+	}
+	if err := gorm.G[models.DeviceGroup](h.db).Create(ctx, &group); err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	gecho.Created(w).WithData(toDeviceGroupInfo(group)).Send()
+}
+
+// GetDeviceGroupById
+//
+// @Summary		Get device group by id
+// @Description	Get a device group and the ids of its members
+// @Description	Requires permission `device_group:read`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device group ID"
+// @Success		200	{object}	apiResponses.BaseResponse{data=DeviceGroupInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group/{id} [get]
+func (h *DeviceGroupHandler) GetDeviceGroupById(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	group, ok := h.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	gecho.Success(w).WithData(toDeviceGroupInfo(*group)).Send()
+}
+
+type PatchDeviceGroupBody struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// PatchDeviceGroupById
+//
+// @Summary		Update a device group
+// @Description	Rename a device group or change its description, fields that are left out stay the same
+// @Description	Requires permission `device_group:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device group ID"
+// @Param			group	body		PatchDeviceGroupBody	true	"`name`: New unique name\n`description`: New description"
+// @Success		200	{object}	apiResponses.BaseResponse{data=DeviceGroupInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		409	{object}	apiResponses.ConflictError "A group with this name already exists"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group/{id} [patch]
+func (h *DeviceGroupHandler) PatchDeviceGroupById(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPatch); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	group, ok := h.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body PatchDeviceGroupBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+
+	updates := map[string]any{}
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if name == "" {
+			gecho.BadRequest(w).WithMessage("Field 'name' can not be empty").Send()
+			return
+		}
+		taken, err := h.groupNameTaken(name, group.ID)
+		if err != nil {
+			gecho.InternalServerError(w).Send()
+			logger.Err(err.Error())
+			return
+		}
+		if taken {
+			gecho.NewErr(w).WithStatus(http.StatusConflict).WithMessage(fmt.Sprintf("A device group named '%s' already exists", name)).Send()
+			return
+		}
+		updates["name"] = name
+		group.Name = name
+	}
+	if body.Description != nil {
+		updates["description"] = *body.Description
+		group.Description = *body.Description
+	}
+
+	if len(updates) != 0 {
+		if err := h.db.Model(group).Updates(updates).Error; err != nil {
+			gecho.InternalServerError(w).Send()
+			logger.Err(err.Error())
+			return
+		}
+	}
+
+	gecho.Success(w).WithData(toDeviceGroupInfo(*group)).Send()
+}
+
+// DeleteDeviceGroupById
+//
+// @Summary		Delete a device group
+// @Description	Delete a device group, its members are not deleted. Use POST `/device-group/{id}/delete` to delete the members.
+// @Description	Requires permission `device_group:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device group ID"
+// @Success		204 {object}	apiResponses.BaseBase
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group/{id} [delete]
+func (h *DeviceGroupHandler) DeleteDeviceGroupById(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodDelete); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	group, ok := h.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Model(group).Association("Devices").Clear(); err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	// Deleted permanently, so the name can be used again
+	if err := h.db.Unscoped().Delete(group).Error; err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
+}
+
+type PostDeviceGroupMembersBody struct {
+	DeviceIDs []uint `json:"device_ids"`
+}
+
+// PostDeviceGroupMembers
+//
+// @Summary		Add devices to a device group
+// @Description	Add devices to a device group, devices that already are a member are ignored
+// @Description	Requires permission `device_group:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device group ID"
+// @Param			members	body		PostDeviceGroupMembersBody	true	"`device_ids`: Ids of the devices to add"
+// @Success		200	{object}	apiResponses.BaseResponse{data=DeviceGroupInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError "Invalid body or unknown device ids"
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group/{id}/members [post]
+func (h *DeviceGroupHandler) PostDeviceGroupMembers(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	group, ok := h.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body PostDeviceGroupMembersBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+	if len(body.DeviceIDs) == 0 {
+		gecho.BadRequest(w).WithMessage("Missing field 'device_ids'").Send()
+		return
+	}
+
+	var devices []models.Device
+	if err := h.db.Where("id IN ?", body.DeviceIDs).Find(&devices).Error; err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	missing := []string{}
+	for _, deviceID := range body.DeviceIDs {
+		if !slices.ContainsFunc(devices, func(device models.Device) bool { return device.ID == deviceID }) {
+			missing = append(missing, strconv.FormatUint(uint64(deviceID), 10))
+		}
+	}
+	if len(missing) != 0 {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("No devices with id: %s", strings.Join(missing, ", "))).Send()
+		return
+	}
+
+	if err := h.db.Model(group).Association("Devices").Append(&devices); err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	group, ok = h.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+	gecho.Success(w).WithData(toDeviceGroupInfo(*group)).Send()
+}
+
+// DeleteDeviceGroupMember
+//
+// @Summary		Remove a device from a device group
+// @Description	Remove a device from a device group, the device itself is not deleted
+// @Description	Requires permission `device_group:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device group ID"
+// @Param			device_id	path		string	true	"Device ID"
+// @Success		204 {object}	apiResponses.BaseBase
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError "No such group or the device is not a member"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group/{id}/members/{device_id} [delete]
+func (h *DeviceGroupHandler) DeleteDeviceGroupMember(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodDelete); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	deviceID, err := strconv.ParseUint(r.PathValue("device_id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid device ID, expected positive integer").Send()
+		return
+	}
+	group, ok := h.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	index := slices.IndexFunc(group.Devices, func(device models.Device) bool { return device.ID == uint(deviceID) })
+	if index == -1 {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("Device %d is not a member of group %d", deviceID, group.ID)).Send()
+		return
+	}
+	if err := h.db.Model(group).Association("Devices").Delete(&group.Devices[index]); err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
+}
+
+type PostDeviceGroupConfigBody struct {
+	Config map[string]any `json:"config"`
+}
+
+// PostDeviceGroupConfig
+//
+// @Summary		Push config to all members of a device group
+// @Description	Send a `device_config` websocket command with the config as data to every member of the group.
+// @Description	Members that are not connected are reported as failed, the config is not stored for later.
+// @Description	Requires permission `device_group:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device group ID"
+// @Param			config	body		PostDeviceGroupConfigBody	true	"`config`: Object that is sent to the devices as is"
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]BulkActionResult}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group/{id}/config [post]
+func (h *DeviceGroupHandler) PostDeviceGroupConfig(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	group, ok := h.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body PostDeviceGroupConfigBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+	if len(body.Config) == 0 {
+		gecho.BadRequest(w).WithMessage("Missing field 'config'").Send()
+		return
+	}
+
+	message := websocketMessage{Command: "device_config", Data: body.Config}
+	results := []BulkActionResult{}
+	for _, device := range group.Devices {
+		err := h.websocketHandler.sendToDevice(device.ID, message)
+		results = append(results, toBulkActionResult(device.ID, err))
+	}
+
+	gecho.Success(w).WithData(results).Send()
+}
+
+type PostDeviceGroupCommandBody struct {
+	Command string         `json:"command"`
+	Data    map[string]any `json:"data"`
+}
+
+// PostDeviceGroupCommand
+//
+// @Summary		Send a command to all members of a device group
+// @Description	Send a custom websocket command to every member of the group.
+// @Description	Commands that are part of the websocket protocol itself, like `session_start`, are rejected.
+// @Description	Requires permission `device_group:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device group ID"
+// @Param			command	body		PostDeviceGroupCommandBody	true	"`command`: Value of the `c` field\n`data`: Value of the `d` field"
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]BulkActionResult}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group/{id}/command [post]
+func (h *DeviceGroupHandler) PostDeviceGroupCommand(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	group, ok := h.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body PostDeviceGroupCommandBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+	if body.Command == "" {
+		gecho.BadRequest(w).WithMessage("Missing field 'command'").Send()
+		return
+	}
+	if slices.Contains(reservedDeviceCommands, body.Command) {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Command '%s' is reserved", body.Command)).Send()
+		return
+	}
+
+	message := websocketMessage{Command: body.Command, Data: body.Data}
+	results := []BulkActionResult{}
+	for _, device := range group.Devices {
+		err := h.websocketHandler.sendToDevice(device.ID, message)
+		results = append(results, toBulkActionResult(device.ID, err))
+	}
+
+	gecho.Success(w).WithData(results).Send()
+}
+
+// PostDeviceGroupDelete
+//
+// @Summary		Delete all members of a device group
+// @Description	Delete every member of the group from the database and terminate their websocket connections. The group itself is kept.
+// @Description	Requires permissions `device_group:write`, `device:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device group ID"
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]BulkActionResult}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group/{id}/delete [post]
+func (h *DeviceGroupHandler) PostDeviceGroupDelete(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+
+	group, ok := h.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	results := []BulkActionResult{}
+	for _, device := range group.Devices {
+		rows, err := h.deviceHandler.deleteDevice(ctx, device.ID)
+		if err != nil {
+			logger.Err(fmt.Sprintf("Could not delete device %d of group %d: %s", device.ID, group.ID, err.Error()))
+		}
+		if rows > 1 {
+			logger.Err(fmt.Sprintf("Deleted %d devices instead of 1 from database!!!!", rows))
+			gecho.InternalServerError(w).Send()
+			h.quitCh <- os.Interrupt
+			return
+		}
+		if err == nil {
+			recordAudit(h.db, r, models.AuditEvent{Action: auditDeviceDelete, TargetType: auditTargetDevice, TargetID: &device.ID, Details: map[string]any{"device_group_id": group.ID}})
+		}
+		results = append(results, toBulkActionResult(device.ID, err))
+	}
+
+	gecho.Success(w).WithData(results).Send()
+}
+
+type PostDeviceGroupSessionBody struct {
+	Question *string `json:"question"`
+}
+
+// PostDeviceGroupSession
+//
+// @Summary		Start a session on all members of a device group
+// @Description	Start a separate session with the same question on every member of the group that is connected and not in a session.
+// @Description	These sessions are owned by the current user, but do not count as their current session.
+// @Description	Requires permissions `device_group:write`, `session:write:own`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device group ID"
+// @Param			session_info	body		PostDeviceGroupSessionBody	true	"`question`: Question to start the sessions with"
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]BulkActionResult}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group/{id}/session [post]
+func (h *DeviceGroupHandler) PostDeviceGroupSession(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	user, ok := r.Context().Value(contextkeys.AuthUserKey).(models.User)
+	if !ok {
+		gecho.InternalServerError(w).Send()
+		return
+	}
+
+	group, ok := h.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body PostDeviceGroupSessionBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+	if body.Question == nil {
+		gecho.BadRequest(w).WithMessage("Missing field 'question'").Send()
+		return
+	}
+
+	results := []BulkActionResult{}
+	for _, device := range group.Devices {
+		if device.ActiveSessionID != nil {
+			results = append(results, toBulkActionResult(device.ID, errors.New("Device already has an active session")))
+			continue
+		}
+		session, err := h.websocketHandler.startSession(user.ID, device.ID, *body.Question)
+		if err != nil {
+			if !errors.Is(err, ErrDeviceNotConnected) {
+				logger.Err(fmt.Sprintf("Could not start session on device %d of group %d: %s", device.ID, group.ID, err.Error()))
+			}
+			results = append(results, toBulkActionResult(device.ID, err))
+			continue
+		}
+		if err := h.sessionHandler.sessionMan.addDeviceSession(session); err != nil {
+			logger.Err(fmt.Sprintf("Could not register session %d: %s", session.ID, err.Error()))
+		}
+		result := toBulkActionResult(device.ID, nil)
+		result.SessionID = &session.ID
+		results = append(results, result)
+	}
+
+	gecho.Success(w).WithData(results).Send()
+}
+
+// PostDeviceGroupSessionStop
+//
+// @Summary		Stop the sessions on all members of a device group
+// @Description	Stop the active session of every member of the group, regardless of who started it
+// @Description	Requires permissions `device_group:write`, `session:stop:any`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device group ID"
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]BulkActionResult}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device-group/{id}/session/stop [post]
+func (h *DeviceGroupHandler) PostDeviceGroupSessionStop(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+
+	group, ok := h.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	results := []BulkActionResult{}
+	for _, device := range group.Devices {
+		if device.ActiveSessionID == nil {
+			results = append(results, toBulkActionResult(device.ID, errors.New("Device has no active session")))
+			continue
+		}
+		session, err := h.sessionHandler.stopSession(ctx, *device.ActiveSessionID)
+		if err != nil {
+			logger.Err(fmt.Sprintf("Could not stop session %d on device %d: %s", *device.ActiveSessionID, device.ID, err.Error()))
+			results = append(results, toBulkActionResult(device.ID, err))
+			continue
+		}
+		recordAudit(h.db, r, models.AuditEvent{
+			Action:     auditSessionStop,
+			TargetType: auditTargetSession,
+			TargetID:   &session.ID,
+			Details:    map[string]any{"user_id": session.UserID, "device_id": session.DeviceID, "device_group_id": group.ID},
+		})
+		result := toBulkActionResult(device.ID, nil)
+		result.SessionID = &session.ID
+		results = append(results, result)
+	}
+
+	gecho.Success(w).WithData(results).Send()
+}
+
+type PutDeviceTagsBody struct {
+	Tags []string `json:"tags"`
+}
+
+// PutDeviceTags
+//
+// @Summary		Set the tags of a device
+// @Description	Replace all tags of a device, e.g. "floor 2" or "science wing". An empty list removes all tags.
+// @Description	Requires permission `device:write`
+// @Tags			device requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Device ID"
+// @Param			tags	body		PutDeviceTagsBody	true	"`tags`: All tags the device should have"
+// @Success		200	{object}	apiResponses.BaseResponse{data=DeviceInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device/{id}/tags [put]
+func (h *DeviceHandler) PutDeviceTags(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPut); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+
+	deviceID, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid device ID, expected positive integer").Send()
+		return
+	}
+
+	var body PutDeviceTagsBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+	tags := []models.DeviceTag{}
+	for _, name := range body.Tags {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			gecho.BadRequest(w).WithMessage("Tags can not be empty").Send()
+			return
+		}
+		if !slices.ContainsFunc(tags, func(tag models.DeviceTag) bool { return tag.Name == name }) {
+			tags = append(tags, models.DeviceTag{DeviceID: uint(deviceID), Name: name})
+		}
+	}
+
+	_, err = gorm.G[models.Device](h.db).Where("id = ?", deviceID).First(ctx)
+	if err == gorm.ErrRecordNotFound {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No device with id of %d", deviceID)).Send()
+		return
+	}
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := gorm.G[models.DeviceTag](tx).Where("device_id = ?", deviceID).Delete(ctx); err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		return gorm.G[models.DeviceTag](tx).CreateInBatches(ctx, &tags, len(tags))
+	})
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	var device models.Device
+	if err := preloadDeviceInfo(h.db).Where("id = ?", deviceID).First(&device).Error; err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	deviceInfo := toDeviceInfo(device)
+	h.websocketHandler.applyPresence(&deviceInfo)
+
+	gecho.Success(w).WithData(deviceInfo).Send()
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/internal/authsession"
+	contextkeys "github.com/CLDWare/schoolbox-backend/internal/contextKeys"
+	"github.com/CLDWare/schoolbox-backend/internal/rbac"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/CLDWare/schoolbox-backend/pkg/tokenhash"
+	"github.com/MonkyMars/gecho"
+	"gorm.io/gorm"
+)
+
+// ImpersonationInfo is an admin looking at the api as another user
+type ImpersonationInfo struct {
+	ImpersonatorID    uint      `json:"impersonator_id"`
+	ImpersonatorEmail string    `json:"impersonator_email" example:"admin@school.nl"`
+	Reason            string    `json:"reason" example:"Teacher reports an empty session list"`
+	AllowWrite        bool      `json:"allow_write"` // Without it only GET requests can be made
+	ExpiresAt         time.Time `json:"expires_at" format:"date-time"`
+}
+
+// currentImpersonation returns the impersonation a request was made with, nil when it was made by the user themselves
+func currentImpersonation(r *http.Request) *ImpersonationInfo {
+	ctx := r.Context()
+	impersonator, ok := ctx.Value(contextkeys.ImpersonatorKey).(models.User)
+	if !ok {
+		return nil
+	}
+	session, ok := ctx.Value(contextkeys.AuthSessionKey).(models.AuthSession)
+	if !ok {
+		return nil
+	}
+	return &ImpersonationInfo{
+		ImpersonatorID:    impersonator.ID,
+		ImpersonatorEmail: impersonator.Email,
+		Reason:            session.ImpersonationReason,
+		AllowWrite:        session.ImpersonationAllowWrite,
+		ExpiresAt:         session.AbsoluteExpiresAt,
+	}
+}
+
+type PostUserImpersonateBody struct {
+	Reason     *string `json:"reason" example:"Teacher reports an empty session list"`
+	AllowWrite bool    `json:"allow_write"`
+}
+
+// PostUserImpersonate
+//
+// @Summary		Impersonate a user
+// @Description	Log in as a user to see what they see, e.g. to help a teacher with a problem. The session cookie is replaced by
+// @Description	a session of the user that ends after AUTH_IMPERSONATION_TTL and is not renewed, the session of the admin is kept
+// @Description	in another cookie until the impersonation is stopped with POST /impersonation/stop.
+// @Description	Only GET requests can be made while impersonating unless `allow_write` is set. `/me` shows the impersonation,
+// @Description	and the start, the end and every audited action during the impersonation are written to the audit log.
+// @Description	Admins and deactivated users can not be impersonated. Can not be used with a personal access token.
+// @Description	Requires permission `user:impersonate`
+// @Tags			user requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"User ID"
+// @Param			impersonation	body		PostUserImpersonateBody	true	"`reason`: Why the user is impersonated, written to the audit log\n`allow_write`: Allow changes as the user"
+// @Success		201	{object}	apiResponses.BaseResponse{data=ImpersonationInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/user/{id}/impersonate [post]
+func (h *UserHandler) PostUserImpersonate(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+	admin, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	adminSession, ok := ctx.Value(contextkeys.AuthSessionKey).(models.AuthSession)
+	if !ok {
+		gecho.InternalServerError(w).Send()
+		return
+	}
+	// Checked by the middleware, read again to move it to the impersonator cookie
+	adminCookie, err := r.Cookie(authsession.CookieName)
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		return
+	}
+
+	user, ok := h.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body PostUserImpersonateBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+	if body.Reason == nil || strings.TrimSpace(*body.Reason) == "" {
+		gecho.BadRequest(w).WithMessage("Missing field 'reason'").Send()
+		return
+	}
+	if user.ID == admin.ID {
+		gecho.BadRequest(w).WithMessage("Can not impersonate yourself").Send()
+		return
+	}
+	// Otherwise an admin could act as another admin, and the audit log would blame them
+	if rbac.Has(user.Role, rbac.UserImpersonate) {
+		gecho.BadRequest(w).WithMessage("Can not impersonate users that can impersonate others").Send()
+		return
+	}
+	if user.DeactivatedAt != nil {
+		gecho.BadRequest(w).WithMessage("Can not impersonate a deactivated user").Send()
+		return
+	}
+
+	sessionToken, err := authsession.Generate()
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	expiresAt := time.Now().Add(h.config.OAuth.ImpersonationTTL)
+	session := models.AuthSession{
+		TokenHash:               tokenhash.Hash(sessionToken),
+		UserID:                  user.ID,
+		ExpiresAt:               expiresAt,
+		AbsoluteExpiresAt:       expiresAt,
+		UserAgent:               r.UserAgent(),
+		IPAddress:               clientAddress(r),
+		ImpersonatorID:          &admin.ID,
+		ImpersonationReason:     strings.TrimSpace(*body.Reason),
+		ImpersonationAllowWrite: body.AllowWrite,
+	}
+	if err := gorm.G[models.AuthSession](h.db).Create(ctx, &session); err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("User %d started impersonating user %d", admin.ID, user.ID))
+	recordAudit(h.db, r, models.AuditEvent{
+		Action:     auditImpersonationStart,
+		TargetType: auditTargetUser,
+		TargetID:   &user.ID,
+		Details: map[string]any{
+			"reason":          session.ImpersonationReason,
+			"allow_write":     session.ImpersonationAllowWrite,
+			"expires_at":      expiresAt,
+			"auth_session_id": session.ID,
+		},
+	})
+
+	http.SetCookie(w, authsession.ImpersonatorCookie(h.config, adminCookie.Value, adminSession.ExpiresAt))
+	http.SetCookie(w, authsession.Cookie(h.config, sessionToken, expiresAt))
+	gecho.Created(w).WithData(ImpersonationInfo{
+		ImpersonatorID:    admin.ID,
+		ImpersonatorEmail: admin.Email,
+		Reason:            session.ImpersonationReason,
+		AllowWrite:        session.ImpersonationAllowWrite,
+		ExpiresAt:         expiresAt,
+	}).Send()
+}
+
+// PostImpersonationStop
+//
+// @Summary		Stop impersonating a user
+// @Description	End the impersonation session and log back in with the session of the admin that started it.
+// @Description	Also works after the impersonation expired, so the admin does not have to log in again.
+// @Tags			user
+// @Accept			json
+// @Produce		json
+// @Success		204 {object}	apiResponses.BaseBase
+// @Failure		400	{object}	apiResponses.BadRequestError "Not impersonating"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/impersonation/stop [post]
+func (h *UserHandler) PostImpersonationStop(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+
+	// Not behind the authentication middleware, an expired impersonation has to be stoppable as well.
+	// Only the sessions the browser has the tokens of are used.
+	adminCookie, err := r.Cookie(authsession.ImpersonatorCookieName)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Not impersonating").Send()
+		return
+	}
+	if sessionCookie, err := r.Cookie(authsession.CookieName); err == nil {
+		session, err := gorm.G[models.AuthSession](h.db).
+			Where("token_hash = ? AND impersonator_id IS NOT NULL", tokenhash.Hash(sessionCookie.Value)).First(ctx)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			gecho.InternalServerError(w).Send()
+			logger.Err(err.Error())
+			return
+		}
+		ended := 0
+		if err == nil {
+			now := time.Now()
+			ended, err = gorm.G[models.AuthSession](h.db).Where("id = ? AND expires_at > ?", session.ID, now).Update(ctx, "expires_at", now)
+			if err != nil {
+				gecho.InternalServerError(w).Send()
+				logger.Err(err.Error())
+				return
+			}
+		}
+		// An impersonation that expired on its own has no stop event
+		if ended != 0 {
+			logger.Info(fmt.Sprintf("User %d stopped impersonating user %d", *session.ImpersonatorID, session.UserID))
+			recordAudit(h.db, r, models.AuditEvent{
+				ActorID:    session.ImpersonatorID,
+				Action:     auditImpersonationStop,
+				TargetType: auditTargetUser,
+				TargetID:   &session.UserID,
+				Details:    map[string]any{"auth_session_id": session.ID},
+			})
+		}
+	}
+
+	http.SetCookie(w, authsession.ExpiredImpersonatorCookie(h.config))
+	adminSession, err := gorm.G[models.AuthSession](h.db).
+		Where("token_hash = ? AND expires_at > ? AND impersonator_id IS NULL", tokenhash.Hash(adminCookie.Value), time.Now()).First(ctx)
+	if err == nil {
+		http.SetCookie(w, authsession.Cookie(h.config, adminCookie.Value, adminSession.ExpiresAt))
+	} else {
+		// The session of the admin ended in the meantime, they have to log in again
+		http.SetCookie(w, authsession.ExpiredCookie(h.config))
+		if err != gorm.ErrRecordNotFound {
+			logger.Err(err.Error())
+		}
+	}
+	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	contextkeys "github.com/CLDWare/schoolbox-backend/internal/contextKeys"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"gorm.io/gorm"
+)
+
+func TestImpersonationCanNotManageTokensOrSessions(t *testing.T) {
+	h := &UserHandler{}
+	user := models.User{Model: gorm.Model{ID: 2}, Email: "teacher@school.nl"}
+	admin := models.User{Model: gorm.Model{ID: 1}, Email: "admin@school.nl"}
+
+	tests := []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+	}{
+		{name: "create personal access token", method: http.MethodPost, handler: h.PostMeTokens},
+		{name: "list personal access tokens", method: http.MethodGet, handler: h.GetMeTokens},
+		{name: "revoke personal access token", method: http.MethodDelete, handler: h.DeleteMeToken},
+		{name: "list login sessions", method: http.MethodGet, handler: h.GetMeSessions},
+		{name: "end login sessions", method: http.MethodDelete, handler: h.DeleteMeSessions},
+		{name: "end login session", method: http.MethodDelete, handler: h.DeleteMeSession},
+		{name: "edit profile", method: http.MethodPatch, handler: h.PatchMe},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.NewReader(`{"name": "script", "scopes": ["session:read:own"]}`)
+			ctx := context.WithValue(context.Background(), contextkeys.AuthUserKey, user)
+			ctx = context.WithValue(ctx, contextkeys.AuthSessionKey, models.AuthSession{UserID: user.ID, ImpersonatorID: &admin.ID, ImpersonationAllowWrite: true})
+			ctx = context.WithValue(ctx, contextkeys.ImpersonatorKey, admin)
+			r := httptest.NewRequest(tt.method, "/me", body).WithContext(ctx)
+			w := httptest.NewRecorder()
+
+			tt.handler(w, r)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("expected status %d while impersonating, got %d", http.StatusForbidden, w.Code)
+			}
+		})
+	}
+}
+
+func TestSessionUser(t *testing.T) {
+	user := models.User{Model: gorm.Model{ID: 2}}
+
+	tests := []struct {
+		name     string
+		values   map[any]any
+		expectOK bool
+	}{
+		{
+			name:     "own session",
+			values:   map[any]any{contextkeys.AuthUserKey: user, contextkeys.AuthSessionKey: models.AuthSession{UserID: user.ID}},
+			expectOK: true,
+		},
+		{
+			name:     "personal access token",
+			values:   map[any]any{contextkeys.AuthUserKey: user, contextkeys.AuthTokenKey: models.PersonalAccessToken{UserID: user.ID}},
+			expectOK: false,
+		},
+		{
+			name:     "impersonating",
+			values:   map[any]any{contextkeys.AuthUserKey: user, contextkeys.ImpersonatorKey: models.User{Model: gorm.Model{ID: 1}}},
+			expectOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			for key, value := range tt.values {
+				ctx = context.WithValue(ctx, key, value)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/me/tokens", nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+
+			if _, ok := sessionUser(w, r); ok != tt.expectOK {
+				t.Errorf("expected ok %t, got %t", tt.expectOK, ok)
+			}
+			if !tt.expectOK && w.Code != http.StatusForbidden {
+				t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+			}
+		})
+	}
+}
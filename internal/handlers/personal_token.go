@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	contextkeys "github.com/CLDWare/schoolbox-backend/internal/contextKeys"
+	"github.com/CLDWare/schoolbox-backend/internal/personaltoken"
+	"github.com/CLDWare/schoolbox-backend/internal/rbac"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/CLDWare/schoolbox-backend/pkg/tokenhash"
+	"github.com/MonkyMars/gecho"
+	"gorm.io/gorm"
+)
+
+type PersonalTokenInfo struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name" example:"Weekly report"`
+	Hint       string     `json:"hint" example:"sbx_pat_Xk3a"`
+	Scopes     []string   `json:"scopes" example:"session:read:own"`
+	CreatedAt  time.Time  `json:"created_at" format:"date-time"`
+	ExpiresAt  time.Time  `json:"expires_at" format:"date-time"`
+	LastUsedAt *time.Time `json:"last_used_at" format:"date-time"`
+	Expired    bool       `json:"expired"`
+}
+
+// CreatedPersonalTokenInfo is only returned when a token is created, the token can not be retrieved later
+type CreatedPersonalTokenInfo struct {
+	PersonalTokenInfo
+	Token string `json:"token" example:"sbx_pat_Xk3aQ0yJ2m7WcVbE1nRtL9pZu4sHdF6gKoA8iY5eMxw"`
+}
+
+func toPersonalTokenInfo(token models.PersonalAccessToken) PersonalTokenInfo {
+	return PersonalTokenInfo{
+		ID:         token.ID,
+		Name:       token.Name,
+		Hint:       token.Hint,
+		Scopes:     token.Scopes,
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		Expired:    time.Now().After(token.ExpiresAt),
+	}
+}
+
+// sessionUser returns the authenticated user of a request that was authenticated with a session of the user themselves.
+// Personal access tokens can not manage tokens, login sessions or the profile of the user, otherwise a leaked token
+// could be used to create new tokens or to log the user out. Impersonating admins can not either, a token they create
+// would outlive the impersonation and act as the user without being traced back to the admin.
+func sessionUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	ctx := r.Context()
+	if _, ok := ctx.Value(contextkeys.AuthTokenKey).(models.PersonalAccessToken); ok {
+		gecho.Forbidden(w).WithMessage("This endpoint can not be used with a personal access token").Send()
+		return models.User{}, false
+	}
+	if _, ok := ctx.Value(contextkeys.ImpersonatorKey).(models.User); ok {
+		gecho.Forbidden(w).WithMessage("This endpoint can not be used while impersonating a user").Send()
+		return models.User{}, false
+	}
+	user, ok := ctx.Value(contextkeys.AuthUserKey).(models.User)
+	if !ok {
+		gecho.InternalServerError(w).Send()
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// GetMeTokens
+//
+// @Summary		Get the personal access tokens of the current user
+// @Description	Get the personal access tokens of the current user, newest first. Revoked tokens are not returned.
+// @Description	Can not be used with a personal access token or while impersonating.
+// @Tags			user requiresAuth
+// @Accept			json
+// @Produce		json
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]PersonalTokenInfo}
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError "Authenticated with a personal access token or impersonating"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/me/tokens [get]
+func (h *UserHandler) GetMeTokens(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := gorm.G[models.PersonalAccessToken](h.db).Where("user_id = ?", user.ID).Order("created_at DESC").Find(ctx)
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	tokenInfoArray := []PersonalTokenInfo{}
+	for _, token := range tokens {
+		tokenInfoArray = append(tokenInfoArray, toPersonalTokenInfo(token))
+	}
+
+	gecho.Success(w).WithData(tokenInfoArray).Send()
+}
+
+type PostMeTokensBody struct {
+	Name      *string    `json:"name"`
+	Scopes    []string   `json:"scopes" example:"session:read:own"`
+	ExpiresAt *time.Time `json:"expires_at" format:"date-time"`
+}
+
+// PostMeTokens
+//
+// @Summary		Create a personal access token
+// @Description	Create a personal access token for scripts, send it as `Authorization: Bearer <token>`.
+// @Description	The token is only returned once. It is limited to its scopes and to the permissions of your role at the time it is used.
+// @Description	Can not be used with a personal access token or while impersonating.
+// @Tags			user requiresAuth
+// @Accept			json
+// @Produce		json
+// @Param			token	body		PostMeTokensBody	true	"`name`: Name to recognise the token by\n`scopes`: Permissions the token can use, your role needs to have them\n`expires_at`: Optional expiry date, defaults to PERSONAL_TOKEN_DEFAULT_LIFETIME from now and can be at most PERSONAL_TOKEN_MAX_LIFETIME from now"
+// @Success		201	{object}	apiResponses.BaseResponse{data=CreatedPersonalTokenInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError "Authenticated with a personal access token or impersonating, or a scope is missing from your role"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/me/tokens [post]
+func (h *UserHandler) PostMeTokens(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var body PostMeTokensBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+	if body.Name == nil || strings.TrimSpace(*body.Name) == "" {
+		gecho.BadRequest(w).WithMessage("Missing field 'name'").Send()
+		return
+	}
+	if len(body.Scopes) == 0 {
+		gecho.BadRequest(w).WithMessage("Missing field 'scopes', a token needs at least one scope").Send()
+		return
+	}
+	scopes := []string{}
+	for _, scope := range body.Scopes {
+		permission := rbac.Permission(scope)
+		if !rbac.ValidPermission(permission) {
+			gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid scope '%s'", scope)).Send()
+			return
+		}
+		if !rbac.Has(user.Role, permission) {
+			gecho.Forbidden(w).WithMessage(fmt.Sprintf("Missing permission '%s'", permission)).Send()
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(h.config.PersonalToken.DefaultLifetime)
+	if body.ExpiresAt != nil {
+		expiresAt = *body.ExpiresAt
+		if !expiresAt.After(now) {
+			gecho.BadRequest(w).WithMessage("Invalid 'expires_at', must be in the future").Send()
+			return
+		}
+		if expiresAt.After(now.Add(h.config.PersonalToken.MaxLifetime)) {
+			gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid 'expires_at', can be at most %s from now", h.config.PersonalToken.MaxLifetime)).Send()
+			return
+		}
+	}
+
+	token, err := personaltoken.Generate()
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	personalToken := models.PersonalAccessToken{
+		UserID:    user.ID,
+		Name:      strings.TrimSpace(*body.Name),
+		TokenHash: tokenhash.Hash(token),
+		Hint:      personaltoken.Hint(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := gorm.G[models.PersonalAccessToken](h.db).Create(ctx, &personalToken); err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	recordAudit(h.db, r, models.AuditEvent{
+		Action:     auditPersonalTokenCreate,
+		TargetType: auditTargetPersonalToken,
+		TargetID:   &personalToken.ID,
+		Details:    map[string]any{"name": personalToken.Name, "scopes": scopes, "expires_at": expiresAt},
+	})
+
+	gecho.Created(w).WithData(CreatedPersonalTokenInfo{
+		PersonalTokenInfo: toPersonalTokenInfo(personalToken),
+		Token:             token,
+	}).Send()
+}
+
+// DeleteMeToken
+//
+// @Summary		Revoke a personal access token
+// @Description	Revoke a personal access token of the current user, it can not be used anymore.
+// @Description	Can not be used with a personal access token or while impersonating.
+// @Tags			user requiresAuth
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Token ID"
+// @Success		204 {object}	apiResponses.BaseBase
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError "Authenticated with a personal access token or impersonating"
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/me/tokens/{id} [delete]
+func (h *UserHandler) DeleteMeToken(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodDelete); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid token ID, expected positive integer").Send()
+		return
+	}
+
+	// Tokens of other users are not found, so their IDs can not be discovered
+	deleted, err := gorm.G[models.PersonalAccessToken](h.db).Where("id = ? AND user_id = ?", tokenID, user.ID).Delete(ctx)
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	if deleted == 0 {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No token with id: %d", tokenID)).Send()
+		return
+	}
+	revokedID := uint(tokenID)
+	recordAudit(h.db, r, models.AuditEvent{Action: auditPersonalTokenRevoke, TargetType: auditTargetPersonalToken, TargetID: &revokedID})
+
+	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/internal/broker"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/MonkyMars/gecho"
+)
+
+// Presence event types
+const (
+	presenceDeviceOnline         = "device_online"
+	presenceDeviceOffline        = "device_offline"
+	presenceDeviceState          = "device_state"
+	presenceRegistrationPending  = "registration_pending"
+	presenceRegistrationFinished = "registration_finished"
+)
+
+// connectionStateName returns the name of a websocketConnection state as used in the API
+func connectionStateName(state uint) string {
+	switch state {
+	case 0:
+		return "connected"
+	case 1:
+		return "registering"
+	case 2:
+		return "authenticating"
+	case 3:
+		return "authenticated"
+	case 4:
+		return "in_session"
+	default:
+		return "unknown"
+	}
+}
+
+type PresenceEvent struct {
+	Type           string     `json:"type" enums:"device_online,device_offline,device_state,registration_pending,registration_finished"`
+	DeviceID       *uint      `json:"device_id,omitempty"`
+	ConnectionID   uint       `json:"connection_id"`
+	State          string     `json:"state,omitempty" enums:"connected,registering,authenticating,authenticated,in_session"`
+	ConnectedSince *time.Time `json:"connected_since,omitempty" format:"date-time"`
+	Pin            *uint      `json:"pin,omitempty"`
+	RemoteAddr     string     `json:"remote_addr,omitempty"`
+	At             time.Time  `json:"at" format:"date-time"`
+}
+
+// presenceHub fans out presence events of all instances to the admin feeds subscribed on this instance
+type presenceHub struct {
+	broker      broker.Broker
+	subscribers map[chan PresenceEvent]struct{}
+	mu          sync.Mutex
+}
+
+func newPresenceHub(b broker.Broker) *presenceHub {
+	return &presenceHub{
+		broker:      b,
+		subscribers: map[chan PresenceEvent]struct{}{},
+	}
+}
+
+// start relays the presence events published by any instance to the local subscribers
+func (hub *presenceHub) start() error {
+	_, err := hub.broker.Subscribe(context.Background(), presenceChannel, func(payload []byte) {
+		var event PresenceEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			logger.Err(fmt.Sprintf("Invalid presence event: %s", err.Error()))
+			return
+		}
+		hub.deliver(event)
+	})
+	return err
+}
+
+func (hub *presenceHub) subscribe() (chan PresenceEvent, func()) {
+	ch := make(chan PresenceEvent, 32)
+	hub.mu.Lock()
+	hub.subscribers[ch] = struct{}{}
+	hub.mu.Unlock()
+
+	return ch, func() {
+		hub.mu.Lock()
+		delete(hub.subscribers, ch)
+		hub.mu.Unlock()
+	}
+}
+
+// publish sends event to the subscribers of all instances
+func (hub *presenceHub) publish(event PresenceEvent) {
+	event.At = time.Now()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not encode %s presence event: %s", event.Type, err.Error()))
+		return
+	}
+	if err := hub.broker.Publish(context.Background(), presenceChannel, payload); err != nil {
+		logger.Err(fmt.Sprintf("Could not publish %s presence event: %s", event.Type, err.Error()))
+	}
+}
+
+// deliver sends event to the local subscribers, subscribers that can not keep up miss the event
+func (hub *presenceHub) deliver(event PresenceEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for ch := range hub.subscribers {
+		select {
+		case ch <- event:
+		default:
+			logger.Warn(fmt.Sprintf("Presence subscriber can not keep up, dropped %s event", event.Type))
+		}
+	}
+}
+
+// publishConnectionState announces the current state of conn
+func (h *WebsocketHandler) publishConnectionState(conn *websocketConnection, eventType string) {
+	conn.mu.RLock()
+	connectedSince := conn.connectedAt
+	event := PresenceEvent{
+		Type:           eventType,
+		DeviceID:       conn.deviceID,
+		ConnectionID:   conn.connectionID,
+		State:          connectionStateName(conn.state),
+		ConnectedSince: &connectedSince,
+		RemoteAddr:     conn.remoteAddr,
+	}
+	conn.mu.RUnlock()
+	h.presence.publish(event)
+}
+
+type devicePresence struct {
+	State          string
+	ConnectedSince time.Time
+	Instance       string
+}
+
+// devicePresence returns the connection state of a device on any instance, false if it is not connected
+func (h *WebsocketHandler) devicePresence(deviceID uint) (devicePresence, bool) {
+	record, _, err := h.getConnectionRecord(devicePresenceKey(deviceID))
+	if err != nil {
+		if !errors.Is(err, broker.ErrNotFound) {
+			logger.Err(fmt.Sprintf("Could not get presence of device %d: %s", deviceID, err.Error()))
+		}
+		return devicePresence{}, false
+	}
+	return devicePresence{
+		State:          connectionStateName(record.State),
+		ConnectedSince: record.ConnectedAt,
+		Instance:       record.Instance,
+	}, true
+}
+
+// applyPresence fills the online status fields of info
+func (h *WebsocketHandler) applyPresence(info *DeviceInfo) {
+	presence, online := h.devicePresence(info.ID)
+	info.Online = online
+	if !online {
+		info.ConnectionState = "offline"
+		return
+	}
+	info.ConnectionState = presence.State
+	info.ConnectedSince = &presence.ConnectedSince
+}
+
+// presenceSnapshot describes all authenticated devices and pending registrations of all instances as presence events
+func (h *WebsocketHandler) presenceSnapshot() ([]PresenceEvent, error) {
+	ctx := context.Background()
+	deviceKeys, err := h.broker.Keys(ctx, devicePresencePrefix)
+	if err != nil {
+		return nil, err
+	}
+	pinKeys, err := h.broker.Keys(ctx, registrationPinPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	events := []PresenceEvent{}
+	for _, key := range append(deviceKeys, pinKeys...) {
+		record, _, err := h.getConnectionRecord(key)
+		if errors.Is(err, broker.ErrNotFound) {
+			continue // expired or removed since listing the keys
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		connectedSince := record.ConnectedAt
+		event := PresenceEvent{
+			Type:           presenceDeviceOnline,
+			ConnectionID:   record.ConnectionID,
+			DeviceID:       record.DeviceID,
+			State:          connectionStateName(record.State),
+			ConnectedSince: &connectedSince,
+			Pin:            record.Pin,
+			RemoteAddr:     record.RemoteAddr,
+			At:             now,
+		}
+		if strings.HasPrefix(key, registrationPinPrefix) {
+			event.Type = presenceRegistrationPending
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func writeServerSentEvent(w http.ResponseWriter, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, payload)
+	return err
+}
+
+// GetDevicePresence
+//
+// @Summary		Live feed of device presence
+// @Description	Server-sent event stream for the admin dashboard.
+// @Description	Starts with a `snapshot` event containing all connected devices and pending registrations of all instances,
+// @Description	followed by a `presence` event (PresenceEvent) for every device that connects, disconnects or changes state and every registration pin that is handed out or used.
+// @Description	Requires permission `device:read`
+// @Tags			device requiresAuth requiresPermission
+// @Produce		text/event-stream
+// @Success		200	{object}	[]PresenceEvent
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/device/presence [get]
+func (h *DeviceHandler) GetDevicePresence(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	// The stream outlives the server write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.Err(fmt.Sprintf("Could not clear write deadline for presence feed: %s", err.Error()))
+		gecho.InternalServerError(w).Send()
+		return
+	}
+
+	events, unsubscribe := h.websocketHandler.presence.subscribe()
+	defer unsubscribe()
+
+	snapshot, err := h.websocketHandler.presenceSnapshot()
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not get presence snapshot: %s", err.Error()))
+		gecho.InternalServerError(w).Send()
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeServerSentEvent(w, "snapshot", snapshot); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if err := writeServerSentEvent(w, "presence", event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/MonkyMars/gecho"
+	"gorm.io/gorm"
+)
+
+// RoomHandler handles requests about rooms
+type RoomHandler struct {
+	quitCh chan os.Signal
+	config *config.Config
+	db     *gorm.DB
+}
+
+// NewRoomHandler creates a new RoomHandler
+func NewRoomHandler(quitCh chan os.Signal, cfg *config.Config, db *gorm.DB) *RoomHandler {
+	return &RoomHandler{
+		quitCh: quitCh,
+		config: cfg,
+		db:     db,
+	}
+}
+
+type RoomInfo struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Building  string    `json:"building"`
+	Floor     *int      `json:"floor"`
+	Capacity  *uint     `json:"capacity"`
+	DeviceIDs []uint    `json:"device_ids"`
+	CreatedAt time.Time `json:"created_at" format:"date-time"`
+}
+
+// toRoomInfo converts a room, Devices should be preloaded
+func toRoomInfo(room models.Room) RoomInfo {
+	roomInfo := RoomInfo{
+		ID:        room.ID,
+		Name:      room.Name,
+		Building:  room.Building,
+		Floor:     room.Floor,
+		Capacity:  room.Capacity,
+		DeviceIDs: []uint{},
+		CreatedAt: room.CreatedAt,
+	}
+	for _, device := range room.Devices {
+		roomInfo.DeviceIDs = append(roomInfo.DeviceIDs, device.ID)
+	}
+	return roomInfo
+}
+
+// roomFromPath loads the room in the id path value with its devices, it sends an error response if it could not
+func (h *RoomHandler) roomFromPath(w http.ResponseWriter, r *http.Request) (*models.Room, bool) {
+	roomID, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid room ID, expected positive integer").Send()
+		return nil, false
+	}
+
+	var room models.Room
+	err = h.db.Preload("Devices").Where("id = ?", roomID).First(&room).Error
+	if err == gorm.ErrRecordNotFound {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No room with id: %d", roomID)).Send()
+		return nil, false
+	}
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return nil, false
+	}
+	return &room, true
+}
+
+// roomNameTaken checks if another room already uses name, deleted rooms do not count
+func (h *RoomHandler) roomNameTaken(name string, exceptID uint) (bool, error) {
+	var count int64
+	err := h.db.Model(&models.Room{}).Where("name = ? AND id != ?", name, exceptID).Count(&count).Error
+	return count > 0, err
+}
+
+// GetRoom
+//
+// @Summary		Get all rooms
+// @Description	Get all rooms and the ids of the devices in them
+// @Description	Requires permission `room:read`
+// @Tags			room requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			building	query		string	false	"Only return rooms in this building"
+// @Success		200	{object}	apiResponses.BaseResponse{data=[]RoomInfo}
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/room [get]
+func (h *RoomHandler) GetRoom(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	dbQuery := h.db.Model(&models.Room{})
+	if building := r.URL.Query().Get("building"); building != "" {
+		dbQuery = dbQuery.Where("building = ?", building)
+	}
+
+	var rooms []models.Room
+	err := dbQuery.Preload("Devices").Order("building, floor, name").Find(&rooms).Error
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	roomInfoArray := []RoomInfo{}
+	for _, room := range rooms {
+		roomInfoArray = append(roomInfoArray, toRoomInfo(room))
+	}
+
+	gecho.Success(w).WithData(roomInfoArray).Send()
+}
+
+type PostRoomBody struct {
+	Name     *string `json:"name"`
+	Building string  `json:"building"`
+	Floor    *int    `json:"floor"`
+	Capacity *uint   `json:"capacity"`
+}
+
+// PostRoom
+//
+// @Summary		Create a room
+// @Description	Create a room, move devices into it with PATCH `/device/{id}`
+// @Description	Requires permission `room:write`
+// @Tags			room requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			room	body		PostRoomBody	true	"`name`: Unique name of the room, also used by `/device/{id}?type=room`\n`building`: Optional building\n`floor`: Optional floor\n`capacity`: Optional amount of people that fit in the room"
+// @Success		201	{object}	apiResponses.BaseResponse{data=RoomInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		409	{object}	apiResponses.ConflictError "A room with this name already exists"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/room [post]
+func (h *RoomHandler) PostRoom(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+
+	var body PostRoomBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+	if body.Name == nil || strings.TrimSpace(*body.Name) == "" {
+		gecho.BadRequest(w).WithMessage("Missing field 'name'").Send()
+		return
+	}
+
+	name := strings.TrimSpace(*body.Name)
+	taken, err := h.roomNameTaken(name, 0)
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	if taken {
+		gecho.NewErr(w).WithStatus(http.StatusConflict).WithMessage(fmt.Sprintf("A room named '%s' already exists", name)).Send()
+		return
+	}
+
+	room := models.Room{
+		Name:     name,
+		Building: body.Building,
+		Floor:    body.Floor,
+		Capacity: body.Capacity,
+	}
+	if err := gorm.G[models.Room](h.db).Create(ctx, &room); err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	gecho.Created(w).WithData(toRoomInfo(room)).Send()
+}
+
+// GetRoomById
+//
+// @Summary		Get room by id
+// @Description	Get a room and the ids of the devices in it
+// @Description	Requires permission `room:read`
+// @Tags			room requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Room ID"
+// @Success		200	{object}	apiResponses.BaseResponse{data=RoomInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/room/{id} [get]
+func (h *RoomHandler) GetRoomById(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodGet); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	room, ok := h.roomFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	gecho.Success(w).WithData(toRoomInfo(*room)).Send()
+}
+
+type PatchRoomBody struct {
+	Name     *string `json:"name"`
+	Building *string `json:"building"`
+	Floor    *int    `json:"floor"`
+	Capacity *uint   `json:"capacity"`
+}
+
+// PatchRoomById
+//
+// @Summary		Update a room
+// @Description	Change the name, building, floor or capacity of a room, fields that are left out stay the same
+// @Description	Requires permission `room:write`
+// @Tags			room requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Room ID"
+// @Param			room	body		PatchRoomBody	true	"`name`: New unique name\n`building`: New building\n`floor`: New floor\n`capacity`: New capacity"
+// @Success		200	{object}	apiResponses.BaseResponse{data=RoomInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		409	{object}	apiResponses.ConflictError "A room with this name already exists"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/room/{id} [patch]
+func (h *RoomHandler) PatchRoomById(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPatch); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	room, ok := h.roomFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body PatchRoomBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+
+	updates := map[string]any{}
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if name == "" {
+			gecho.BadRequest(w).WithMessage("Field 'name' can not be empty").Send()
+			return
+		}
+		taken, err := h.roomNameTaken(name, room.ID)
+		if err != nil {
+			gecho.InternalServerError(w).Send()
+			logger.Err(err.Error())
+			return
+		}
+		if taken {
+			gecho.NewErr(w).WithStatus(http.StatusConflict).WithMessage(fmt.Sprintf("A room named '%s' already exists", name)).Send()
+			return
+		}
+		updates["name"] = name
+		room.Name = name
+	}
+	if body.Building != nil {
+		updates["building"] = *body.Building
+		room.Building = *body.Building
+	}
+	if body.Floor != nil {
+		updates["floor"] = *body.Floor
+		room.Floor = body.Floor
+	}
+	if body.Capacity != nil {
+		updates["capacity"] = *body.Capacity
+		room.Capacity = body.Capacity
+	}
+
+	if len(updates) != 0 {
+		if err := h.db.Model(room).Updates(updates).Error; err != nil {
+			gecho.InternalServerError(w).Send()
+			logger.Err(err.Error())
+			return
+		}
+	}
+
+	gecho.Success(w).WithData(toRoomInfo(*room)).Send()
+}
+
+// DeleteRoomById
+//
+// @Summary		Delete a room
+// @Description	Delete a room, devices in it are left without a room. Sessions that took place in the room keep referring to it.
+// @Description	Requires permission `room:write`
+// @Tags			room requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id	path		string	true	"Room ID"
+// @Success		204 {object}	apiResponses.BaseBase
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/room/{id} [delete]
+func (h *RoomHandler) DeleteRoomById(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodDelete); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+
+	room, ok := h.roomFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Device{}).Where("room_id = ?", room.ID).Update("room_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(room).Error
+	})
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
+}
@@ -3,15 +3,19 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/broker"
 	contextkeys "github.com/CLDWare/schoolbox-backend/internal/contextKeys"
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
+	"github.com/CLDWare/schoolbox-backend/internal/middleware"
+	"github.com/CLDWare/schoolbox-backend/internal/rbac"
 	models "github.com/CLDWare/schoolbox-backend/pkg/db"
 	"github.com/CLDWare/schoolbox-backend/pkg/logger"
 	"github.com/MonkyMars/gecho"
@@ -29,39 +33,125 @@ type SessionHandler struct {
 
 // NewSessionHandler creates a new SessionHandler
 func NewSessionHandler(quitCh chan os.Signal, cfg *config.Config, db *gorm.DB, websocketHandler *WebsocketHandler) *SessionHandler {
+	sessionMan := NewSessionManager(websocketHandler.broker)
+	if err := sessionMan.restoreSessions(db); err != nil {
+		logger.Err(fmt.Sprintf("Could not restore active sessions: %s", err.Error()))
+	}
+	if err := metrics.RegisterActiveSessions(sessionMan.countSessions); err != nil {
+		logger.Warn(fmt.Sprintf("Could not register active session metrics: %s", err.Error()))
+	}
 	return &SessionHandler{
 		quitCh:           quitCh,
 		config:           cfg,
 		db:               db,
-		sessionMan:       NewSessionManager(),
+		sessionMan:       sessionMan,
 		websocketHandler: websocketHandler,
 	}
 }
 
+// SessionManager keeps track of the active session of every user and device on all instances
 type SessionManager struct {
-	sessionsByUser   map[uint]*uint
-	sessionsByDevice map[uint]*uint
-	mu               sync.RWMutex
+	broker broker.Broker
 }
 
-func NewSessionManager() *SessionManager {
+func NewSessionManager(b broker.Broker) *SessionManager {
 	return &SessionManager{
-		sessionsByUser:   make(map[uint]*uint),
-		sessionsByDevice: make(map[uint]*uint),
+		broker: b,
+	}
+}
+
+func userSessionKey(userID uint) string {
+	return fmt.Sprintf("session:user:%d", userID)
+}
+
+func deviceSessionKey(deviceID uint) string {
+	return fmt.Sprintf("session:device:%d", deviceID)
+}
+
+func (sm *SessionManager) addSession(session *models.Session) error {
+	ctx := context.Background()
+	sessionID := strconv.FormatUint(uint64(session.ID), 10)
+	if err := sm.broker.Set(ctx, userSessionKey(session.UserID), sessionID, 0); err != nil {
+		return err
 	}
+	return sm.addDeviceSession(session)
 }
 
-func (sm *SessionManager) addSession(session *models.Session) {
-	sm.mu.Lock()
-	sm.sessionsByUser[session.UserID] = &session.ID
-	sm.sessionsByDevice[session.DeviceID] = &session.ID
-	sm.mu.Unlock()
+// addDeviceSession only registers the session for its device, so it does not count as the current session of its user.
+// Used for sessions that are started on many devices at once.
+func (sm *SessionManager) addDeviceSession(session *models.Session) error {
+	sessionID := strconv.FormatUint(uint64(session.ID), 10)
+	return sm.broker.Set(context.Background(), deviceSessionKey(session.DeviceID), sessionID, 0)
+}
+
+func (sm *SessionManager) removeSession(session *models.Session) error {
+	ctx := context.Background()
+	sessionID := strconv.FormatUint(uint64(session.ID), 10)
+	if _, err := sm.broker.CompareAndDelete(ctx, userSessionKey(session.UserID), sessionID); err != nil {
+		return err
+	}
+	_, err := sm.broker.CompareAndDelete(ctx, deviceSessionKey(session.DeviceID), sessionID)
+	return err
+}
+
+// restoreSessions registers the sessions that are still active in the database, which the memory broker forgot
+// when the server restarted. Users only get a current session back if they have a single active session, sessions
+// started on a device group are not the current session of their user.
+func (sm *SessionManager) restoreSessions(db *gorm.DB) error {
+	ctx := context.Background()
+	sessions, err := gorm.G[models.Session](db).
+		Where("stopped_at IS NULL AND id IN (?)", db.Model(&models.Device{}).Select("active_session_id")).
+		Find(ctx)
+	if err != nil {
+		return err
+	}
+
+	sessionsPerUser := map[uint]int{}
+	for _, session := range sessions {
+		sessionsPerUser[session.UserID]++
+	}
+	for _, session := range sessions {
+		sessionID := strconv.FormatUint(uint64(session.ID), 10)
+		if _, err := sm.broker.SetNX(ctx, deviceSessionKey(session.DeviceID), sessionID, 0); err != nil {
+			return err
+		}
+		if sessionsPerUser[session.UserID] == 1 {
+			if _, err := sm.broker.SetNX(ctx, userSessionKey(session.UserID), sessionID, 0); err != nil {
+				return err
+			}
+		}
+	}
+	if len(sessions) > 0 {
+		logger.Info(fmt.Sprintf("Restored %d active sessions", len(sessions)))
+	}
+	return nil
 }
-func (sm *SessionManager) removeSession(session *models.Session) {
-	sm.mu.Lock()
-	delete(sm.sessionsByUser, session.UserID)
-	delete(sm.sessionsByDevice, session.DeviceID)
-	sm.mu.Unlock()
+
+// countSessions returns the number of active sessions on all instances
+func (sm *SessionManager) countSessions() float64 {
+	keys, err := sm.broker.Keys(context.Background(), "session:device:")
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not count active sessions: %s", err.Error()))
+		return 0
+	}
+	return float64(len(keys))
+}
+
+// sessionForUser returns the id of the active session of a user, nil if there is none
+func (sm *SessionManager) sessionForUser(userID uint) (*uint, error) {
+	value, err := sm.broker.Get(context.Background(), userSessionKey(userID))
+	if errors.Is(err, broker.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sessionID, err := strconv.ParseUint(value, 10, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session id '%s' for user %d", value, userID)
+	}
+	id := uint(sessionID)
+	return &id, nil
 }
 
 type SessionInfo struct {
@@ -70,6 +160,7 @@ type SessionInfo struct {
 	QuestionID      uint       `json:"question_id"`
 	Question        string     `json:"question"`
 	DeviceID        uint       `json:"device_id"`
+	RoomID          *uint      `json:"room_id"`
 	Date            time.Time  `json:"date" format:"date-time"`
 	StoppedAt       *time.Time `json:"stopped_at" format:"date-time"`
 	FirstAnwserTime *time.Time `json:"first_answer_time" format:"date-time"`
@@ -83,6 +174,7 @@ func toSessionInfo(session models.Session) SessionInfo {
 		QuestionID:      session.QuestionID,
 		Question:        session.Question.Question,
 		DeviceID:        session.DeviceID,
+		RoomID:          session.RoomID,
 		Date:            session.Date,
 		StoppedAt:       session.StoppedAt,
 		FirstAnwserTime: session.FirstAnwserTime,
@@ -97,20 +189,71 @@ func toSessionInfo(session models.Session) SessionInfo {
 	}
 }
 
+// Scopes of the sessions a user can read
+const (
+	sessionScopeOwn     = "own"
+	sessionScopeSection = "section"
+	sessionScopeAny     = "any"
+)
+
+// sessionScopePermissions maps every session scope to the permission needed to read sessions in it
+var sessionScopePermissions = map[string]rbac.Permission{
+	sessionScopeOwn:     rbac.SessionReadOwn,
+	sessionScopeSection: rbac.SessionReadSection,
+	sessionScopeAny:     rbac.SessionReadAny,
+}
+
+// defaultSessionScope is the scope used when a user does not ask for one, their own sessions if they can have any
+func defaultSessionScope(ctx context.Context) string {
+	for _, scope := range []string{sessionScopeOwn, sessionScopeAny, sessionScopeSection} {
+		if middleware.HasPermission(ctx, sessionScopePermissions[scope]) {
+			return scope
+		}
+	}
+	return sessionScopeOwn
+}
+
+// canReadSession reports if user is allowed to read session
+func (h *SessionHandler) canReadSession(ctx context.Context, user models.User, session models.Session) (bool, error) {
+	if middleware.HasPermission(ctx, rbac.SessionReadAny) {
+		return true, nil
+	}
+	if session.UserID == user.ID && middleware.HasPermission(ctx, rbac.SessionReadOwn) {
+		return true, nil
+	}
+	if user.Section == "" || !middleware.HasPermission(ctx, rbac.SessionReadSection) {
+		return false, nil
+	}
+	owner, err := gorm.G[models.User](h.db).Where("id = ?", session.UserID).First(ctx)
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return owner.Section == user.Section, nil
+}
+
 // GetSession
 //
-// @Summary		Get sessions owned by the current user or all users
-// @Description	Get all sessions owned by the current user or for all users if acting as admin
-// @Tags			session requiresAuth supportsAdmin
+// @Summary		Get sessions owned by the current user or other users
+// @Description	Get the sessions in a scope, newest first:
+// @Description	`own` sessions of the current user, needs `session:read:own`
+// @Description	`section` sessions of all users in the section of the current user, needs `session:read:section`
+// @Description	`any` sessions of all users, needs `session:read:any`
+// @Tags			session requiresAuth
 // @Accept			json
 // @Produce		json
-// @Param			asRole	query		uint	false	"Try to act as this role (will cause 403 if you do not have this role)" Enums(0,1) default(0)
+// @Param			scope	query		string	false	"Whose sessions to return, defaults to own if you have permission to read your own sessions" Enums(own,section,any)
+// @Param			user_id	query		int	false	"Only return sessions owned by this user, ignored in the own scope"
 // @Param			limit	query		int	false	"Amount of sessions to return" default(20) maximum(20)
 // @Param			offset	query		int	false	"How much sessions to skip before starting to return sessions" default(0) minimum(0)
 // @Param			questionID	query		int	false	"Only return sessions that use this question"
+// @Param			roomID	query		int	false	"Only return sessions that took place in this room"
 // @Success		200	{object}	apiResponses.BaseResponse{data=[]SessionInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
 // @Failure		401	{object}	apiResponses.UnauthorizedError
-// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		403	{object}	apiResponses.ForbiddenError "Missing the permission for the scope"
 // @Failure		500	{object}	apiResponses.InternalServerError
 // @Router			/session [get]
 func (h *SessionHandler) GetSession(w http.ResponseWriter, r *http.Request) {
@@ -127,32 +270,36 @@ func (h *SessionHandler) GetSession(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	dbQuery := h.db.Model(&models.Session{})
 
-	asRole := uint(0)
-	if asRoleStr := query.Get("asRole"); asRoleStr != "" {
-		asRoleParsed, err := strconv.ParseUint(asRoleStr, 10, 0)
-		if err != nil {
-			gecho.BadRequest(w).WithMessage(err.Error()).Send()
+	scope := query.Get("scope")
+	if scope == "" {
+		scope = defaultSessionScope(ctx)
+	}
+	permission, ok := sessionScopePermissions[scope]
+	if !ok {
+		gecho.BadRequest(w).WithMessage("Invalid 'scope', expected one of: own, section, any").Send()
+		return
+	}
+	if !middleware.HasPermission(ctx, permission) {
+		gecho.Forbidden(w).WithMessage(fmt.Sprintf("Missing permission '%s'", permission)).Send()
+		return
+	}
+	switch scope {
+	case sessionScopeOwn:
+		dbQuery = dbQuery.Where("user_id = ?", user.ID)
+	case sessionScopeSection:
+		if user.Section == "" {
+			gecho.BadRequest(w).WithMessage("You are not in a section").Send()
 			return
 		}
-		if asRoleParsed != 0 && user.Role != uint(asRoleParsed) {
-			gecho.Forbidden(w).Send()
+		dbQuery = dbQuery.Where("user_id IN (?)", h.db.Model(&models.User{}).Select("id").Where("section = ?", user.Section))
+	}
+	if userIDStr := query.Get("user_id"); userIDStr != "" && scope != sessionScopeOwn {
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			gecho.BadRequest(w).WithMessage(err.Error()).Send()
 			return
 		}
-		asRole = uint(asRoleParsed)
-	}
-	switch asRole {
-	case 1:
-		// privileged filters
-		if userIDStr := query.Get("user_id"); userIDStr != "" {
-			userID, err := strconv.Atoi(userIDStr)
-			if err != nil {
-				gecho.BadRequest(w).WithMessage(err.Error()).Send()
-				return
-			}
-			dbQuery = dbQuery.Where("user_id = ?", userID)
-		}
-	case 0:
-		dbQuery = dbQuery.Where("user_id = ?", user.ID)
+		dbQuery = dbQuery.Where("user_id = ?", userID)
 	}
 
 	// return count filters
@@ -186,6 +333,14 @@ func (h *SessionHandler) GetSession(w http.ResponseWriter, r *http.Request) {
 		}
 		dbQuery = dbQuery.Where("questionID = ?", questionID)
 	}
+	if roomIDStr := query.Get("roomID"); roomIDStr != "" {
+		roomID, err := strconv.Atoi(roomIDStr)
+		if err != nil {
+			gecho.BadRequest(w).WithMessage(err.Error()).Send()
+			return
+		}
+		dbQuery = dbQuery.Where("room_id = ?", roomID)
+	}
 
 	var sessions []models.Session
 	err := dbQuery.Order("date DESC").Preload("Question").Find(&sessions).Error // retrieve sessions, sorted by date (newest first)
@@ -214,7 +369,8 @@ type PostSessionBody struct {
 //
 // @Summary		Start a new session if no active one is present
 // @Description	Any user can POST this endpoint to start a session if they dont have an active session
-// @Tags			session requiresAuth
+// @Description	Requires permission `session:write:own`
+// @Tags			session requiresAuth requiresPermission
 // @Accept			json
 // @Produce		json
 // @Param			session_info	body		PostSessionBody	true	"device id and question to use for the session\n`device_id`: Id of the device to start the session on.\n`question`: Question to start the session id with. If identical question already exists in the database, it is used. If it doesnt exist a new entry is created."
@@ -236,16 +392,19 @@ func (h *SessionHandler) PostSession(w http.ResponseWriter, r *http.Request) {
 		gecho.InternalServerError(w).Send()
 	}
 
-	h.sessionMan.mu.RLock()
-	if h.sessionMan.sessionsByUser[user.ID] != nil {
-		h.sessionMan.mu.RUnlock()
+	activeSessionID, err := h.sessionMan.sessionForUser(user.ID)
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err)
+		return
+	}
+	if activeSessionID != nil {
 		gecho.NewErr(w).WithStatus(http.StatusConflict).WithMessage("Can not have more than 1 session").Send()
 		return
 	}
-	h.sessionMan.mu.RUnlock()
 
 	var body PostSessionBody
-	err := json.NewDecoder(r.Body).Decode(&body)
+	err = json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		errMsg := fmt.Sprintf("Error while decoding json: %E", err)
 		logger.Err(errMsg)
@@ -262,7 +421,7 @@ func (h *SessionHandler) PostSession(w http.ResponseWriter, r *http.Request) {
 	}
 
 	session, err := h.websocketHandler.startSession(user.ID, *body.DeviceID, *body.Question)
-	if err == ErrDeviceNotConnected {
+	if errors.Is(err, ErrDeviceNotConnected) {
 		gecho.ServiceUnavailable(w).WithMessage("Device currently unavailable").Send()
 		return
 	} else if err != nil {
@@ -271,38 +430,42 @@ func (h *SessionHandler) PostSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.sessionMan.addSession(session)
+	if err := h.sessionMan.addSession(session); err != nil {
+		logger.Err(fmt.Sprintf("Could not register session %d: %s", session.ID, err.Error()))
+	}
 
 	sessionInfo := toSessionInfo(*session)
 
 	gecho.Success(w).WithData(sessionInfo).Send()
 }
 
-func (h *SessionHandler) StopSession(w http.ResponseWriter, ctx context.Context, sessionID uint) *models.Session {
-	h.db.Model(&models.Session{}).
-		Where("id = ?", sessionID).
-		UpdateColumn("stopped_at", time.Now())
-
+// stopSession stops a session and tells its device, gorm.ErrRecordNotFound is returned if the session does not exist
+func (h *SessionHandler) stopSession(ctx context.Context, sessionID uint) (*models.Session, error) {
 	session, err := gorm.G[models.Session](h.db).Preload("Question", nil).Where("id = ?", sessionID).First(ctx)
-	if err == gorm.ErrRecordNotFound {
-		gecho.InternalServerError(w).WithMessage(fmt.Sprintf("No session with id: %d", sessionID)).Send()
-		return nil
+	if err != nil {
+		return nil, err
 	}
+
+	now := time.Now()
+	_, err = gorm.G[models.Session](h.db).Where("id = ?", sessionID).Update(ctx, "stopped_at", now)
 	if err != nil {
-		logger.Err(err.Error())
-		gecho.InternalServerError(w).Send()
-		return nil
+		return nil, err
 	}
+	session.StoppedAt = &now
 
-	_, err = gorm.G[models.Device](h.db).Where("id = ?", session.DeviceID).Update(ctx, "active_session_id", nil)
+	_, err = gorm.G[models.Device](h.db).Where("id = ? AND active_session_id = ?", session.DeviceID, session.ID).Update(ctx, "active_session_id", nil)
 	if err != nil {
 		logger.Err(err.Error())
 	}
 
-	h.sessionMan.removeSession(&session)
-	h.websocketHandler.stopSession(&session)
+	if err := h.sessionMan.removeSession(&session); err != nil {
+		logger.Err(fmt.Sprintf("Could not unregister session %d: %s", session.ID, err.Error()))
+	}
+	if err := h.websocketHandler.stopSession(&session); err != nil && !errors.Is(err, ErrDeviceNotConnected) {
+		logger.Err(fmt.Sprintf("Could not stop session %d on device %d: %s", session.ID, session.DeviceID, err.Error()))
+	}
 
-	return &session
+	return &session, nil
 }
 
 // PostSessionStop
@@ -310,7 +473,8 @@ func (h *SessionHandler) StopSession(w http.ResponseWriter, ctx context.Context,
 // @Summary		Stop your own sesssion
 // @Description	Any user can POST this endpoint to stop their own session.
 // @Description Might be moved to PATCH `/session`
-// @Tags			session requiresAuth
+// @Description	Requires permission `session:write:own`
+// @Tags			session requiresAuth requiresPermission
 // @Accept			json
 // @Produce		json
 // @Success		200	{object}	apiResponses.BaseResponse{data=SessionInfo}
@@ -329,15 +493,23 @@ func (h *SessionHandler) PostSessionStop(w http.ResponseWriter, r *http.Request)
 		gecho.InternalServerError(w).Send()
 	}
 
-	h.sessionMan.mu.RLock()
-	sessionID := h.sessionMan.sessionsByUser[user.ID]
-	h.sessionMan.mu.RUnlock()
+	sessionID, err := h.sessionMan.sessionForUser(user.ID)
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err)
+		return
+	}
 	if sessionID == nil {
 		gecho.NotFound(w).WithMessage("No current session").Send()
 		return
 	}
 
-	session := h.StopSession(w, ctx, *sessionID)
+	session, err := h.stopSession(ctx, *sessionID)
+	if err != nil {
+		logger.Err(err.Error())
+		gecho.InternalServerError(w).Send()
+		return
+	}
 
 	sessionInfo := toSessionInfo(*session)
 
@@ -349,7 +521,8 @@ func (h *SessionHandler) PostSessionStop(w http.ResponseWriter, r *http.Request)
 // @Summary		Stop a session with specific id
 // @Description	Admins can POST this endpoint to stop any session
 // @Description Might be moved to PATCH `/session/{id}`
-// @Tags			session requiresAuth requiresAdmin
+// @Description	Requires permission `session:stop:any`
+// @Tags			session requiresAuth requiresPermission
 // @Accept			json
 // @Produce		json
 // @Param			id	path		string	true	"Session id of the session to stop"
@@ -374,7 +547,22 @@ func (h *SessionHandler) PostSessionStopById(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	session := h.StopSession(w, ctx, uint(sessionID))
+	session, err := h.stopSession(ctx, uint(sessionID))
+	if err == gorm.ErrRecordNotFound {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No session with id: %d", sessionID)).Send()
+		return
+	}
+	if err != nil {
+		logger.Err(err.Error())
+		gecho.InternalServerError(w).Send()
+		return
+	}
+	recordAudit(h.db, r, models.AuditEvent{
+		Action:     auditSessionStop,
+		TargetType: auditTargetSession,
+		TargetID:   &session.ID,
+		Details:    map[string]any{"user_id": session.UserID, "device_id": session.DeviceID},
+	})
 
 	sessionInfo := toSessionInfo(*session)
 
@@ -385,7 +573,7 @@ func (h *SessionHandler) PostSessionStopById(w http.ResponseWriter, r *http.Requ
 //
 // @Summary		Get your current session
 // @Description	Any user can query this endpoint for their own session
-// @Tags			session requiresAuth supportsAdmin
+// @Tags			session requiresAuth
 // @Accept			json
 // @Produce		json
 // @Success		200	{object}	apiResponses.BaseResponse{data=SessionInfo}
@@ -406,9 +594,12 @@ func (h *SessionHandler) GetCurrentSession(w http.ResponseWriter, r *http.Reques
 		gecho.InternalServerError(w).Send()
 	}
 
-	h.sessionMan.mu.RLock()
-	sessionID := h.sessionMan.sessionsByUser[user.ID]
-	h.sessionMan.mu.RUnlock()
+	sessionID, err := h.sessionMan.sessionForUser(user.ID)
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err)
+		return
+	}
 	if sessionID == nil {
 		gecho.NotFound(w).WithMessage("No current session").Send()
 		return
@@ -432,13 +623,12 @@ func (h *SessionHandler) GetCurrentSession(w http.ResponseWriter, r *http.Reques
 
 // GetSessionById
 //
-// @Summary		Get sessions by id if owner or acting as admin
-// @Description	Any user can query this endpoint for their own sessions
-// @Description Privileged users can add `asRole=1` query parameter to act with their privileges
-// @Tags			session requiresAuth supportsAdmin
+// @Summary		Get session by id
+// @Description	Get a session you are allowed to read: your own with `session:read:own`,
+// @Description	one of a user in your section with `session:read:section` or any with `session:read:any`
+// @Tags			session requiresAuth
 // @Accept			json
 // @Produce		json
-// @Param			asRole	query		uint	false	"Try to act as this role (will cause 403 if you do not have this role)" Enums(0,1) default(0)
 // @Param			id	path		string	true	"Id of the session"
 // @Success		200	{object}	apiResponses.BaseResponse{data=[]SessionInfo}
 // @Failure		401	{object}	apiResponses.UnauthorizedError
@@ -458,22 +648,6 @@ func (h *SessionHandler) GetSessionById(w http.ResponseWriter, r *http.Request)
 		gecho.InternalServerError(w).Send()
 	}
 
-	query := r.URL.Query()
-
-	asRole := uint(0)
-	if asRoleStr := query.Get("asRole"); asRoleStr != "" {
-		asRoleParsed, err := strconv.ParseUint(asRoleStr, 10, 0)
-		if err != nil {
-			gecho.BadRequest(w).WithMessage(err.Error()).Send()
-			return
-		}
-		if asRoleParsed != 0 && user.Role != uint(asRoleParsed) {
-			gecho.Forbidden(w).Send()
-			return
-		}
-		asRole = uint(asRoleParsed)
-	}
-
 	sessionIDStr := r.PathValue("id")
 	sessionID, err := strconv.ParseUint(sessionIDStr, 10, 0)
 	if err != nil {
@@ -492,7 +666,13 @@ func (h *SessionHandler) GetSessionById(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if asRole != 1 && user.ID != session.UserID {
+	allowed, err := h.canReadSession(ctx, user, session)
+	if err != nil {
+		logger.Err(err.Error())
+		gecho.InternalServerError(w).Send()
+		return
+	}
+	if !allowed {
 		gecho.Forbidden(w).Send()
 		return
 	}
@@ -1,72 +1,107 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/CLDWare/schoolbox-backend/config"
 	contextkeys "github.com/CLDWare/schoolbox-backend/internal/contextKeys"
+	"github.com/CLDWare/schoolbox-backend/internal/middleware"
+	"github.com/CLDWare/schoolbox-backend/internal/provisioning"
+	"github.com/CLDWare/schoolbox-backend/internal/rbac"
 	models "github.com/CLDWare/schoolbox-backend/pkg/db"
 	"github.com/CLDWare/schoolbox-backend/pkg/logger"
 	"github.com/MonkyMars/gecho"
 	"gorm.io/gorm"
 )
 
+var emailRegexp = regexp.MustCompile(`^[\w\-\.]+@([\w-]+\.)+[\w-]{2,}$`)
+
 // UserHandler handles requests about users
 type UserHandler struct {
-	quitCh chan os.Signal
-	config *config.Config
-	db     *gorm.DB
+	quitCh         chan os.Signal
+	config         *config.Config
+	db             *gorm.DB
+	sessionHandler *SessionHandler
 }
 
 // NewUserHandler creates a new UserHandler
-func NewUserHandler(quitCh chan os.Signal, cfg *config.Config, db *gorm.DB) *UserHandler {
+func NewUserHandler(quitCh chan os.Signal, cfg *config.Config, db *gorm.DB, sessionHandler *SessionHandler) *UserHandler {
 	return &UserHandler{
-		quitCh: quitCh,
-		config: cfg,
-		db:     db,
+		quitCh:         quitCh,
+		config:         cfg,
+		db:             db,
+		sessionHandler: sessionHandler,
 	}
 }
 
 type UserInfo struct {
-	ID              uint      `json:"id"`
-	Email           string    `json:"email" format:"email"`
-	GoogleSubject   string    `json:"google_sub" example:"012345678901234567890"`
-	ProfilePicture  string    `json:"picture_url" format:"url"`
-	Role            uint      `json:"role"`
-	CreatedAt       time.Time `json:"joinedAt" format:"date-time"`
-	Name            string    `json:"name" format:"name"`
-	DisplayName     string    `json:"display_name"`
-	DefaultQuestion string    `json:"default_question"`
+	ID              uint              `json:"id"`
+	Email           string            `json:"email" format:"email"`
+	GoogleSubject   string            `json:"google_sub" example:"012345678901234567890"` // Deprecated: only set for users that logged in with Google before other login providers were supported
+	ProfilePicture  string            `json:"picture_url" format:"url"`
+	Role            string            `json:"role" enums:"teacher,department_head,it_admin,auditor,admin"`
+	Section         string            `json:"section"`
+	Permissions     []rbac.Permission `json:"permissions" swaggertype:"array,string" example:"session:read:own,session:write:own"`
+	CreatedAt       time.Time         `json:"joinedAt" format:"date-time"`
+	Name            string            `json:"name" format:"name"`
+	DisplayName     string            `json:"display_name"`
+	DefaultQuestion string            `json:"default_question"`
+	Active          bool              `json:"active"` // Deactivated users can not log in
 }
 
 func toUserInfo(user models.User) UserInfo {
 	pfp_url := fmt.Sprintf("/api/user/%d/pfp", user.ID) // TODO: This needs some config things i think
+	googleSubject := ""
+	if user.GoogleSubject != nil {
+		googleSubject = *user.GoogleSubject
+	}
 	return UserInfo{
 		ID:              user.ID,
 		Email:           user.Email,
-		GoogleSubject:   user.GoogleSubject,
+		GoogleSubject:   googleSubject,
 		ProfilePicture:  pfp_url,
 		Role:            user.Role,
+		Section:         user.Section,
+		Permissions:     rbac.Permissions(user.Role),
 		CreatedAt:       user.CreatedAt,
 		Name:            user.Name,
 		DisplayName:     user.DisplayName,
 		DefaultQuestion: user.DefaultQuestion,
+		Active:          user.DeactivatedAt == nil,
 	}
 }
 
+// findUserByEmailIncludingDeleted finds the user with email, ignoring case. Deleted users keep their email address so
+// they are included, gorm.G drops Unscoped so this can not use the generics API.
+func findUserByEmailIncludingDeleted(ctx context.Context, db *gorm.DB, email string) (models.User, error) {
+	var user models.User
+	err := db.WithContext(ctx).Unscoped().Where("LOWER(email) = LOWER(?)", email).First(&user).Error
+	return user, err
+}
+
+// MeInfo is the current user, and the admin impersonating them if they are
+type MeInfo struct {
+	UserInfo
+	Impersonation *ImpersonationInfo `json:"impersonation"` // null unless an admin is impersonating the user
+}
+
 // GetMe
 //
 // @Summary		Get UserInfo about current authenticated user
-// @Description
+// @Description	When an admin is impersonating the user, `impersonation` says who and until when
 // @Tags			user requiresAuth
 // @Accept			json
 // @Produce		json
-// @Success		200	{object}	apiResponses.BaseResponse{data=UserInfo}
+// @Success		200	{object}	apiResponses.BaseResponse{data=MeInfo}
 // @Failure		401	{object}	apiResponses.UnauthorizedError
 // @Failure		500	{object}	apiResponses.InternalServerError
 // @Router			/me [get]
@@ -82,21 +117,93 @@ func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
 		gecho.InternalServerError(w).Send()
 	}
 
-	userInfo := toUserInfo(user)
+	meInfo := MeInfo{
+		UserInfo:      toUserInfo(user),
+		Impersonation: currentImpersonation(r),
+	}
 
-	gecho.Success(w).WithData(userInfo).Send()
+	gecho.Success(w).WithData(meInfo).Send()
+}
+
+type PatchMeBody struct {
+	DisplayName     *string `json:"display_name"`
+	DefaultQuestion *string `json:"default_question" example:"Wat vond je van de les?"`
+}
+
+// PatchMe
+//
+// @Summary		Edit the current user
+// @Description	Change the display name and default question of the current user, fields that are not given are not changed.
+// @Description	Can not be used with a personal access token or while impersonating.
+// @Tags			user requiresAuth
+// @Accept			json
+// @Produce		json
+// @Param			user	body		PatchMeBody	true	"`display_name`: Name shown to others\n`default_question`: Question new sessions start with"
+// @Success		200	{object}	apiResponses.BaseResponse{data=UserInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError "Authenticated with a personal access token or impersonating"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/me [patch]
+func (h *UserHandler) PatchMe(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPatch); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var body PatchMeBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+
+	updates := map[string]any{}
+	if body.DisplayName != nil {
+		displayName := strings.TrimSpace(*body.DisplayName)
+		if displayName == "" {
+			gecho.BadRequest(w).WithMessage("Field 'display_name' can not be empty").Send()
+			return
+		}
+		updates["display_name"] = displayName
+		user.DisplayName = displayName
+	}
+	if body.DefaultQuestion != nil {
+		defaultQuestion := strings.TrimSpace(*body.DefaultQuestion)
+		if defaultQuestion == "" {
+			gecho.BadRequest(w).WithMessage("Field 'default_question' can not be empty").Send()
+			return
+		}
+		updates["default_question"] = defaultQuestion
+		user.DefaultQuestion = defaultQuestion
+	}
+
+	if len(updates) != 0 {
+		if err := h.db.Model(&user).Updates(updates).Error; err != nil {
+			gecho.InternalServerError(w).Send()
+			logger.Err(err.Error())
+			return
+		}
+	}
+
+	gecho.Success(w).WithData(toUserInfo(user)).Send()
 }
 
 // GetUser
 //
 // @Summary		Get all users
 // @Description	Get UserInfo about all users
-// @Tags			user requiresAuth requiresAdmin
+// @Description	Requires permission `user:read:any`
+// @Tags			user requiresAuth requiresPermission
 // @Accept			json
 // @Produce		json
 // @Param			limit	query		int	false	"Amount of users to return" default(20) maximum(20)
 // @Param			offset	query		int	false	"How much users to skip before starting to return users" default(0) minimum(0)
-// @Param			role	query		int	false	"Only return users with this role" Enums(0,1)
+// @Param			role	query		string	false	"Only return users with this role" Enums(teacher,department_head,it_admin,auditor,admin)
+// @Param			section	query		string	false	"Only return users in this section"
 // @Success		200	{object}	apiResponses.BaseResponse{data=[]UserInfo}
 // @Failure		401	{object}	apiResponses.UnauthorizedError
 // @Failure		403	{object}	apiResponses.ForbiddenError
@@ -134,14 +241,16 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 		dbQuery = dbQuery.Offset(offset)
 	}
 	// filters
-	if roleStr := query.Get("role"); roleStr != "" {
-		role, err := strconv.ParseUint(roleStr, 10, 0)
-		if err != nil {
-			gecho.BadRequest(w).WithMessage(err.Error()).Send()
+	if role := query.Get("role"); role != "" {
+		if !rbac.ValidRole(role) {
+			gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid role '%s'", role)).Send()
 			return
 		}
 		dbQuery = dbQuery.Where("role = ?", role)
 	}
+	if section := query.Get("section"); section != "" {
+		dbQuery = dbQuery.Where("section = ?", section)
+	}
 
 	var users []models.User
 	err := dbQuery.Find(&users).Error
@@ -159,11 +268,103 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	gecho.Success(w).WithData(userInfoArray).Send()
 }
 
+type PostUserBody struct {
+	Email   *string `json:"email" format:"email"`
+	Name    string  `json:"name"`
+	Role    string  `json:"role" enums:"teacher,department_head,it_admin,auditor,admin"`
+	Section string  `json:"section"`
+}
+
+// PostUser
+//
+// @Summary		Invite a user
+// @Description	Create a user that can log in with any provider that shares the same, verified, email address.
+// @Description	With LOGIN_INVITE_ONLY only invited users can log in.
+// @Description	Requires permission `user:write`
+// @Tags			user requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			user	body		PostUserBody	true	"`email`: Email address the user logs in with\n`name`: Optional name, without it the name at the provider is used\n`role`: Optional role, defaults to the role LOGIN_ROLE_RULES give the email address\n`section`: Optional section"
+// @Success		201	{object}	apiResponses.BaseResponse{data=UserInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		409	{object}	apiResponses.ConflictError "A user with this email address already exists"
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/user [post]
+func (h *UserHandler) PostUser(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPost); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+
+	var body PostUserBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+	if body.Email == nil || *body.Email == "" {
+		gecho.BadRequest(w).WithMessage("Missing field 'email'").Send()
+		return
+	}
+	email := strings.TrimSpace(*body.Email)
+	if !emailRegexp.MatchString(email) {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid email '%s'", email)).Send()
+		return
+	}
+	if !provisioning.DomainAllowed(&h.config.Provisioning, email) {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Users with email '%s' can not log in, the domain is not in LOGIN_ALLOWED_DOMAINS", email)).Send()
+		return
+	}
+	role := body.Role
+	if role == "" {
+		role = provisioning.Role(&h.config.Provisioning, email)
+	}
+	if !rbac.ValidRole(role) {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid role '%s'", role)).Send()
+		return
+	}
+
+	_, err := findUserByEmailIncludingDeleted(ctx, h.db, email)
+	if err == nil {
+		gecho.NewErr(w).WithStatus(http.StatusConflict).WithMessage(fmt.Sprintf("A user with email '%s' already exists", email)).Send()
+		return
+	}
+	if err != gorm.ErrRecordNotFound {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	user := models.User{
+		Email:       email,
+		Name:        body.Name,
+		DisplayName: body.Name,
+		Role:        role,
+		Section:     body.Section,
+	}
+	if err := gorm.G[models.User](h.db).Create(ctx, &user); err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+	recordAudit(h.db, r, models.AuditEvent{
+		Action:     auditUserInvite,
+		TargetType: auditTargetUser,
+		TargetID:   &user.ID,
+		Details:    map[string]any{"email": user.Email, "role": user.Role, "section": user.Section},
+	})
+
+	gecho.Created(w).WithData(toUserInfo(user)).Send()
+}
+
 // GetUserById
 //
 // @Summary		Get user by id
 // @Description	Get info about a user by using either their id or email
-// @Tags			user requiresAuth requiresAdmin
+// @Description	Requires permission `user:read:any`
+// @Tags			user requiresAuth requiresPermission
 // @Accept			json
 // @Produce		json
 // @Param			id	path		string	true	"User ID or email"
@@ -198,15 +399,10 @@ func (h *UserHandler) GetUserById(w http.ResponseWriter, r *http.Request) {
 		}
 		dbQuery = dbQuery.Where("id = ?", userID)
 	case "email":
-		ok, err := regexp.Match(`^[\w\-\.]+@([\w-]+\.)+[\w-]{2,}$`, []byte(idStr))
-		if !ok {
+		if !emailRegexp.MatchString(idStr) {
 			gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid email '%s'", idStr)).Send()
 			return
 		}
-		if err != nil {
-			gecho.InternalServerError(w).WithMessage(err.Error()).Send()
-			return
-		}
 		dbQuery = dbQuery.Where("email = ?", idStr)
 	default:
 		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid identifier type '%s'", idType)).Send()
@@ -230,6 +426,289 @@ func (h *UserHandler) GetUserById(w http.ResponseWriter, r *http.Request) {
 	gecho.Success(w).WithData(userInfo).Send()
 }
 
+// userFromPath returns the user with the ID in the path, it sends an error response if there is none
+func (h *UserHandler) userFromPath(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	userID, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		gecho.BadRequest(w).WithMessage("Invalid user ID, expected positive integer").Send()
+		return models.User{}, false
+	}
+	user, err := gorm.G[models.User](h.db).Where("id = ?", userID).First(r.Context())
+	if err == gorm.ErrRecordNotFound {
+		gecho.NotFound(w).WithMessage(fmt.Sprintf("No user with id of '%d'", userID)).Send()
+		return models.User{}, false
+	} else if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// revokeAccess ends the login sessions and revokes the personal access tokens of a user, and stops the sessions that
+// are running on devices for them
+func (h *UserHandler) revokeAccess(ctx context.Context, userID uint) error {
+	if _, err := h.endAuthSessions(ctx, userID, ""); err != nil {
+		return err
+	}
+	if _, err := gorm.G[models.PersonalAccessToken](h.db).Where("user_id = ?", userID).Delete(ctx); err != nil {
+		return err
+	}
+
+	devices, err := gorm.G[models.Device](h.db).
+		Where("active_session_id IN (?)", h.db.Model(&models.Session{}).Select("id").Where("user_id = ?", userID)).Find(ctx)
+	if err != nil {
+		return err
+	}
+	for _, device := range devices {
+		if _, err := h.sessionHandler.stopSession(ctx, *device.ActiveSessionID); err != nil {
+			logger.Err(fmt.Sprintf("Could not stop session %d on device %d: %s", *device.ActiveSessionID, device.ID, err.Error()))
+		}
+	}
+	return nil
+}
+
+type PatchUserBody struct {
+	Role        *string `json:"role" enums:"teacher,department_head,it_admin,auditor,admin"`
+	DisplayName *string `json:"display_name"`
+	Section     *string `json:"section"`
+	Active      *bool   `json:"active"`
+}
+
+// PatchUserById
+//
+// @Summary		Edit a user
+// @Description	Change the role, display name, section or active flag of a user, fields that are not given are not changed.
+// @Description	Deactivating a user ends their login sessions, revokes their personal access tokens and stops their running sessions.
+// @Description	You can not change your own role or deactivate yourself.
+// @Description	Requires permission `user:write`
+// @Tags			user requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id		path		string			true	"User ID"
+// @Param			user	body		PatchUserBody	true	"`role`: New role\n`display_name`: Name shown to others\n`section`: Department of the user, empty to remove\n`active`: false to deactivate the user, true to activate them again"
+// @Success		200	{object}	apiResponses.BaseResponse{data=UserInfo}
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/user/{id} [patch]
+func (h *UserHandler) PatchUserById(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodPatch); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+	authUser, ok := ctx.Value(contextkeys.AuthUserKey).(models.User)
+	if !ok {
+		gecho.InternalServerError(w).Send()
+		return
+	}
+
+	user, ok := h.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body PatchUserBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Error while decoding json: %s", err.Error())).Send()
+		return
+	}
+
+	previousRole := user.Role
+	updates := map[string]any{}
+	if body.Role != nil && *body.Role != user.Role {
+		if !rbac.ValidRole(*body.Role) {
+			gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid role '%s'", *body.Role)).Send()
+			return
+		}
+		// Otherwise the last admin could lock everybody out
+		if user.ID == authUser.ID {
+			gecho.BadRequest(w).WithMessage("Can not change your own role").Send()
+			return
+		}
+		updates["role"] = *body.Role
+		user.Role = *body.Role
+	}
+	if body.DisplayName != nil {
+		displayName := strings.TrimSpace(*body.DisplayName)
+		if displayName == "" {
+			gecho.BadRequest(w).WithMessage("Field 'display_name' can not be empty").Send()
+			return
+		}
+		updates["display_name"] = displayName
+		user.DisplayName = displayName
+	}
+	if body.Section != nil {
+		section := strings.TrimSpace(*body.Section)
+		updates["section"] = section
+		user.Section = section
+	}
+	activate, deactivate := false, false
+	if body.Active != nil && *body.Active != (user.DeactivatedAt == nil) {
+		if !*body.Active {
+			if user.ID == authUser.ID {
+				gecho.BadRequest(w).WithMessage("Can not deactivate yourself").Send()
+				return
+			}
+			now := time.Now()
+			updates["deactivated_at"] = now
+			user.DeactivatedAt = &now
+			deactivate = true
+		} else {
+			updates["deactivated_at"] = nil
+			user.DeactivatedAt = nil
+			activate = true
+		}
+	}
+
+	if len(updates) != 0 {
+		if err := h.db.Model(&user).Updates(updates).Error; err != nil {
+			gecho.InternalServerError(w).Send()
+			logger.Err(err.Error())
+			return
+		}
+	}
+	if deactivate {
+		if err := h.revokeAccess(ctx, user.ID); err != nil {
+			gecho.InternalServerError(w).Send()
+			logger.Err(fmt.Sprintf("Could not revoke access of deactivated user %d: %s", user.ID, err.Error()))
+			return
+		}
+		logger.Info(fmt.Sprintf("User %d deactivated user %d", authUser.ID, user.ID))
+	}
+
+	// Role changes and (de)activations get their own events, so they can be found without reading the details
+	if _, ok := updates["role"]; ok {
+		recordAudit(h.db, r, models.AuditEvent{
+			Action:     auditUserRoleChange,
+			TargetType: auditTargetUser,
+			TargetID:   &user.ID,
+			Details:    map[string]any{"from": previousRole, "to": user.Role},
+		})
+	}
+	if deactivate {
+		recordAudit(h.db, r, models.AuditEvent{Action: auditUserDeactivate, TargetType: auditTargetUser, TargetID: &user.ID})
+	}
+	if activate {
+		recordAudit(h.db, r, models.AuditEvent{Action: auditUserActivate, TargetType: auditTargetUser, TargetID: &user.ID})
+	}
+	profileChanges := map[string]any{}
+	for _, field := range []string{"display_name", "section"} {
+		if value, ok := updates[field]; ok {
+			profileChanges[field] = value
+		}
+	}
+	if len(profileChanges) != 0 {
+		recordAudit(h.db, r, models.AuditEvent{Action: auditUserUpdate, TargetType: auditTargetUser, TargetID: &user.ID, Details: profileChanges})
+	}
+
+	gecho.Success(w).WithData(toUserInfo(user)).Send()
+}
+
+// DeleteUserById
+//
+// @Summary		Delete a user
+// @Description	Delete a user, e.g. a teacher who left. Their access is revoked like when they are deactivated.
+// @Description	With `session_data=keep` the sessions of the user keep referring to them, and they can not log in or be invited again.
+// @Description	With `session_data=anonymise` their email address, name and profile picture are removed and their login is unlinked,
+// @Description	so their sessions are only counted in statistics. They can log in or be invited again as a new user.
+// @Description	You can not delete yourself.
+// @Description	Requires permission `user:write`
+// @Tags			user requiresAuth requiresPermission
+// @Accept			json
+// @Produce		json
+// @Param			id				path		string	true	"User ID"
+// @Param			session_data	query		string	true	"What to do with the sessions of the user" Enums(keep,anonymise)
+// @Success		204 {object}	apiResponses.BaseBase
+// @Failure		400	{object}	apiResponses.BadRequestError
+// @Failure		401	{object}	apiResponses.UnauthorizedError
+// @Failure		403	{object}	apiResponses.ForbiddenError
+// @Failure		404	{object}	apiResponses.NotFoundError
+// @Failure		500	{object}	apiResponses.InternalServerError
+// @Router			/user/{id} [delete]
+func (h *UserHandler) DeleteUserById(w http.ResponseWriter, r *http.Request) {
+	if err := gecho.Handlers.HandleMethod(w, r, http.MethodDelete); err != nil {
+		err.Send() // Automatically sends 405 Method Not Allowed
+		return
+	}
+	ctx := r.Context()
+	authUser, ok := ctx.Value(contextkeys.AuthUserKey).(models.User)
+	if !ok {
+		gecho.InternalServerError(w).Send()
+		return
+	}
+
+	user, ok := h.userFromPath(w, r)
+	if !ok {
+		return
+	}
+	sessionData := r.URL.Query().Get("session_data")
+	if sessionData != "keep" && sessionData != "anonymise" {
+		gecho.BadRequest(w).WithMessage("Invalid 'session_data', expected 'keep' or 'anonymise'").Send()
+		return
+	}
+	if user.ID == authUser.ID {
+		gecho.BadRequest(w).WithMessage("Can not delete yourself").Send()
+		return
+	}
+
+	if err := h.revokeAccess(ctx, user.ID); err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(fmt.Sprintf("Could not revoke access of user %d: %s", user.ID, err.Error()))
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if sessionData == "anonymise" {
+			err := tx.Model(&user).Updates(map[string]any{
+				"email":           fmt.Sprintf("deleted-%d@invalid", user.ID), // Emails are unique, also among deleted users
+				"google_subject":  nil,
+				"profile_picture": "",
+				"name":            "",
+				"display_name":    "",
+			}).Error
+			if err != nil {
+				return err
+			}
+			// Hard deleted, the issuer and subject stay unique among deleted identities
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.UserIdentity{}).Error; err != nil {
+				return err
+			}
+		}
+		_, err := gorm.G[models.User](tx).Where("id = ?", user.ID).Delete(ctx)
+		return err
+	})
+	if err != nil {
+		gecho.InternalServerError(w).Send()
+		logger.Err(err.Error())
+		return
+	}
+
+	if sessionData == "anonymise" {
+		pfpFiles := []string{fmt.Sprintf("data/user_pfp/%d.jpg", user.ID)}
+		if user.GoogleSubject != nil {
+			pfpFiles = append(pfpFiles, fmt.Sprintf("data/user_pfp/%s.jpg", *user.GoogleSubject))
+		}
+		for _, filename := range pfpFiles {
+			if err := os.Remove(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+				logger.Err(fmt.Sprintf("Could not remove profile picture '%s': %s", filename, err.Error()))
+			}
+		}
+	}
+	logger.Info(fmt.Sprintf("User %d deleted user %d (session data: %s)", authUser.ID, user.ID, sessionData))
+	recordAudit(h.db, r, models.AuditEvent{
+		Action:     auditUserDelete,
+		TargetType: auditTargetUser,
+		TargetID:   &user.ID,
+		Details:    map[string]any{"session_data": sessionData},
+	})
+
+	gecho.NewErr(w).WithStatus(http.StatusNoContent).Send()
+}
+
 // GetUserPfpById
 //
 // @Summary		Get user pfp by id
@@ -275,15 +754,10 @@ func (h *UserHandler) GetUserPfpById(w http.ResponseWriter, r *http.Request) {
 		}
 		dbQuery = dbQuery.Where("id = ?", userID)
 	case "email":
-		ok, err := regexp.Match(`^[\w\-\.]+@([\w-]+\.)+[\w-]{2,}$`, []byte(idStr))
-		if !ok {
+		if !emailRegexp.MatchString(idStr) {
 			gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid email '%s'", idStr)).Send()
 			return
 		}
-		if err != nil {
-			gecho.InternalServerError(w).WithMessage(err.Error()).Send()
-			return
-		}
 		dbQuery = dbQuery.Where("email = ?", idStr)
 	default:
 		gecho.BadRequest(w).WithMessage(fmt.Sprintf("Invalid identifier type '%s'", idType)).Send()
@@ -302,12 +776,17 @@ func (h *UserHandler) GetUserPfpById(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// auth user not requested user and auth user not admin
-	if user.ID != authUser.ID && authUser.Role != 1 {
+	// auth user not requested user and auth user can not read other users
+	if user.ID != authUser.ID && !middleware.HasPermission(ctx, rbac.UserReadAny) {
 		gecho.Forbidden(w).Send()
+		return
 	}
 
-	filename := fmt.Sprintf("data/user_pfp/%s.jpg", user.GoogleSubject)
+	filename := fmt.Sprintf("data/user_pfp/%d.jpg", user.ID)
+	// Profile pictures used to be stored by google subject, until the next login of the user
+	if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) && user.GoogleSubject != nil {
+		filename = fmt.Sprintf("data/user_pfp/%s.jpg", *user.GoogleSubject)
+	}
 
 	http.ServeFile(w, r, filename)
 }
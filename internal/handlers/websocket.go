@@ -4,11 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/alerts"
+	"github.com/CLDWare/schoolbox-backend/internal/broker"
+	"github.com/CLDWare/schoolbox-backend/internal/devicetoken"
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
 	models "github.com/CLDWare/schoolbox-backend/pkg/db"
 	"github.com/CLDWare/schoolbox-backend/pkg/logger"
 	"gorm.io/gorm"
@@ -16,13 +23,23 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// WebsocketHandler handles the device connections of this instance.
+// Which instance a device or registration pin belongs to is shared with all instances through the broker.
 type WebsocketHandler struct {
 	config           *config.Config
 	db               *gorm.DB
-	connections      map[uint]*websocketConnection // connection id -> connection
+	broker           broker.Broker
+	instanceID       string
+	rpc              *broker.RPC
+	connections      map[uint]*websocketConnection // connection id -> connection, only connections of this instance
 	nextID           uint
-	connectedDevices map[uint]uint // device id -> connection id
-	registrationPins map[uint]uint // registration pin -> connection id
+	connectedDevices map[uint]uint // device id -> connection id, only devices connected to this instance
+	authFailures     *authFailureTracker
+	alerter          *alerts.Alerter
+	tokenCipher      *devicetoken.Cipher
+	presence         *presenceHub
+	shuttingDown     bool           // No new connections are accepted once set, see Shutdown
+	readLoops        sync.WaitGroup // Running read loops, each handles the messages of one connection
 	mu               sync.RWMutex
 }
 
@@ -37,46 +54,115 @@ func (h *WebsocketHandler) addConnection(conn *websocketConnection) {
 	h.mu.Unlock()
 
 	conn.ws.SetCloseHandler(func(code int, text string) error {
-		return conn.close()
+		return conn.closeWithReason(disconnectCloseFrame)
 	})
 }
 
 type websocketConnection struct {
-	handler         *WebsocketHandler
-	connectionID    uint
-	ws              *websocket.Conn
-	db              *gorm.DB
-	deviceID        *uint
-	state           uint // 0 none;1 registering;2 authenticating;3 authenticated;4 active_session;
-	stateFlow       any
-	connectedAt     time.Time
-	latestMessage   time.Time
-	hearbeat_cancel context.CancelFunc
-	latestHeartbeat time.Time
-	pingsSent       uint
-	pongsReceived   uint
-	mu              sync.RWMutex
+	handler           *WebsocketHandler
+	connectionID      uint
+	ws                *websocket.Conn
+	db                *gorm.DB
+	remoteAddr        string
+	deviceID          *uint
+	state             uint // 0 none;1 registering;2 authenticating;3 authenticated;4 active_session;
+	stateFlow         any
+	connectedAt       time.Time
+	latestMessage     time.Time
+	hearbeat_cancel   context.CancelFunc
+	latestHeartbeat   time.Time
+	lastSeenWrittenAt time.Time // Time LastSeen of the device was last written, see markSeen
+	pingsSent         uint
+	pongsReceived     uint
+	recordKey         string // broker key of the connectionRecord of this connection, empty if it has none
+	record            string // value last stored under recordKey
+	recordRefreshedAt time.Time
+	tokenAckNonce     string               // Nonce the device signs with its new token in token_ack, empty when there is nothing to acknowledge
+	sendQueue         chan outboundMessage // Written by the writer goroutine, see ws_writer.go
+	closing           chan struct{}        // Closed when the connection closes, the writer flushes the queue and stops
+	writerDone        chan struct{}        // Closed when the writer stopped and the websocket is closed
+	closeFrame        []byte               // Close frame the writer sends after flushing the queue, nil to close without one
+	closed            bool
+	mu                sync.RWMutex
 }
 
 func (conn *websocketConnection) close() error {
-	if err := conn.ws.Close(); err != nil {
-		logger.Err("Error closing websocket:", err)
+	return conn.closeWithReason(disconnectReadError)
+}
+
+// closeWithCode closes the connection like closeWithReason, the device gets a close frame with code and text
+// after the queued messages.
+func (conn *websocketConnection) closeWithCode(reason string, code int, text string) error {
+	conn.mu.Lock()
+	if !conn.closed {
+		conn.closeFrame = websocket.FormatCloseMessage(code, text)
+	}
+	conn.mu.Unlock()
+	return conn.closeWithReason(reason)
+}
+
+// closeWithReason closes the connection and records why in the device event log. Only the first call has any effect.
+func (conn *websocketConnection) closeWithReason(reason string) error {
+	conn.mu.Lock()
+	if conn.closed {
+		conn.mu.Unlock()
+		return nil
 	}
+	conn.closed = true
+	deviceID := conn.deviceID
+	stateFlow := conn.stateFlow
+	latestMessage := conn.latestMessage
+	conn.mu.Unlock()
+
+	// The writer flushes the queued messages and closes the websocket
+	close(conn.closing)
 	conn.stopHeartbeatMonitor()
 
 	conn.handler.mu.Lock()
-	defer conn.handler.mu.Unlock()
 	delete(conn.handler.connections, conn.connectionID)
-	if conn.deviceID != nil {
-		delete(conn.handler.connectedDevices, *conn.deviceID)
-		logger.Info(fmt.Sprintf("Closed connection %d, device %d", conn.connectionID, *conn.deviceID))
+	if deviceID != nil {
+		// Only remove the device if it did not already reconnect on another connection
+		if connID, ok := conn.handler.connectedDevices[*deviceID]; ok && connID == conn.connectionID {
+			delete(conn.handler.connectedDevices, *deviceID)
+		}
+		logger.Info(fmt.Sprintf("Closed connection %d, device %d (%s)", conn.connectionID, *deviceID, reason))
 	} else {
-		logger.Info(fmt.Sprintf("Closed connection %d", conn.connectionID))
+		logger.Info(fmt.Sprintf("Closed connection %d (%s)", conn.connectionID, reason))
+	}
+	conn.handler.mu.Unlock()
+	conn.handler.releaseConnectionRecord(conn)
+	regFlowData, registering := stateFlow.(registrationFlowData)
+
+	if deviceID != nil {
+		conn.handler.presence.publish(PresenceEvent{
+			Type:         presenceDeviceOffline,
+			DeviceID:     deviceID,
+			ConnectionID: conn.connectionID,
+			RemoteAddr:   conn.remoteAddr,
+		})
 	}
-	regFlowData, ok := conn.stateFlow.(registrationFlowData)
-	if ok {
-		delete(conn.handler.registrationPins, regFlowData.pin)
+	if registering {
+		if regFlowData.claimToken != "" {
+			conn.handler.releaseClaimToken(regFlowData.claimToken, regFlowData.pin)
+		}
+		pin := regFlowData.pin
+		conn.handler.presence.publish(PresenceEvent{
+			Type:         presenceRegistrationFinished,
+			ConnectionID: conn.connectionID,
+			Pin:          &pin,
+		})
 	}
+
+	if deviceID != nil {
+		// LastSeen is not written on every message, store the latest value
+		err := conn.db.Model(&models.Device{}).Where("id = ?", *deviceID).Update("last_seen", latestMessage).Error
+		if err != nil {
+			logger.Err(fmt.Sprintf("Could not update last seen of device %d: %s", *deviceID, err.Error()))
+		}
+	}
+
+	duration := time.Since(conn.connectedAt)
+	conn.handler.recordDeviceEvent(conn, deviceID, deviceEventDisconnect, reason, &duration)
 	return nil
 }
 
@@ -90,34 +176,82 @@ type websocketErrorMessage struct {
 	Info      *string `json:"info,omitempty"`
 }
 
-func NewWebsocketHandler(cfg *config.Config, db *gorm.DB) *WebsocketHandler {
-	return &WebsocketHandler{
+func NewWebsocketHandler(cfg *config.Config, db *gorm.DB, b broker.Broker, alerter *alerts.Alerter) *WebsocketHandler {
+	tokenCipher, err := devicetoken.New(cfg.DeviceToken.EncryptionKeys)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid device token keys: %v", err))
+	}
+	if !tokenCipher.Enabled() {
+		logger.Warn("No DEVICE_TOKEN_KEYS configured, device tokens are stored in plaintext")
+	}
+	h := &WebsocketHandler{
 		config:           cfg,
 		db:               db,
+		broker:           b,
+		instanceID:       cfg.Broker.InstanceID,
+		rpc:              broker.NewRPC(b, cfg.Broker.InstanceID, cfg.Broker.RPCTimeout),
 		connections:      map[uint]*websocketConnection{},
 		connectedDevices: map[uint]uint{},
 		nextID:           0,
-		registrationPins: map[uint]uint{},
+		authFailures:     newAuthFailureTracker(&cfg.DeviceAuth),
+		alerter:          alerter,
+		tokenCipher:      tokenCipher,
+		presence:         newPresenceHub(b),
+	}
+
+	if err := metrics.RegisterConnectionStates(h.connectionStates); err != nil {
+		logger.Warn(fmt.Sprintf("Could not register websocket connection metrics: %s", err.Error()))
+	}
+
+	h.registerRPCHandlers()
+	if err := h.rpc.Start(context.Background()); err != nil {
+		panic(fmt.Sprintf("Could not subscribe to broker requests: %v", err))
+	}
+	if err := h.presence.start(); err != nil {
+		panic(fmt.Sprintf("Could not subscribe to broker presence events: %v", err))
 	}
+	return h
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // In production, check the origin properly!
-	},
+// connectionStates counts the connections of this instance per state
+func (h *WebsocketHandler) connectionStates() map[string]int {
+	h.mu.RLock()
+	conns := make([]*websocketConnection, 0, len(h.connections))
+	for _, conn := range h.connections {
+		conns = append(conns, conn)
+	}
+	h.mu.RUnlock()
+
+	states := map[string]int{}
+	for state := range uint(5) {
+		states[connectionStateName(state)] = 0
+	}
+	for _, conn := range conns {
+		conn.mu.RLock()
+		states[connectionStateName(conn.state)]++
+		conn.mu.RUnlock()
+	}
+	return states
 }
 
-func sendMessage(ws *websocket.Conn, msg any) error {
-	message, err := json.Marshal(msg)
+// clientAddress returns the address of the client, using X-Forwarded-For when the request comes from a local reverse proxy
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		logger.Err("JSON marshal err: ", err)
-		return err
+		host = r.RemoteAddr
 	}
-	err = ws.WriteMessage(websocket.TextMessage, message)
-	if err != nil {
-		logger.Err("write:", err)
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+			return strings.TrimSpace(strings.Split(forwardedFor, ",")[0])
+		}
 	}
-	return err
+	return host
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true // In production, check the origin properly!
+	},
 }
 
 // InitialiseWebscoket
@@ -130,40 +264,54 @@ func sendMessage(ws *websocket.Conn, msg any) error {
 // @Produce      json
 // @Success      101 {string} string "Switching Protocols (WebSocket Upgrade)"
 // @Failure      400 {string} string "Bad Request"
+// @Failure      503 {string} string "Server is shutting down"
 // @Router       /ws [get]
 func (h *WebsocketHandler) InitialiseWebsocket(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	if h.shuttingDown {
+		h.mu.Unlock()
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.config.Server.ShutdownReconnectDelay.Seconds())))
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	h.readLoops.Add(1)
+	h.mu.Unlock()
+	defer h.readLoops.Done()
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.Err(err)
 		return
 	}
-	ctx := context.Background()
 
 	conn := websocketConnection{
 		handler:       h,
 		ws:            ws,
 		db:            h.db,
+		remoteAddr:    clientAddress(r),
 		connectedAt:   time.Now(),
 		latestMessage: time.Now(),
 	}
+	conn.startWriter()
 	h.addConnection(&conn)
 	conn.startHeartbeatMonitor()
 	defer conn.close()
-	logger.Info(fmt.Sprintf("New connection %d", conn.connectionID))
+	logger.Info(fmt.Sprintf("New connection %d from %s", conn.connectionID, conn.remoteAddr))
+	h.recordDeviceEvent(&conn, nil, deviceEventConnect, "", nil)
 
 	for {
 		// Read message from client
 		_, msg, err := ws.ReadMessage()
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			// Read deadline of the control frame heartbeat passed
+			conn.closeWithReason(disconnectHeartbeatMissed)
+			break
+		}
 		if err != nil {
 			logger.Err("read:", err)
 			break
 		}
-		conn.mu.Lock()
-		conn.latestMessage = time.Now()
-		conn.mu.Unlock()
-		if conn.deviceID != nil {
-			gorm.G[models.Device](h.db).Where("id = ?", conn.deviceID).Update(ctx, "LastSeen", time.Now())
-		}
+		conn.markSeen()
 
 		var message websocketMessage
 		err = json.Unmarshal(msg, &message)
@@ -171,7 +319,7 @@ func (h *WebsocketHandler) InitialiseWebsocket(w http.ResponseWriter, r *http.Re
 			logger.Err("Invalid JSON:", err)
 			errCode := 0
 			errMsg := err.Error()
-			sendErr := sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // bad request
+			sendErr := conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // bad request
 			if sendErr != nil {
 				break
 			}
@@ -183,13 +331,13 @@ func (h *WebsocketHandler) InitialiseWebsocket(w http.ResponseWriter, r *http.Re
 		if message.Command == "" {
 			errCode := 0
 			errMsg := "A command ('c') is required"
-			sendErr := sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // bad request
+			sendErr := conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // bad request
 			if sendErr != nil {
 				break
 			}
 		} else if message.Command == "ping" {
 			command := "pong"
-			sendErr := sendMessage(conn.ws, websocketMessage{Command: command})
+			sendErr := conn.sendMessage(websocketMessage{Command: command})
 			if sendErr != nil {
 				break
 			}
@@ -198,6 +346,7 @@ func (h *WebsocketHandler) InitialiseWebsocket(w http.ResponseWriter, r *http.Re
 			conn.mu.Lock()
 			conn.pongsReceived++
 			conn.mu.Unlock()
+			metrics.HeartbeatPongsReceived.Inc()
 		} else if triggersRegistrationFlow(&message) {
 			regErr := registrationFlow(&conn, message)
 			if regErr != nil {
@@ -208,6 +357,11 @@ func (h *WebsocketHandler) InitialiseWebsocket(w http.ResponseWriter, r *http.Re
 			if authErr != nil {
 				break
 			}
+		} else if triggersTokenFlow(&message) {
+			tokenErr := tokenFlow(&conn, message)
+			if tokenErr != nil {
+				break
+			}
 		} else if triggersSessionFlow(&message) {
 			sessionErr := sessionFlow(&conn, message)
 			if sessionErr != nil {
@@ -216,7 +370,7 @@ func (h *WebsocketHandler) InitialiseWebsocket(w http.ResponseWriter, r *http.Re
 		} else {
 			errCode := 0
 			errMsg := fmt.Sprintf("Invalid command '%s'", message.Command)
-			sendErr := sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // bad request
+			sendErr := conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // bad request
 			if sendErr != nil {
 				break
 			}
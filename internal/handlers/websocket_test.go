@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/alerts"
+	"github.com/CLDWare/schoolbox-backend/internal/broker"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/gorilla/websocket"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestWebsocketHandler creates a WebsocketHandler with an in memory database and broker,
+// configure can change the default configuration
+func newTestWebsocketHandler(t *testing.T, configure func(cfg *config.Config)) *WebsocketHandler {
+	t.Helper()
+	logger.Init()
+
+	cfg := *config.Get()
+	cfg.DeviceToken.EncryptionKeys = []config.DeviceTokenKey{{ID: "test", Key: []byte("0123456789abcdef0123456789abcdef")}}
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to file::memory: is a new database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.Device{}, &models.DeviceEvent{}, &models.Alert{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewWebsocketHandler(&cfg, db, broker.NewMemory(), alerts.NewAlerter(&cfg, db))
+}
+
+// connectTestDevice opens a websocket connection to h, it returns the client side and the connection on h
+func connectTestDevice(t *testing.T, h *WebsocketHandler) (*websocket.Conn, *websocketConnection) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(h.InitialiseWebsocket))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	// The connection is added right after the upgrade
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		h.mu.RLock()
+		for _, conn := range h.connections {
+			h.mu.RUnlock()
+			return client, conn
+		}
+		h.mu.RUnlock()
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("connection was not added to the handler")
+	return nil, nil
+}
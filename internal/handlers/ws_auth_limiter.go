@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+)
+
+// authFailureRecord keeps track of failed authentication attempts for a single key (device, device and remote address
+// or remote address)
+type authFailureRecord struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	alerted     bool
+}
+
+// authFailureTracker tracks failed device authentication attempts and hands out exponential lockouts.
+//
+// Lockouts are given per remote address and per device and remote address, never per device alone, otherwise anybody
+// that knows the ID of a device could lock the real device out by failing on purpose. Failures per device are only
+// counted to alert admins.
+type authFailureTracker struct {
+	config  *config.DeviceAuthConfig
+	records map[string]*authFailureRecord // failure key -> record
+	mu      sync.Mutex
+}
+
+func newAuthFailureTracker(cfg *config.DeviceAuthConfig) *authFailureTracker {
+	return &authFailureTracker{
+		config:  cfg,
+		records: map[string]*authFailureRecord{},
+	}
+}
+
+func deviceFailureKey(deviceID uint) string {
+	return fmt.Sprintf("device:%d", deviceID)
+}
+
+func addressFailureKey(remoteAddr string) string {
+	return "address:" + remoteAddr
+}
+
+func deviceAddressFailureKey(deviceID uint, remoteAddr string) string {
+	return fmt.Sprintf("device:%d@%s", deviceID, remoteAddr)
+}
+
+// expired reports if a record no longer influences anything and can be forgotten
+func (t *authFailureTracker) expired(record *authFailureRecord, now time.Time) bool {
+	return now.Sub(record.lastFailure) > t.config.FailureWindow && now.After(record.lockedUntil)
+}
+
+// lockoutDuration calculates the lockout for the given amount of failures, doubling for every failure above MaxFailures
+func (t *authFailureTracker) lockoutDuration(failures int) time.Duration {
+	if failures < t.config.MaxFailures {
+		return 0
+	}
+	lockout := t.config.LockoutBase
+	for i := t.config.MaxFailures; i < failures; i++ {
+		lockout *= 2
+		if lockout >= t.config.LockoutMax {
+			return t.config.LockoutMax
+		}
+	}
+	return min(lockout, t.config.LockoutMax)
+}
+
+// lockedFor returns the longest remaining lockout of all given keys, 0 if none of them are locked
+func (t *authFailureTracker) lockedFor(keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var remaining time.Duration
+	for _, key := range keys {
+		record, ok := t.records[key]
+		if !ok {
+			continue
+		}
+		remaining = max(remaining, record.lockedUntil.Sub(now))
+	}
+	return remaining
+}
+
+// recordFailure registers a failed attempt for key and returns a copy of the updated record,
+// key is locked out when it reaches MaxFailures and lockout is set
+func (t *authFailureTracker) recordFailure(key string, lockout bool) authFailureRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for k, record := range t.records {
+		if t.expired(record, now) {
+			delete(t.records, k)
+		}
+	}
+
+	record, ok := t.records[key]
+	if !ok {
+		record = &authFailureRecord{}
+		t.records[key] = record
+	}
+	record.failures++
+	record.lastFailure = now
+	if duration := t.lockoutDuration(record.failures); lockout && duration > 0 {
+		record.lockedUntil = now.Add(duration)
+	}
+	return *record
+}
+
+// markAlerted marks key as alerted, returns false if it already was
+func (t *authFailureTracker) markAlerted(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	record, ok := t.records[key]
+	if !ok || record.alerted {
+		return false
+	}
+	record.alerted = true
+	return true
+}
+
+// reset forgets all failures for the given keys
+func (t *authFailureTracker) reset(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range keys {
+		delete(t.records, key)
+	}
+}
+
+type AuthFailureInfo struct {
+	Key         string     `json:"key" example:"device:12"`
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"last_failure" format:"date-time"`
+	LockedUntil *time.Time `json:"locked_until" format:"date-time"`
+	Alerted     bool       `json:"alerted"`
+}
+
+// snapshot returns info about all records that are still relevant, most failures first
+func (t *authFailureTracker) snapshot() []AuthFailureInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	infoArray := []AuthFailureInfo{}
+	for key, record := range t.records {
+		if t.expired(record, now) {
+			continue
+		}
+		info := AuthFailureInfo{
+			Key:         key,
+			Failures:    record.failures,
+			LastFailure: record.lastFailure,
+			Alerted:     record.alerted,
+		}
+		if now.Before(record.lockedUntil) {
+			lockedUntil := record.lockedUntil
+			info.LockedUntil = &lockedUntil
+		}
+		infoArray = append(infoArray, info)
+	}
+	sort.Slice(infoArray, func(i, j int) bool {
+		return infoArray[i].Failures > infoArray[j].Failures
+	})
+	return infoArray
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+)
+
+func testAuthFailureConfig() *config.DeviceAuthConfig {
+	return &config.DeviceAuthConfig{
+		MaxFailures:   3,
+		LockoutBase:   10 * time.Second,
+		LockoutMax:    60 * time.Second,
+		FailureWindow: time.Hour,
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	tracker := newAuthFailureTracker(testAuthFailureConfig())
+
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{failures: 0, expected: 0},
+		{failures: 2, expected: 0},
+		{failures: 3, expected: 10 * time.Second},
+		{failures: 4, expected: 20 * time.Second},
+		{failures: 5, expected: 40 * time.Second},
+		{failures: 6, expected: 60 * time.Second},
+		{failures: 50, expected: 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if lockout := tracker.lockoutDuration(tt.failures); lockout != tt.expected {
+			t.Errorf("lockoutDuration(%d) = %s, expected %s", tt.failures, lockout, tt.expected)
+		}
+	}
+}
+
+func TestRecordFailure(t *testing.T) {
+	tests := []struct {
+		name           string
+		failures       int
+		lockout        bool
+		expectedLocked bool
+	}{
+		{
+			name:           "below max failures",
+			failures:       2,
+			lockout:        true,
+			expectedLocked: false,
+		},
+		{
+			name:           "at max failures",
+			failures:       3,
+			lockout:        true,
+			expectedLocked: true,
+		},
+		{
+			name:           "only counted",
+			failures:       10,
+			lockout:        false,
+			expectedLocked: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newAuthFailureTracker(testAuthFailureConfig())
+			key := addressFailureKey("192.0.2.1")
+
+			var record authFailureRecord
+			for range tt.failures {
+				record = tracker.recordFailure(key, tt.lockout)
+			}
+			if record.failures != tt.failures {
+				t.Errorf("expected %d failures, got %d", tt.failures, record.failures)
+			}
+			if locked := tracker.lockedFor(key) > 0; locked != tt.expectedLocked {
+				t.Errorf("expected locked %t, got %t", tt.expectedLocked, locked)
+			}
+		})
+	}
+}
+
+func TestDeviceLockoutIsPerAddress(t *testing.T) {
+	tracker := newAuthFailureTracker(testAuthFailureConfig())
+
+	// Somebody that knows the device ID fails on purpose
+	for range 10 {
+		tracker.recordFailure(deviceAddressFailureKey(1, "198.51.100.7"), true)
+		tracker.recordFailure(deviceFailureKey(1), false)
+	}
+
+	if tracker.lockedFor(deviceAddressFailureKey(1, "198.51.100.7")) == 0 {
+		t.Error("expected the device to be locked out from the failing address")
+	}
+	if lockedFor := tracker.lockedFor(deviceAddressFailureKey(1, "192.0.2.1"), addressFailureKey("192.0.2.1")); lockedFor != 0 {
+		t.Errorf("expected the device to not be locked out from its own address, locked for %s", lockedFor)
+	}
+}
+
+func TestAuthFailureExpiryAndReset(t *testing.T) {
+	cfg := testAuthFailureConfig()
+	tracker := newAuthFailureTracker(cfg)
+	oldKey := addressFailureKey("192.0.2.1")
+	newKey := addressFailureKey("192.0.2.2")
+
+	for range cfg.MaxFailures {
+		tracker.recordFailure(oldKey, true)
+	}
+	// The failures and the lockout are over
+	tracker.records[oldKey].lastFailure = time.Now().Add(-2 * cfg.FailureWindow)
+	tracker.records[oldKey].lockedUntil = time.Now().Add(-time.Minute)
+
+	if lockedFor := tracker.lockedFor(oldKey); lockedFor > 0 {
+		t.Errorf("expected the lockout to be over, locked for %s", lockedFor)
+	}
+	for _, info := range tracker.snapshot() {
+		if info.Key == oldKey {
+			t.Error("expected the expired record to not be in the snapshot")
+		}
+	}
+
+	// Expired records are forgotten on the next failure, the count starts over
+	tracker.recordFailure(newKey, true)
+	if _, ok := tracker.records[oldKey]; ok {
+		t.Error("expected the expired record to be removed")
+	}
+	if record := tracker.recordFailure(oldKey, true); record.failures != 1 {
+		t.Errorf("expected the failure count to start over, got %d failures", record.failures)
+	}
+
+	tracker.reset(oldKey, newKey)
+	if len(tracker.records) != 0 {
+		t.Errorf("expected no records after reset, got %d", len(tracker.records))
+	}
+}
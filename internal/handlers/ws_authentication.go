@@ -12,6 +12,8 @@ import (
 	"math/big"
 	"time"
 
+	"github.com/CLDWare/schoolbox-backend/internal/alerts"
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
 	"github.com/CLDWare/schoolbox-backend/pkg/db"
 	"github.com/CLDWare/schoolbox-backend/pkg/logger"
 	"gorm.io/gorm"
@@ -28,7 +30,7 @@ func triggersAuthenticationFlow(message *websocketMessage) bool {
 
 type authenticationFlowData struct {
 	startedAt   time.Time
-	flowTimeout uint
+	flowTimeout time.Duration
 	targetID    uint
 	nonce       string
 }
@@ -117,31 +119,47 @@ func authenticationFlow(conn *websocketConnection, message websocketMessage) err
 			conn.mu.RUnlock()
 			errCode := 0
 			errMsg := fmt.Sprintf("Can not start authentication in current state %d, only state 0 is allowed", conn.state)
-			sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
 			return nil
 		}
 		conn.mu.RUnlock()
 
 		message, parseErr := toWebsocketAuthStartMessage(message)
 		if parseErr != nil {
-			sendMessage(conn.ws, parseErr)
+			conn.sendMessage(parseErr)
 			return nil
 		}
 		ctx := context.Background()
 
+		id := message.TargetID
+		if lockedFor := conn.handler.authFailures.lockedFor(deviceAddressFailureKey(id, conn.remoteAddr), addressFailureKey(conn.remoteAddr)); lockedFor > 0 {
+			sendLockedOut(conn, lockedFor)
+			return nil
+		}
+
 		conn.mu.Lock()
 		conn.state = 2
 		conn.mu.Unlock()
 
-		id := message.TargetID
 		_, err := gorm.G[db.Device](conn.db).Where("id = ?", id).First(ctx)
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-
+			errCode := 3
+			errMsg := fmt.Sprintf("Unknown device %d", id)
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid auth data
+			conn.mu.Lock()
+			conn.state = 0
+			conn.stateFlow = nil
+			conn.mu.Unlock()
+			conn.handler.recordAuthFailure(conn, nil, "unknown device")
+			return nil
 		}
 		if err != nil {
 			errCode := -1
 			errMsg := err.Error()
-			sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
+			conn.mu.Lock()
+			conn.state = 0
+			conn.mu.Unlock()
 			return nil
 		}
 
@@ -149,14 +167,14 @@ func authenticationFlow(conn *websocketConnection, message websocketMessage) err
 		if err != nil {
 			errCode := -1
 			errMsg := err.Error()
-			sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
 			return nil
 		}
 
 		conn.mu.Lock()
 		conn.stateFlow = authenticationFlowData{
 			startedAt:   time.Now(),
-			flowTimeout: 30,
+			flowTimeout: conn.handler.config.DeviceAuth.FlowTimeout,
 			targetID:    id,
 			nonce:       nonce,
 		}
@@ -167,21 +185,21 @@ func authenticationFlow(conn *websocketConnection, message websocketMessage) err
 		data := map[string]any{
 			"nonce": nonce,
 		}
-		sendMessage(conn.ws, websocketMessage{Command: command, Data: data})
+		conn.sendMessage(websocketMessage{Command: command, Data: data})
 	case "auth_validate":
 		conn.mu.RLock()
 		if conn.state != 2 {
 			conn.mu.RUnlock()
 			errCode := 0
 			errMsg := fmt.Sprintf("Can not validate authentication in current state %d, only state 2 is allowed", conn.state)
-			sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
 			return nil
 		}
 		conn.mu.RUnlock()
 
 		message, parseErr := toWebsocketAuthValidateMessage(message)
 		if parseErr != nil {
-			sendMessage(conn.ws, parseErr)
+			conn.sendMessage(parseErr)
 			return nil
 		}
 
@@ -189,21 +207,43 @@ func authenticationFlow(conn *websocketConnection, message websocketMessage) err
 		if !ok {
 			errCode := -1
 			errMsg := fmt.Sprintf("Fatal: Invalid stateFlow type of %T, not authenticationFlowData", conn.stateFlow)
-			sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
 			logger.Err(errMsg)
-			conn.close()
+			conn.closeWithReason(disconnectInternalError)
 			return errors.New(errMsg)
 		}
 
+		if time.Since(flowData.startedAt) > flowData.flowTimeout {
+			errCode := 3
+			errMsg := fmt.Sprintf("Authentication flow timed out after %s, start a new one.", flowData.flowTimeout)
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid auth data
+			conn.mu.Lock()
+			conn.state = 0
+			conn.stateFlow = nil
+			conn.mu.Unlock()
+			return nil
+		}
+
+		if lockedFor := conn.handler.authFailures.lockedFor(deviceAddressFailureKey(flowData.targetID, conn.remoteAddr), addressFailureKey(conn.remoteAddr)); lockedFor > 0 {
+			sendLockedOut(conn, lockedFor)
+			conn.mu.Lock()
+			conn.state = 0
+			conn.stateFlow = nil
+			conn.mu.Unlock()
+			return nil
+		}
+
 		ctx := context.Background()
 
 		device, err := gorm.G[db.Device](conn.db).Where("id = ?", flowData.targetID).First(ctx)
 		if err != nil {
 			errCode := -1
 			errMsg := fmt.Sprintf("Could not retrieve device %d from database", flowData.targetID)
-			sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
+			conn.mu.Lock()
 			conn.state = 0
 			conn.stateFlow = nil
+			conn.mu.Unlock()
 			return nil
 		}
 
@@ -211,36 +251,50 @@ func authenticationFlow(conn *websocketConnection, message websocketMessage) err
 		if err != nil {
 			errCode := 3
 			errMsg := "Invalid signature encoding."
-			sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid auth data
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid auth data
 			conn.mu.Lock()
 			conn.state = 0
 			conn.stateFlow = nil
 			conn.mu.Unlock()
+			conn.handler.recordAuthFailure(conn, &flowData.targetID, "invalid signature encoding")
 			return nil
 		}
 
-		mac := hmac.New(sha256.New, []byte(device.Token))
-		mac.Write([]byte(flowData.nonce))
-		expectedMAC := mac.Sum(nil)
-		if !hmac.Equal(decodedSignature, expectedMAC) {
+		validSignature, usedPreviousToken, err := conn.handler.verifyDeviceSignature(&device, flowData.nonce, decodedSignature)
+		if err != nil {
+			errCode := -1
+			errMsg := "Could not verify signature"
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
+			logger.Err(fmt.Sprintf("Could not verify signature for device %d: %s", device.ID, err.Error()))
+			conn.mu.Lock()
+			conn.state = 0
+			conn.stateFlow = nil
+			conn.mu.Unlock()
+			return nil
+		}
+		if !validSignature {
 			errCode := 3
 			errMsg := "Invalid signature."
-			sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid auth data
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid auth data
 			conn.mu.Lock()
 			conn.state = 0
 			conn.stateFlow = nil
 			conn.mu.Unlock()
-
-			logger.Info(fmt.Sprintf(
-				"Auth fail for device %d, Invalid signature. Got '%s', expected '%s'",
-				flowData.targetID,
-				message.Signature,
-				hex.EncodeToString(expectedMAC),
-			))
-
+			conn.handler.recordAuthFailure(conn, &flowData.targetID, "invalid signature")
 			return nil
 		}
 
+		conn.handler.authFailures.reset(
+			deviceFailureKey(flowData.targetID),
+			deviceAddressFailureKey(flowData.targetID, conn.remoteAddr),
+			addressFailureKey(conn.remoteAddr),
+		)
+		if usedPreviousToken {
+			logger.Info(fmt.Sprintf("Device %d authenticated with its previous token, rotation grace period ends at %s", device.ID, device.PreviousTokenExpiresAt))
+		} else if conn.handler.tokenCipher.NeedsReencryption(device.Token) {
+			conn.handler.reencryptDeviceToken(&device)
+		}
+
 		conn.mu.Lock()
 		conn.state = 3
 		conn.stateFlow = nil
@@ -248,22 +302,141 @@ func authenticationFlow(conn *websocketConnection, message websocketMessage) err
 		conn.mu.Unlock()
 
 		conn.handler.mu.Lock()
+		conn.handler.connectedDevices[*conn.deviceID] = conn.connectionID
+		conn.handler.mu.Unlock()
+
+		previous, err := conn.handler.announceDevice(conn)
+		if err != nil {
+			logger.Err(fmt.Sprintf("Could not announce device %d: %s", device.ID, err.Error()))
+		}
+
 		// Kick old device
-		if conn.handler.connectedDevices[*conn.deviceID] != 0 {
-			oldConn := conn.handler.connections[conn.handler.connectedDevices[*conn.deviceID]]
+		if previous != nil {
 			errCode := 4
 			errMsg := "Logged in at other place. Only one connection allowed per device."
-			sendMessage(oldConn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // multiple logins
-			oldConn.close()
+			err := conn.handler.disconnectConnection(previous, device.ID, disconnectKicked, errCode, errMsg) // multiple logins
+			if err != nil && !errors.Is(err, ErrDeviceNotConnected) {
+				logger.Err(fmt.Sprintf("Could not kick previous connection %d of device %d on %s: %s", previous.ConnectionID, device.ID, previous.Instance, err.Error()))
+			}
 		}
 
-		conn.handler.connectedDevices[*conn.deviceID] = conn.connectionID
-		conn.handler.mu.Unlock()
+		now := time.Now()
+		err = conn.handler.db.Model(&db.Device{}).Where("id = ?", device.ID).Updates(map[string]any{"latest_login": now, "last_seen": now}).Error
+		if err != nil {
+			logger.Err(fmt.Sprintf("Could not update latest login of device %d: %s", device.ID, err.Error()))
+		}
+		conn.mu.Lock()
+		conn.lastSeenWrittenAt = now
+		conn.mu.Unlock()
+		// Authentication failure alerts stay open, whoever brute forced the token can also log in
+		if err := conn.handler.alerter.Resolve(alerts.TypeDeviceOffline, device.ID); err != nil {
+			logger.Err(fmt.Sprintf("Could not resolve %s alert for device %d: %s", alerts.TypeDeviceOffline, device.ID, err.Error()))
+		}
+		conn.handler.recordDeviceEvent(conn, &device.ID, deviceEventAuthSuccess, "", nil)
+		conn.handler.publishConnectionState(conn, presenceDeviceOnline)
 
-		sendMessage(conn.ws, websocketMessage{Command: "auth_ok"})
+		conn.sendMessage(websocketMessage{Command: "auth_ok"})
 		logger.Info(fmt.Sprintf("Device %d authenticated successfully", *conn.deviceID))
+		conn.handler.resumeSession(conn, &device)
 	default:
 		logger.Err(fmt.Sprintf("Invalid command '%s' reached authenticationFlow", message.Command))
 	}
 	return nil
 }
+
+// sendLockedOut tells the device it has to wait before it can try to authenticate again
+func sendLockedOut(conn *websocketConnection, lockedFor time.Duration) {
+	retryAfter := int(math.Ceil(lockedFor.Seconds()))
+	errCode := 5
+	errMsg := fmt.Sprintf("Too many failed authentication attempts, retry in %d seconds.", retryAfter)
+	conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // locked out
+}
+
+// recordAuthFailure registers a failed authentication attempt for the remote address of conn and deviceID (if known).
+// The remote address, and the device from that address, are locked out. Admins are alerted once a single device reaches
+// the alert threshold, counting the failures from all addresses.
+func (h *WebsocketHandler) recordAuthFailure(conn *websocketConnection, deviceID *uint, reason string) {
+	metrics.DeviceAuthFailures.WithLabelValues(reason).Inc()
+	h.recordDeviceEvent(conn, deviceID, deviceEventAuthFailure, reason, nil)
+
+	addressKey := addressFailureKey(conn.remoteAddr)
+	addressRecord := h.authFailures.recordFailure(addressKey, true)
+	if deviceID == nil {
+		logger.Info(fmt.Sprintf("Auth fail on connection %d from %s: %s", conn.connectionID, conn.remoteAddr, reason))
+		if addressRecord.failures == h.config.DeviceAuth.MaxFailures {
+			logger.Warn(fmt.Sprintf("Address %s locked out after %d failed device authentication attempts", conn.remoteAddr, addressRecord.failures))
+		}
+		return
+	}
+
+	deviceAddressRecord := h.authFailures.recordFailure(deviceAddressFailureKey(*deviceID, conn.remoteAddr), true)
+	deviceKey := deviceFailureKey(*deviceID)
+	deviceRecord := h.authFailures.recordFailure(deviceKey, false)
+	logger.Info(fmt.Sprintf("Auth fail for device %d on connection %d from %s: %s", *deviceID, conn.connectionID, conn.remoteAddr, reason))
+	if deviceAddressRecord.failures == h.config.DeviceAuth.MaxFailures {
+		logger.Warn(fmt.Sprintf("Device %d locked out from %s after %d failed authentication attempts", *deviceID, conn.remoteAddr, deviceAddressRecord.failures))
+	}
+	if deviceRecord.failures >= h.config.DeviceAuth.AlertThreshold && h.authFailures.markAlerted(deviceKey) {
+		h.alertAuthFailures(*deviceID, deviceRecord, conn.remoteAddr)
+	}
+}
+
+// alertAuthFailures notifies admins about repeated authentication failures on a device,
+// the alert is resolved when an admin acknowledges it
+func (h *WebsocketHandler) alertAuthFailures(deviceID uint, record authFailureRecord, remoteAddr string) {
+	message := fmt.Sprintf(
+		"Device %d had %d failed authentication attempts, latest from %s",
+		deviceID,
+		record.failures,
+		remoteAddr,
+	)
+	if err := h.alerter.Raise(alerts.TypeDeviceAuthFailures, deviceID, message); err != nil {
+		logger.Err(fmt.Sprintf("Could not raise authentication failure alert for device %d: %s", deviceID, err.Error()))
+	}
+}
+
+// signNonce calculates the HMAC signature a device should send for nonce
+func signNonce(token string, nonce string) []byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(nonce))
+	return mac.Sum(nil)
+}
+
+// verifyDeviceSignature checks signature against the current token of device and,
+// during the rotation grace period, against its previous token.
+func (h *WebsocketHandler) verifyDeviceSignature(device *db.Device, nonce string, signature []byte) (valid bool, usedPreviousToken bool, err error) {
+	token, err := h.tokenCipher.Decrypt(device.Token)
+	if err != nil {
+		return false, false, err
+	}
+	if hmac.Equal(signature, signNonce(token, nonce)) {
+		return true, false, nil
+	}
+
+	if device.PreviousToken == nil || device.PreviousTokenExpiresAt == nil || time.Now().After(*device.PreviousTokenExpiresAt) {
+		return false, false, nil
+	}
+	previousToken, err := h.tokenCipher.Decrypt(*device.PreviousToken)
+	if err != nil {
+		return false, false, err
+	}
+	return hmac.Equal(signature, signNonce(previousToken, nonce)), true, nil
+}
+
+// reencryptDeviceToken stores the token of device encrypted with the current server key
+func (h *WebsocketHandler) reencryptDeviceToken(device *db.Device) {
+	encryptedToken, err := h.tokenCipher.Reencrypt(device.Token)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not re-encrypt token of device %d: %s", device.ID, err.Error()))
+		return
+	}
+	// Conditional, a token rotated in the mean time must not be undone
+	result := h.db.Model(&db.Device{}).Where("id = ? AND token = ?", device.ID, device.Token).Update("token", encryptedToken)
+	if result.Error != nil {
+		logger.Err(fmt.Sprintf("Could not store re-encrypted token of device %d: %s", device.ID, result.Error.Error()))
+		return
+	}
+	if result.RowsAffected == 1 {
+		device.Token = encryptedToken
+	}
+}
@@ -0,0 +1,487 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/internal/broker"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+)
+
+// Broker keys and channels shared by all instances
+const (
+	devicePresencePrefix  = "device:"
+	registrationPinPrefix = "regpin:"
+	claimTokenPrefix      = "regclaim:"
+	presenceChannel       = "presence"
+)
+
+func devicePresenceKey(deviceID uint) string {
+	return fmt.Sprintf("%s%d", devicePresencePrefix, deviceID)
+}
+
+func registrationPinKey(pin uint) string {
+	return fmt.Sprintf("%s%d", registrationPinPrefix, pin)
+}
+
+func claimTokenKey(token string) string {
+	return claimTokenPrefix + token
+}
+
+// RPC methods handled by the instance a device is connected to
+const (
+	rpcSessionStart = "session_start"
+	rpcSessionStop  = "session_stop"
+	rpcTokenRotate  = "token_rotate"
+	rpcDisconnect   = "disconnect"
+	rpcRegister     = "register"
+	rpcSend         = "send"
+)
+
+// Error codes used to pass errors of this package between instances
+const (
+	rpcErrDeviceNotConnected = "device_not_connected"
+	rpcErrInvalidPin         = "invalid_pin"
+	rpcErrRotationPending    = "token_rotation_pending"
+)
+
+// connectionRecord tells all instances where a connection lives and what it is doing.
+// A connection stores at most one record: under its registration pin while registering and under its device once authenticated.
+type connectionRecord struct {
+	Instance     string    `json:"instance"`
+	ConnectionID uint      `json:"conn"`
+	DeviceID     *uint     `json:"device_id,omitempty"`
+	Pin          *uint     `json:"pin,omitempty"`
+	State        uint      `json:"state"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	RemoteAddr   string    `json:"remote_addr"`
+}
+
+func (h *WebsocketHandler) newConnectionRecord(conn *websocketConnection, pin *uint) (string, error) {
+	conn.mu.RLock()
+	record := connectionRecord{
+		Instance:     h.instanceID,
+		ConnectionID: conn.connectionID,
+		DeviceID:     conn.deviceID,
+		Pin:          pin,
+		State:        conn.state,
+		ConnectedAt:  conn.connectedAt,
+		RemoteAddr:   conn.remoteAddr,
+	}
+	conn.mu.RUnlock()
+	value, err := json.Marshal(record)
+	return string(value), err
+}
+
+// getConnectionRecord returns the record stored under key and its raw value, broker.ErrNotFound if there is none
+func (h *WebsocketHandler) getConnectionRecord(key string) (connectionRecord, string, error) {
+	value, err := h.broker.Get(context.Background(), key)
+	if err != nil {
+		return connectionRecord{}, "", err
+	}
+	var record connectionRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return connectionRecord{}, "", fmt.Errorf("invalid connection record under '%s': %s", key, err.Error())
+	}
+	return record, value, nil
+}
+
+func (conn *websocketConnection) setRecord(key string, value string) {
+	conn.mu.Lock()
+	conn.recordKey = key
+	conn.record = value
+	conn.recordRefreshedAt = time.Now()
+	conn.mu.Unlock()
+}
+
+// claimRegistrationPin reserves an unused registration pin for conn on all instances
+func (h *WebsocketHandler) claimRegistrationPin(conn *websocketConnection) (uint, error) {
+	for range 20 {
+		pin := uint(rand.Intn(9000) + 1000)
+		value, err := h.newConnectionRecord(conn, &pin)
+		if err != nil {
+			return 0, err
+		}
+		key := registrationPinKey(pin)
+		ok, err := h.broker.SetNX(context.Background(), key, value, h.config.Broker.PresenceTTL)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			conn.setRecord(key, value)
+			return pin, nil
+		}
+	}
+	return 0, errors.New("Could not find an unused registration pin")
+}
+
+// newClaimToken creates a token that registers the device waiting with pin and the address of its confirmation page,
+// so the device can show it as a QR code
+func (h *WebsocketHandler) newClaimToken(pin uint) (string, string, error) {
+	token, err := generateSecureToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	claimURL, err := url.Parse(h.config.DeviceClaim.URL)
+	if err != nil {
+		return "", "", err
+	}
+	query := claimURL.Query()
+	query.Set("token", token)
+	claimURL.RawQuery = query.Encode()
+
+	ok, err := h.broker.SetNX(context.Background(), claimTokenKey(token), strconv.FormatUint(uint64(pin), 10), h.config.DeviceClaim.TTL)
+	if err != nil {
+		return "", "", err
+	}
+	if !ok {
+		return "", "", errors.New("Claim token already in use")
+	}
+	return token, claimURL.String(), nil
+}
+
+// pinForClaimToken returns the registration pin a claim token belongs to, ErrInvalidPin if the token is unknown or expired
+func (h *WebsocketHandler) pinForClaimToken(token string) (uint, error) {
+	value, err := h.broker.Get(context.Background(), claimTokenKey(token))
+	if errors.Is(err, broker.ErrNotFound) {
+		return 0, ErrInvalidPin
+	}
+	if err != nil {
+		return 0, err
+	}
+	pin, err := strconv.ParseUint(value, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pin '%s' for claim token", value)
+	}
+	return uint(pin), nil
+}
+
+// releaseClaimToken removes a claim token once its registration finished, so it can not register the next device that gets the same pin
+func (h *WebsocketHandler) releaseClaimToken(token string, pin uint) {
+	_, err := h.broker.CompareAndDelete(context.Background(), claimTokenKey(token), strconv.FormatUint(uint64(pin), 10))
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not release claim token of pin %d: %s", pin, err.Error()))
+	}
+}
+
+// announceDevice marks the device of conn as connected to this instance and returns the connection it replaces, if any
+func (h *WebsocketHandler) announceDevice(conn *websocketConnection) (*connectionRecord, error) {
+	conn.mu.RLock()
+	key := devicePresenceKey(*conn.deviceID)
+	conn.mu.RUnlock()
+
+	value, err := h.newConnectionRecord(conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	previous, _, lookupErr := h.getConnectionRecord(key)
+	if lookupErr != nil && !errors.Is(lookupErr, broker.ErrNotFound) {
+		logger.Warn(lookupErr.Error())
+	}
+	if err := h.broker.Set(context.Background(), key, value, h.config.Broker.PresenceTTL); err != nil {
+		return nil, err
+	}
+	conn.setRecord(key, value)
+
+	if lookupErr != nil || (previous.Instance == h.instanceID && previous.ConnectionID == conn.connectionID) {
+		return nil, nil
+	}
+	return &previous, nil
+}
+
+// updateConnectionRecord stores the current state of conn, unless another connection took over its record
+func (h *WebsocketHandler) updateConnectionRecord(conn *websocketConnection) {
+	conn.mu.RLock()
+	key, old := conn.recordKey, conn.record
+	conn.mu.RUnlock()
+	if key == "" {
+		return
+	}
+
+	value, err := h.newConnectionRecord(conn, nil)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not encode record of connection %d: %s", conn.connectionID, err.Error()))
+		return
+	}
+	swapped, err := h.broker.CompareAndSwap(context.Background(), key, old, value, h.config.Broker.PresenceTTL)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not update record of connection %d: %s", conn.connectionID, err.Error()))
+		return
+	}
+	if !swapped {
+		logger.Warn(fmt.Sprintf("Connection %d no longer owns '%s'", conn.connectionID, key))
+		return
+	}
+	conn.setRecord(key, value)
+}
+
+// refreshConnectionRecord keeps the record of conn from expiring, at most a few times per presence TTL
+func (h *WebsocketHandler) refreshConnectionRecord(conn *websocketConnection) {
+	conn.mu.RLock()
+	key, value := conn.recordKey, conn.record
+	due := time.Since(conn.recordRefreshedAt) > h.config.Broker.PresenceTTL/4
+	conn.mu.RUnlock()
+	if key == "" || !due {
+		return
+	}
+
+	swapped, err := h.broker.CompareAndSwap(context.Background(), key, value, value, h.config.Broker.PresenceTTL)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not refresh record of connection %d: %s", conn.connectionID, err.Error()))
+		return
+	}
+	if !swapped {
+		// Expired or taken over, store it again if nobody else did in the mean time
+		swapped, err = h.broker.SetNX(context.Background(), key, value, h.config.Broker.PresenceTTL)
+		if err != nil || !swapped {
+			logger.Warn(fmt.Sprintf("Connection %d lost '%s'", conn.connectionID, key))
+			conn.setRecord("", "")
+			return
+		}
+	}
+	conn.setRecord(key, value)
+}
+
+// releaseConnectionRecord removes the record of conn, unless another connection took it over
+func (h *WebsocketHandler) releaseConnectionRecord(conn *websocketConnection) {
+	conn.mu.RLock()
+	key, value := conn.recordKey, conn.record
+	conn.mu.RUnlock()
+	if key == "" {
+		return
+	}
+	if _, err := h.broker.CompareAndDelete(context.Background(), key, value); err != nil {
+		logger.Err(fmt.Sprintf("Could not remove record of connection %d: %s", conn.connectionID, err.Error()))
+	}
+	conn.setRecord("", "")
+}
+
+// routeToDevice finds the connection of a device. It returns the connection if it lives on this instance,
+// otherwise the record of the instance it is connected to.
+func (h *WebsocketHandler) routeToDevice(deviceID uint) (*websocketConnection, *connectionRecord, error) {
+	key := devicePresenceKey(deviceID)
+	record, value, err := h.getConnectionRecord(key)
+	if errors.Is(err, broker.ErrNotFound) {
+		return nil, nil, ErrDeviceNotConnected
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if record.Instance != h.instanceID {
+		return nil, &record, nil
+	}
+
+	conn, err := h.localDeviceConnection(deviceID)
+	if err != nil {
+		// The record outlived its connection
+		h.broker.CompareAndDelete(context.Background(), key, value)
+		return nil, nil, err
+	}
+	return conn, nil, nil
+}
+
+// localDeviceConnection returns the connection of a device connected to this instance
+func (h *WebsocketHandler) localDeviceConnection(deviceID uint) (*websocketConnection, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	connID, ok := h.connectedDevices[deviceID]
+	if !ok {
+		return nil, ErrDeviceNotConnected
+	}
+	conn, ok := h.connections[connID]
+	if !ok {
+		return nil, fmt.Errorf("Connection %d for device %d does not exist", connID, deviceID)
+	}
+	return conn, nil
+}
+
+// callInstance runs method on the instance of record
+func (h *WebsocketHandler) callInstance(record *connectionRecord, method string, payload any, result any) error {
+	err := h.rpc.Call(context.Background(), record.Instance, method, payload, result)
+	if errors.Is(err, broker.ErrTimeout) {
+		logger.Warn(fmt.Sprintf("Instance %s did not answer '%s' for connection %d", record.Instance, method, record.ConnectionID))
+		return ErrDeviceNotConnected
+	}
+	var rpcErr *broker.Error
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.Code {
+		case rpcErrDeviceNotConnected:
+			return ErrDeviceNotConnected
+		case rpcErrInvalidPin:
+			return ErrInvalidPin
+		case rpcErrRotationPending:
+			return ErrTokenRotationPending
+		}
+	}
+	return err
+}
+
+// toRPCError keeps errors of this package recognisable for the calling instance
+func toRPCError(err error) error {
+	switch {
+	case errors.Is(err, ErrDeviceNotConnected):
+		return &broker.Error{Code: rpcErrDeviceNotConnected, Message: err.Error()}
+	case errors.Is(err, ErrInvalidPin):
+		return &broker.Error{Code: rpcErrInvalidPin, Message: err.Error()}
+	case errors.Is(err, ErrTokenRotationPending):
+		return &broker.Error{Code: rpcErrRotationPending, Message: err.Error()}
+	}
+	return err
+}
+
+type rpcSessionStartRequest struct {
+	DeviceID  uint   `json:"device_id"`
+	SessionID uint   `json:"session_id"`
+	Question  string `json:"question"`
+}
+
+type rpcDeviceRequest struct {
+	DeviceID uint `json:"device_id"`
+}
+
+type rpcDisconnectRequest struct {
+	ConnectionID uint   `json:"connection_id"`
+	DeviceID     uint   `json:"device_id"`
+	Reason       string `json:"reason"`
+	ErrorCode    int    `json:"error_code"`
+	Info         string `json:"info"`
+}
+
+type rpcSendRequest struct {
+	DeviceID uint             `json:"device_id"`
+	Message  websocketMessage `json:"message"`
+}
+
+type rpcRegisterRequest struct {
+	Pin      uint  `json:"pin"`
+	DeviceID *uint `json:"device_id,omitempty"`
+}
+
+type rpcRegisterResponse struct {
+	DeviceID uint `json:"device_id"`
+}
+
+// rpcHandler decodes the request of an RPC method into T before calling handle
+func rpcHandler[T any](handle func(request T) (any, error)) broker.RPCHandlerFunc {
+	return func(ctx context.Context, payload json.RawMessage) (any, error) {
+		var request T
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return nil, err
+		}
+		result, err := handle(request)
+		return result, toRPCError(err)
+	}
+}
+
+// registerRPCHandlers lets other instances reach the connections of this instance
+func (h *WebsocketHandler) registerRPCHandlers() {
+	h.rpc.Handle(rpcSessionStart, rpcHandler(func(request rpcSessionStartRequest) (any, error) {
+		conn, err := h.localDeviceConnection(request.DeviceID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, h.activateSession(conn, request.SessionID, request.Question)
+	}))
+	h.rpc.Handle(rpcSessionStop, rpcHandler(func(request rpcDeviceRequest) (any, error) {
+		conn, err := h.localDeviceConnection(request.DeviceID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, h.deactivateSession(conn)
+	}))
+	h.rpc.Handle(rpcTokenRotate, rpcHandler(func(request rpcDeviceRequest) (any, error) {
+		conn, err := h.localDeviceConnection(request.DeviceID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, h.rotateToken(conn, request.DeviceID)
+	}))
+	h.rpc.Handle(rpcDisconnect, rpcHandler(func(request rpcDisconnectRequest) (any, error) {
+		return nil, h.disconnectLocal(request.ConnectionID, request.DeviceID, request.Reason, request.ErrorCode, request.Info)
+	}))
+	h.rpc.Handle(rpcSend, rpcHandler(func(request rpcSendRequest) (any, error) {
+		conn, err := h.localDeviceConnection(request.DeviceID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, conn.sendMessageAndWait(request.Message)
+	}))
+	h.rpc.Handle(rpcRegister, rpcHandler(func(request rpcRegisterRequest) (any, error) {
+		var device *models.Device
+		if request.DeviceID != nil {
+			device = &models.Device{}
+			if err := h.db.Where("id = ?", *request.DeviceID).First(device).Error; err != nil {
+				return nil, err
+			}
+		}
+		device, err := h.registerLocal(request.Pin, device)
+		if err != nil {
+			return nil, err
+		}
+		return rpcRegisterResponse{DeviceID: device.ID}, nil
+	}))
+}
+
+// disconnectLocal sends an error to a connection of this instance and closes it
+func (h *WebsocketHandler) disconnectLocal(connID uint, deviceID uint, reason string, errCode int, info string) error {
+	h.mu.RLock()
+	conn, ok := h.connections[connID]
+	h.mu.RUnlock()
+	if !ok {
+		return ErrDeviceNotConnected
+	}
+	conn.mu.RLock()
+	isDevice := conn.deviceID != nil && *conn.deviceID == deviceID
+	conn.mu.RUnlock()
+	if !isDevice {
+		return ErrDeviceNotConnected
+	}
+
+	conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &info})
+	return conn.closeWithReason(reason)
+}
+
+// disconnectConnection closes the connection described by record, on whichever instance it lives
+func (h *WebsocketHandler) disconnectConnection(record *connectionRecord, deviceID uint, reason string, errCode int, info string) error {
+	if record.Instance == h.instanceID {
+		return h.disconnectLocal(record.ConnectionID, deviceID, reason, errCode, info)
+	}
+	return h.callInstance(record, rpcDisconnect, rpcDisconnectRequest{
+		ConnectionID: record.ConnectionID,
+		DeviceID:     deviceID,
+		Reason:       reason,
+		ErrorCode:    errCode,
+		Info:         info,
+	}, nil)
+}
+
+// disconnectDevice closes the connection of a device, on whichever instance it is connected to
+func (h *WebsocketHandler) disconnectDevice(deviceID uint, reason string, errCode int, info string) error {
+	record, _, err := h.getConnectionRecord(devicePresenceKey(deviceID))
+	if errors.Is(err, broker.ErrNotFound) {
+		return ErrDeviceNotConnected
+	}
+	if err != nil {
+		return err
+	}
+	return h.disconnectConnection(&record, deviceID, reason, errCode, info)
+}
+
+// sendToDevice sends a message to an authenticated device, on whichever instance it is connected to
+func (h *WebsocketHandler) sendToDevice(deviceID uint, message websocketMessage) error {
+	conn, record, err := h.routeToDevice(deviceID)
+	if err != nil {
+		return err
+	}
+	if conn == nil {
+		return h.callInstance(record, rpcSend, rpcSendRequest{DeviceID: deviceID, Message: message}, nil)
+	}
+	return conn.sendMessageAndWait(message)
+}
@@ -5,13 +5,76 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
 	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/gorilla/websocket"
 )
 
+// Heartbeat modes
+const (
+	heartbeatModeJSON    = "json"    // ping/pong commands, understood by all firmware
+	heartbeatModeControl = "control" // websocket ping/pong control frames, answered by the websocket library of the device
+)
+
+// readDeadline is the time the next message or pong has to arrive before, in control mode.
+// It is a bit later than the kill delay so the heartbeat monitor can tell the device why it is disconnected first.
+func (conn *websocketConnection) readDeadline() time.Time {
+	heartbeat := conn.handler.config.Heartbeat
+	return time.Now().Add(heartbeat.KillDelay + 2*heartbeat.CheckInterval)
+}
+
+// markSeen records that the other side of conn is still there. It is called from the read loop only.
+// The LastSeen of the device is written at most once per LastSeenInterval.
+func (conn *websocketConnection) markSeen() {
+	now := time.Now()
+	conn.mu.Lock()
+	conn.latestMessage = now
+	deviceID := conn.deviceID
+	due := deviceID != nil && now.Sub(conn.lastSeenWrittenAt) >= conn.handler.config.Heartbeat.LastSeenInterval
+	if due {
+		conn.lastSeenWrittenAt = now
+	}
+	conn.mu.Unlock()
+
+	if due {
+		err := conn.db.Model(&models.Device{}).Where("id = ?", *deviceID).Update("last_seen", now).Error
+		if err != nil {
+			logger.Err(fmt.Sprintf("Could not update last seen of device %d: %s", *deviceID, err.Error()))
+		}
+	}
+	conn.handler.refreshConnectionRecord(conn)
+	if conn.handler.config.Heartbeat.Mode == heartbeatModeControl {
+		conn.ws.SetReadDeadline(conn.readDeadline())
+	}
+}
+
+// sendHeartbeat sends a ping in the configured heartbeat mode
+func (conn *websocketConnection) sendHeartbeat() error {
+	if conn.handler.config.Heartbeat.Mode == heartbeatModeControl {
+		// Control frames may be written next to the writer goroutine
+		return conn.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(conn.handler.config.Websocket.WriteTimeout))
+	}
+	command := "ping"
+	return conn.sendMessage(websocketMessage{Command: command})
+}
+
 func (conn *websocketConnection) startHeartbeatMonitor() {
 	ctx, cancel := context.WithCancel(context.Background())
 	conn.hearbeat_cancel = cancel
 
+	if conn.handler.config.Heartbeat.Mode == heartbeatModeControl {
+		conn.ws.SetReadDeadline(conn.readDeadline())
+		conn.ws.SetPongHandler(func(string) error {
+			conn.mu.Lock()
+			conn.pongsReceived++
+			conn.mu.Unlock()
+			metrics.HeartbeatPongsReceived.Inc()
+			conn.markSeen()
+			return nil
+		})
+	}
+
 	go func() {
 		ticker := time.NewTicker(conn.handler.config.Heartbeat.CheckInterval)
 		defer ticker.Stop()
@@ -27,8 +90,8 @@ func (conn *websocketConnection) startHeartbeatMonitor() {
 				if age >= conn.handler.config.Heartbeat.KillDelay {
 					errCode := 1
 					errMsg := "Hearbeat missed"
-					sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // heartbeat missed
-					conn.close()
+					conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // heartbeat missed
+					conn.closeWithReason(disconnectHeartbeatMissed)
 					logger.Info(fmt.Sprintf(
 						"Disconnected %d, heartbeat missed. %.2f%% response rate (%d/%d)",
 						conn.connectionID,
@@ -37,12 +100,15 @@ func (conn *websocketConnection) startHeartbeatMonitor() {
 						conn.pingsSent,
 					))
 				} else if age >= conn.handler.config.Heartbeat.Delay && heartbeat_age >= conn.handler.config.Heartbeat.Interval {
-					command := "ping"
-					sendMessage(conn.ws, websocketMessage{Command: command})
+					if err := conn.sendHeartbeat(); err != nil {
+						logger.Warn(fmt.Sprintf("Could not send heartbeat to %d: %s", conn.connectionID, err.Error()))
+						continue
+					}
 					conn.mu.Lock()
 					conn.pingsSent++
 					conn.latestHeartbeat = time.Now()
 					conn.mu.Unlock()
+					metrics.HeartbeatPingsSent.Inc()
 					logger.Info(fmt.Sprintf("Send heartbeat to %d", conn.connectionID))
 				}
 			}
@@ -6,8 +6,9 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/rand"
 
+	"github.com/CLDWare/schoolbox-backend/internal/broker"
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
 	models "github.com/CLDWare/schoolbox-backend/pkg/db"
 	"github.com/CLDWare/schoolbox-backend/pkg/logger"
 
@@ -24,7 +25,8 @@ func triggersRegistrationFlow(message *websocketMessage) bool {
 }
 
 type registrationFlowData struct {
-	pin uint
+	pin        uint
+	claimToken string // Empty if no claim token could be created
 }
 
 func generateSecureToken(n int) (string, error) {
@@ -41,78 +43,165 @@ func generateSecureToken(n int) (string, error) {
 
 func registrationFlow(conn *websocketConnection, message websocketMessage) error {
 	if message.Command == "reg_start" {
-		conn.mu.RLock()
+		conn.mu.Lock()
 		if conn.state != 0 {
-			conn.mu.RUnlock()
+			conn.mu.Unlock()
 			errCode := 0
 			errMsg := fmt.Sprintf("Can not start registration in current state %d, only state 0 is allowed", conn.state)
-			sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
 			return nil
 		}
-		conn.mu.RUnlock()
-
-		pin := uint(rand.Intn(9000) + 1000)
-
-		conn.mu.Lock()
 		conn.state = 1
-		conn.stateFlow = registrationFlowData{pin: pin}
 		conn.mu.Unlock()
 
-		conn.handler.mu.Lock()
-		conn.handler.registrationPins[pin] = conn.connectionID
-		conn.handler.mu.Unlock()
+		pin, err := conn.handler.claimRegistrationPin(conn)
+		if err != nil {
+			conn.mu.Lock()
+			conn.state = 0
+			conn.mu.Unlock()
+			errCode := -1
+			errMsg := "Could not start registration"
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
+			logger.Err(fmt.Sprintf("Could not claim registration pin for connection %d: %s", conn.connectionID, err.Error()))
+			return nil
+		}
 
 		command := "reg_pin"
 		data := map[string]any{
 			"pin": pin,
 		}
-		sendMessage(conn.ws, websocketMessage{Command: command, Data: data})
-		logger.Info(fmt.Sprintf("Started registration for connection %d with pin %d", conn.handler.registrationPins[pin], pin))
+		claimToken, claimURL, err := conn.handler.newClaimToken(pin)
+		if err != nil {
+			// The claim token is optional, admins can always fall back to the pin
+			logger.Warn(fmt.Sprintf("Could not create claim token for connection %d: %s", conn.connectionID, err.Error()))
+		} else {
+			data["claim_token"] = claimToken
+			data["claim_url"] = claimURL
+		}
+
+		conn.mu.Lock()
+		conn.stateFlow = registrationFlowData{pin: pin, claimToken: claimToken}
+		conn.mu.Unlock()
+
+		conn.sendMessage(websocketMessage{Command: command, Data: data})
+		conn.handler.presence.publish(PresenceEvent{
+			Type:         presenceRegistrationPending,
+			ConnectionID: conn.connectionID,
+			State:        connectionStateName(1),
+			Pin:          &pin,
+			RemoteAddr:   conn.remoteAddr,
+		})
+		logger.Info(fmt.Sprintf("Started registration for connection %d with pin %d", conn.connectionID, pin))
 	}
 	return nil
 }
 
+var ErrInvalidPin = errors.New("No connectionID for this pin")
+
+// registerWithPin registers the connection waiting with pin as device, on whichever instance it is connected to.
+// If device is nil a new device is created, otherwise the existing device is relinked.
 func (h *WebsocketHandler) registerWithPin(pin uint, device *models.Device) (*models.Device, error) {
-	h.mu.RLock()
-	connectionID, ok := h.registrationPins[pin]
-	if !ok {
-		h.mu.RUnlock()
+	record, _, err := h.getConnectionRecord(registrationPinKey(pin))
+	if errors.Is(err, broker.ErrNotFound) {
 		logger.Info("Wrong pin provided for registration")
-		return nil, errors.New("No connectionID for this pin")
+		return nil, ErrInvalidPin
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.Instance == h.instanceID {
+		return h.registerLocal(pin, device)
+	}
+
+	request := rpcRegisterRequest{Pin: pin}
+	if device != nil {
+		request.DeviceID = &device.ID
+	}
+	var response rpcRegisterResponse
+	if err := h.callInstance(&record, rpcRegister, request, &response); err != nil {
+		if errors.Is(err, ErrDeviceNotConnected) {
+			return nil, ErrInvalidPin
+		}
+		return nil, err
+	}
+	registered, err := gorm.G[models.Device](h.db).Where("id = ?", response.DeviceID).First(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &registered, nil
+}
+
+// registerLocal registers the connection of this instance waiting with pin
+func (h *WebsocketHandler) registerLocal(pin uint, device *models.Device) (*models.Device, error) {
+	key := registrationPinKey(pin)
+	record, value, err := h.getConnectionRecord(key)
+	if errors.Is(err, broker.ErrNotFound) {
+		return nil, ErrInvalidPin
+	}
+	if err != nil {
+		return nil, err
 	}
-	conn, ok := h.connections[connectionID]
+	h.mu.RLock()
+	conn, ok := h.connections[record.ConnectionID]
 	h.mu.RUnlock()
-	if !ok {
-		logger.Err(fmt.Sprintf("No connection for connectionID %d during registration with pin", connectionID))
+	if !ok || record.Instance != h.instanceID {
+		logger.Err(fmt.Sprintf("No connection for connectionID %d during registration with pin", record.ConnectionID))
 		return nil, errors.New("No connection for connectionID")
 	}
-	h.mu.Lock() // Keep a lock on the handler so registerWithPin can not be called again until this registeration is successfull (prevent double registration)
-	defer h.mu.Unlock()
+
+	// Claiming the pin makes sure it can only be used once, even when multiple instances try at the same time
+	claimed, err := h.broker.CompareAndDelete(context.Background(), key, value)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrInvalidPin
+	}
+	conn.setRecord("", "")
+	releaseClaim := func() {
+		if ok, _ := h.broker.SetNX(context.Background(), key, value, h.config.Broker.PresenceTTL); ok {
+			conn.setRecord(key, value)
+		}
+	}
 
 	token, err := generateSecureToken(128)
 	if err != nil {
 		logger.Err(fmt.Sprintf("An Error occured while generating secure token for %d: %s", conn.connectionID, err))
+		releaseClaim()
+		return nil, err
+	}
+
+	encryptedToken, err := h.tokenCipher.Encrypt(token)
+	if err != nil {
+		logger.Err(fmt.Sprintf("An Error occured while encrypting token for %d: %s", conn.connectionID, err))
+		releaseClaim()
 		return nil, err
 	}
 
 	ctx := context.Background()
 
+	registrationType := "relink"
 	if device == nil {
+		registrationType = "new"
 		device = &models.Device{
-			Token: token,
+			Token: encryptedToken,
 		}
 
 		err = gorm.G[models.Device](h.db).Create(ctx, device)
 		if err != nil {
 			logger.Err(fmt.Sprintf("Error while creating device in database during registration: %s", err.Error()))
+			releaseClaim()
 			return nil, errors.New(err.Error())
 		}
 	} else {
-		device.Token = token
+		device.Token = encryptedToken
+		device.PreviousToken = nil
+		device.PreviousTokenExpiresAt = nil
 
-		_, err = gorm.G[models.Device](h.db).Updates(ctx, *device)
+		err = h.db.Model(device).Select("Token", "PreviousToken", "PreviousTokenExpiresAt").Updates(device).Error
 		if err != nil {
 			logger.Err(fmt.Sprintf("Error while updating device in database during relink: %s", err.Error()))
+			releaseClaim()
 			return nil, errors.New(err.Error())
 		}
 	}
@@ -122,15 +211,25 @@ func (h *WebsocketHandler) registerWithPin(pin uint, device *models.Device) (*mo
 		"id":    device.ID,
 		"token": token,
 	}
-	sendMessage(conn.ws, websocketMessage{Command: command, Data: data})
+	conn.sendMessage(websocketMessage{Command: command, Data: data})
 
 	conn.mu.Lock()
+	flowData, _ := conn.stateFlow.(registrationFlowData)
 	conn.state = 0
 	conn.stateFlow = nil
 	conn.mu.Unlock()
+	if flowData.claimToken != "" {
+		h.releaseClaimToken(flowData.claimToken, pin)
+	}
 
-	delete(h.registrationPins, pin)
+	h.presence.publish(PresenceEvent{
+		Type:         presenceRegistrationFinished,
+		DeviceID:     &device.ID,
+		ConnectionID: conn.connectionID,
+		Pin:          &pin,
+	})
 
+	metrics.DeviceRegistrations.WithLabelValues(registrationType).Inc()
 	logger.Info(fmt.Sprintf("Registered new device with ID %d", device.ID))
 
 	return device, nil
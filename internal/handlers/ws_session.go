@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strconv"
 	"time"
 
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
 	models "github.com/CLDWare/schoolbox-backend/pkg/db"
 	"github.com/CLDWare/schoolbox-backend/pkg/logger"
 	"gorm.io/gorm"
@@ -69,14 +71,14 @@ func sessionFlow(conn *websocketConnection, message websocketMessage) error {
 			conn.mu.RUnlock()
 			errCode := 0
 			errMsg := fmt.Sprintf("Can not vote while not in session. current state %d, only state 4 is allowed", conn.state)
-			sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
 			return nil
 		}
 		conn.mu.RUnlock()
 
 		message, parseErr := toSessionVoteMessage(message)
 		if parseErr != nil {
-			sendMessage(conn.ws, parseErr)
+			conn.sendMessage(parseErr)
 			return nil
 		}
 
@@ -84,12 +86,13 @@ func sessionFlow(conn *websocketConnection, message websocketMessage) error {
 		if !ok {
 			errCode := -1
 			errMsg := fmt.Sprintf("Fatal: Invalid stateFlow type of %T, not sessionFlowData", conn.stateFlow)
-			sendMessage(conn.ws, websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
 			logger.Err(errMsg)
-			conn.close()
+			conn.closeWithReason(disconnectInternalError)
 			return errors.New(errMsg)
 		}
 
+		metrics.Votes.WithLabelValues(strconv.Itoa(int(message.Vote))).Inc()
 		column := fmt.Sprintf("A%d_count", message.Vote)
 		expr := gorm.Expr(fmt.Sprintf("%s + 1", column))
 		conn.handler.db.Model(&models.Session{}).Where("id = ?", flowData.sessionID).UpdateColumn(column, expr)
@@ -110,6 +113,7 @@ func sessionFlow(conn *websocketConnection, message websocketMessage) error {
 
 var ErrDeviceNotConnected = errors.New("Device is currently connected, can not start session")
 
+// startSession starts a session on a device, on whichever instance it is connected to
 func (h *WebsocketHandler) startSession(userID uint, deviceID uint, questionStr string) (*models.Session, error) {
 	ctx := context.Background()
 
@@ -123,19 +127,12 @@ func (h *WebsocketHandler) startSession(userID uint, deviceID uint, questionStr
 		return nil, err
 	}
 
-	h.mu.RLock()
-	connID, ok := h.connectedDevices[deviceID]
-	if !ok {
-		h.mu.RUnlock()
-		return nil, ErrDeviceNotConnected
+	conn, record, err := h.routeToDevice(deviceID)
+	if err != nil {
+		return nil, err
 	}
-	conn, ok := h.connections[connID]
-	h.mu.RUnlock()
-	if !ok {
-		err := fmt.Errorf("Connection %d for device %d does not exist", connID, deviceID)
-		h.mu.Lock()
-		delete(h.connectedDevices, deviceID) // remove device from connectedDevices map because the connection no longer exists
-		h.mu.Unlock()
+	device, err := gorm.G[models.Device](h.db).Where("id = ?", deviceID).First(ctx)
+	if err != nil {
 		return nil, err
 	}
 
@@ -144,67 +141,110 @@ func (h *WebsocketHandler) startSession(userID uint, deviceID uint, questionStr
 		QuestionID: question.ID,
 		Question:   question,
 		DeviceID:   deviceID,
+		RoomID:     device.RoomID,
 		Date:       time.Now(),
 	}
-	err := gorm.G[models.Session](h.db).Create(ctx, &session)
+	err = gorm.G[models.Session](h.db).Create(ctx, &session)
 	if err != nil {
 		return nil, err
 	}
-	flowData := sessionFlowData{
-		sessionID: session.ID,
-	}
-	conn.mu.Lock()
-	conn.state = 4
-	conn.stateFlow = flowData
-	conn.mu.Unlock()
 
-	device, err := gorm.G[models.Device](h.db).Where("id = ?", deviceID).First(ctx)
+	if conn != nil {
+		err = h.activateSession(conn, session.ID, question.Question)
+	} else {
+		err = h.callInstance(record, rpcSessionStart, rpcSessionStartRequest{
+			DeviceID:  deviceID,
+			SessionID: session.ID,
+			Question:  question.Question,
+		}, nil)
+	}
 	if err != nil {
+		// The device never got the session, so it should not exist
+		if _, deleteErr := gorm.G[models.Session](h.db).Where("id = ?", session.ID).Delete(ctx); deleteErr != nil {
+			logger.Err(fmt.Sprintf("Could not delete session %d that failed to start: %s", session.ID, deleteErr.Error()))
+		}
 		return nil, err
 	}
+
 	device.ActiveSessionID = &session.ID
 	_, err = gorm.G[models.Device](h.db).Updates(ctx, device)
 	if err != nil {
 		return nil, err
 	}
 
+	return &session, nil
+}
+
+// activateSession puts a connection of this instance in session and tells the device
+func (h *WebsocketHandler) activateSession(conn *websocketConnection, sessionID uint, question string) error {
+	flowData := sessionFlowData{
+		sessionID: sessionID,
+	}
+	conn.mu.Lock()
+	conn.state = 4
+	conn.stateFlow = flowData
+	conn.mu.Unlock()
+	h.updateConnectionRecord(conn)
+	h.publishConnectionState(conn, presenceDeviceState)
+
 	command := "session_start"
 	data := map[string]any{
-		"text": question.Question,
+		"text": question,
 	}
-	sendMessage(conn.ws, websocketMessage{
+	// A failed write closes the connection, so the state does not have to be reverted
+	return conn.sendMessageAndWait(websocketMessage{
 		Command: command,
 		Data:    data,
 	})
+}
 
-	return &session, nil
+// resumeSession puts a device that just authenticated back in its active session, like after a server restart
+func (h *WebsocketHandler) resumeSession(conn *websocketConnection, device *models.Device) {
+	if device.ActiveSessionID == nil {
+		return
+	}
+	session, err := gorm.G[models.Session](h.db).
+		Preload("Question", nil).
+		Where("id = ? AND stopped_at IS NULL", *device.ActiveSessionID).
+		First(context.Background())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+	if err != nil {
+		logger.Err(fmt.Sprintf("Could not load session %d of device %d: %s", *device.ActiveSessionID, device.ID, err.Error()))
+		return
+	}
+
+	if err := h.activateSession(conn, session.ID, session.Question.Question); err != nil {
+		logger.Warn(fmt.Sprintf("Could not resume session %d on device %d: %s", session.ID, device.ID, err.Error()))
+		return
+	}
+	logger.Info(fmt.Sprintf("Resumed session %d on device %d", session.ID, device.ID))
 }
 
+// stopSession stops a session on its device, on whichever instance it is connected to
 func (h *WebsocketHandler) stopSession(session *models.Session) error {
-	h.mu.RLock()
-	connID, ok := h.connectedDevices[session.DeviceID]
-	if !ok {
-		return ErrDeviceNotConnected
-	}
-	conn, ok := h.connections[connID]
-	h.mu.RUnlock()
-	if !ok {
-		err := fmt.Errorf("Connection %d for device %d does not exist", connID, session.DeviceID)
-		h.mu.Lock()
-		delete(h.connectedDevices, session.DeviceID) // remove device from connectedDevices map because the connection no longer exists
-		h.mu.Unlock()
+	conn, record, err := h.routeToDevice(session.DeviceID)
+	if err != nil {
 		return err
 	}
+	if conn == nil {
+		return h.callInstance(record, rpcSessionStop, rpcDeviceRequest{DeviceID: session.DeviceID}, nil)
+	}
+	return h.deactivateSession(conn)
+}
 
+// deactivateSession takes a connection of this instance out of its session and tells the device
+func (h *WebsocketHandler) deactivateSession(conn *websocketConnection) error {
 	conn.mu.Lock()
 	conn.state = 3
 	conn.stateFlow = nil
 	conn.mu.Unlock()
+	h.updateConnectionRecord(conn)
+	h.publishConnectionState(conn, presenceDeviceState)
 
 	command := "session_stop"
-	sendMessage(conn.ws, websocketMessage{
+	return conn.sendMessageAndWait(websocketMessage{
 		Command: command,
 	})
-
-	return nil
 }
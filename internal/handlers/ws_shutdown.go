@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/gorilla/websocket"
+)
+
+// Shutdown tells every connection of this instance that the server is going away and when to reconnect, then closes
+// them. Sessions are left running, devices resume them once they authenticated again. It waits until the messages
+// that were being handled, like votes, are stored or ctx is done.
+func (h *WebsocketHandler) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.shuttingDown = true
+	conns := make([]*websocketConnection, 0, len(h.connections))
+	for _, conn := range h.connections {
+		conns = append(conns, conn)
+	}
+	h.mu.Unlock()
+
+	logger.Info(fmt.Sprintf("Closing %d websocket connections", len(conns)))
+	delay := h.config.Server.ShutdownReconnectDelay
+	for _, conn := range conns {
+		// Spread the reconnects over twice the delay, so the devices do not all come back at once
+		reconnectAfter := delay + time.Duration(rand.Int64N(int64(delay)+1))
+		command := "server_shutdown"
+		data := map[string]any{
+			"reconnect_after": int(math.Ceil(reconnectAfter.Seconds())),
+		}
+		conn.sendMessage(websocketMessage{Command: command, Data: data})
+		conn.closeWithCode(disconnectShutdown, websocket.CloseServiceRestart, "Server is shutting down")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.readLoops.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
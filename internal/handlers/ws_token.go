@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+)
+
+func triggersTokenFlow(message *websocketMessage) bool {
+	for _, value := range [2]string{"token_rotate", "token_ack"} {
+		if value == message.Command {
+			return true
+		}
+	}
+	return false
+}
+
+func tokenFlow(conn *websocketConnection, message websocketMessage) error {
+	conn.mu.RLock()
+	if conn.state < 3 || conn.deviceID == nil {
+		conn.mu.RUnlock()
+		errCode := 0
+		errMsg := fmt.Sprintf("Can not use '%s' in current state %d, authenticate first", message.Command, conn.state)
+		conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
+		return nil
+	}
+	deviceID := *conn.deviceID
+	conn.mu.RUnlock()
+
+	switch message.Command {
+	case "token_rotate":
+		err := conn.handler.rotateToken(conn, deviceID)
+		if errors.Is(err, ErrTokenRotationPending) {
+			errCode := 0
+			errMsg := "Can not rotate token before the previous new token is acknowledged with 'token_ack'"
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
+		} else if err != nil {
+			errCode := -1
+			errMsg := "Could not rotate token"
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
+			logger.Err(fmt.Sprintf("Could not rotate token of device %d: %s", deviceID, err.Error()))
+		}
+	case "token_ack":
+		signatureStr, ok := message.Data["signature"].(string)
+		if !ok {
+			errCode := 0
+			errMsg := "No data field 'signature'"
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // bad request
+			return nil
+		}
+		signature, err := hex.DecodeString(signatureStr)
+		if err != nil {
+			errCode := 3
+			errMsg := "Invalid signature encoding."
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid auth data
+			return nil
+		}
+
+		// Every token_new can be acknowledged once, so the signature can not be guessed
+		conn.mu.Lock()
+		nonce := conn.tokenAckNonce
+		conn.tokenAckNonce = ""
+		conn.mu.Unlock()
+		if nonce == "" {
+			errCode := 0
+			errMsg := "No new token to acknowledge on this connection"
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
+			return nil
+		}
+
+		err = conn.handler.acknowledgeToken(deviceID, nonce, signature)
+		if errors.Is(err, ErrInvalidTokenAck) {
+			errCode := 3
+			errMsg := "Invalid signature."
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid auth data
+			return nil
+		} else if errors.Is(err, ErrNoTokenRotation) {
+			errCode := 0
+			errMsg := "No new token to acknowledge"
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // invalid state
+			return nil
+		} else if err != nil {
+			errCode := -1
+			errMsg := "Could not acknowledge token"
+			conn.sendMessage(websocketErrorMessage{ErrorCode: errCode, Info: &errMsg}) // internal server error
+			logger.Err(fmt.Sprintf("Could not clear previous token of device %d: %s", deviceID, err.Error()))
+			return nil
+		}
+		logger.Info(fmt.Sprintf("Device %d acknowledged its new token", deviceID))
+	default:
+		err := fmt.Errorf("Invalid command '%s' reached tokenFlow", message.Command)
+		logger.Err(err)
+		return err
+	}
+	return nil
+}
+
+var (
+	// ErrTokenRotationPending is returned when a token is rotated before the device acknowledged the previous rotation
+	ErrTokenRotationPending = errors.New("Previous token rotation has not been acknowledged yet")
+	// ErrNoTokenRotation is returned when a token is acknowledged while there is no rotation to acknowledge
+	ErrNoTokenRotation = errors.New("No token rotation to acknowledge")
+	// ErrInvalidTokenAck is returned when a token acknowledgement is not signed with the new token
+	ErrInvalidTokenAck = errors.New("Token acknowledgement is not signed with the new token")
+)
+
+// rotateToken issues a new token to the device on conn. The previous token stays valid for the rotation grace period.
+// The device acknowledges the new token by signing the nonce sent with it, like it signs the nonce of auth_validate.
+func (h *WebsocketHandler) rotateToken(conn *websocketConnection, deviceID uint) error {
+	token, err := generateSecureToken(128)
+	if err != nil {
+		return err
+	}
+	encryptedToken, err := h.tokenCipher.Encrypt(token)
+	if err != nil {
+		return err
+	}
+	nonce, err := generateNonce()
+	if err != nil {
+		return err
+	}
+
+	graceEnd, err := h.storeRotatedToken(deviceID, encryptedToken)
+	if err != nil {
+		return err
+	}
+
+	conn.mu.Lock()
+	conn.tokenAckNonce = nonce
+	conn.mu.Unlock()
+	command := "token_new"
+	data := map[string]any{
+		"token":        token,
+		"nonce":        nonce,
+		"grace_period": int(h.config.DeviceToken.RotationGrace.Seconds()),
+	}
+	if err := conn.sendMessageAndWait(websocketMessage{Command: command, Data: data}); err != nil {
+		// The device did not get the new token, it would be locked out once the grace period of its token ends
+		if restoreErr := h.restoreRotatedToken(deviceID, encryptedToken); restoreErr != nil {
+			logger.Err(fmt.Sprintf("Could not restore previous token of device %d: %s", deviceID, restoreErr.Error()))
+		}
+		return err
+	}
+	logger.Info(fmt.Sprintf("Rotated token of device %d, previous token valid until %s", deviceID, graceEnd))
+	return nil
+}
+
+// storeRotatedToken replaces the token of a device with encryptedToken and keeps the replaced token as previous token
+// until the returned end of the grace period.
+//
+// ErrTokenRotationPending is returned while the previous token of an earlier rotation is still accepted. Replacing it
+// could drop the only token the device has, when the device did not receive the token of the earlier rotation.
+func (h *WebsocketHandler) storeRotatedToken(deviceID uint, encryptedToken string) (time.Time, error) {
+	var device models.Device
+	if err := h.db.Where("id = ?", deviceID).First(&device).Error; err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	graceEnd := now.Add(h.config.DeviceToken.RotationGrace)
+	// Conditional, so two rotations at the same time can not both succeed
+	result := h.db.Model(&models.Device{}).
+		Where("id = ? AND token = ?", deviceID, device.Token).
+		Where("previous_token IS NULL OR previous_token_expires_at IS NULL OR previous_token_expires_at <= ?", now).
+		Updates(map[string]any{
+			"token":                     encryptedToken,
+			"previous_token":            device.Token,
+			"previous_token_expires_at": graceEnd,
+			"token_rotated_at":          now,
+		})
+	if result.Error != nil {
+		return time.Time{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return time.Time{}, ErrTokenRotationPending
+	}
+	return graceEnd, nil
+}
+
+// restoreRotatedToken undoes the rotation to encryptedToken, the previous token becomes the token again.
+// Nothing changes when the token was replaced since, e.g. because the device registered again.
+func (h *WebsocketHandler) restoreRotatedToken(deviceID uint, encryptedToken string) error {
+	var device models.Device
+	if err := h.db.Where("id = ?", deviceID).First(&device).Error; err != nil {
+		return err
+	}
+	if device.Token != encryptedToken || device.PreviousToken == nil {
+		return nil
+	}
+
+	return h.db.Model(&models.Device{}).
+		Where("id = ? AND token = ? AND previous_token = ?", deviceID, encryptedToken, *device.PreviousToken).
+		Updates(map[string]any{
+			"token":                     *device.PreviousToken,
+			"previous_token":            nil,
+			"previous_token_expires_at": nil,
+		}).Error
+}
+
+// acknowledgeToken stops accepting the previous token of a device once it proved to have the new token,
+// signature is the signature of nonce with the new token.
+func (h *WebsocketHandler) acknowledgeToken(deviceID uint, nonce string, signature []byte) error {
+	var device models.Device
+	if err := h.db.Where("id = ?", deviceID).First(&device).Error; err != nil {
+		return err
+	}
+	if device.PreviousToken == nil {
+		return ErrNoTokenRotation
+	}
+	token, err := h.tokenCipher.Decrypt(device.Token)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(signature, signNonce(token, nonce)) {
+		return ErrInvalidTokenAck
+	}
+
+	// Conditional, the token may have been rotated or restored since it was read
+	result := h.db.Model(&models.Device{}).
+		Where("id = ? AND token = ? AND previous_token IS NOT NULL", deviceID, device.Token).
+		Updates(map[string]any{"previous_token": nil, "previous_token_expires_at": nil})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNoTokenRotation
+	}
+	return nil
+}
+
+// rotateDeviceToken rotates the token of a connected device, on whichever instance it is connected to
+func (h *WebsocketHandler) rotateDeviceToken(deviceID uint) error {
+	conn, record, err := h.routeToDevice(deviceID)
+	if err != nil {
+		return err
+	}
+	if conn == nil {
+		return h.callInstance(record, rpcTokenRotate, rpcDeviceRequest{DeviceID: deviceID}, nil)
+	}
+	return h.rotateToken(conn, deviceID)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestStoreRotatedTokenTwice(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Device{}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{DeviceToken: config.DeviceTokenConfig{RotationGrace: time.Hour}}
+	h := &WebsocketHandler{config: cfg, db: db}
+
+	device := models.Device{Token: "original"}
+	if err := db.Create(&device).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.storeRotatedToken(device.ID, "first"); err != nil {
+		t.Fatalf("first rotation failed: %s", err)
+	}
+	// The device did not acknowledge the first token, it may only have the original token
+	if _, err := h.storeRotatedToken(device.ID, "second"); !errors.Is(err, ErrTokenRotationPending) {
+		t.Fatalf("expected ErrTokenRotationPending for the second rotation, got %v", err)
+	}
+
+	var stored models.Device
+	if err := db.First(&stored, device.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Token != "first" {
+		t.Errorf("expected token 'first', got '%s'", stored.Token)
+	}
+	if stored.PreviousToken == nil || *stored.PreviousToken != "original" {
+		t.Errorf("expected the original token to still be accepted, got previous token %v", stored.PreviousToken)
+	}
+
+	// After the token_ack the token can be rotated again
+	if err := db.Model(&stored).Updates(map[string]any{"previous_token": nil, "previous_token_expires_at": nil}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.storeRotatedToken(device.ID, "second"); err != nil {
+		t.Fatalf("rotation after acknowledgement failed: %s", err)
+	}
+	if err := db.First(&stored, device.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Token != "second" || stored.PreviousToken == nil || *stored.PreviousToken != "first" {
+		t.Errorf("expected token 'second' with previous token 'first', got '%s' and %v", stored.Token, stored.PreviousToken)
+	}
+}
+
+func TestRotateTokenSendFailure(t *testing.T) {
+	h := newTestWebsocketHandler(t, nil)
+	encryptedToken, err := h.tokenCipher.Encrypt("original")
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := models.Device{Token: encryptedToken}
+	if err := h.db.Create(&device).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The device is gone before it gets the new token
+	_, conn := connectTestDevice(t, h)
+	conn.closeWithReason(disconnectReadError)
+	if err := h.rotateToken(conn, device.ID); err == nil {
+		t.Fatal("expected the rotation to fail")
+	}
+
+	var stored models.Device
+	if err := h.db.First(&stored, device.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.PreviousToken != nil || stored.PreviousTokenExpiresAt != nil {
+		t.Errorf("expected no previous token, got %v until %v", stored.PreviousToken, stored.PreviousTokenExpiresAt)
+	}
+	nonce := "nonce"
+	valid, usedPreviousToken, err := h.verifyDeviceSignature(&stored, nonce, signNonce("original", nonce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid || usedPreviousToken {
+		t.Errorf("expected the device to authenticate with its token, valid %t, used previous token %t", valid, usedPreviousToken)
+	}
+	if _, err := h.storeRotatedToken(device.ID, "next"); err != nil {
+		t.Errorf("expected the token to be rotatable again, got %s", err)
+	}
+}
+
+func TestAcknowledgeToken(t *testing.T) {
+	h := newTestWebsocketHandler(t, nil)
+	encryptedToken, err := h.tokenCipher.Encrypt("original")
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := models.Device{Token: encryptedToken}
+	if err := h.db.Create(&device).Error; err != nil {
+		t.Fatal(err)
+	}
+	nonce := "nonce"
+
+	if err := h.acknowledgeToken(device.ID, nonce, signNonce("original", nonce)); !errors.Is(err, ErrNoTokenRotation) {
+		t.Errorf("expected ErrNoTokenRotation without a rotation, got %v", err)
+	}
+
+	newToken, err := h.tokenCipher.Encrypt("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.storeRotatedToken(device.ID, newToken); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		nonce    string
+		expected error
+	}{
+		{name: "signed with the previous token", token: "original", nonce: nonce, expected: ErrInvalidTokenAck},
+		{name: "signed another nonce", token: "new", nonce: "other", expected: ErrInvalidTokenAck},
+		{name: "signed with the new token", token: "new", nonce: nonce, expected: nil},
+		{name: "acknowledged twice", token: "new", nonce: nonce, expected: ErrNoTokenRotation},
+	}
+	for _, tt := range tests {
+		err := h.acknowledgeToken(device.ID, nonce, signNonce(tt.token, tt.nonce))
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, err)
+		}
+
+		var stored models.Device
+		if err := h.db.First(&stored, device.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if acknowledged := stored.PreviousToken == nil; acknowledged != (tt.expected != ErrInvalidTokenAck) {
+			t.Errorf("%s: expected acknowledged %t, got previous token %v", tt.name, tt.expected != ErrInvalidTokenAck, stored.PreviousToken)
+		}
+	}
+}
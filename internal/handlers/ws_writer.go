@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrConnectionClosed is returned when a message is sent to a connection that closed before the message was written
+	ErrConnectionClosed = fmt.Errorf("%w: connection closed", ErrDeviceNotConnected)
+	// ErrSendQueueFull is returned when the send queue of a connection stayed full, the connection is closed
+	ErrSendQueueFull = fmt.Errorf("%w: send queue full", ErrDeviceNotConnected)
+)
+
+// outboundMessage is a message waiting in the send queue of a connection
+type outboundMessage struct {
+	data    []byte
+	written chan error // Receives the result of the write, nil when nobody waits for it
+}
+
+// startWriter starts the goroutine that owns all writes of data messages to the websocket.
+// gorilla/websocket allows only one concurrent writer, so everything is sent through the send queue.
+func (conn *websocketConnection) startWriter() {
+	conn.sendQueue = make(chan outboundMessage, conn.handler.config.Websocket.SendQueueSize)
+	conn.closing = make(chan struct{})
+	conn.writerDone = make(chan struct{})
+	go conn.writeLoop()
+}
+
+func (conn *websocketConnection) writeLoop() {
+	defer close(conn.writerDone)
+	defer conn.ws.Close()
+
+	for {
+		select {
+		case <-conn.closing:
+			conn.flushSendQueue()
+			conn.mu.RLock()
+			closeFrame := conn.closeFrame
+			conn.mu.RUnlock()
+			if closeFrame != nil {
+				conn.ws.WriteControl(websocket.CloseMessage, closeFrame, time.Now().Add(conn.handler.config.Websocket.WriteTimeout))
+			}
+			return
+		case message := <-conn.sendQueue:
+			err := conn.write(message, time.Now().Add(conn.handler.config.Websocket.WriteTimeout))
+			if err != nil {
+				logger.Err(fmt.Sprintf("Could not write to connection %d: %s", conn.connectionID, err.Error()))
+				conn.closeWithReason(disconnectWriteError)
+				return
+			}
+		}
+	}
+}
+
+func (conn *websocketConnection) write(message outboundMessage, deadline time.Time) error {
+	conn.ws.SetWriteDeadline(deadline)
+	err := conn.ws.WriteMessage(websocket.TextMessage, message.data)
+	if message.written != nil {
+		message.written <- err
+	}
+	return err
+}
+
+// flushSendQueue writes the messages that were queued before the connection closed, like the error telling a device
+// why it is disconnected. All of them together get a single write timeout.
+func (conn *websocketConnection) flushSendQueue() {
+	deadline := time.Now().Add(conn.handler.config.Websocket.WriteTimeout)
+	for {
+		select {
+		case message := <-conn.sendQueue:
+			if err := conn.write(message, deadline); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// enqueue adds a message to the send queue. When the queue is full it waits for room, if there is still no room after
+// the send queue timeout the device is not reading fast enough and the connection is closed.
+func (conn *websocketConnection) enqueue(message outboundMessage) error {
+	select {
+	case <-conn.closing:
+		return ErrConnectionClosed
+	default:
+	}
+
+	select {
+	case conn.sendQueue <- message:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(conn.handler.config.Websocket.SendQueueTimeout)
+	defer timer.Stop()
+	select {
+	case conn.sendQueue <- message:
+		return nil
+	case <-conn.closing:
+		return ErrConnectionClosed
+	case <-timer.C:
+		logger.Warn(fmt.Sprintf("Send queue of connection %d is full, closing slow connection", conn.connectionID))
+		metrics.WebsocketSlowConnections.Inc()
+		conn.closeWithReason(disconnectSlowConnection)
+		return ErrSendQueueFull
+	}
+}
+
+// sendMessage queues msg to be written to the connection, it does not wait for the write
+func (conn *websocketConnection) sendMessage(msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.Err("JSON marshal err: ", err)
+		return err
+	}
+	return conn.enqueue(outboundMessage{data: data})
+}
+
+// sendMessageAndWait queues msg and waits until it is written, for callers that have to know if the device got it
+func (conn *websocketConnection) sendMessageAndWait(msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.Err("JSON marshal err: ", err)
+		return err
+	}
+	written := make(chan error, 1)
+	if err := conn.enqueue(outboundMessage{data: data, written: written}); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-written:
+		return err
+	case <-conn.writerDone:
+		// The message may have been written while flushing the queue
+		select {
+		case err := <-written:
+			return err
+		default:
+			return ErrConnectionClosed
+		}
+	}
+}
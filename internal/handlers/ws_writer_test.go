@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/gorilla/websocket"
+)
+
+func TestSlowConnectionIsDisconnected(t *testing.T) {
+	h := newTestWebsocketHandler(t, func(cfg *config.Config) {
+		cfg.Websocket.SendQueueSize = 1
+		cfg.Websocket.SendQueueTimeout = 50 * time.Millisecond
+		cfg.Websocket.WriteTimeout = 10 * time.Second
+	})
+	// The client never reads, so the writes block once the socket buffers are full
+	_, conn := connectTestDevice(t, h)
+
+	message := websocketMessage{Command: "test", Data: map[string]any{"padding": strings.Repeat("a", 1<<20)}}
+	var err error
+	for range 200 {
+		if err = conn.sendMessage(message); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("expected ErrSendQueueFull, got %v", err)
+	}
+
+	conn.mu.RLock()
+	closed := conn.closed
+	conn.mu.RUnlock()
+	if !closed {
+		t.Error("expected the slow connection to be closed")
+	}
+	var event models.DeviceEvent
+	if err := h.db.Where("type = ?", deviceEventDisconnect).First(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+	if event.Reason != disconnectSlowConnection {
+		t.Errorf("expected disconnect reason %s, got %s", disconnectSlowConnection, event.Reason)
+	}
+}
+
+func TestSendMessageAndWaitAfterClose(t *testing.T) {
+	h := newTestWebsocketHandler(t, nil)
+	_, conn := connectTestDevice(t, h)
+
+	// Waited sends racing the close either got written while flushing or fail, none of them may hang
+	results := make(chan error)
+	for range 10 {
+		go func() {
+			results <- conn.sendMessageAndWait(websocketMessage{Command: "test"})
+		}()
+	}
+	conn.closeWithReason(disconnectKicked)
+	for range 10 {
+		select {
+		case err := <-results:
+			if err != nil && !errors.Is(err, ErrConnectionClosed) {
+				t.Errorf("expected nil or ErrConnectionClosed, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("waited send did not return after the connection closed")
+		}
+	}
+
+	select {
+	case <-conn.writerDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writer did not stop")
+	}
+	if err := conn.sendMessageAndWait(websocketMessage{Command: "test"}); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("expected ErrConnectionClosed, got %v", err)
+	}
+}
+
+func TestQueueIsFlushedBeforeCloseFrame(t *testing.T) {
+	h := newTestWebsocketHandler(t, nil)
+	client, conn := connectTestDevice(t, h)
+
+	// More than the socket buffers hold, so the messages are still queued when the connection closes
+	commands := []string{}
+	for range 24 {
+		commands = append(commands, "padding")
+	}
+	commands = append(commands, "first", "second")
+	for _, command := range commands {
+		message := websocketMessage{Command: command}
+		if command == "padding" {
+			message.Data = map[string]any{"padding": strings.Repeat("a", 1<<20)}
+		}
+		if err := conn.sendMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.closeWithCode(disconnectShutdown, websocket.CloseServiceRestart, "Server is shutting down")
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, expected := range commands {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("expected message '%s' before the close frame, got %v", expected, err)
+		}
+		var message websocketMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatal(err)
+		}
+		if message.Command != expected {
+			t.Fatalf("expected message '%s', got '%s'", expected, message.Command)
+		}
+	}
+	_, _, err := client.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Errorf("expected close frame with code %d, got %v", websocket.CloseServiceRestart, err)
+	}
+}
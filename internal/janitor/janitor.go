@@ -3,9 +3,12 @@ package janitor
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/devicetoken"
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
 	models "github.com/CLDWare/schoolbox-backend/pkg/db"
 	"github.com/CLDWare/schoolbox-backend/pkg/logger"
 	"gorm.io/gorm"
@@ -16,6 +19,7 @@ type Janitor struct {
 	database         *gorm.DB
 	announceNoAction bool
 	cancel           context.CancelFunc
+	done             chan struct{} // Closed when the cleaning loop stopped
 }
 
 func NewJanitor(cfg *config.Config, db *gorm.DB, announceNoAction bool) *Janitor {
@@ -29,8 +33,10 @@ func NewJanitor(cfg *config.Config, db *gorm.DB, announceNoAction bool) *Janitor
 func (jan *Janitor) Start() {
 	ctx, cancel := context.WithCancel(context.Background())
 	jan.cancel = cancel
+	jan.done = make(chan struct{})
 
 	go func() {
+		defer close(jan.done)
 		shortTicker := time.NewTicker(jan.cfg.Janitor.ShortCleanInterval)
 		defer shortTicker.Stop()
 		fullTicker := time.NewTicker(jan.cfg.Janitor.FullCleanInterval)
@@ -48,35 +54,54 @@ func (jan *Janitor) Start() {
 	}()
 }
 
+// Stop stops the janitor, it waits for a running cleaning sequence to finish
 func (jan *Janitor) Stop() {
 	if jan.cancel != nil {
 		jan.cancel()
 		jan.cancel = nil
+		<-jan.done
 	}
 }
 
 func (jan *Janitor) RunShort() {
 	logger.Info("Janitor: Running short cleaning sequence.")
+	defer observeRun("short", time.Now())
 	jan.CleanUpExpiredAuthSession()
-
+	jan.CleanUpExpiredPersonalTokens()
+	jan.CleanUpPreviousDeviceTokens()
+	jan.CleanUpOldDeviceEvents()
+	jan.CleanUpResolvedAlerts()
+	jan.CleanUpOldAuditEvents()
 }
 
 func (jan *Janitor) RunFull() {
 	logger.Info("Janitor: Running full cleaning sequence.")
+	defer observeRun("full", time.Now())
 	jan.RunShort()
 
+	jan.ReencryptDeviceTokens()
 	jan.DeepCleanDatabase(nil)
 }
 
-// DeepCleanDatabase forces gorm to delete all "deleted" entries
+// observeRun records the duration of a cleaning sequence that started at start
+func observeRun(sequence string, start time.Time) {
+	metrics.JanitorRunDuration.WithLabelValues(sequence).Observe(time.Since(start).Seconds())
+}
+
+// DeepCleanDatabase forces gorm to delete all "deleted" entries.
+//
+// Deleted users are never removed, their email address keeps them from logging in or being invited again, and their
+// sessions and audit events keep referring to them.
 func (jan *Janitor) DeepCleanDatabase(deepcleanModels *[]any) {
 	if deepcleanModels == nil {
 		deepcleanModels = &[]any{
 			models.Device{},
-			models.User{},
 			models.AuthSession{},
+			models.PersonalAccessToken{},
 			models.Question{},
 			models.Session{},
+			models.DeviceEvent{},
+			models.Alert{},
 		}
 	}
 	for _, deepcleanModel := range *deepcleanModels {
@@ -84,6 +109,7 @@ func (jan *Janitor) DeepCleanDatabase(deepcleanModels *[]any) {
 		if result.Error != nil {
 			logger.Err(fmt.Sprintf("Janitor: Error while deepcleaning model %t: %s", deepcleanModel, result.Error.Error()))
 		} else {
+			metrics.JanitorRowsDeleted.WithLabelValues("deep_clean", reflect.TypeOf(deepcleanModel).Name()).Add(float64(result.RowsAffected))
 			if jan.announceNoAction || result.RowsAffected != 0 {
 				logger.Info(fmt.Sprintf("Janitor: Deleted %d rows from model %T", result.RowsAffected, deepcleanModel))
 			}
@@ -99,5 +125,141 @@ func (jan *Janitor) CleanUpExpiredAuthSession() {
 	if err != nil {
 		return
 	}
+	metrics.JanitorRowsDeleted.WithLabelValues("expired_auth_sessions", "AuthSession").Add(float64(sessionsDeleted))
 	logger.Info(fmt.Sprintf("Janitor: cleaned %d expired auth sessions", sessionsDeleted))
 }
+
+// CleanUpExpiredPersonalTokens deletes personal access tokens that have expired
+func (jan *Janitor) CleanUpExpiredPersonalTokens() {
+	ctx := context.Background()
+
+	tokensDeleted, err := gorm.G[models.PersonalAccessToken](jan.database).Where("expires_at < ?", time.Now()).Delete(ctx)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Janitor: Error while cleaning expired personal access tokens: %s", err.Error()))
+		return
+	}
+	metrics.JanitorRowsDeleted.WithLabelValues("expired_personal_tokens", "PersonalAccessToken").Add(float64(tokensDeleted))
+	if jan.announceNoAction || tokensDeleted != 0 {
+		logger.Info(fmt.Sprintf("Janitor: cleaned %d expired personal access tokens", tokensDeleted))
+	}
+}
+
+// CleanUpOldDeviceEvents deletes device events older than the configured retention
+func (jan *Janitor) CleanUpOldDeviceEvents() {
+	ctx := context.Background()
+
+	eventsDeleted, err := gorm.G[models.DeviceEvent](jan.database).Where("created_at < ?", time.Now().Add(-jan.cfg.Janitor.DeviceEventRetention)).Delete(ctx)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Janitor: Error while cleaning old device events: %s", err.Error()))
+		return
+	}
+	metrics.JanitorRowsDeleted.WithLabelValues("old_device_events", "DeviceEvent").Add(float64(eventsDeleted))
+	if jan.announceNoAction || eventsDeleted != 0 {
+		logger.Info(fmt.Sprintf("Janitor: cleaned %d old device events", eventsDeleted))
+	}
+}
+
+// CleanUpResolvedAlerts deletes alerts that were resolved longer ago than the configured retention
+func (jan *Janitor) CleanUpResolvedAlerts() {
+	ctx := context.Background()
+
+	alertsDeleted, err := gorm.G[models.Alert](jan.database).Where("resolved_at < ?", time.Now().Add(-jan.cfg.Janitor.AlertRetention)).Delete(ctx)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Janitor: Error while cleaning resolved alerts: %s", err.Error()))
+		return
+	}
+	metrics.JanitorRowsDeleted.WithLabelValues("resolved_alerts", "Alert").Add(float64(alertsDeleted))
+	if jan.announceNoAction || alertsDeleted != 0 {
+		logger.Info(fmt.Sprintf("Janitor: cleaned %d resolved alerts", alertsDeleted))
+	}
+}
+
+// CleanUpOldAuditEvents deletes audit events older than the configured retention, the only way they are ever removed
+func (jan *Janitor) CleanUpOldAuditEvents() {
+	ctx := context.Background()
+
+	eventsDeleted, err := gorm.G[models.AuditEvent](jan.database).Where("created_at < ?", time.Now().Add(-jan.cfg.Janitor.AuditRetention)).Delete(ctx)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Janitor: Error while cleaning old audit events: %s", err.Error()))
+		return
+	}
+	metrics.JanitorRowsDeleted.WithLabelValues("old_audit_events", "AuditEvent").Add(float64(eventsDeleted))
+	if jan.announceNoAction || eventsDeleted != 0 {
+		logger.Info(fmt.Sprintf("Janitor: cleaned %d old audit events", eventsDeleted))
+	}
+}
+
+// CleanUpPreviousDeviceTokens forgets previous device tokens whose rotation grace period has ended
+func (jan *Janitor) CleanUpPreviousDeviceTokens() {
+	result := jan.database.Model(&models.Device{}).
+		Where("previous_token_expires_at < ?", time.Now()).
+		Updates(map[string]any{"previous_token": nil, "previous_token_expires_at": nil})
+	if result.Error != nil {
+		logger.Err(fmt.Sprintf("Janitor: Error while cleaning previous device tokens: %s", result.Error.Error()))
+		return
+	}
+	if jan.announceNoAction || result.RowsAffected != 0 {
+		logger.Info(fmt.Sprintf("Janitor: cleaned %d expired previous device tokens", result.RowsAffected))
+	}
+}
+
+// ReencryptDeviceTokens encrypts all device tokens that are stored in plaintext or with an old server key with the current key
+func (jan *Janitor) ReencryptDeviceTokens() {
+	tokenCipher, err := devicetoken.New(jan.cfg.DeviceToken.EncryptionKeys)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Janitor: Could not create device token cipher: %s", err.Error()))
+		return
+	}
+	if !tokenCipher.Enabled() {
+		return
+	}
+
+	var devices []models.Device
+	if err := jan.database.Find(&devices).Error; err != nil {
+		logger.Err(fmt.Sprintf("Janitor: Error while retrieving devices for token re-encryption: %s", err.Error()))
+		return
+	}
+
+	reencrypted := 0
+	for _, device := range devices {
+		updates := map[string]any{}
+		if tokenCipher.NeedsReencryption(device.Token) {
+			token, err := tokenCipher.Reencrypt(device.Token)
+			if err != nil {
+				logger.Err(fmt.Sprintf("Janitor: Could not re-encrypt token of device %d: %s", device.ID, err.Error()))
+				continue
+			}
+			updates["token"] = token
+		}
+		if device.PreviousToken != nil && tokenCipher.NeedsReencryption(*device.PreviousToken) {
+			previousToken, err := tokenCipher.Reencrypt(*device.PreviousToken)
+			if err != nil {
+				logger.Err(fmt.Sprintf("Janitor: Could not re-encrypt previous token of device %d: %s", device.ID, err.Error()))
+				continue
+			}
+			updates["previous_token"] = previousToken
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		// Only when the tokens did not change since they were read, a token rotated in the mean time must not be undone
+		query := jan.database.Model(&models.Device{}).Where("id = ? AND token = ?", device.ID, device.Token)
+		if device.PreviousToken == nil {
+			query = query.Where("previous_token IS NULL")
+		} else {
+			query = query.Where("previous_token = ?", *device.PreviousToken)
+		}
+		result := query.Updates(updates)
+		if result.Error != nil {
+			logger.Err(fmt.Sprintf("Janitor: Could not store re-encrypted token of device %d: %s", device.ID, result.Error.Error()))
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue // Re-encrypted on the next run
+		}
+		reencrypted++
+	}
+	if jan.announceNoAction || reencrypted != 0 {
+		logger.Info(fmt.Sprintf("Janitor: re-encrypted tokens of %d devices", reencrypted))
+	}
+}
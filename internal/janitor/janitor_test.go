@@ -0,0 +1,57 @@
+package janitor
+
+import (
+	"testing"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestDeepCleanKeepsDeletedUsers(t *testing.T) {
+	logger.Init()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&models.Device{}, &models.User{}, &models.AuthSession{}, &models.PersonalAccessToken{},
+		&models.Question{}, &models.Session{}, &models.DeviceEvent{}, &models.Alert{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleted with session_data=keep, the email address stays
+	user := models.User{Email: "teacher@school.nl", Name: "Teacher"}
+	device := models.Device{Token: "token"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&device).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&device).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	NewJanitor(&config.Config{}, db, false).DeepCleanDatabase(nil)
+
+	var deletedUser models.User
+	if err := db.Unscoped().Where("email = ?", user.Email).First(&deletedUser).Error; err != nil {
+		t.Fatalf("expected the deleted user to survive a full clean: %s", err)
+	}
+	if !deletedUser.DeletedAt.Valid {
+		t.Error("expected the user to still be deleted")
+	}
+	var deviceCount int64
+	if err := db.Unscoped().Model(&models.Device{}).Where("id = ?", device.ID).Count(&deviceCount).Error; err != nil {
+		t.Fatal(err)
+	}
+	if deviceCount != 0 {
+		t.Error("expected the deleted device to be removed")
+	}
+}
@@ -0,0 +1,127 @@
+// Package loginstate protects the login flow against login CSRF.
+//
+// A login starts by storing a signed State in a cookie and sending its Value to the provider as the OAuth state
+// parameter. The callback only accepts a code when the state the provider redirects back with matches the cookie of
+// the browser that started the login, and exchanges the code with the PKCE verifier from that cookie.
+package loginstate
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// CookieName is the name of the cookie the state is stored in between the login and the callback
+const CookieName = "login_state"
+
+var (
+	ErrInvalid  = errors.New("invalid login state")
+	ErrExpired  = errors.New("login state expired")
+	ErrMismatch = errors.New("login state does not match")
+)
+
+// State is a login that was started but did not return from the provider yet
+type State struct {
+	Provider  string `json:"p"`
+	Value     string `json:"s"` // Random value sent to the provider as the OAuth state parameter
+	Verifier  string `json:"v"` // PKCE code verifier
+	ReturnTo  string `json:"r"` // Where to redirect after the login
+	ExpiresAt int64  `json:"e"`
+}
+
+// New returns the state of a new login with provider
+func New(provider string, returnTo string, ttl time.Duration) State {
+	return State{
+		Provider:  provider,
+		Value:     rand.Text(),
+		Verifier:  oauth2.GenerateVerifier(),
+		ReturnTo:  returnTo,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}
+}
+
+// Verify checks that the state the provider redirected back with for provider belongs to s
+func (s State) Verify(provider string, value string) error {
+	if s.Provider != provider || subtle.ConstantTimeCompare([]byte(s.Value), []byte(value)) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// Signer signs states so they can be stored in a cookie
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+func (s *Signer) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Encode returns state as a signed cookie value
+func (s *Signer) Encode(state State) (string, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.sign(payload), nil
+}
+
+// Decode returns the state in a cookie value, if it was signed by s and did not expire
+func (s *Signer) Decode(value string) (State, error) {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return State{}, ErrInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return State{}, ErrInvalid
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return State{}, ErrInvalid
+	}
+	if time.Now().Unix() > state.ExpiresAt {
+		return State{}, ErrExpired
+	}
+	return state, nil
+}
+
+// ValidReturnTo reports if users can be redirected to returnTo after a login.
+// Paths on this server are always allowed, absolute urls only when their origin is in allowedOrigins.
+func ValidReturnTo(returnTo string, allowedOrigins []string) bool {
+	// Browsers treat '\' as '/', so "/\evil.com" would be a protocol relative url
+	if strings.Contains(returnTo, `\`) {
+		return false
+	}
+	parsedURL, err := url.Parse(returnTo)
+	if err != nil {
+		return false
+	}
+	if parsedURL.Scheme == "" && parsedURL.Host == "" {
+		return strings.HasPrefix(returnTo, "/") && !strings.HasPrefix(returnTo, "//")
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" || parsedURL.User != nil {
+		return false
+	}
+	origin := parsedURL.Scheme + "://" + parsedURL.Host
+	return slices.ContainsFunc(allowedOrigins, func(allowed string) bool {
+		return strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin)
+	})
+}
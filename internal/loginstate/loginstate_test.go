@@ -0,0 +1,77 @@
+package loginstate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSigner_Decode(t *testing.T) {
+	signer := NewSigner([]byte("01234567890123456789012345678901"))
+	state := New("google", "/dashboard", time.Minute)
+
+	value, err := signer.Encode(state)
+	if err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+	decoded, err := signer.Decode(value)
+	if err != nil {
+		t.Fatalf("Decode() error: %v", err)
+	}
+	if decoded != state {
+		t.Errorf("expected %+v, got %+v", state, decoded)
+	}
+	if err := decoded.Verify("google", state.Value); err != nil {
+		t.Errorf("Verify() error: %v", err)
+	}
+	if err := decoded.Verify("google", "other"); err != ErrMismatch {
+		t.Errorf("expected ErrMismatch for another state value, got %v", err)
+	}
+	if err := decoded.Verify("mock", state.Value); err != ErrMismatch {
+		t.Errorf("expected ErrMismatch for another provider, got %v", err)
+	}
+
+	otherSigner := NewSigner([]byte("abcdefghijklmnopqrstuvwxyz012345"))
+	if _, err := otherSigner.Decode(value); err != ErrInvalid {
+		t.Errorf("expected ErrInvalid for another key, got %v", err)
+	}
+	if _, err := signer.Decode("x" + value); err != ErrInvalid {
+		t.Errorf("expected ErrInvalid for a tampered value, got %v", err)
+	}
+
+	expired, err := signer.Encode(New("google", "/", -time.Minute))
+	if err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+	if _, err := signer.Decode(expired); err != ErrExpired {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+}
+
+func TestValidReturnTo(t *testing.T) {
+	allowedOrigins := []string{"https://dashboard.school.nl", "http://localhost:5173/"}
+
+	tests := []struct {
+		returnTo string
+		expected bool
+	}{
+		{returnTo: "/", expected: true},
+		{returnTo: "/dev_device_admin_page.html?id=1", expected: true},
+		{returnTo: "https://dashboard.school.nl/sessions", expected: true},
+		{returnTo: "http://localhost:5173", expected: true},
+		{returnTo: "//evil.com", expected: false},
+		{returnTo: `/\evil.com`, expected: false},
+		{returnTo: "dashboard", expected: false},
+		{returnTo: "http://dashboard.school.nl/", expected: false},
+		{returnTo: "https://dashboard.school.nl.evil.com/", expected: false},
+		{returnTo: "https://user@dashboard.school.nl/", expected: false},
+		{returnTo: "javascript:alert(1)", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.returnTo, func(t *testing.T) {
+			if got := ValidReturnTo(test.returnTo, allowedOrigins); got != test.expected {
+				t.Errorf("ValidReturnTo(%q) = %v, expected %v", test.returnTo, got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "schoolbox"
+
+// Registry holds all metrics of this instance
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route pattern, websocket and event stream connections are not included.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	HeartbeatPingsSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_heartbeat_pings_sent_total",
+		Help:      "Heartbeat pings sent to devices.",
+	})
+	HeartbeatPongsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_heartbeat_pongs_received_total",
+		Help:      "Heartbeat pongs received from devices.",
+	})
+	WebsocketSlowConnections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_slow_connections_closed_total",
+		Help:      "Connections closed because their send queue stayed full.",
+	})
+	DeviceAuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "device_auth_failures_total",
+		Help:      "Failed device authentication attempts by reason.",
+	}, []string{"reason"})
+	DeviceRegistrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "device_registrations_total",
+		Help:      "Devices registered with a registration pin, type is new or relink.",
+	}, []string{"type"})
+	Votes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_votes_total",
+		Help:      "Votes received from devices in a session by vote value.",
+	}, []string{"vote"})
+
+	AlertsRaised = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alerts_raised_total",
+		Help:      "Alerts raised by alert type.",
+	}, []string{"type"})
+	AlertNotificationsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alert_notifications_failed_total",
+		Help:      "Alert notifications that could not be delivered by notifier.",
+	}, []string{"notifier"})
+
+	JanitorRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "janitor_run_duration_seconds",
+		Help:      "Duration of janitor cleaning sequences, sequence is short or full.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60},
+	}, []string{"sequence"})
+	JanitorRowsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "janitor_rows_deleted_total",
+		Help:      "Rows deleted by the janitor by task and model.",
+	}, []string{"task", "model"})
+)
+
+var connectionsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "websocket", "connections"),
+	"Current websocket connections of this instance by state.",
+	[]string{"state"}, nil,
+)
+
+// connectionCollector asks for the current connection count per state on every scrape
+type connectionCollector struct {
+	count func() map[string]int
+}
+
+func (c connectionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectionsDesc
+}
+
+func (c connectionCollector) Collect(ch chan<- prometheus.Metric) {
+	for state, connections := range c.count() {
+		ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(connections), state)
+	}
+}
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		HeartbeatPingsSent,
+		HeartbeatPongsReceived,
+		WebsocketSlowConnections,
+		DeviceAuthFailures,
+		DeviceRegistrations,
+		Votes,
+		AlertsRaised,
+		AlertNotificationsFailed,
+		JanitorRunDuration,
+		JanitorRowsDeleted,
+	)
+}
+
+// RegisterConnectionStates reports the websocket connections returned by count, count is called on every scrape
+func RegisterConnectionStates(count func() map[string]int) error {
+	return Registry.Register(connectionCollector{count: count})
+}
+
+// RegisterActiveSessions reports the sessions returned by count, count is called on every scrape
+func RegisterActiveSessions(count func() float64) error {
+	return Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Sessions that are currently running on all instances.",
+	}, count))
+}
+
+// Handler serves all metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
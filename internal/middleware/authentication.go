@@ -2,12 +2,20 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/authsession"
 	contextkeys "github.com/CLDWare/schoolbox-backend/internal/contextKeys"
+	"github.com/CLDWare/schoolbox-backend/internal/personaltoken"
+	"github.com/CLDWare/schoolbox-backend/internal/rbac"
 	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/CLDWare/schoolbox-backend/pkg/tokenhash"
 	"github.com/MonkyMars/gecho"
 	"gorm.io/gorm"
 )
@@ -24,12 +32,26 @@ func NewAuthenticationMiddleware(cfg *config.Config, db *gorm.DB) *Authenticatio
 	}
 }
 
+// lastUsedInterval is the minimum time between writes of the LastUsedAt of an auth session or personal access token,
+// auth sessions are also renewed at most this often
+const lastUsedInterval = time.Minute
+
 // AuthenticationMiddleware.Required checks if valid authentication is present and sets the contextkeys.AuthSessionKey, contextkeys.AuthUserKey values on the context (something like that)
+// Requests with an "Authorization: Bearer" header are authenticated with a personal access token instead of the
+// session cookie, these set contextkeys.AuthTokenKey instead of contextkeys.AuthSessionKey.
+// Sessions are renewed for AUTH_SESSION_IDLE_TIMEOUT, up to their absolute expiry.
+// Impersonation sessions also set contextkeys.ImpersonatorKey, and can only make safe requests unless the admin allowed
+// writes when starting the impersonation.
 func (mw AuthenticationMiddleware) Required(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth_session, err := r.Cookie("auth_session_token")
+		if authorization := r.Header.Get("Authorization"); authorization != "" {
+			mw.requiredToken(w, r, authorization, next)
+			return
+		}
+
+		auth_session, err := r.Cookie(authsession.CookieName)
 		if err == http.ErrNoCookie {
-			gecho.Unauthorized(w).WithMessage("'auth_session_token' cookie is required for authenticated requests").Send()
+			gecho.Unauthorized(w).WithMessage(fmt.Sprintf("'%s' cookie or 'Authorization' header is required for authenticated requests", authsession.CookieName)).Send()
 			return
 		} else if err != nil {
 			gecho.InternalServerError(w).Send()
@@ -37,18 +59,11 @@ func (mw AuthenticationMiddleware) Required(next func(w http.ResponseWriter, r *
 		}
 		ctx := r.Context()
 
-		cookie := http.Cookie{
-			Name:     "auth_session_token",
-			Value:    "",
-			Domain:   mw.config.Server.Host,
-			Path:     "/",
-			HttpOnly: true,
-			Expires:  time.Unix(0, 0),
-		}
+		cookie := authsession.ExpiredCookie(mw.config)
 
-		session, err := gorm.G[models.AuthSession](mw.db).Where("session_token = ?", auth_session.Value).First(ctx)
+		session, err := gorm.G[models.AuthSession](mw.db).Where("token_hash = ?", tokenhash.Hash(auth_session.Value)).First(ctx)
 		if err == gorm.ErrRecordNotFound {
-			http.SetCookie(w, &cookie)
+			http.SetCookie(w, cookie)
 			gecho.Unauthorized(w).WithMessage("Invalid or expired session").Send()
 			return
 		} else if err != nil {
@@ -56,21 +71,62 @@ func (mw AuthenticationMiddleware) Required(next func(w http.ResponseWriter, r *
 			return
 		}
 
-		if time.Now().After(session.ExpiresAt) {
-			http.SetCookie(w, &cookie)
+		now := time.Now()
+		if now.After(session.ExpiresAt) || now.After(session.AbsoluteExpiresAt) {
+			http.SetCookie(w, cookie)
 			gecho.Unauthorized(w).WithMessage("Invalid or expired session").Send()
 			return
 		}
 
 		user, err := gorm.G[models.User](mw.db).Where("id = ?", session.UserID).First(ctx)
 		if err == gorm.ErrRecordNotFound {
-			http.SetCookie(w, &cookie)
+			http.SetCookie(w, cookie)
 			gecho.Unauthorized(w).WithMessage("Invalid or expired session").Send()
 			return
 		} else if err != nil {
 			gecho.InternalServerError(w).Send()
 			return
 		}
+		if user.DeactivatedAt != nil {
+			http.SetCookie(w, cookie)
+			gecho.Unauthorized(w).WithMessage("Account is deactivated").Send()
+			return
+		}
+
+		if session.ImpersonatorID != nil {
+			impersonator, err := gorm.G[models.User](mw.db).Where("id = ?", *session.ImpersonatorID).First(ctx)
+			if err != nil && err != gorm.ErrRecordNotFound {
+				gecho.InternalServerError(w).Send()
+				return
+			}
+			// The impersonation ends when the admin is deleted, deactivated or may no longer impersonate
+			if err == gorm.ErrRecordNotFound || impersonator.DeactivatedAt != nil || !rbac.Has(impersonator.Role, rbac.UserImpersonate) {
+				http.SetCookie(w, cookie)
+				gecho.Unauthorized(w).WithMessage("Impersonation ended").Send()
+				return
+			}
+			if !session.ImpersonationAllowWrite && !slices.Contains([]string{http.MethodGet, http.MethodHead, http.MethodOptions}, r.Method) {
+				gecho.Forbidden(w).WithMessage("Impersonation is read only, start it with 'allow_write' to make changes as this user").Send()
+				return
+			}
+			ctx = context.WithValue(ctx, contextkeys.ImpersonatorKey, impersonator)
+		}
+
+		if session.LastUsedAt == nil || now.Sub(*session.LastUsedAt) >= lastUsedInterval {
+			expiresAt := now.Add(mw.config.OAuth.SessionIdleTimeout)
+			if expiresAt.After(session.AbsoluteExpiresAt) {
+				expiresAt = session.AbsoluteExpiresAt
+			}
+			err := mw.db.Model(&models.AuthSession{}).Where("id = ?", session.ID).
+				UpdateColumns(map[string]any{"last_used_at": now, "expires_at": expiresAt}).Error
+			if err != nil {
+				logger.Err(fmt.Sprintf("Could not renew auth session %d: %s", session.ID, err.Error()))
+			} else {
+				session.LastUsedAt = &now
+				session.ExpiresAt = expiresAt
+				http.SetCookie(w, authsession.Cookie(mw.config, auth_session.Value, expiresAt))
+			}
+		}
 
 		ctx = context.WithValue(ctx, contextkeys.AuthSessionKey, session)
 		ctx = context.WithValue(ctx, contextkeys.AuthUserKey, user)
@@ -79,18 +135,82 @@ func (mw AuthenticationMiddleware) Required(next func(w http.ResponseWriter, r *
 	}
 }
 
-func (mw AuthenticationMiddleware) RequiresAdmin(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
-	return mw.Required(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		user, ok := ctx.Value(contextkeys.AuthUserKey).(models.User)
-		if !ok {
-			gecho.InternalServerError(w).Send()
-			return
+// requiredToken authenticates a request with the personal access token in the Authorization header
+func (mw AuthenticationMiddleware) requiredToken(w http.ResponseWriter, r *http.Request, authorization string, next func(w http.ResponseWriter, r *http.Request)) {
+	ctx := r.Context()
+
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		gecho.Unauthorized(w).WithMessage("Invalid 'Authorization' header, expected 'Bearer <token>'").Send()
+		return
+	}
+	if !personaltoken.Valid(token) {
+		gecho.Unauthorized(w).WithMessage("Invalid or expired token").Send()
+		return
+	}
+
+	personalToken, err := gorm.G[models.PersonalAccessToken](mw.db).Preload("User", nil).Where("token_hash = ?", tokenhash.Hash(token)).First(ctx)
+	if err == gorm.ErrRecordNotFound {
+		gecho.Unauthorized(w).WithMessage("Invalid or expired token").Send()
+		return
+	} else if err != nil {
+		gecho.InternalServerError(w).Send()
+		return
+	}
+	// The user is not found when it was deleted
+	if time.Now().After(personalToken.ExpiresAt) || personalToken.User.ID == 0 {
+		gecho.Unauthorized(w).WithMessage("Invalid or expired token").Send()
+		return
+	}
+	if personalToken.User.DeactivatedAt != nil {
+		gecho.Unauthorized(w).WithMessage("Account is deactivated").Send()
+		return
+	}
+
+	now := time.Now()
+	if personalToken.LastUsedAt == nil || now.Sub(*personalToken.LastUsedAt) >= lastUsedInterval {
+		err := mw.db.Model(&models.PersonalAccessToken{}).Where("id = ?", personalToken.ID).UpdateColumn("last_used_at", now).Error
+		if err != nil {
+			logger.Err(fmt.Sprintf("Could not update last use of personal access token %d: %s", personalToken.ID, err.Error()))
 		}
-		if user.Role != 1 {
-			gecho.Forbidden(w).Send()
-			return
+	}
+
+	ctx = context.WithValue(ctx, contextkeys.AuthTokenKey, personalToken)
+	ctx = context.WithValue(ctx, contextkeys.AuthUserKey, personalToken.User)
+
+	next(w, r.WithContext(ctx))
+}
+
+// HasPermission reports if the authenticated user of ctx has all of permissions.
+// Requests authenticated with a personal access token are limited to the scopes of the token.
+func HasPermission(ctx context.Context, permissions ...rbac.Permission) bool {
+	user, ok := ctx.Value(contextkeys.AuthUserKey).(models.User)
+	if !ok || !rbac.Has(user.Role, permissions...) {
+		return false
+	}
+	if personalToken, ok := ctx.Value(contextkeys.AuthTokenKey).(models.PersonalAccessToken); ok {
+		for _, permission := range permissions {
+			if !slices.Contains(personalToken.Scopes, string(permission)) {
+				return false
+			}
 		}
-		next(w, r)
-	})
+	}
+	return true
+}
+
+// AuthenticationMiddleware.Requires checks if valid authentication is present and the role of the user has all of
+// permissions, e.g. auth.Requires(rbac.DeviceRead)(handler)
+func (mw AuthenticationMiddleware) Requires(permissions ...rbac.Permission) func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return mw.Required(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			for _, permission := range permissions {
+				if !HasPermission(ctx, permission) {
+					gecho.Forbidden(w).WithMessage(fmt.Sprintf("Missing permission '%s'", permission)).Send()
+					return
+				}
+			}
+			next(w, r)
+		})
+	}
 }
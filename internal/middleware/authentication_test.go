@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/authsession"
+	models "github.com/CLDWare/schoolbox-backend/pkg/db"
+	"github.com/CLDWare/schoolbox-backend/pkg/logger"
+	"github.com/CLDWare/schoolbox-backend/pkg/tokenhash"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRequiredSession(t *testing.T) {
+	logger.Init()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.AuthSession{}); err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Email: "teacher@school.nl", Role: "teacher"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{OAuth: config.OAuthConfig{SessionIdleTimeout: time.Hour, CookieSameSite: "lax"}}
+	mw := NewAuthenticationMiddleware(cfg, db)
+
+	now := time.Now()
+	lastUsed := now.Add(-10 * time.Second)
+	tests := []struct {
+		name              string
+		storeRawToken     bool // Store the token itself instead of its hash
+		expiresAt         time.Time
+		absoluteExpiresAt time.Time
+		lastUsedAt        *time.Time
+		expectedStatus    int
+		expectedExpiresAt time.Time // Expiry after the request, only checked for accepted sessions
+	}{
+		{
+			name:              "renewed",
+			expiresAt:         now.Add(10 * time.Minute),
+			absoluteExpiresAt: now.Add(24 * time.Hour),
+			expectedStatus:    http.StatusOK,
+			expectedExpiresAt: now.Add(time.Hour),
+		},
+		{
+			name:              "renewal capped at absolute expiry",
+			expiresAt:         now.Add(10 * time.Minute),
+			absoluteExpiresAt: now.Add(30 * time.Minute),
+			expectedStatus:    http.StatusOK,
+			expectedExpiresAt: now.Add(30 * time.Minute),
+		},
+		{
+			name:              "not renewed within a minute",
+			expiresAt:         now.Add(10 * time.Minute),
+			absoluteExpiresAt: now.Add(24 * time.Hour),
+			lastUsedAt:        &lastUsed,
+			expectedStatus:    http.StatusOK,
+			expectedExpiresAt: now.Add(10 * time.Minute),
+		},
+		{
+			name:              "idle expired",
+			expiresAt:         now.Add(-time.Minute),
+			absoluteExpiresAt: now.Add(24 * time.Hour),
+			expectedStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:              "absolute expired",
+			expiresAt:         now.Add(10 * time.Minute),
+			absoluteExpiresAt: now.Add(-time.Minute),
+			expectedStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:              "raw token stored",
+			storeRawToken:     true,
+			expiresAt:         now.Add(10 * time.Minute),
+			absoluteExpiresAt: now.Add(24 * time.Hour),
+			expectedStatus:    http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := "token " + tt.name
+			session := models.AuthSession{
+				TokenHash:         tokenhash.Hash(token),
+				ExpiresAt:         tt.expiresAt,
+				AbsoluteExpiresAt: tt.absoluteExpiresAt,
+				UserID:            user.ID,
+				LastUsedAt:        tt.lastUsedAt,
+			}
+			if tt.storeRawToken {
+				session.TokenHash = token
+			}
+			if err := db.Create(&session).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(&http.Cookie{Name: authsession.CookieName, Value: token})
+			w := httptest.NewRecorder()
+			mw.Required(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var stored models.AuthSession
+			if err := db.First(&stored, session.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if diff := stored.ExpiresAt.Sub(tt.expectedExpiresAt).Abs(); diff > time.Second {
+				t.Errorf("expected the session to expire at %s, got %s", tt.expectedExpiresAt, stored.ExpiresAt)
+			}
+			for _, cookie := range w.Result().Cookies() {
+				if diff := cookie.Expires.Sub(tt.expectedExpiresAt).Abs(); cookie.Name == authsession.CookieName && diff > time.Second {
+					t.Errorf("expected the cookie to expire at %s, got %s", tt.expectedExpiresAt, cookie.Expires)
+				}
+			}
+		})
+	}
+}
@@ -1,19 +1,62 @@
 package middleware
 
 import (
+	"bufio"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/CLDWare/schoolbox-backend/internal/metrics"
 	"github.com/CLDWare/schoolbox-backend/pkg/logger"
 )
 
-// LoggingMiddleware logs HTTP requests
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *statusRecorder) Flush() {
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+// Hijack is needed for websocket upgrades
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil {
+		rec.hijacked = true
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// LoggingMiddleware logs HTTP requests and records their count and latency per route
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-
-		next.ServeHTTP(w, r)
-
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
 		duration := time.Since(start)
 		logger.Info(
 			"method", r.Method,
@@ -21,6 +64,21 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 			"duration", duration,
 			"remote_addr", r.RemoteAddr,
 		)
+
+		// The mux stores the matched pattern on the request, using it instead of the path keeps the number of routes bounded
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		// Websockets and event streams stay open for as long as the client wants, that is not request latency
+		if !rec.hijacked && rec.Header().Get("Content-Type") != "text/event-stream" {
+			metrics.HTTPRequestDuration.WithLabelValues(r.Method, route).Observe(duration.Seconds())
+		}
 	})
 }
 
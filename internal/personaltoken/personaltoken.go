@@ -0,0 +1,38 @@
+// Package personaltoken generates the personal access tokens users authenticate scripts with.
+//
+// Only the hash of a token is stored, see tokenhash.Hash, the token itself is shown once when it is created.
+package personaltoken
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+)
+
+// Prefix starts every token, so tokens are easy to recognise in scripts and secret scanners
+const Prefix = "sbx_pat_"
+
+// hintLength is the length of the start of a token that is stored in plaintext
+const hintLength = len(Prefix) + 4
+
+// Generate returns a new random token
+func Generate() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return Prefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Valid reports if token looks like a personal access token
+func Valid(token string) bool {
+	return strings.HasPrefix(token, Prefix) && len(token) > hintLength
+}
+
+// Hint returns the start of token, which lets users recognise their tokens
+func Hint(token string) string {
+	if len(token) < hintLength {
+		return token
+	}
+	return token[:hintLength]
+}
@@ -0,0 +1,43 @@
+// Package provisioning decides who can log in and which role users get when they are created on their first login.
+package provisioning
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/rbac"
+)
+
+// domain returns the lower case domain of email
+func domain(email string) string {
+	_, domain, _ := strings.Cut(strings.ToLower(email), "@")
+	return domain
+}
+
+// RestrictsDomains reports if only some email domains are allowed to log in
+func RestrictsDomains(cfg *config.ProvisioningConfig) bool {
+	return len(cfg.AllowedDomains) > 0
+}
+
+// DomainAllowed reports if users with email are allowed to log in
+func DomainAllowed(cfg *config.ProvisioningConfig, email string) bool {
+	if !RestrictsDomains(cfg) {
+		return true
+	}
+	return slices.ContainsFunc(cfg.AllowedDomains, func(allowed string) bool {
+		return strings.EqualFold(allowed, domain(email))
+	})
+}
+
+// Role returns the role of the first rule that matches email, or the default role
+func Role(cfg *config.ProvisioningConfig, email string) rbac.Role {
+	email = strings.ToLower(email)
+	for _, rule := range cfg.RoleRules {
+		if matched, _ := path.Match(rule.Pattern, email); matched {
+			return rule.Role
+		}
+	}
+	return cfg.DefaultRole
+}
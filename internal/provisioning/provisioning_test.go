@@ -0,0 +1,69 @@
+package provisioning
+
+import (
+	"testing"
+
+	"github.com/CLDWare/schoolbox-backend/config"
+	"github.com/CLDWare/schoolbox-backend/internal/rbac"
+)
+
+func TestDomainAllowed(t *testing.T) {
+	cfg := &config.ProvisioningConfig{AllowedDomains: []string{"school.nl", "Leerling.School.nl"}}
+
+	tests := []struct {
+		email    string
+		expected bool
+	}{
+		{email: "j.jansen@school.nl", expected: true},
+		{email: "J.Jansen@SCHOOL.NL", expected: true},
+		{email: "p.de.vries@leerling.school.nl", expected: true},
+		{email: "j.jansen@gmail.com", expected: false},
+		{email: "j.jansen@notschool.nl", expected: false},
+		{email: "school.nl@gmail.com", expected: false},
+		{email: "j.jansen", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.email, func(t *testing.T) {
+			if got := DomainAllowed(cfg, test.email); got != test.expected {
+				t.Errorf("DomainAllowed(%q) = %v, expected %v", test.email, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestDomainAllowed_NoRestriction(t *testing.T) {
+	if !DomainAllowed(&config.ProvisioningConfig{}, "j.jansen@gmail.com") {
+		t.Error("expected every domain to be allowed without allowed domains")
+	}
+}
+
+func TestRole(t *testing.T) {
+	cfg := &config.ProvisioningConfig{
+		DefaultRole: rbac.RoleTeacher,
+		RoleRules: []config.RoleRule{
+			{Pattern: "directie@school.nl", Role: rbac.RoleAdmin},
+			{Pattern: "*@it.school.nl", Role: rbac.RoleITAdmin},
+			{Pattern: "*@*.school.nl", Role: rbac.RoleAuditor},
+		},
+	}
+
+	tests := []struct {
+		email    string
+		expected rbac.Role
+	}{
+		{email: "directie@school.nl", expected: rbac.RoleAdmin},
+		{email: "Directie@School.nl", expected: rbac.RoleAdmin},
+		{email: "helpdesk@it.school.nl", expected: rbac.RoleITAdmin},
+		{email: "controle@audit.school.nl", expected: rbac.RoleAuditor},
+		{email: "j.jansen@school.nl", expected: rbac.RoleTeacher},
+	}
+
+	for _, test := range tests {
+		t.Run(test.email, func(t *testing.T) {
+			if got := Role(cfg, test.email); got != test.expected {
+				t.Errorf("Role(%q) = %q, expected %q", test.email, got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Package rbac defines the roles users can have and the permissions that come with them.
+//
+// Permissions are written as "<resource>:<action>" or "<resource>:<action>:<scope>", where the scope is own, section
+// or any. A handler that supports multiple scopes checks for the widest one first.
+package rbac
+
+import "slices"
+
+// Role is the role of a user, stored in User.Role
+type Role = string
+
+const (
+	RoleTeacher        Role = "teacher"
+	RoleDepartmentHead Role = "department_head"
+	RoleITAdmin        Role = "it_admin"
+	RoleAuditor        Role = "auditor"
+	RoleAdmin          Role = "admin"
+)
+
+// Permission allows a user to do something
+type Permission string
+
+const (
+	SessionReadOwn     Permission = "session:read:own"
+	SessionReadSection Permission = "session:read:section" // Sessions of users in the same section
+	SessionReadAny     Permission = "session:read:any"
+	SessionWriteOwn    Permission = "session:write:own" // Start and stop your own sessions
+	SessionStopAny     Permission = "session:stop:any"
+
+	DeviceRead       Permission = "device:read"
+	DeviceWrite      Permission = "device:write" // Register, edit and delete devices
+	DeviceGroupRead  Permission = "device_group:read"
+	DeviceGroupWrite Permission = "device_group:write"
+	RoomRead         Permission = "room:read"
+	RoomWrite        Permission = "room:write"
+
+	UserReadAny     Permission = "user:read:any"
+	UserWrite       Permission = "user:write"       // Invite users, change their role and end their login sessions
+	UserImpersonate Permission = "user:impersonate" // Log in as another user to see what they see
+
+	AlertRead  Permission = "alert:read"
+	AlertWrite Permission = "alert:write" // Acknowledge alerts
+
+	MetricsRead Permission = "metrics:read"
+
+	AuditRead Permission = "audit:read" // Read and export the audit log
+)
+
+var allPermissions = []Permission{
+	SessionReadOwn,
+	SessionReadSection,
+	SessionReadAny,
+	SessionWriteOwn,
+	SessionStopAny,
+	DeviceRead,
+	DeviceWrite,
+	DeviceGroupRead,
+	DeviceGroupWrite,
+	RoomRead,
+	RoomWrite,
+	UserReadAny,
+	UserWrite,
+	UserImpersonate,
+	AlertRead,
+	AlertWrite,
+	MetricsRead,
+	AuditRead,
+}
+
+var teacherPermissions = []Permission{
+	SessionReadOwn,
+	SessionWriteOwn,
+}
+
+var rolePermissions = map[Role][]Permission{
+	RoleTeacher: teacherPermissions,
+	RoleDepartmentHead: append(slices.Clone(teacherPermissions),
+		SessionReadSection,
+	),
+	// IT admins manage the devices, but can not see the results of sessions
+	RoleITAdmin: {
+		DeviceRead,
+		DeviceWrite,
+		DeviceGroupRead,
+		DeviceGroupWrite,
+		RoomRead,
+		RoomWrite,
+		UserReadAny,
+		AlertRead,
+		AlertWrite,
+		MetricsRead,
+	},
+	RoleAuditor: {
+		SessionReadAny,
+		DeviceRead,
+		DeviceGroupRead,
+		RoomRead,
+		UserReadAny,
+		AlertRead,
+		MetricsRead,
+		AuditRead,
+	},
+	RoleAdmin: allPermissions,
+}
+
+// Roles returns all roles
+func Roles() []Role {
+	return []Role{RoleTeacher, RoleDepartmentHead, RoleITAdmin, RoleAuditor, RoleAdmin}
+}
+
+// ValidRole reports if role exists
+func ValidRole(role Role) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// ValidPermission reports if permission exists
+func ValidPermission(permission Permission) bool {
+	return slices.Contains(allPermissions, permission)
+}
+
+// Permissions returns the permissions of role, unknown roles have none
+func Permissions(role Role) []Permission {
+	return slices.Clone(rolePermissions[role])
+}
+
+// Has reports if role has all of permissions
+func Has(role Role, permissions ...Permission) bool {
+	for _, permission := range permissions {
+		if !slices.Contains(rolePermissions[role], permission) {
+			return false
+		}
+	}
+	return true
+}
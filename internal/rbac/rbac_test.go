@@ -0,0 +1,91 @@
+package rbac
+
+import "testing"
+
+func TestHas(t *testing.T) {
+	tests := []struct {
+		name        string
+		role        Role
+		permissions []Permission
+		expected    bool
+	}{
+		{
+			name:        "teacher reads own sessions",
+			role:        RoleTeacher,
+			permissions: []Permission{SessionReadOwn},
+			expected:    true,
+		},
+		{
+			name:        "teacher can not read sessions of others",
+			role:        RoleTeacher,
+			permissions: []Permission{SessionReadSection},
+			expected:    false,
+		},
+		{
+			name:        "department head reads sessions of their section",
+			role:        RoleDepartmentHead,
+			permissions: []Permission{SessionReadOwn, SessionReadSection},
+			expected:    true,
+		},
+		{
+			name:        "it admin manages devices",
+			role:        RoleITAdmin,
+			permissions: []Permission{DeviceRead, DeviceWrite},
+			expected:    true,
+		},
+		{
+			name:        "it admin can not see results",
+			role:        RoleITAdmin,
+			permissions: []Permission{SessionReadAny},
+			expected:    false,
+		},
+		{
+			name:        "auditor is read only",
+			role:        RoleAuditor,
+			permissions: []Permission{DeviceWrite},
+			expected:    false,
+		},
+		{
+			name:        "all permissions are required",
+			role:        RoleAuditor,
+			permissions: []Permission{DeviceRead, DeviceWrite},
+			expected:    false,
+		},
+		{
+			name:        "auditor reads the audit log",
+			role:        RoleAuditor,
+			permissions: []Permission{AuditRead},
+			expected:    true,
+		},
+		{
+			name:        "it admin can not read the audit log",
+			role:        RoleITAdmin,
+			permissions: []Permission{AuditRead},
+			expected:    false,
+		},
+		{
+			name:        "unknown role has no permissions",
+			role:        "1",
+			permissions: []Permission{SessionReadOwn},
+			expected:    false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Has(test.role, test.permissions...); got != test.expected {
+				t.Errorf("Has(%q, %v) = %v, expected %v", test.role, test.permissions, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestAdminHasAllPermissions(t *testing.T) {
+	for _, role := range Roles() {
+		for _, permission := range Permissions(role) {
+			if !Has(RoleAdmin, permission) {
+				t.Errorf("admin is missing permission %s of %s", permission, role)
+			}
+		}
+	}
+}
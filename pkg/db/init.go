@@ -23,6 +23,19 @@ func InitialiseDatabase() (*gorm.DB, error) {
 
 	// ctx := context.Background()
 
-	db.AutoMigrate(&Device{}, &User{}, &AuthSession{}, &Question{}, &Session{})
+	// Before AutoMigrate, which adds the unique index on the token hashes
+	if err := migrateSessionTokens(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate session tokens: %s", err.Error())
+	}
+	db.AutoMigrate(&Device{}, &User{}, &UserIdentity{}, &AuthSession{}, &Question{}, &Session{}, &DeviceEvent{}, &DeviceGroup{}, &DeviceTag{}, &Room{}, &Alert{}, &PersonalAccessToken{}, &AuditEvent{})
+	if err := migrateDeviceRooms(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate device rooms: %s", err.Error())
+	}
+	if err := migrateUserRoles(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate user roles: %s", err.Error())
+	}
+	if err := migrateGoogleSubjects(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate google subjects: %s", err.Error())
+	}
 	return db, nil
 }
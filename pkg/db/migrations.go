@@ -0,0 +1,124 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/CLDWare/schoolbox-backend/pkg/tokenhash"
+	"gorm.io/gorm"
+)
+
+// migrateDeviceRooms moves the room names that used to be stored on devices into the rooms table
+func migrateDeviceRooms(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&Device{}, "room") {
+		return nil
+	}
+
+	var oldRooms []struct {
+		ID   uint
+		Room string
+	}
+	if err := db.Table("devices").Select("id", "room").Where("room IS NOT NULL").Scan(&oldRooms).Error; err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, oldRoom := range oldRooms {
+			room := Room{Name: oldRoom.Room}
+			if err := tx.Where(room).FirstOrCreate(&room).Error; err != nil {
+				return err
+			}
+			if err := tx.Table("devices").Where("id = ?", oldRoom.ID).Update("room_id", room.ID).Error; err != nil {
+				return err
+			}
+		}
+		if tx.Migrator().HasConstraint(&Device{}, "uni_devices_room") {
+			if err := tx.Migrator().DropConstraint(&Device{}, "uni_devices_room"); err != nil {
+				return fmt.Errorf("could not drop old room constraint: %s", err.Error())
+			}
+		}
+		if err := tx.Migrator().DropColumn(&Device{}, "room"); err != nil {
+			return fmt.Errorf("could not drop old room column: %s", err.Error())
+		}
+		return nil
+	})
+}
+
+// migrateUserRoles replaces the numeric roles users used to have with role names
+func migrateUserRoles(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&User{}).Where("role = ?", "0").Update("role", "teacher").Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&User{}).Where("role = ?", "1").Update("role", "admin").Error
+	})
+}
+
+// googleIssuer is the issuer of the Google accounts users were linked to before identities existed
+const googleIssuer = "https://accounts.google.com"
+
+// migrateGoogleSubjects links every user that logged in with Google before identities existed to a Google identity
+func migrateGoogleSubjects(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		// An empty subject used to be stored for users that never logged in, which breaks the unique constraint
+		if err := tx.Unscoped().Model(&User{}).Where("google_subject = ?", "").Update("google_subject", nil).Error; err != nil {
+			return err
+		}
+
+		var users []User
+		err := tx.Where("google_subject IS NOT NULL AND id NOT IN (?)", tx.Model(&UserIdentity{}).Select("user_id")).Find(&users).Error
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			identity := UserIdentity{
+				UserID:  user.ID,
+				Issuer:  googleIssuer,
+				Subject: *user.GoogleSubject,
+				Email:   user.Email,
+			}
+			if err := tx.Create(&identity).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateSessionTokens replaces the session tokens that used to be stored in plaintext with their hash, sessions from
+// before renewal existed can not be renewed
+func migrateSessionTokens(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&AuthSession{}) || !db.Migrator().HasColumn(&AuthSession{}, "session_token") {
+		return nil
+	}
+
+	var oldSessions []struct {
+		ID           uint
+		SessionToken string
+	}
+	if err := db.Table("auth_sessions").Select("id", "session_token").Scan(&oldSessions).Error; err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, column := range []string{"TokenHash", "AbsoluteExpiresAt"} {
+			if !tx.Migrator().HasColumn(&AuthSession{}, column) {
+				if err := tx.Migrator().AddColumn(&AuthSession{}, column); err != nil {
+					return fmt.Errorf("could not add column %s: %s", column, err.Error())
+				}
+			}
+		}
+		for _, oldSession := range oldSessions {
+			err := tx.Table("auth_sessions").Where("id = ?", oldSession.ID).Updates(map[string]any{
+				"token_hash":          tokenhash.Hash(oldSession.SessionToken),
+				"absolute_expires_at": gorm.Expr("expires_at"),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Migrator().DropColumn(&AuthSession{}, "session_token"); err != nil {
+			return fmt.Errorf("could not drop old session token column: %s", err.Error())
+		}
+		return nil
+	})
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
@@ -11,31 +12,104 @@ type Device struct {
 	RegistrationDate time.Time
 	LatestLogin      *time.Time
 	LastSeen         *time.Time
-	Token            string
-	Room             *string `gorm:"unique"`
+	Token            string // Encrypted with the server key, see internal/devicetoken
+	// Metadata
+	RoomID      *uint `gorm:"uniqueIndex"`
+	Room        *Room `gorm:"foreignKey:RoomID;references:ID"`
+	DisplayName *string
+	Notes       string
+	Location    string // Free form location within the room, e.g. "next to the door"
+	// Token rotation
+	PreviousToken          *string // Token replaced by the latest rotation, still accepted until PreviousTokenExpiresAt
+	PreviousTokenExpiresAt *time.Time
+	TokenRotatedAt         *time.Time
 	// Device lease
 	LeaseStart      time.Time
 	ActiveSessionID *uint
 	ActiveSession   *Session `gorm:"foreignKey:ActiveSessionID;references:ID"`
+	// Organisation
+	Groups []DeviceGroup `gorm:"many2many:device_group_members"`
+	Tags   []DeviceTag   `gorm:"foreignKey:DeviceID;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+// Room is a place a device can be in, a device can move between rooms
+type Room struct {
+	gorm.Model
+	Name     string `gorm:"index"` // Unique among rooms that are not deleted
+	Building string
+	Floor    *int
+	Capacity *uint
+	Devices  []Device `gorm:"foreignKey:RoomID;references:ID"`
+}
+
+// DeviceGroup is a named set of devices that can be managed together, e.g. "building A"
+type DeviceGroup struct {
+	gorm.Model
+	Name        string `gorm:"uniqueIndex"`
+	Description string
+	Devices     []Device `gorm:"many2many:device_group_members"`
+}
+
+// DeviceTag is a free form label on a single device, e.g. "science wing"
+type DeviceTag struct {
+	DeviceID  uint   `gorm:"primaryKey"`
+	Name      string `gorm:"primaryKey;index"`
+	CreatedAt time.Time
 }
 
 type User struct {
 	gorm.Model
-	Email           string `gorm:"unique"`
-	GoogleSubject   string `gorm:"unique"` // "sub" field from the OAuth response, should be unique
-	ProfilePicture  string // url to the users pfp (straight from google)
+	Email           string  `gorm:"unique"`
+	GoogleSubject   *string `gorm:"unique"` // Deprecated: users are linked to their login provider by Identities, only set for users from before that
+	ProfilePicture  string  // url to the users pfp (straight from the login provider)
 	Name            string
 	DisplayName     string
-	Role            uint   // 0: default user, 1: admin
-	DefaultQuestion string `gorm:"default:'Wat vond je van de les?'"`
+	Role            string         `gorm:"default:'teacher'"` // teacher, department_head, it_admin, auditor or admin, see internal/rbac
+	Section         string         `gorm:"index"`             // Department of the user, department heads can see the sessions of their section
+	DefaultQuestion string         `gorm:"default:'Wat vond je van de les?'"`
+	DeactivatedAt   *time.Time     // Deactivated users can not log in, nil for active users
+	Identities      []UserIdentity `gorm:"foreignKey:UserID;references:ID"`
+}
+
+// UserIdentity links a user to an account at an OpenID Connect provider, a user can log in with any of their identities
+type UserIdentity struct {
+	gorm.Model
+	UserID  uint   `gorm:"index"`
+	User    User   `gorm:"foreignKey:UserID;references:ID"`
+	Issuer  string `gorm:"uniqueIndex:idx_user_identities_issuer_subject"`
+	Subject string `gorm:"uniqueIndex:idx_user_identities_issuer_subject"` // "sub" claim of the ID token, unique per issuer
+	Email   string // Email address at the provider when the identity was linked
 }
 
+// AuthSession is a login of a user in a browser, it is renewed on use until AbsoluteExpiresAt
 type AuthSession struct {
 	gorm.Model
-	SessionToken string
-	ExpiresAt    time.Time
-	UserID       uint
-	User         User `gorm:"foreignKey:UserID;references:ID"`
+	TokenHash         string     `gorm:"uniqueIndex"` // hash of the session token, see pkg/tokenhash
+	ExpiresAt         time.Time  // Ends the session when it is not used before, moved forward on use
+	AbsoluteExpiresAt time.Time  // Ends the session even when it is used
+	UserID            uint       `gorm:"index"`
+	User              User       `gorm:"foreignKey:UserID;references:ID"`
+	UserAgent         string     // User agent of the browser that logged in
+	IPAddress         string     // Address the login came from
+	LastUsedAt        *time.Time // Updated at most once per minute
+	// Set when an admin started the session to see what the user sees, see POST /user/{id}/impersonate
+	ImpersonatorID          *uint `gorm:"index"`
+	Impersonator            *User `gorm:"foreignKey:ImpersonatorID;references:ID"`
+	ImpersonationReason     string
+	ImpersonationAllowWrite bool // Without it only safe requests (GET, HEAD, OPTIONS) can be made
+}
+
+// PersonalAccessToken lets scripts authenticate as a user with "Authorization: Bearer <token>", revoking a token deletes it
+type PersonalAccessToken struct {
+	gorm.Model
+	UserID     uint `gorm:"index"`
+	User       User `gorm:"foreignKey:UserID;references:ID"`
+	Name       string
+	TokenHash  string   `gorm:"uniqueIndex"` // See internal/personaltoken, the token itself is not stored
+	Hint       string   // Start of the token, so users can recognise it
+	Scopes     []string `gorm:"serializer:json"` // Permissions the token is limited to, see internal/rbac
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
 }
 
 type Question struct {
@@ -53,6 +127,8 @@ type Session struct {
 	Question        Question `gorm:"foreignKey:QuestionID;references:ID"`
 	DeviceID        uint
 	Device          Device `gorm:"foreignKey:DeviceID;references:ID"`
+	RoomID          *uint  // Room of the device when the session started
+	Room            *Room  `gorm:"foreignKey:RoomID;references:ID"`
 	Date            time.Time
 	FirstAnwserTime *time.Time
 	LastAnwserTime  *time.Time
@@ -63,3 +139,51 @@ type Session struct {
 	A4_count        uint16
 	A5_count        uint16
 }
+
+type DeviceEvent struct {
+	gorm.Model
+	DeviceID     *uint   `gorm:"index"` // nil until the connection authenticated as a device
+	Device       *Device `gorm:"foreignKey:DeviceID;references:ID"`
+	ConnectionID uint
+	Type         string `gorm:"index"` // connect, auth_success, auth_failure, disconnect
+	Reason       string // why a connection was closed or authentication failed
+	RemoteAddr   string
+	Duration     *time.Duration // how long the connection was open, only set on disconnect
+}
+
+// Alert tells admins a device needs attention, it stays open until the problem is gone or, for authentication failures,
+// until an admin acknowledges it
+type Alert struct {
+	gorm.Model
+	Type             string  `gorm:"index"` // device_offline, device_auth_failures
+	DeviceID         uint    `gorm:"index"`
+	Device           *Device `gorm:"foreignKey:DeviceID;references:ID"`
+	Message          string
+	AcknowledgedAt   *time.Time
+	AcknowledgedByID *uint
+	AcknowledgedBy   *User      `gorm:"foreignKey:AcknowledgedByID;references:ID"`
+	ResolvedAt       *time.Time `gorm:"index"` // nil while the alert is open
+}
+
+// ErrAuditEventImmutable is returned when an audit event would be changed
+var ErrAuditEventImmutable = errors.New("audit events can not be changed")
+
+// AuditEvent records who did an administrative or security relevant action. The audit log is append only, events are
+// only deleted by the janitor once they are older than the retention.
+type AuditEvent struct {
+	ID             uint           `gorm:"primarykey"`
+	CreatedAt      time.Time      `gorm:"index"`
+	ActorID        *uint          `gorm:"index"` // nil when nobody was logged in, e.g. for rejected logins
+	Actor          *User          `gorm:"foreignKey:ActorID;references:ID"`
+	ImpersonatorID *uint          `gorm:"index"` // Admin that did the action while impersonating the actor
+	Impersonator   *User          `gorm:"foreignKey:ImpersonatorID;references:ID"`
+	Action         string         `gorm:"index"`                         // e.g. device.delete, see internal/handlers/audit.go
+	TargetType     string         `gorm:"index:idx_audit_events_target"` // device, user, session, ...
+	TargetID       *uint          `gorm:"index:idx_audit_events_target"`
+	Details        map[string]any `gorm:"serializer:json"`
+	IPAddress      string
+}
+
+func (event *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
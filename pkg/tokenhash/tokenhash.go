@@ -0,0 +1,15 @@
+// Package tokenhash hashes the secret tokens of which only the hash is stored, like session tokens and personal access
+// tokens.
+package tokenhash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Hash returns the hash a token is stored and looked up by.
+// Tokens have 256 bits of randomness, so a fast hash without salt is enough.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}